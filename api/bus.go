@@ -0,0 +1,250 @@
+package api
+
+import "sync"
+
+type (
+	// Policy decides what happens to the events of a subscriber that can't keep up
+	Policy int
+
+	// Identifier is an event that belongs to a download, so it can be filtered by the id of the download
+	Identifier interface {
+		EventID() string
+	}
+
+	// Coalescer is an event that can be replaced by a newer one with the same key while it waits for a slow subscriber, e.g progress of a download.
+	// Empty key means the event must not be coalesced
+	Coalescer interface {
+		CoalesceKey() string
+	}
+
+	Subscription interface {
+		Events() <-chan interface{}
+		// Dropped returns how many events are dropped because the subscriber is too slow
+		Dropped() int
+		Close()
+	}
+
+	SubscribeOptions func(s *subscription)
+
+	// Bus fans out the events of a topic to every subscriber of it. Publish never blocks, since every subscriber has its own buffer
+	Bus struct {
+		mutex  sync.RWMutex
+		topics map[string]map[*subscription]struct{}
+	}
+
+	subscription struct {
+		bus     *Bus
+		topic   string
+		mutex   sync.Mutex
+		pending []interface{}
+		size    int
+		policy  Policy
+		ids     map[string]bool
+		dropped int
+		notify  chan struct{}
+		events  chan interface{}
+		quit    chan struct{}
+		once    sync.Once
+	}
+)
+
+const (
+	// DropOldest drops the oldest pending event once the buffer is full
+	DropOldest Policy = iota
+	// Coalesce replaces the pending event that has the same coalesce key, and drops the oldest one once the buffer is still full
+	Coalesce
+)
+
+const defaultBufferSize = 100
+
+// WithBuffer sets how many events can wait for the subscriber
+func WithBuffer(size int) SubscribeOptions {
+	return func(s *subscription) {
+		if size > 0 {
+			s.size = size
+		}
+	}
+}
+
+// WithPolicy sets what happens to the events once the subscriber can't keep up
+func WithPolicy(policy Policy) SubscribeOptions {
+	return func(s *subscription) {
+		s.policy = policy
+	}
+}
+
+// WithIDs only receives the events of the given downloads. Events that don't belong to any download are always received
+func WithIDs(ids ...string) SubscribeOptions {
+	return func(s *subscription) {
+		for _, id := range ids {
+			s.ids[id] = true
+		}
+	}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		topics: make(map[string]map[*subscription]struct{}),
+	}
+}
+
+func (b *Bus) Publish(topic string, data interface{}) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for sub := range b.topics[topic] {
+		sub.push(data)
+	}
+}
+
+func (b *Bus) Subscribe(topic string, options ...SubscribeOptions) Subscription {
+	sub := &subscription{
+		bus:     b,
+		topic:   topic,
+		pending: make([]interface{}, 0),
+		size:    defaultBufferSize,
+		ids:     make(map[string]bool),
+		notify:  make(chan struct{}, 1),
+		events:  make(chan interface{}),
+		quit:    make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(sub)
+	}
+
+	b.mutex.Lock()
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make(map[*subscription]struct{})
+	}
+
+	b.topics[topic][sub] = struct{}{}
+	b.mutex.Unlock()
+
+	go sub.deliver()
+
+	return sub
+}
+
+func (b *Bus) unsubscribe(sub *subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.topics[sub.topic], sub)
+	if len(b.topics[sub.topic]) == 0 {
+		delete(b.topics, sub.topic)
+	}
+}
+
+func (s *subscription) accepts(data interface{}) bool {
+	if len(s.ids) == 0 {
+		return true
+	}
+
+	if event, ok := data.(Identifier); ok {
+		return s.ids[event.EventID()]
+	}
+
+	return true
+}
+
+func coalesceKey(data interface{}) string {
+	if event, ok := data.(Coalescer); ok {
+		return event.CoalesceKey()
+	}
+
+	return ""
+}
+
+// push queues the event without waiting for the subscriber
+func (s *subscription) push(data interface{}) {
+	if !s.accepts(data) {
+		return
+	}
+
+	s.mutex.Lock()
+
+	if key := coalesceKey(data); s.policy == Coalesce && key != "" {
+		// the newer event takes the place at the end, so it still comes after the events published before it
+		for i, pending := range s.pending {
+			if coalesceKey(pending) == key {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if len(s.pending) >= s.size {
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		s.dropped++
+	}
+
+	s.pending = append(s.pending, data)
+	s.mutex.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// deliver hands the pending events to the subscriber one by one until the subscription is closed
+func (s *subscription) deliver() {
+	defer close(s.events)
+
+	for {
+		s.mutex.Lock()
+		if len(s.pending) == 0 {
+			s.mutex.Unlock()
+
+			select {
+			case <-s.notify:
+				continue
+			case <-s.quit:
+				return
+			}
+		}
+
+		data := s.pending[0]
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		s.mutex.Unlock()
+
+		select {
+		case s.events <- data:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *subscription) Events() <-chan interface{} {
+	return s.events
+}
+
+func (s *subscription) Dropped() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.dropped
+}
+
+func (s *subscription) Close() {
+	s.once.Do(func() {
+		s.bus.unsubscribe(s)
+		close(s.quit)
+	})
+}
+
+var bus = NewBus()
+
+// Publish sends the event to every subscriber of the topic, which is the id of the client, e.g gui or cli
+func Publish(topic string, data interface{}) {
+	bus.Publish(topic, data)
+}
+
+// Subscribe receives the events of the topic until the subscription is closed
+func Subscribe(topic string, options ...SubscribeOptions) Subscription {
+	return bus.Subscribe(topic, options...)
+}
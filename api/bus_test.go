@@ -0,0 +1,156 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+type event struct {
+	id       string
+	coalesce bool
+	value    int
+}
+
+func (e event) EventID() string {
+	return e.id
+}
+
+func (e event) CoalesceKey() string {
+	if !e.coalesce {
+		return ""
+	}
+
+	return e.id
+}
+
+func receive(t *testing.T, sub Subscription) event {
+	select {
+	case data := <-sub.Events():
+		return data.(event)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+
+	return event{}
+}
+
+func TestBusFanOut(t *testing.T) {
+	bus := NewBus()
+
+	first := bus.Subscribe("gui")
+	defer first.Close()
+
+	second := bus.Subscribe("gui")
+	defer second.Close()
+
+	other := bus.Subscribe("cli")
+	defer other.Close()
+
+	bus.Publish("gui", event{id: "1", value: 1})
+
+	if e := receive(t, first); e.value != 1 {
+		t.Errorf("Expected first subscriber to receive 1, but got %d", e.value)
+	}
+
+	if e := receive(t, second); e.value != 1 {
+		t.Errorf("Expected second subscriber to receive 1, but got %d", e.value)
+	}
+
+	select {
+	case data := <-other.Events():
+		t.Errorf("Expected other topic to receive nothing, but got %v", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBusFilterByID(t *testing.T) {
+	bus := NewBus()
+
+	sub := bus.Subscribe("gui", WithIDs("2"))
+	defer sub.Close()
+
+	bus.Publish("gui", event{id: "1", value: 1})
+	bus.Publish("gui", event{id: "2", value: 2})
+
+	if e := receive(t, sub); e.id != "2" {
+		t.Errorf("Expected only events of download 2, but got %s", e.id)
+	}
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+
+	sub := bus.Subscribe("gui", WithBuffer(10))
+	defer sub.Close()
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+
+		for i := 0; i < 1000; i++ {
+			bus.Publish("gui", event{id: "1", value: i})
+		}
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Expected publish not to block on a slow subscriber")
+	}
+
+	if sub.Dropped() == 0 {
+		t.Error("Expected the oldest events to be dropped")
+	}
+}
+
+func TestBusCoalesce(t *testing.T) {
+	bus := NewBus()
+
+	sub := bus.Subscribe("gui", WithPolicy(Coalesce))
+	defer sub.Close()
+
+	// the subscriber isn't reading yet, so every progress replaces the pending one, but the status is kept
+	for i := 0; i < 50; i++ {
+		bus.Publish("gui", event{id: "1", coalesce: true, value: i})
+	}
+
+	bus.Publish("gui", event{id: "1", value: 100})
+	bus.Publish("gui", event{id: "1", coalesce: true, value: 101})
+
+	// the first progress may have been taken by the deliverer before the rest are published
+	e := receive(t, sub)
+	if e.value == 0 {
+		e = receive(t, sub)
+	}
+
+	if e.value != 100 {
+		t.Errorf("Expected the outdated progress to be skipped until the status 100, but got %d", e.value)
+	}
+
+	if e := receive(t, sub); e.value != 101 {
+		t.Errorf("Expected the latest progress 101 after the status, but got %d", e.value)
+	}
+
+	select {
+	case data := <-sub.Events():
+		t.Errorf("Expected nothing else, but got %v", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	bus := NewBus()
+
+	sub := bus.Subscribe("gui")
+	sub.Close()
+
+	bus.Publish("gui", event{id: "1"})
+
+	if _, ok := <-sub.Events(); ok {
+		t.Error("Expected events to be closed after unsubscribing")
+	}
+
+	if len(bus.topics) != 0 {
+		t.Error("Expected the topic to be removed once it has no subscriber")
+	}
+}
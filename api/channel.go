@@ -52,25 +52,33 @@ func (c *channel) Close() error {
 		close(c.ch)
 
 		if c.name != "" {
-			delete(channels, c.name)
+			channels.Lock()
+			delete(channels.channels, c.name)
+			channels.Unlock()
 		}
 	})
 
 	return nil
 }
 
-var channels = map[string]Channel{
-	"gui": NewChannel(),
-	"cli": NewChannel(),
+// channels are the queues between services, e.g memstore. Events for the clients go through the bus instead
+var channels = struct {
+	sync.Mutex
+	channels map[string]Channel
+}{
+	channels: make(map[string]Channel),
 }
 
 func CreateChannel(name string) Channel {
-	if channel, ok := channels[name]; ok {
+	channels.Lock()
+	defer channels.Unlock()
+
+	if channel, ok := channels.channels[name]; ok {
 		return channel
 	}
 
 	channel := NewChannel(name)
-	channels[name] = channel
+	channels.channels[name] = channel
 
 	return channel
 }
@@ -95,14 +95,24 @@ func (s *service) Shutdown() {
 			}
 		}
 
-		for _, channel := range channels {
+		channels.Lock()
+		toClose := make([]Channel, 0, len(channels.channels))
+		for _, channel := range channels.channels {
+			toClose = append(toClose, channel)
+		}
+		channels.Unlock()
+
+		// closing removes the channel from the map, so it can't be done while holding the lock
+		for _, channel := range toClose {
 			channel.Close()
 		}
 	}()
 
+	// only listen on loopback by default, so the other hosts on the network can't reach the engine
+	host := env.Get("API_BIND").String("127.0.0.1")
 	port := env.Get("API_PORT").String(":8888")
 
-	if err := s.app.Listen(port); err != nil {
+	if err := s.app.Listen(host + port); err != nil {
 		log.Fatal(err)
 	}
 }
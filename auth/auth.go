@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	response "github.com/rapid-downloader/rapid/helper"
+)
+
+const (
+	// Header is the header which carries the token
+	Header = "X-Rapid-Token"
+	// Query is the query param which carries the token, for the client that can't set a header, e.g websocket in browser
+	Query = "token"
+)
+
+// Origins are the origins of the gui, both the build and the dev server, and of the browser extension, whose id differs
+// per browser and install so it is allowed by its scheme. Every request still has to carry the token
+const Origins = "wails://wails,http://wails.localhost,http://wails.localhost:34115,http://localhost:34115," +
+	"chrome-extension://*,moz-extension://*,safari-web-extension://*"
+
+const tokenFile = "token"
+
+var errUnauthorized = fmt.Errorf("missing or invalid token")
+
+func tokenPath(location string) string {
+	return filepath.Join(location, tokenFile)
+}
+
+// Token reads the secret from the data location, and generates it on the first run. Only the user who runs the engine can read it
+func Token(location string) (string, error) {
+	val, err := os.ReadFile(tokenPath(location))
+	if err == nil && len(strings.TrimSpace(string(val))) > 0 {
+		return strings.TrimSpace(string(val)), nil
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error reading token:%s", err.Error())
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating token:%s", err.Error())
+	}
+
+	token := hex.EncodeToString(secret)
+
+	if err := os.MkdirAll(location, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(tokenPath(location), []byte(token), 0600); err != nil {
+		return "", fmt.Errorf("error writing token:%s", err.Error())
+	}
+
+	return token, nil
+}
+
+// tokenFrom reads the token from the header, the bearer authorization, or the query param
+func tokenFrom(ctx *fiber.Ctx) string {
+	if token := ctx.Get(Header); token != "" {
+		return token
+	}
+
+	if authorization := ctx.Get(fiber.HeaderAuthorization); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	return ctx.Query(Query)
+}
+
+// New rejects every request that doesn't carry the token
+func New(token string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if subtle.ConstantTimeCompare([]byte(tokenFrom(ctx)), []byte(token)) != 1 {
+			return response.Error(ctx, fiber.StatusUnauthorized, errUnauthorized)
+		}
+
+		return ctx.Next()
+	}
+}
+
+// Cors allows the browser to call the api from the given origins only, which carry the token in the header
+func Cors(origins string) fiber.Handler {
+	return cors.New(cors.Config{
+		AllowOrigins: origins,
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, " + Header,
+	})
+}
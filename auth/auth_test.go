@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestToken(t *testing.T) {
+	dir := t.TempDir()
+
+	token, err := Token(dir)
+	if err != nil {
+		t.Fatal("Error generating token:", err.Error())
+	}
+
+	if len(token) != 64 {
+		t.Errorf("Expected 64 characters token, but got %d", len(token))
+	}
+
+	stat, err := os.Stat(tokenPath(dir))
+	if err != nil {
+		t.Fatal("Error reading token file:", err.Error())
+	}
+
+	if stat.Mode().Perm() != 0600 {
+		t.Errorf("Expected token to be readable by the owner only, but got %s", stat.Mode().Perm())
+	}
+
+	again, err := Token(dir)
+	if err != nil || again != token {
+		t.Errorf("Expected the same token on the next run, but got %s", again)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	app := fiber.New()
+	app.Use(New("secret"))
+	app.Get("/entries", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	cases := []struct {
+		name   string
+		header string
+		value  string
+		query  string
+		status int
+	}{
+		{name: "missing", status: fiber.StatusUnauthorized},
+		{name: "wrong", header: Header, value: "wrong", status: fiber.StatusUnauthorized},
+		{name: "header", header: Header, value: "secret", status: fiber.StatusOK},
+		{name: "bearer", header: fiber.HeaderAuthorization, value: "Bearer secret", status: fiber.StatusOK},
+		{name: "query", query: "?token=secret", status: fiber.StatusOK},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/entries"+c.query, nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal("Error testing request:", err.Error())
+		}
+
+		if res.StatusCode != c.status {
+			t.Errorf("Expected %s token to respond %d, but got %d", c.name, c.status, res.StatusCode)
+		}
+	}
+}
+
+func TestCors(t *testing.T) {
+	app := fiber.New()
+	app.Use(Cors(Origins))
+	app.Get("/entries", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{origin: "wails://wails", allowed: true},
+		{origin: "chrome-extension://abcdefghijklmnopabcdefghijklmnop", allowed: true},
+		{origin: "moz-extension://0b6c5a0e-8f4e-4b5b-9d4c-2b1f8e7a6c3d", allowed: true},
+		{origin: "https://example.com", allowed: false},
+		{origin: "http://localhost:3000", allowed: false},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("OPTIONS", "/entries", nil)
+		req.Header.Set(fiber.HeaderOrigin, c.origin)
+		req.Header.Set(fiber.HeaderAccessControlRequestMethod, "GET")
+
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal("Error testing request:", err.Error())
+		}
+
+		if allowed := res.Header.Get(fiber.HeaderAccessControlAllowOrigin) == c.origin; allowed != c.allowed {
+			t.Errorf("Expected %s to be allowed %v, but got %q", c.origin, c.allowed, res.Header.Get(fiber.HeaderAccessControlAllowOrigin))
+		}
+	}
+}
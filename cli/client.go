@@ -8,9 +8,11 @@ import (
 
 	"github.com/goccy/go-json"
 
+	"github.com/rapid-downloader/rapid/auth"
 	"github.com/rapid-downloader/rapid/client"
 	"github.com/rapid-downloader/rapid/client/websocket"
 	"github.com/rapid-downloader/rapid/env"
+	"github.com/rapid-downloader/rapid/setting"
 )
 
 type rapidClient struct {
@@ -18,6 +20,7 @@ type rapidClient struct {
 	url    string
 	wsUrl  string
 	ws     websocket.Websocket
+	token  string
 	ctx    context.Context
 	cancel context.CancelFunc
 }
@@ -26,8 +29,14 @@ func NewRapid(ctx context.Context, id string) (*rapidClient, error) {
 	host := env.Get("API_HOST").String("localhost")
 	port := env.Get("API_PORT").String(":8888")
 
+	// the engine and the cli share the data location, so the token is read from there
+	token, err := auth.Token(setting.Get().DataLocation)
+	if err != nil {
+		return nil, err
+	}
+
 	url := fmt.Sprintf("http://%s%s", host, port)
-	wsUrl := fmt.Sprintf("ws://%s%s/ws/%s", host, port, id)
+	wsUrl := fmt.Sprintf("ws://%s%s/ws/%s?%s=%s", host, port, id, auth.Query, token)
 
 	ctx, cancel := context.WithCancel(ctx)
 	ws := websocket.Connect(ctx, wsUrl)
@@ -39,6 +48,7 @@ func NewRapid(ctx context.Context, id string) (*rapidClient, error) {
 		ctx:    ctx,
 		cancel: cancel,
 		ws:     ws,
+		token:  token,
 	}, nil
 
 }
@@ -70,7 +80,7 @@ func (r *rapidClient) Fetch(request client.Request) (*client.Download, error) {
 
 	req.Header.Add("Content-Type", "application/json")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := r.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error creating fetch request: %s", err)
 	}
@@ -91,7 +101,7 @@ func (r *rapidClient) Download(id string) error {
 		return fmt.Errorf("error preparing download request: %s", err.Error())
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := r.do(req)
 	if err != nil {
 		return fmt.Errorf("error creating download request: %s", err)
 
@@ -109,7 +119,7 @@ func (r *rapidClient) Stop(id string) error {
 		return fmt.Errorf("error preparing stop request: %s", err)
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := r.do(req)
 	if err != nil {
 		return fmt.Errorf("error stoping download: %s", err)
 	}
@@ -117,6 +127,11 @@ func (r *rapidClient) Stop(id string) error {
 	return res.Body.Close()
 }
 
+func (r *rapidClient) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(auth.Header, r.token)
+	return http.DefaultClient.Do(req)
+}
+
 func (r *rapidClient) Close() error {
 	r.cancel()
 	return r.ws.Close()
@@ -11,6 +11,7 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/rapid-downloader/rapid/client"
+	"github.com/rapid-downloader/rapid/entry"
 	"github.com/rapid-downloader/rapid/helper"
 	"github.com/vbauerster/mpb"
 	"github.com/vbauerster/mpb/decor"
@@ -31,6 +32,11 @@ func progressbar() progressBar {
 var once = sync.Once{}
 
 func (p *progressBar) update(progress client.Progress) {
+	// status transitions carry no chunk
+	if len(progress.Chunks) == 0 {
+		return
+	}
+
 	once.Do(func() {
 		for i, chunk := range progress.Chunks {
 			bar := p.mpb.AddBar(chunk.Size,
@@ -96,6 +102,13 @@ func main() {
 			return
 		}
 
+		switch progress.Status {
+		case entry.Failed, entry.Canceled, entry.Expired, entry.Mismatch:
+			fmt.Println("download is", progress.Status)
+			cancel()
+			return
+		}
+
 		progressBar.update(progress)
 	})
 
@@ -20,6 +20,12 @@ type Request struct {
 	MimeType  *string   `json:"mimeType"`
 	UserAgent *string   `json:"userAgent"`
 	Cookies   *[]Cookie `json:"cookies"`
+	Checksum  *string   `json:"checksum"` // <algorithm>:<hex digest>, e.g sha256:2cf24d...
+	Mirrors   *[]string `json:"mirrors"`  // other urls that serve the same file
+	Location  *string   `json:"location"` // folder to save into, instead of the folder of the file category
+	Filename  *string   `json:"filename"` // name to save as, instead of the name given by the server
+	Proxy     *string   `json:"proxy"`    // proxy of the download instead of the proxy of the setting, direct to bypass it
+	Insecure  *bool     `json:"insecure"` // skip the verification of the server certificate
 }
 
 type Download struct {
@@ -37,20 +43,55 @@ type Download struct {
 	TimeLeft         int       `json:"timeLeft"`
 	Speed            int       `json:"speed"`
 	Status           string    `json:"status"`
+	Checksum         string    `json:"checksum"`
+	ExpectedChecksum string    `json:"expectedChecksum"`
+	Mirrors          []string  `json:"mirrors"`
+	Fallback         string    `json:"fallback"` // why the file is downloaded in a single stream, e.g the server ignores range
 	Date             time.Time `json:"date"`
 }
 
 type Progress struct {
-	ID     string          `json:"id"`
-	Done   bool            `json:"done"`
-	Chunks []ChunkProgress `json:"chunks"`
+	ID         string           `json:"id"`
+	Done       bool             `json:"done"`
+	Status     string           `json:"status"`
+	Downloaded int64            `json:"downloaded"`
+	Size       int64            `json:"size"`
+	Progress   float64          `json:"progress"` // overall percentage
+	Speed      float64          `json:"speed"`    // moving average of bytes per second
+	TimeLeft   float64          `json:"timeLeft"` // seconds, -1 if it can't be estimated
+	Chunks     []ChunkProgress  `json:"chunks"`
+	Mirrors    []MirrorProgress `json:"mirrors"`
+}
+
+// EventID returns the id of the download, so the progress can be filtered by it
+func (p Progress) EventID() string {
+	return p.ID
+}
+
+// CoalesceKey lets a slow client skip the outdated progress of a download. Status transitions and the final progress are never skipped
+func (p Progress) CoalesceKey() string {
+	if p.Done || p.Chunks == nil {
+		return ""
+	}
+
+	return p.ID
 }
 
 type ChunkProgress struct {
 	Downloaded int64   `json:"downloaded"`
 	Size       int64   `json:"size"`
 	Progress   float64 `json:"progress"`
+	Speed      float64 `json:"speed"`    // moving average of bytes per second
+	TimeLeft   float64 `json:"timeLeft"` // seconds, -1 if it can't be estimated
 	Done       bool    `json:"done"`
+	Mirror     int     `json:"mirror"` // index of the mirror which the chunk is downloaded from
+}
+
+type MirrorProgress struct {
+	URL        string  `json:"url"`
+	Downloaded int64   `json:"downloaded"`
+	Speed      float64 `json:"speed"` // average bytes per second since the download started
+	Failures   int     `json:"failures"`
 }
 
 type OnProgress = func(progress Progress, err error)
@@ -1,33 +1,55 @@
 package db
 
 import (
-	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/rapid-downloader/rapid/fs"
+	"github.com/rapid-downloader/rapid/log"
 	"github.com/rapid-downloader/rapid/setting"
 	bolt "go.etcd.io/bbolt"
 )
 
+const filename = "entries.db"
+
 var k = "default"
 var instances = make(map[string]*bolt.DB)
+var mutex sync.RWMutex
 
+// DB returns the opened instance. The instance is replaced when the data location changes, so it shouldn't be kept around
 func DB(key ...string) *bolt.DB {
 	instance := k
 	if len(key) > 0 {
 		instance = key[0]
 	}
 
+	mutex.RLock()
+	defer mutex.RUnlock()
+
 	return instances[instance]
 }
 
-func Open(key ...string) {
-	setting := setting.Get()
+// Resolve returns a function which resolves the current instance, for the stores which outlive a relocation
+func Resolve(key ...string) func() *bolt.DB {
+	return func() *bolt.DB {
+		return DB(key...)
+	}
+}
 
-	path := fmt.Sprintf("%s/%s", setting.DataLocation, "entries.db")
+func open(location string) (*bolt.DB, error) {
+	path := filepath.Join(location, filename)
 	options := bolt.DefaultOptions
 	options.Timeout = time.Duration(time.Second)
 
-	db, err := bolt.Open(path, 0600, options)
+	return bolt.Open(path, 0600, options)
+}
+
+func Open(key ...string) {
+	setting := setting.Get()
+
+	db, err := open(setting.DataLocation)
 	if err != nil {
 		panic(err)
 	}
@@ -37,9 +59,98 @@ func Open(key ...string) {
 		instance = key[0]
 	}
 
+	mutex.Lock()
 	instances[instance] = db
+	mutex.Unlock()
 }
 
 func Close(key ...string) error {
 	return DB(key...).Close()
 }
+
+// data are the files and the folders which are kept in the data location besides the database, i.e the manifests of the unfinished
+// downloads, the logs and the token of the api
+var data = []string{"manifests", "logs", "token"}
+
+// relocate moves the database and the rest of the data into the new data location, and reopens the database there
+func relocate(old, new *setting.Setting) {
+	if old.DataLocation == new.DataLocation {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// the location is only validated, so it may not exist yet
+	if err := os.MkdirAll(new.DataLocation, os.ModePerm); err != nil {
+		log.Println("error creating the new data location:", err.Error())
+		return
+	}
+
+	for _, name := range data {
+		if err := moveAll(filepath.Join(old.DataLocation, name), filepath.Join(new.DataLocation, name)); err != nil {
+			log.Println("error moving", name, "into the new data location:", err.Error())
+		}
+	}
+
+	for key, db := range instances {
+		if err := db.Close(); err != nil {
+			log.Println("error closing db:", err.Error())
+			continue
+		}
+
+		from := filepath.Join(old.DataLocation, filename)
+		to := filepath.Join(new.DataLocation, filename)
+
+		location := new.DataLocation
+		if err := fs.Move(from, to); err != nil {
+			log.Println("error moving db into the new data location:", err.Error())
+			location = old.DataLocation
+		}
+
+		reopened, err := open(location)
+		if err != nil {
+			panic(err)
+		}
+
+		instances[key] = reopened
+	}
+}
+
+// moveAll moves the file, or the folder with everything in it. The files of a folder which already exists in the new location,
+// e.g the logs which are written there since the location has changed, are merged into it
+func moveAll(from, to string) error {
+	info, err := os.Stat(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fs.Move(from, to)
+	}
+
+	if err := os.MkdirAll(to, os.ModePerm); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := moveAll(filepath.Join(from, entry.Name()), filepath.Join(to, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(from)
+}
+
+func init() {
+	setting.Subscribe(relocate)
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+func TestRelocate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	old := setting.Get()
+	old.DataLocation = t.TempDir()
+
+	new := *old
+	new.DataLocation = filepath.Join(t.TempDir(), "data")
+
+	files := []string{filepath.Join("manifests", "1.json"), filepath.Join("logs", "01-01-2026.txt"), "token"}
+	for _, file := range files {
+		path := filepath.Join(old.DataLocation, file)
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+
+		if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+			t.Fatal("Error writing file:", err.Error())
+		}
+	}
+
+	db, err := open(old.DataLocation)
+	if err != nil {
+		t.Fatal("Error opening db:", err.Error())
+	}
+
+	mutex.Lock()
+	instances["relocate"] = db
+	mutex.Unlock()
+
+	defer func() {
+		Close("relocate")
+
+		mutex.Lock()
+		delete(instances, "relocate")
+		mutex.Unlock()
+	}()
+
+	relocate(old, &new)
+
+	if path := DB("relocate").Path(); path != filepath.Join(new.DataLocation, filename) {
+		t.Errorf("Expected the db to be reopened in the new location, but got %s", path)
+	}
+
+	for _, file := range files {
+		if val, err := os.ReadFile(filepath.Join(new.DataLocation, file)); err != nil || string(val) != file {
+			t.Errorf("Expected %s to be moved into the new location, but got %v", file, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(old.DataLocation, file)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed from the old location", file)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	logger "log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	rapidClient "github.com/rapid-downloader/rapid/client"
@@ -12,20 +16,28 @@ import (
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/rapid-downloader/rapid/api"
 	"github.com/rapid-downloader/rapid/db"
 	"github.com/rapid-downloader/rapid/downloader"
 	"github.com/rapid-downloader/rapid/entry"
 	entryApi "github.com/rapid-downloader/rapid/entry/api"
 	response "github.com/rapid-downloader/rapid/helper"
+	"github.com/rapid-downloader/rapid/queue"
+	"github.com/rapid-downloader/rapid/scheduler"
 	"github.com/rapid-downloader/rapid/setting"
 )
 
 type downloaderService struct {
-	app      *fiber.App
-	memstore entry.Store
-	channel  api.Channel
-	store    entryApi.Store
+	app       *fiber.App
+	memstore  entry.Store
+	channel   api.Channel
+	store     entryApi.Store
+	queue     queue.Manager
+	scheduler scheduler.Manager
+	held      atomic.Bool // the queue is held during the quiet hours
+	mutex     sync.Mutex  // serializes the status transitions
+	clients   sync.Map    // id of the download to the client which runs it
 }
 
 func newService(app *fiber.App) api.Service {
@@ -33,23 +45,128 @@ func newService(app *fiber.App) api.Service {
 		app:      app,
 		memstore: entry.Memstore(),
 		channel:  api.CreateChannel("memstore"),
-		store:    entryApi.NewStore("download", db.DB()),
+		store:    entryApi.NewStore("download", db.Resolve()),
 	}
 }
 
 func (s *downloaderService) Init() error {
+	setting.Subscribe(s.reload)
+
+	setting := setting.Get()
+
+	q, err := queue.New(db.Resolve(), "queue", setting.MaxConcurrentDownload, s.run)
+	if err != nil {
+		return err
+	}
+
+	s.queue = q
+	s.restore(setting)
+
+	sch, err := scheduler.New(db.Resolve(), "schedule", &controller{s})
+	if err != nil {
+		return err
+	}
+
+	s.scheduler = sch
+
+	downloader.SetGlobalLimit(setting.MaxDownloadSpeed)
+
 	go s.channel.Subscribe(func(data interface{}) {
-		entry, ok := data.(entry.Entry)
-		if !ok {
-			return
+		switch data := data.(type) {
+		case entry.Entry:
+			if err := s.memstore.Set(data.ID(), data); err != nil {
+				log.Println("error inserting into memstore:", err.Error())
+				return
+			}
+
+			s.persist(data)
+		case entryApi.Deletion:
+			s.drop(data.ID)
+			close(data.Done)
+		case queue.Item:
+			if !s.scheduler.Allowed(data.ID) {
+				return
+			}
+
+			if err := s.queue.Push(data); err != nil {
+				log.Println("error pushing into queue:", err.Error())
+				return
+			}
 		}
+	})
+
+	// the scheduler holds the queue if it starts during the quiet hours
+	s.scheduler.Start()
+	if !s.held.Load() {
+		s.queue.Start()
+	}
 
-		if err := s.memstore.Set(entry.ID(), entry); err != nil {
+	return nil
+}
+
+// drop takes the deleted entry out of the queue and stops it, so it neither holds a download slot nor is run later
+func (s *downloaderService) drop(id string) {
+	s.queue.Remove(id)
+
+	if e := s.memstore.Get(id); e != nil {
+		e.Cancel()
+
+		if err := s.memstore.Delete(id); err != nil {
+			log.Println("error removing from memstore:", err.Error())
+		}
+	}
+
+	s.clients.Delete(id)
+}
+
+// persist writes the manifest of the fetched entry, so it is restored after the engine restarts even though it only waits in the queue.
+// The download writes it again once it starts
+func (s *downloaderService) persist(e entry.Entry) {
+	// the ranges are unknown until the download starts, without chunks a resume starts from the chunk files instead
+	manifest := entry.NewManifest(e)
+	manifest.Chunks = make([]entry.ChunkState, 0)
+
+	if err := manifest.Save(setting.Get().DataLocation); err != nil {
+		log.Println("error saving manifest of", e.Name(), ":", err.Error())
+	}
+}
+
+// restore rebuilds the unfinished entries from their manifests, so they can be resumed after the engine restarts
+func (s *downloaderService) restore(setting *setting.Setting) {
+	for _, manifest := range entry.LoadManifests(setting.DataLocation) {
+		e, err := manifest.Entry()
+		if err != nil {
+			log.Println("error restoring entry", manifest.ID, ":", err.Error())
+			continue
+		}
+
+		if err := s.memstore.Set(e.ID(), e); err != nil {
 			log.Println("error inserting into memstore:", err.Error())
-			return
 		}
-	})
 
+		// the engine stopped in the middle of the download
+		if download := s.store.Get(e.ID()); download != nil && download.Status == entry.Downloading {
+			if err := s.transition(e.ID(), entry.Paused); err != nil {
+				log.Println("error pausing restored download", e.Name(), ":", err.Error())
+			}
+		}
+	}
+}
+
+// reload applies the updated setting to the running engine. The other fields are picked up by the next download
+func (s *downloaderService) reload(old, new *setting.Setting) {
+	if old.MaxDownloadSpeed != new.MaxDownloadSpeed {
+		downloader.SetGlobalLimit(new.MaxDownloadSpeed)
+	}
+
+	if old.MaxConcurrentDownload != new.MaxConcurrentDownload {
+		s.queue.SetLimit(new.MaxConcurrentDownload)
+	}
+}
+
+func (s *downloaderService) Close() error {
+	s.scheduler.Stop()
+	s.queue.Stop()
 	return nil
 }
 
@@ -60,170 +177,444 @@ func (s *downloaderService) download(ctx *fiber.Ctx) error {
 	client := ctx.Params("client")
 
 	id := ctx.Params("id")
-	entry := s.memstore.Get(id)
-	if entry == nil {
+	if s.memstore.Get(id) == nil {
 		return response.NotFound(ctx)
 	}
 
-	status := "Downloading"
-	err := s.store.Update(entry.ID(), entryApi.UpdateDownload{
-		Status: &status,
+	// the status is queued once it is transitioned, so run can no longer tell that the download has to continue
+	if download := s.store.Get(id); download != nil {
+		switch download.Status {
+		case entry.Paused, entry.Failed:
+			return response.BadRequest(ctx, fmt.Errorf("download is %s, resume or restart it instead", strings.ToLower(download.Status)))
+		case entry.Canceled:
+			return response.BadRequest(ctx, fmt.Errorf("download is canceled, restart it instead"))
+		}
+	}
+
+	s.clients.Store(id, client)
+
+	if err := s.transition(id, entry.Queued); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	// it stays queued until the scheduler starts it
+	if !s.scheduler.Allowed(id) {
+		return response.Ok(ctx)
+	}
+
+	err := s.queue.Push(queue.Item{
+		ID:     id,
+		Client: client,
 	})
 
 	if err != nil {
-		return response.InternalServerError(ctx, err)
+		return response.BadRequest(ctx, err)
 	}
 
-	go s.doDownload(entry, client)
-
 	return response.Ok(ctx)
 }
 
-func (s *downloaderService) doDownload(entry entry.Entry, client string) {
-	channel := api.CreateChannel(client)
-	setting := setting.Get()
+// run is called by the queue once the entry gets a free download slot
+func (s *downloaderService) run(item queue.Item) {
+	e := s.memstore.Get(item.ID)
+	if e == nil {
+		log.Println("entry", item.ID, "is not in memory. Skipping...")
+		return
+	}
+
+	s.clients.Store(item.ID, item.Client)
 
-	dl := downloader.New(entry.Downloader(),
-		downloader.UseSetting(setting),
+	// a paused or failed download continues where it left off
+	resume := item.Resume
+	if download := s.store.Get(item.ID); download != nil {
+		resume = resume || download.Status == entry.Paused || download.Status == entry.Failed
+	}
+
+	if err := s.transition(item.ID, entry.Downloading); err != nil {
+		log.Println("error starting download", e.Name(), ":", err.Error(), ". Skipping...")
+		return
+	}
+
+	switch {
+	case item.Restart:
+		s.doRestart(e, item.Client)
+	case resume:
+		s.doResume(e, item.Client)
+	default:
+		s.doDownload(e, item.Client)
+	}
+}
+
+func (s *downloaderService) doDownload(e entry.Entry, client string) {
+	dl := downloader.New(e.Downloader(),
+		downloader.UseSetting(setting.Get()),
 	)
 
-	if watcher, ok := dl.(downloader.Watcher); ok {
-		watcher.Watch(func(data ...interface{}) {
-			channel.Publish(data[0])
-		})
+	s.watch(dl, client)
+
+	err := dl.Download(e)
+	s.storeEntry(e)
+	s.complete(e, dl, err)
+}
+
+// storeEntry writes back what the engine learns about the entry while downloading, e.g the fallback to a single stream once range is broken,
+// or the size of the remote file which has changed
+func (s *downloaderService) storeEntry(e entry.Entry) {
+	size := e.Size()
+	resumable := e.Resumable()
+	chunklen := e.ChunkLen()
+	checksum := e.Checksum()
+
+	update := entryApi.UpdateDownload{
+		Size:             &size,
+		Resumable:        &resumable,
+		ChunkLen:         &chunklen,
+		ExpectedChecksum: &checksum,
 	}
 
-	if err := dl.Download(entry); err != nil {
-		log.Printf("error downloading %s: %s", entry.Name(), err.Error())
+	if client, ok := e.(entry.SingleStreamClient); ok {
+		fallback := client.Fallback()
+		update.Fallback = &fallback
+	}
+
+	if client, ok := e.(entry.ConditionalClient); ok {
+		etag, modified := client.ETag(), client.LastModified()
+		update.ETag = &etag
+		update.LastModified = &modified
+	}
+
+	if err := s.store.Update(e.ID(), update); err != nil {
+		log.Println("error updating download entry:", err.Error())
+	}
+}
+
+// storeInterval is how often the progress is written back into the store, since every progress event would be too many writes
+const storeInterval = 2 * time.Second
+
+// watch publishes the progress to the client, and writes it back into the store periodically, so the entries reflect the live state
+func (s *downloaderService) watch(dl downloader.Downloader, client string) {
+	watcher, ok := dl.(downloader.Watcher)
+	if !ok {
 		return
 	}
 
-	channel.Publish(rapidClient.Progress{
-		ID:   entry.ID(),
-		Done: true,
+	var mutex sync.Mutex
+	var last time.Time
+
+	watcher.Watch(func(data ...interface{}) {
+		api.Publish(client, data[0])
+
+		progress, ok := data[0].(rapidClient.Progress)
+		if !ok {
+			return
+		}
+
+		mutex.Lock()
+		if time.Since(last) < storeInterval {
+			mutex.Unlock()
+			return
+		}
+
+		last = time.Now()
+		mutex.Unlock()
+
+		s.storeProgress(progress)
 	})
 }
 
-func (s *downloaderService) resume(ctx *fiber.Ctx) error {
-	client := ctx.Params("client")
+func (s *downloaderService) storeProgress(progress rapidClient.Progress) {
+	downloadedChunks := make([]int64, len(progress.Chunks))
+	for i, chunk := range progress.Chunks {
+		downloadedChunks[i] = chunk.Downloaded
+	}
 
-	id := ctx.Params("id")
+	err := s.store.Update(progress.ID, entryApi.UpdateDownload{
+		Progress:         &progress.Progress,
+		Speed:            &progress.Speed,
+		TimeLeft:         &progress.TimeLeft,
+		DownloadedChunks: downloadedChunks,
+	})
 
-	entry := s.memstore.Get(id)
-	if entry == nil {
-		return response.Success(ctx, fiber.StatusNoContent)
+	if err != nil {
+		log.Println("error updating download progress:", err.Error())
 	}
+}
 
-	go s.doResume(entry, client)
+// complete stores the checksum of the downloaded file, and moves the download into the status that matches the result
+func (s *downloaderService) complete(e entry.Entry, dl downloader.Downloader, err error) {
+	// paused or stopped, which is already transitioned by pause or stop
+	if e.Context().Err() != nil {
+		return
+	}
 
-	return response.Ok(ctx)
-}
+	status := entry.Completed
+	switch {
+	case errors.Is(err, downloader.ErrChecksumMismatch):
+		status = entry.Mismatch
+	case errors.Is(err, downloader.ErrUrlExpired):
+		status = entry.Expired
+	case err != nil:
+		status = entry.Failed
+	}
 
-func (s *downloaderService) doResume(entry entry.Entry, client string) {
-	channel := api.CreateChannel(client)
-	setting := setting.Get()
+	if err != nil {
+		log.Printf("error downloading %s: %s", e.Name(), err.Error())
+	}
 
-	dl := downloader.New(entry.Downloader(),
-		downloader.UseSetting(setting),
-	)
+	if verifier, ok := dl.(downloader.Verifier); ok {
+		digest := verifier.Digest(e)
 
-	if watcher, ok := dl.(downloader.Watcher); ok {
-		watcher.Watch(func(data ...interface{}) {
-			channel.Publish(data[0])
+		err := s.store.Update(e.ID(), entryApi.UpdateDownload{
+			Checksum: &digest,
 		})
+
+		if err != nil {
+			log.Println("error updating download checksum:", err.Error())
+		}
 	}
 
-	if err := dl.Resume(entry); err != nil {
-		log.Printf("error downloading %s: %s", entry.Name(), err.Error())
+	if err := s.transition(e.ID(), status); err != nil {
+		log.Println("error completing download", e.Name(), ":", err.Error())
 		return
 	}
 
-	channel.Publish(rapidClient.Progress{
-		ID:   entry.ID(),
-		Done: true,
-	})
+	// hooks may take long, e.g extracting a large archive, so they don't hold the download slot
+	if status == entry.Completed {
+		go s.runHooks(e)
+	}
 }
 
-func (s *downloaderService) restart(ctx *fiber.Ctx) error {
+func (s *downloaderService) resume(ctx *fiber.Ctx) error {
 	client := ctx.Params("client")
 
 	id := ctx.Params("id")
-	entry := s.memstore.Get(id)
-	if entry == nil {
-		return response.NotFound(ctx)
+	if s.memstore.Get(id) == nil {
+		return response.Success(ctx, fiber.StatusNoContent)
 	}
 
-	go s.doRestart(entry, client)
+	return s.enqueue(ctx, queue.Item{
+		ID:     id,
+		Client: client,
+		Resume: true,
+	})
+}
+
+// enqueue moves the download into the queue, where it waits for a download slot like a new download
+func (s *downloaderService) enqueue(ctx *fiber.Ctx, item queue.Item) error {
+	s.clients.Store(item.ID, item.Client)
+
+	if err := s.transition(item.ID, entry.Queued); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	// it may still wait in the queue, e.g it is resumed right after it is queued
+	s.queue.Remove(item.ID)
+
+	if err := s.queue.Push(item); err != nil {
+		return response.BadRequest(ctx, err)
+	}
 
 	return response.Ok(ctx)
 }
 
-func (s *downloaderService) doRestart(entry entry.Entry, client string) {
-	channel := api.CreateChannel(client)
-	setting := setting.Get()
-
-	dl := downloader.New(entry.Downloader(),
-		downloader.UseSetting(setting),
+func (s *downloaderService) doResume(e entry.Entry, client string) {
+	dl := downloader.New(e.Downloader(),
+		downloader.UseSetting(setting.Get()),
 	)
 
-	if watcher, ok := dl.(downloader.Watcher); ok {
-		watcher.Watch(func(data ...interface{}) {
-			channel.Publish(data[0])
-		})
-	}
+	s.watch(dl, client)
 
-	if err := dl.Restart(entry); err != nil {
-		log.Printf("error restarting %s: %s", entry.Name(), err.Error())
-		return
+	err := dl.Resume(e)
+	s.storeEntry(e)
+	s.complete(e, dl, err)
+}
+
+func (s *downloaderService) restart(ctx *fiber.Ctx) error {
+	client := ctx.Params("client")
+
+	id := ctx.Params("id")
+	if s.memstore.Get(id) == nil {
+		return response.NotFound(ctx)
 	}
 
-	channel.Publish(rapidClient.Progress{
-		ID:   entry.ID(),
-		Done: true,
+	return s.enqueue(ctx, queue.Item{
+		ID:      id,
+		Client:  client,
+		Restart: true,
 	})
 }
 
+func (s *downloaderService) doRestart(e entry.Entry, client string) {
+	dl := downloader.New(e.Downloader(),
+		downloader.UseSetting(setting.Get()),
+	)
+
+	s.watch(dl, client)
+
+	err := dl.Restart(e)
+	s.storeEntry(e)
+	s.complete(e, dl, err)
+}
+
 func (s *downloaderService) pause(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
-	entry := s.memstore.Get(id)
-	if entry == nil {
+	e := s.memstore.Get(id)
+	if e == nil {
 		return response.Success(ctx, fiber.StatusNoContent)
 	}
 
-	return s.doStop(entry, ctx)
+	if err := s.transition(id, entry.Paused); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	s.queue.Remove(id)
+
+	return s.doStop(e, ctx)
 }
 
 func (s *downloaderService) stop(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
-	entry := s.memstore.Get(id)
-	if entry == nil {
+	e := s.memstore.Get(id)
+	if e == nil {
 		return response.NotFound(ctx)
 	}
 
-	return s.doStop(entry, ctx)
+	if err := s.transition(id, entry.Canceled); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	s.queue.Remove(id)
+
+	return s.doStop(e, ctx)
 }
 
-func (s *downloaderService) doStop(entry entry.Entry, ctx *fiber.Ctx) error {
-	setting := setting.Get()
+func (s *downloaderService) doStop(e entry.Entry, ctx *fiber.Ctx) error {
+	if err := s.stopDownload(e); err != nil {
+		return response.InternalServerError(ctx, err)
+	}
+
+	return response.Ok(ctx)
+}
 
-	dl := downloader.New(entry.Downloader(),
-		downloader.UseSetting(setting),
+func (s *downloaderService) stopDownload(e entry.Entry) error {
+	dl := downloader.New(e.Downloader(),
+		downloader.UseSetting(setting.Get()),
 	)
 
-	if err := dl.Stop(entry); err != nil {
-		return response.InternalServerError(ctx, fmt.Errorf("error stopping download: %s", err.Error()))
+	if err := dl.Stop(e); err != nil {
+		return fmt.Errorf("error stopping download: %s", err.Error())
 	}
 
+	return nil
+}
+
+func (s *downloaderService) getQueue(ctx *fiber.Ctx) error {
+	return response.Ok(ctx, fiber.Map{
+		"active": s.queue.Active(),
+		"queued": s.queue.Queued(),
+	})
+}
+
+func (s *downloaderService) moveQueue(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+
+	var payload struct {
+		Position int `json:"position"`
+	}
+
+	if err := ctx.BodyParser(&payload); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	if err := s.queue.Move(id, payload.Position); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	return response.Ok(ctx)
+}
+
+func (s *downloaderService) removeQueue(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+
+	if err := s.queue.Remove(id); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	// it no longer waits for a download slot
+	if err := s.transition(id, entry.Paused); err != nil {
+		log.Println("error pausing download", id, ":", err.Error())
+	}
+
+	return response.Ok(ctx)
+}
+
+type throttleRequest struct {
+	Limit int64 `json:"limit"`
+}
+
+func (s *downloaderService) getThrottle(ctx *fiber.Ctx) error {
+	return response.Ok(ctx, fiber.Map{
+		"global":  downloader.GlobalLimit(),
+		"entries": downloader.EntryLimits(),
+	})
+}
+
+func (s *downloaderService) throttle(ctx *fiber.Ctx) error {
+	var payload throttleRequest
+	if err := ctx.BodyParser(&payload); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	// the limit is the max download speed of the setting, so it is kept after the engine restarts and applied by reload
+	stg := setting.Get()
+	stg.MaxDownloadSpeed = payload.Limit
+
+	if err := setting.Update(stg); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	return response.Ok(ctx)
+}
+
+func (s *downloaderService) throttleEntry(ctx *fiber.Ctx) error {
+	// the limiter outlives the request, so the id mustn't share the buffer which fiber reuses
+	id := utils.CopyString(ctx.Params("id"))
+	if s.memstore.Get(id) == nil {
+		return response.NotFound(ctx)
+	}
+
+	var payload throttleRequest
+	if err := ctx.BodyParser(&payload); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	if payload.Limit < 0 {
+		return response.BadRequest(ctx, fmt.Errorf("limit can't be negative"))
+	}
+
+	downloader.SetEntryLimit(id, payload.Limit)
+
 	return response.Ok(ctx)
 }
 
 func (s *downloaderService) progressBar(c *websocket.Conn) {
-	channel := api.CreateChannel(c.Params("client"))
-	done := make(chan bool)
+	// every connection has its own subscription, so more than one window of the same client receives every event
+	options := []api.SubscribeOptions{api.WithPolicy(api.Coalesce)}
+	if ids := c.Query("ids"); ids != "" {
+		options = append(options, api.WithIDs(strings.Split(ids, ",")...))
+	}
+
+	sub := api.Subscribe(c.Params("client"), options...)
+	defer sub.Close()
+
+	done := make(chan struct{})
 
 	ping := time.NewTicker(time.Second)
+	defer ping.Stop()
 
 	go func() {
+		defer close(done)
+
 		for {
 			t, _, err := c.ReadMessage()
 			if err != nil {
@@ -232,7 +623,7 @@ func (s *downloaderService) progressBar(c *websocket.Conn) {
 			}
 
 			if t == websocket.CloseMessage {
-				done <- true
+				return
 			}
 		}
 	}()
@@ -241,7 +632,7 @@ func (s *downloaderService) progressBar(c *websocket.Conn) {
 		select {
 		case <-done:
 			return
-		case data, ok := <-channel.Subscribe():
+		case data, ok := <-sub.Events():
 			if !ok {
 				return
 			}
@@ -272,6 +663,16 @@ func (s *downloaderService) CreateRoutes() {
 	s.app.Add("PUT", "/:client/resume/:id", s.resume)
 	s.app.Add("PUT", "/pause/:id", s.pause)
 	s.app.Add("PUT", "/stop/:id", s.stop)
+	s.app.Add("GET", "/queue", s.getQueue)
+	s.app.Add("PUT", "/queue/:id", s.moveQueue)
+	s.app.Add("DELETE", "/queue/:id", s.removeQueue)
+	s.app.Add("GET", "/throttle", s.getThrottle)
+	s.app.Add("PUT", "/throttle", s.throttle)
+	s.app.Add("PUT", "/throttle/:id", s.throttleEntry)
+	s.app.Add("GET", "/schedules", s.getSchedules)
+	s.app.Add("GET", "/schedules/:id", s.getSchedule)
+	s.app.Add("PUT", "/schedules/:id", s.setSchedule)
+	s.app.Add("DELETE", "/schedules/:id", s.removeSchedule)
 	s.app.Add("GET", "/ws/:client", websocket.New(s.progressBar))
 }
 
@@ -0,0 +1,405 @@
+package api
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rapid-downloader/rapid/downloader"
+	"github.com/rapid-downloader/rapid/entry"
+	entryApi "github.com/rapid-downloader/rapid/entry/api"
+	"github.com/rapid-downloader/rapid/queue"
+	"github.com/rapid-downloader/rapid/scheduler"
+	"github.com/rapid-downloader/rapid/setting"
+	"go.etcd.io/bbolt"
+)
+
+// testService creates the service on a db of its own, with the setting in a home of its own
+func testService(t *testing.T) *downloaderService {
+	t.Setenv("HOME", t.TempDir())
+
+	database, err := bbolt.Open(filepath.Join(t.TempDir(), "entries.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Error opening db:", err.Error())
+	}
+
+	t.Cleanup(func() { database.Close() })
+
+	resolve := func() *bbolt.DB { return database }
+
+	s := &downloaderService{
+		app:      fiber.New(),
+		memstore: entry.Memstore(),
+		store:    entryApi.NewStore("download", resolve),
+	}
+
+	if s.queue, err = queue.New(resolve, "queue", setting.Get().MaxConcurrentDownload, s.run); err != nil {
+		t.Fatal("Error creating queue:", err.Error())
+	}
+
+	if s.scheduler, err = scheduler.New(resolve, "schedule", &controller{s}); err != nil {
+		t.Fatal("Error creating scheduler:", err.Error())
+	}
+
+	s.queue.Start()
+	t.Cleanup(func() { s.Close() })
+
+	s.CreateRoutes()
+
+	return s
+}
+
+// add fetches the url into the memstore, and stores it as a download of the status
+func (s *downloaderService) add(t *testing.T, url, status string) entry.Entry {
+	e, err := entry.Fetch(url, entry.UseSetting(setting.Get()))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if err := s.memstore.Set(e.ID(), e); err != nil {
+		t.Fatal("Error inserting into memstore:", err.Error())
+	}
+
+	if err := s.store.Create(e.ID(), entryApi.Download{ID: e.ID(), Name: e.Name(), Status: status}); err != nil {
+		t.Fatal("Error creating download:", err.Error())
+	}
+
+	return e
+}
+
+// await waits until the download moves into the status
+func (s *downloaderService) await(t *testing.T, id, status string) {
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		download := s.store.Get(id)
+		if download != nil && download.Status == status {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the download to be %s, but got %v", status, download)
+		}
+	}
+}
+
+func TestRestartCanceled(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+	e := s.add(t, server.URL+"/file.bin", entry.Downloading)
+
+	res, err := s.app.Test(httptest.NewRequest("PUT", "/stop/"+e.ID(), nil))
+	if err != nil || res.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected the download to be canceled, but got %v (%v)", res, err)
+	}
+
+	s.await(t, e.ID(), entry.Canceled)
+
+	res, err = s.app.Test(httptest.NewRequest("PUT", "/gui/restart/"+e.ID(), nil))
+	if err != nil || res.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected the canceled download to be restarted, but got %v (%v)", res, err)
+	}
+
+	s.await(t, e.ID(), entry.Completed)
+}
+
+func TestResumeWaitsForSlot(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	release := make(chan struct{})
+
+	var blocking atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the download of the slow file holds the only slot until it is released
+		if r.URL.Path == "/slow.bin" && blocking.Load() {
+			select {
+			case <-release:
+			case <-time.After(10 * time.Second):
+			}
+		}
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+	s.queue.SetLimit(1)
+
+	slow := s.add(t, server.URL+"/slow.bin", entry.Queued)
+	paused := s.add(t, server.URL+"/file.bin", entry.Paused)
+
+	blocking.Store(true)
+
+	if err := s.queue.Push(queue.Item{ID: slow.ID(), Client: entryApi.ClientGUI}); err != nil {
+		t.Fatal("Error pushing into queue:", err.Error())
+	}
+
+	s.await(t, slow.ID(), entry.Downloading)
+
+	res, err := s.app.Test(httptest.NewRequest("PUT", "/gui/resume/"+paused.ID(), nil))
+	if err != nil || res.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected the download to be resumed, but got %v (%v)", res, err)
+	}
+
+	if queued := s.queue.Queued(); len(queued) != 1 || queued[0].ID != paused.ID() || !queued[0].Resume {
+		t.Errorf("Expected the resumed download to wait for the slot, but got %v", queued)
+	}
+
+	if download := s.store.Get(paused.ID()); download.Status != entry.Queued {
+		t.Errorf("Expected the resumed download to be queued, but got %s", download.Status)
+	}
+
+	close(release)
+
+	s.await(t, slow.ID(), entry.Completed)
+	s.await(t, paused.ID(), entry.Completed)
+}
+
+func TestThrottle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(make([]byte, 1000)))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+	e := s.add(t, server.URL+"/file.bin", entry.Paused)
+
+	defer downloader.RemoveEntryLimit(e.ID())
+	defer downloader.SetGlobalLimit(0)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "global", path: "/throttle", body: `{"limit":1048576}`, status: fiber.StatusOK},
+		{name: "negative global", path: "/throttle", body: `{"limit":-1}`, status: fiber.StatusBadRequest},
+		{name: "known download", path: "/throttle/" + e.ID(), body: `{"limit":524288}`, status: fiber.StatusOK},
+		{name: "unknown download", path: "/throttle/unknown", body: `{"limit":524288}`, status: fiber.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", test.path, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			res, err := s.app.Test(req)
+			if err != nil || res.StatusCode != test.status {
+				t.Fatalf("Expected status %d, but got %v (%v)", test.status, res, err)
+			}
+		})
+	}
+
+	if speed := setting.Get().MaxDownloadSpeed; speed != 1048576 {
+		t.Errorf("Expected the global limit to be saved into the setting, but got %d", speed)
+	}
+
+	if limits := downloader.EntryLimits(); len(limits) != 1 || limits[e.ID()] != 524288 {
+		t.Errorf("Expected only the known download to be limited, but got %v", limits)
+	}
+}
+
+func TestDownloadContinuedOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(make([]byte, 1000)))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+
+	for _, status := range []string{entry.Paused, entry.Failed, entry.Canceled} {
+		e := s.add(t, server.URL+"/file.bin", status)
+
+		res, err := s.app.Test(httptest.NewRequest("GET", "/gui/download/"+e.ID(), nil))
+		if err != nil || res.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected the %s download to be refused, but got %v (%v)", status, res, err)
+		}
+
+		if download := s.store.Get(e.ID()); download.Status != status {
+			t.Errorf("Expected the refused download to stay %s, but got %s", status, download.Status)
+		}
+	}
+}
+
+func TestRestoreQueued(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+
+	// e.g removed from the queue before it is started, so it's resumed without any chunk
+	queued := s.add(t, server.URL+"/queued.bin", entry.Queued)
+	paused := s.add(t, server.URL+"/paused.bin", entry.Paused)
+
+	s.persist(queued)
+	s.persist(paused)
+
+	// the engine restarts before the downloads get a slot
+	s.memstore = entry.Memstore()
+	s.restore(setting.Get())
+
+	for _, item := range []queue.Item{{ID: queued.ID(), Client: entryApi.ClientGUI}, {ID: paused.ID(), Client: entryApi.ClientGUI, Resume: true}} {
+		if s.memstore.Get(item.ID) == nil {
+			t.Fatal("Expected the waiting entry to be restored")
+		}
+
+		if err := s.queue.Push(item); err != nil {
+			t.Fatal("Error pushing into queue:", err.Error())
+		}
+
+		s.await(t, item.ID, entry.Completed)
+	}
+
+	for _, e := range []entry.Entry{queued, paused} {
+		if result, err := os.ReadFile(e.Location()); err != nil || !bytes.Equal(result, content) {
+			t.Errorf("Expected %s to be the served content (%v)", e.Name(), err)
+		}
+	}
+}
+
+func TestDropDeleted(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	release := make(chan struct{})
+	defer close(release)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the running download only gets its chunks once the test ends
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+	s.queue.SetLimit(1)
+
+	running := s.add(t, server.URL+"/running.bin", entry.Queued)
+	waiting := s.add(t, server.URL+"/waiting.bin", entry.Queued)
+
+	for _, e := range []entry.Entry{running, waiting} {
+		if err := s.queue.Push(queue.Item{ID: e.ID(), Client: entryApi.ClientGUI}); err != nil {
+			t.Fatal("Error pushing into queue:", err.Error())
+		}
+	}
+
+	s.await(t, running.ID(), entry.Downloading)
+
+	s.drop(waiting.ID())
+
+	if queued := s.queue.Queued(); len(queued) != 0 {
+		t.Errorf("Expected the deleted download to leave the queue, but got %v", queued)
+	}
+
+	s.drop(running.ID())
+
+	if running.Context().Err() == nil {
+		t.Error("Expected the deleted download to be stopped")
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); len(s.queue.Active()) > 0; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the deleted download to release its slot, but got %v", s.queue.Active())
+		}
+	}
+
+	if s.memstore.Get(running.ID()) != nil || s.memstore.Get(waiting.ID()) != nil {
+		t.Error("Expected the deleted entries to leave the memstore")
+	}
+}
+
+func TestResumeStoresEntry(t *testing.T) {
+	var mutex sync.Mutex
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	etag := `"v1"`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		sum := md5.Sum(content)
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+	e := s.add(t, server.URL+"/file.bin", entry.Paused)
+
+	// the file changes while the download is paused, so it starts over on the new one
+	mutex.Lock()
+	content = bytes.Repeat([]byte("abcdefghij"), 50)
+	etag = `"v2"`
+	mutex.Unlock()
+
+	if err := s.queue.Push(queue.Item{ID: e.ID(), Client: entryApi.ClientGUI, Resume: true}); err != nil {
+		t.Fatal("Error pushing into queue:", err.Error())
+	}
+
+	s.await(t, e.ID(), entry.Completed)
+
+	sum := md5.Sum(content)
+	if download := s.store.Get(e.ID()); download.Size != 500 || download.ExpectedChecksum != "md5:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the new file to be stored, but got %d bytes and checksum %s", download.Size, download.ExpectedChecksum)
+	}
+}
+
+func TestRestartStoresValidators(t *testing.T) {
+	var mutex sync.Mutex
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	etag := `"v1"`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testService(t)
+	e := s.add(t, server.URL+"/file.bin", entry.Failed)
+
+	mutex.Lock()
+	content = bytes.Repeat([]byte("abcdefghij"), 50)
+	etag = `"v2"`
+	mutex.Unlock()
+
+	if err := s.queue.Push(queue.Item{ID: e.ID(), Client: entryApi.ClientGUI, Restart: true}); err != nil {
+		t.Fatal("Error pushing into queue:", err.Error())
+	}
+
+	s.await(t, e.ID(), entry.Completed)
+
+	if download := s.store.Get(e.ID()); download.Size != 500 || download.ETag != `"v2"` {
+		t.Errorf("Expected the validators of the new file to be stored, but got %d bytes and etag %s", download.Size, download.ETag)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"github.com/rapid-downloader/rapid/entry"
+	entryApi "github.com/rapid-downloader/rapid/entry/api"
+	"github.com/rapid-downloader/rapid/hook"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// runHooks performs the configured hooks on the completed download, and attaches their results to the download record
+func (s *downloaderService) runHooks(e entry.Entry) {
+	hooks := setting.Get().Hooks
+	if len(hooks) == 0 {
+		return
+	}
+
+	results, path := hook.Run(hooks, hook.File{
+		ID:   e.ID(),
+		Name: e.Name(),
+		Path: e.Location(),
+		Type: e.Type(),
+		URL:  e.URL(),
+	})
+
+	if len(results) == 0 {
+		return
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			log.Printf("hook %s (%s) failed on %s: %s", result.Hook, result.Action, e.Name(), result.Error)
+			continue
+		}
+
+		log.Printf("hook %s (%s) ran on %s: %s", result.Hook, result.Action, e.Name(), result.Output)
+	}
+
+	update := entryApi.UpdateDownload{
+		Hooks: results,
+	}
+
+	if path != e.Location() {
+		update.Location = &path
+	}
+
+	if err := s.store.Update(e.ID(), update); err != nil {
+		log.Println("error storing hook results:", err.Error())
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/rapid-downloader/rapid/api"
+	rapidClient "github.com/rapid-downloader/rapid/client"
+	"github.com/rapid-downloader/rapid/entry"
+	entryApi "github.com/rapid-downloader/rapid/entry/api"
+)
+
+// transition moves the download into the status, persists it, and broadcasts it to the client which runs the download.
+// It returns entry.ErrIllegalTransition if the download can't move from its current status
+func (s *downloaderService) transition(id string, status string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	download := s.store.Get(id)
+	if download == nil {
+		return fmt.Errorf("download %s is not found", id)
+	}
+
+	if err := entry.Transition(download.Status, status); err != nil {
+		return err
+	}
+
+	update := entryApi.UpdateDownload{
+		Status: &status,
+	}
+
+	progress := download.Progress
+	if status == entry.Completed {
+		progress = 100
+		speed := float64(0)
+		timeLeft := float64(0)
+
+		update.Progress = &progress
+		update.Speed = &speed
+		update.TimeLeft = &timeLeft
+	}
+
+	if err := s.store.Update(id, update); err != nil {
+		return fmt.Errorf("error updating download status:%s", err.Error())
+	}
+
+	if client, ok := s.clients.Load(id); ok {
+		api.Publish(client.(string), rapidClient.Progress{
+			ID:       id,
+			Done:     status == entry.Completed,
+			Status:   status,
+			Progress: progress,
+		})
+	}
+
+	return nil
+}
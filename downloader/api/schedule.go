@@ -0,0 +1,164 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rapid-downloader/rapid/entry"
+	entryApi "github.com/rapid-downloader/rapid/entry/api"
+	response "github.com/rapid-downloader/rapid/helper"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/queue"
+	"github.com/rapid-downloader/rapid/scheduler"
+)
+
+// controller starts and pauses the scheduled downloads for the scheduler
+type controller struct {
+	s *downloaderService
+}
+
+// Start pushes the download into the queue, where a paused or failed download is resumed once it gets a slot
+func (c *controller) Start(schedule scheduler.Schedule) error {
+	download := c.s.store.Get(schedule.ID)
+	if download == nil || c.s.memstore.Get(schedule.ID) == nil {
+		return scheduler.ErrGone
+	}
+
+	switch download.Status {
+	case entry.Downloading:
+		return nil
+	case entry.Queued, entry.Paused, entry.Failed:
+	default:
+		// finished, or it needs the user to act, e.g an expired link
+		return scheduler.ErrGone
+	}
+
+	client := schedule.Client
+	if client == "" {
+		client = entryApi.ClientGUI
+	}
+
+	c.s.clients.Store(schedule.ID, client)
+
+	err := c.s.queue.Push(queue.Item{
+		ID:     schedule.ID,
+		Client: client,
+	})
+
+	if err != nil && !errors.Is(err, queue.ErrDuplicate) {
+		return err
+	}
+
+	return nil
+}
+
+// Pause stops the running download, or takes it out of the queue if it still waits for a slot
+func (c *controller) Pause(id string) error {
+	download := c.s.store.Get(id)
+	if download == nil {
+		return scheduler.ErrGone
+	}
+
+	switch download.Status {
+	case entry.Queued:
+		c.s.queue.Remove(id)
+		return nil
+	case entry.Downloading:
+	case entry.Completed, entry.Canceled:
+		return scheduler.ErrGone
+	default:
+		return nil
+	}
+
+	e := c.s.memstore.Get(id)
+	if e == nil {
+		return scheduler.ErrGone
+	}
+
+	if err := c.s.transition(id, entry.Paused); err != nil {
+		return err
+	}
+
+	c.s.queue.Remove(id)
+
+	return c.s.stopDownload(e)
+}
+
+func (c *controller) Hold() []scheduler.Schedule {
+	c.s.held.Store(true)
+	c.s.queue.Stop()
+
+	running := make([]scheduler.Schedule, 0)
+	for _, item := range c.s.queue.Active() {
+		running = append(running, scheduler.Schedule{
+			ID:     item.ID,
+			Client: item.Client,
+		})
+	}
+
+	return running
+}
+
+func (c *controller) Release() {
+	c.s.held.Store(false)
+	c.s.queue.Start()
+}
+
+func (s *downloaderService) getSchedules(ctx *fiber.Ctx) error {
+	return response.Ok(ctx, s.scheduler.All())
+}
+
+func (s *downloaderService) getSchedule(ctx *fiber.Ctx) error {
+	schedule := s.scheduler.Get(ctx.Params("id"))
+	if schedule == nil {
+		return response.Success(ctx, fiber.StatusNoContent)
+	}
+
+	return response.Ok(ctx, schedule)
+}
+
+func (s *downloaderService) setSchedule(ctx *fiber.Ctx) error {
+	var schedule scheduler.Schedule
+	if err := ctx.BodyParser(&schedule); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	schedule.ID = ctx.Params("id")
+	if s.store.Get(schedule.ID) == nil {
+		return response.NotFound(ctx)
+	}
+
+	if schedule.Start == nil && schedule.Window == nil {
+		return response.BadRequest(ctx, fmt.Errorf("schedule needs either a start time or a window"))
+	}
+
+	if err := s.scheduler.Set(schedule); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	return response.Ok(ctx, schedule)
+}
+
+// removeSchedule removes the schedule, and starts the download if it was waiting for its schedule
+func (s *downloaderService) removeSchedule(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+
+	schedule := s.scheduler.Get(id)
+	if schedule == nil {
+		return response.NotFound(ctx)
+	}
+
+	if err := s.scheduler.Remove(id); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	if download := s.store.Get(id); download != nil && download.Status == entry.Queued {
+		c := controller{s}
+		if err := c.Start(*schedule); err != nil {
+			log.Println("error starting unscheduled download", id, ":", err.Error())
+		}
+	}
+
+	return response.Ok(ctx)
+}
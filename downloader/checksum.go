@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rapid-downloader/rapid/entry"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksum hashes the downloaded file. It uses the algorithm of the expected checksum if present, otherwise sha256. A folder has
+// no checksum
+func checksum(e entry.Entry) (string, error) {
+	algorithm := entry.SHA256
+	if e.Checksum() != "" {
+		expected, _, err := entry.ParseChecksum(e.Checksum())
+		if err != nil {
+			return "", err
+		}
+
+		algorithm = expected
+	}
+
+	h, err := entry.NewHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(e.Location())
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	// a folder, e.g of a torrent of many files, has no checksum
+	if stat, err := file.Stat(); err == nil && stat.IsDir() {
+		return "", nil
+	}
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%s", algorithm, hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// verify hashes the downloaded file and compares it with the expected checksum of the entry
+func verify(e entry.Entry) (string, error) {
+	digest, err := checksum(e)
+	if err != nil {
+		return "", err
+	}
+
+	if e.Checksum() != "" && e.Checksum() != digest {
+		return digest, fmt.Errorf("%w: expected %s, but got %s", ErrChecksumMismatch, e.Checksum(), digest)
+	}
+
+	return digest, nil
+}
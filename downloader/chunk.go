@@ -10,51 +10,30 @@ import (
 	"sync"
 	"time"
 
-	"github.com/rapid-downloader/rapid/client"
 	"github.com/rapid-downloader/rapid/entry"
 	"github.com/rapid-downloader/rapid/log"
 	"github.com/rapid-downloader/rapid/setting"
 )
 
-type progress struct {
-	onprogress OnProgress
-	reader     io.ReadCloser
-	index      int
-	chunkSize  int64
-	prog       *client.Progress
-}
-
-func (r *progress) Read(payload []byte) (n int, err error) {
-	n, err = r.reader.Read(payload)
-	if err != nil {
-		return n, err
-	}
-
-	r.prog.Chunks[r.index].Downloaded += int64(n)
-	r.prog.Chunks[r.index].Progress = float64(100*r.prog.Chunks[r.index].Downloaded) / float64(r.prog.Chunks[r.index].Size)
-
-	if r.onprogress != nil {
-		r.onprogress(r.prog)
-	}
-
-	return n, err
-}
-
-func (r *progress) Close() error {
-	return r.reader.Close()
-}
+// opener opens the body of the chunk from its current position
+type opener func(c *chunk, ctx context.Context) (io.ReadCloser, error)
 
 type chunk struct {
 	entry      entry.Entry
 	setting    *setting.Setting
 	wg         *sync.WaitGroup
+	tracker    *tracker
+	storage    storage
+	open       opener
 	path       string
 	index      int
 	start      int64
-	end        int64
-	size       int64
-	onprogress OnProgress
-	prog       *client.Progress
+	end        int64 // guarded by tracker, since it changes when the chunk is split
+	downloaded int64 // guarded by tracker
+	started    bool  // guarded by tracker
+	finished   bool  // guarded by tracker
+	source     int   // guarded by tracker, since it changes when the chunk fails over to another mirror
+	onfinish   func()
 }
 
 func calculatePosition(entry entry.Entry, chunkSize int64, index int) (int64, int64) {
@@ -72,13 +51,19 @@ func calculatePosition(entry entry.Entry, chunkSize int64, index int) (int64, in
 	return start, end
 }
 
-func resumePosition(location string) int64 {
+// resumePosition returns how many bytes of the chunk file can be kept. The recorded value from the manifest is trusted
+// over the file size, since bytes after it may not be completely written. Negative recorded value means it is unknown
+func resumePosition(location string, recorded int64) int64 {
 	file, err := os.Stat(location)
 	if err != nil {
 		return 0
 	}
 
 	resumePos := file.Size()
+	if recorded >= 0 && recorded < resumePos {
+		resumePos = recorded
+	}
+
 	if err := os.Truncate(location, resumePos); err != nil {
 		return 0
 	}
@@ -86,126 +71,217 @@ func resumePosition(location string) int64 {
 	return resumePos
 }
 
-func newChunk(entry entry.Entry, index int, setting *setting.Setting, progress *client.Progress, wg *sync.WaitGroup) *chunk {
-	chunkSize := entry.Size() / int64(entry.ChunkLen())
-	start, end := calculatePosition(entry, chunkSize, index)
-
-	progress.Chunks[index].Size = chunkSize
+func chunkPath(entry entry.Entry, setting *setting.Setting, index int) string {
+	return filepath.Join(setting.DownloadLocation, fmt.Sprintf("%s-%d", entry.ID(), index))
+}
 
+func newChunk(entry entry.Entry, index int, start, end int64, setting *setting.Setting, wg *sync.WaitGroup) *chunk {
 	return &chunk{
-		path:       filepath.Join(setting.DownloadLocation, fmt.Sprintf("%s-%d", entry.ID(), index)),
-		entry:      entry,
-		setting:    setting,
-		wg:         wg,
-		index:      index,
-		start:      start,
-		end:        end,
-		size:       chunkSize,
-		prog:       progress,
-		onprogress: nil,
+		path:    chunkPath(entry, setting, index),
+		entry:   entry,
+		setting: setting,
+		wg:      wg,
+		index:   index,
+		start:   start,
+		end:     end,
+	}
+}
+
+// length returns the size of the chunk range. The end of the last chunk may go past the file size, so it is clamped
+func (c *chunk) length() int64 {
+	if c.end == -1 {
+		return c.entry.Size()
+	}
+
+	end := c.end
+	if size := c.entry.Size(); end >= size {
+		end = size - 1
+	}
+
+	return end - c.start + 1
+}
+
+// restore keeps the bytes of the chunk which the previous attempt has written. A chunk which is done is not requested again,
+// since the range after its end is refused by the server
+func (c *chunk) restore(downloaded int64, done bool) {
+	c.downloaded = downloaded
+
+	if c.end != -1 && (done || downloaded >= c.length()) {
+		c.started = true
+		c.finished = true
 	}
 }
 
 func (c *chunk) download(ctx context.Context) error {
-	defer c.wg.Done()
 	start := time.Now()
 
-	srcFile, err := c.getDownloadFile(ctx, c.prog)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	guard := newWatchdog(time.Duration(c.setting.StallTimeout)*time.Second, cancel)
+	defer guard.stop()
+
+	c.tracker.start(c)
+
+	srcFile, err := c.open(c, ctx)
 	if err != nil {
 		log.Println("error fetching chunk file:", err.Error())
-		return err
+		return guard.err(err)
 	}
 	defer srcFile.Close()
 
 	dstFile, err := c.getSaveFile()
 	if err != nil {
 		log.Println("error creating temp file for chunk:", err.Error())
-		return err
+		return permanent(err)
 	}
 	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
-	if err != nil {
+	// only the time spent waiting for the source counts as a stall, not the time spent waiting for the limiters
+	if err := c.copy(dstFile, throttle(ctx, c.entry.ID(), guard.watch(srcFile), guard)); err != nil {
 		log.Println("error downloading chunk:", err.Error())
-		return err
+		return guard.err(err)
 	}
 
-	c.prog.Chunks[c.index].Done = true
-	c.prog.Chunks[c.index].Downloaded = c.size
-	c.prog.Chunks[c.index].Progress = 100
-
-	c.onprogress(c.prog)
+	c.tracker.done(c)
 
 	elapsed := time.Since(start)
 	log.Println("chunk", c.index, "downloaded in", elapsed.Seconds(), "s")
 
+	if c.onfinish != nil {
+		c.onfinish()
+	}
+
 	return nil
 }
 
+// copy writes the body into the chunk file until the end of the chunk, which may move closer while copying when the chunk is split
+func (c *chunk) copy(dst io.Writer, src io.Reader) error {
+	buffer := make([]byte, 32*1024)
+
+	for {
+		remaining := c.tracker.remaining(c)
+		if remaining == 0 {
+			return nil
+		}
+
+		payload := buffer
+		if remaining > 0 && remaining < int64(len(payload)) {
+			payload = payload[:remaining]
+		}
+
+		n, err := src.Read(payload)
+		if n > 0 {
+			keep := c.tracker.advance(c, n)
+			if _, werr := dst.Write(payload[:keep]); werr != nil {
+				return permanent(werr)
+			}
+		}
+
+		if err == io.EOF {
+			if remaining := c.tracker.remaining(c); remaining > 0 {
+				return fmt.Errorf("%w: %d bytes of chunk %d are missing", io.ErrUnexpectedEOF, remaining, c.index)
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Execute downloads the chunk. The wait group is only released once the chunk succeeds or OnError gives up retrying it
 func (c *chunk) Execute(ctx context.Context) error {
-	return c.download(ctx)
+	err := c.download(ctx)
+	if err == nil {
+		c.wg.Done()
+	}
+
+	return err
 }
 
+// OnError retries the chunk with backoff until it succeeds, the error is permanent or the retries run out.
+// A chunk that fails for good fails the whole download
 func (c *chunk) OnError(ctx context.Context, err error) {
-	if c.entry.Context().Err() != nil {
-		return
-	}
+	defer c.wg.Done()
 
-	var e error
-	for i := 0; i < c.setting.MaxRetry; i++ {
-		c.wg.Add(1)
-		log.Println("error downloading file:", err.Error(), ". Retrying...")
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			return
+		}
 
-		if c.entry.Resumable() {
-			c.start += resumePosition(c.path)
+		if !retryable(err) || attempt >= c.setting.MaxRetry {
+			log.Println("error downloading chunk", c.index, ":", err.Error())
+			c.tracker.fail(c, err)
+			return
 		}
 
-		if e = c.download(ctx); e == nil {
+		delay := backoff(attempt, err)
+		log.Println("error downloading chunk", c.index, ":", err.Error(), ". Retrying in", delay.String(), "...")
+
+		c.tracker.failover(c)
+		if !sleep(ctx, delay) {
 			return
 		}
-	}
 
-	log.Println("error downloading file:", err.Error())
-}
+		// the chunk can only continue where it left off if the server supports range
+		if !c.entry.Resumable() || c.end == -1 {
+			c.tracker.reset(c)
+		}
 
-func (c *chunk) onProgress(onprogress OnProgress) {
-	c.onprogress = onprogress
+		if err = c.download(ctx); err == nil {
+			return
+		}
+	}
 }
 
-func (c *chunk) getDownloadFile(ctx context.Context, prog *client.Progress) (io.ReadCloser, error) {
-	req := c.entry.(entry.RequestClient).Request().Clone(ctx)
+func (c *chunk) getDownloadFile(ctx context.Context) (io.ReadCloser, error) {
+	source, primary := c.tracker.request(c)
+	req := source.Clone(ctx)
 
-	if c.start != -1 && c.end != -1 {
-		bytesRange := fmt.Sprintf("bytes=%d-%d", c.start, c.end)
+	from, to := c.tracker.position(c)
+	if to != -1 {
+		bytesRange := fmt.Sprintf("bytes=%d-%d", from, to)
 		req.Header.Add("Range", bytesRange)
 
-		log.Println("downloading chunk", c.index, "from", c.start, "to", c.end, fmt.Sprintf("(~%d MB)", (c.end-c.start)/(1024*1024)))
+		// mirrors have validators of their own
+		if primary {
+			conditional(c.entry, req)
+		}
+
+		log.Println("downloading chunk", c.index, "from", from, "to", to, fmt.Sprintf("(~%d MB)", (to-from)/(1024*1024)))
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := httpClient(c.entry, c.setting).Do(req)
 	if err != nil {
 		log.Println("error fething chunk body:", err.Error())
 		return nil, err
 	}
 
-	progressBar := &progress{
-		onprogress: c.onprogress,
-		reader:     res.Body,
-		index:      c.index,
-		chunkSize:  c.size,
-		prog:       prog,
+	if res.StatusCode >= http.StatusBadRequest {
+		res.Body.Close()
+		return nil, newStatusError(res)
 	}
 
-	return progressBar, nil
-}
+	// neither a retry nor another mirror helps once the server doesn't serve the requested bytes
+	if primary && changedResponse(c.entry, res) {
+		err = fmt.Errorf("%w: validators of %s don't match", entry.ErrChanged, req.URL.Host)
+	} else if to != -1 {
+		err = validateRange(res, from, to, c.entry.Size())
+	} else if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
 
-func (c *chunk) getSaveFile() (io.WriteCloser, error) {
-	tmpFilename := filepath.Join(c.setting.DownloadLocation, fmt.Sprintf("%s-%d", c.entry.ID(), c.index))
-	file, err := os.OpenFile(tmpFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Println("error creating or appending file:", err.Error())
-		return nil, err
+		res.Body.Close()
+		return nil, permanent(err)
 	}
 
-	return file, nil
+	return res.Body, nil
+}
+
+func (c *chunk) getSaveFile() (io.WriteCloser, error) {
+	return c.storage.open(c)
 }
@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+)
+
+// maxRestarts bounds how many times a download starts over because the remote file has changed, e.g on a server which etag changes on every request
+const maxRestarts = 3
+
+func changed(err error) bool {
+	return errors.Is(err, entry.ErrChanged)
+}
+
+// renew gives a stopped entry, e.g a canceled one which is downloaded again, a new context. A running entry keeps its own,
+// so a download which starts over on its own can still be stopped
+func renew(e entry.Entry) error {
+	if e.Context().Err() == nil {
+		return nil
+	}
+
+	if err := e.Refresh(); err != nil && !changed(err) {
+		return err
+	}
+
+	return nil
+}
+
+// conditional sends If-Range on the chunk request, so the server sends the whole file instead of a range of another version
+func conditional(e entry.Entry, req *http.Request) {
+	client, ok := e.(entry.ConditionalClient)
+	if !ok {
+		return
+	}
+
+	if validator := entry.IfRange(client); validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+}
+
+// changedResponse tells whether the chunk response serves another version of the file than the one which was fetched
+func changedResponse(e entry.Entry, res *http.Response) bool {
+	client, ok := e.(entry.ConditionalClient)
+	return ok && entry.Changed(client, res)
+}
+
+// restart refreshes the entry when the download failed because the remote file has changed, so it can be downloaded from scratch.
+// It returns false if it doesn't apply, or the download has started over too many times
+func (dl *localDownloader) restart(e entry.Entry, err error) bool {
+	if !changed(err) || e.Context().Err() != nil {
+		return false
+	}
+
+	count, _ := dl.restarts.LoadOrStore(e.ID(), 0)
+	if count.(int) >= maxRestarts {
+		dl.restarts.Delete(e.ID())
+		return false
+	}
+
+	dl.restarts.Store(e.ID(), count.(int)+1)
+
+	log.Println("remote file of", e.Name(), "has changed. Restarting...")
+
+	if err := e.Refresh(); err != nil && !changed(err) {
+		log.Println("error refreshing", e.Name(), ":", err.Error())
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/fs"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network/dash"
+)
+
+type (
+	// dashDownloader downloads the segments of the video and the audio track as chunks, so both tracks are downloaded in parallel
+	dashDownloader struct {
+		*streamDownloader
+	}
+
+	// trackStorage writes the segments into temp files, and combines the segments of every track into its own file. The muxer,
+	// if there is one, merges the tracks into the entry file
+	trackStorage struct {
+		tempStorage
+		muxer string
+	}
+)
+
+var DASH = "dash"
+
+func newDASHDownloader(opt *option) Downloader {
+	dl := &dashDownloader{}
+	dl.streamDownloader = newStreamDownloader(opt, dl)
+	dl.open = dl.getSegment
+
+	return dl
+}
+
+func dashTracks(e entry.Entry) (entry.DASHClient, []dash.Representation, error) {
+	client, ok := e.(entry.DASHClient)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a dash entry", e.URL())
+	}
+
+	tracks, err := client.Tracks()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, tracks, nil
+}
+
+func (dl *dashDownloader) segments(e entry.Entry) (int, error) {
+	_, tracks, err := dashTracks(e)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, track := range tracks {
+		count += len(track.Segments)
+	}
+
+	return count, nil
+}
+
+func (dl *dashDownloader) storage(e entry.Entry) storage {
+	return &trackStorage{muxer: dl.setting.Muxer}
+}
+
+func (dl *dashDownloader) release(e entry.Entry) {}
+
+// getSegment opens the segment of the chunk, which index counts the segments of the video track before the ones of the audio track
+func (dl *dashDownloader) getSegment(c *chunk, ctx context.Context) (io.ReadCloser, error) {
+	_, tracks, err := dashTracks(c.entry)
+	if err != nil {
+		return nil, permanent(err)
+	}
+
+	index := c.index
+	for _, track := range tracks {
+		if index >= len(track.Segments) {
+			index -= len(track.Segments)
+			continue
+		}
+
+		segment := track.Segments[index]
+
+		res, err := openSegment(ctx, c, segment.URL, segment.Offset, segment.Length)
+		if err != nil {
+			return nil, err
+		}
+
+		return res.Body, nil
+	}
+
+	return nil, permanent(fmt.Errorf("segment %d is not in the tracks", c.index))
+}
+
+func (s *trackStorage) combine(e entry.Entry, chunks []*chunk) error {
+	client, tracks, err := dashTracks(e)
+	if err != nil {
+		return err
+	}
+
+	// the tracks are muxed from temp files next to the entry file, so they are moved rather than copied if the muxer fails
+	muxed := s.muxer != "" && len(tracks) == 2
+	locations := make(map[string]string)
+
+	for _, track := range tracks {
+		if len(chunks) < len(track.Segments) {
+			return fmt.Errorf("error combining chunks:%s track has %d segments, but %d chunks are left", track.Type, len(track.Segments), len(chunks))
+		}
+
+		location := client.TrackLocation(track)
+		if muxed {
+			location = filepath.Join(filepath.Dir(e.Location()), fmt.Sprintf("%s-%s%s", e.ID(), track.Type, track.Extension()))
+		}
+
+		if err := mergeChunks(location, chunks[:len(track.Segments)]); err != nil {
+			return err
+		}
+
+		chunks = chunks[len(track.Segments):]
+		locations[track.Type] = location
+	}
+
+	if !muxed {
+		return nil
+	}
+
+	if err := mux(e.Context(), s.muxer, locations[dash.Video], locations[dash.Audio], e.Location()); err != nil {
+		log.Println("error muxing", e.Name(), ":", err.Error(), ". Keeping the tracks in separate files...")
+
+		for _, track := range tracks {
+			if err := fs.Move(locations[track.Type], client.TrackLocation(track)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, location := range locations {
+		os.Remove(location)
+	}
+
+	return nil
+}
+
+// mux runs the muxer without a shell. {video}, {audio} and {output} in the arguments are replaced by their paths
+func mux(ctx context.Context, muxer, video, audio, output string) error {
+	args := strings.Fields(muxer)
+	if len(args) == 0 {
+		return fmt.Errorf("muxer is empty")
+	}
+
+	replacer := strings.NewReplacer("{video}", video, "{audio}", audio, "{output}", output)
+	for i, arg := range args {
+		args[i] = replacer.Replace(arg)
+	}
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running %s: %s %s", args[0], err.Error(), strings.TrimSpace(string(out)))
+	}
+
+	if _, err := os.Stat(output); err != nil {
+		return fmt.Errorf("error running %s: %s", args[0], err.Error())
+	}
+
+	return nil
+}
+
+func init() {
+	registerDownloader(DASH, newDASHDownloader)
+}
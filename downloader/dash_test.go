@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/entry"
+)
+
+// dashServer serves an mpd of a video track of an initialization section and two segments, and an audio track of two byte
+// ranges of a single file. It returns the content of both tracks
+func dashServer() (*httptest.Server, []byte, []byte) {
+	video := [][]byte{content(500, 1), content(40000, 2), content(30000, 3)}
+	audio := content(9000, 4)
+
+	files := map[string][]byte{
+		"/movie/v/init.mp4": video[0],
+		"/movie/v/1.m4s":    video[1],
+		"/movie/v/2.m4s":    video[2],
+		"/movie/audio.mp4":  append(audio, content(100, 5)...),
+	}
+
+	mpd := `<MPD type="static" mediaPresentationDuration="PT4S"><Period>
+<AdaptationSet mimeType="video/mp4"><SegmentTemplate duration="2" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number$.m4s"/>
+<Representation id="v" bandwidth="800000" width="640" height="360"/>
+</AdaptationSet>
+<AdaptationSet mimeType="audio/mp4"><Representation id="a" bandwidth="64000"><BaseURL>audio.mp4</BaseURL>
+<SegmentList><SegmentURL mediaRange="0-4999"/><SegmentURL mediaRange="5000-8999"/></SegmentList>
+</Representation></AdaptationSet>
+</Period></MPD>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/movie/manifest.mpd" {
+			fmt.Fprint(w, mpd)
+			return
+		}
+
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeContent(w, r, filepath.Base(r.URL.Path), time.Time{}, bytes.NewReader(file))
+	}))
+
+	return server, bytes.Join(video, nil), audio
+}
+
+func TestDASHDownloadTracks(t *testing.T) {
+	server, video, audio := dashServer()
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		muxer string
+		muxed bool
+	}{
+		{name: "separate files"},
+		{name: "muxed", muxer: "cp {video} {output}", muxed: true},
+		{name: "failing muxer", muxer: "false {output}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := testSetting(t)
+			s.Muxer = test.muxer
+
+			e, err := entry.Fetch(server.URL+"/movie/manifest.mpd", entry.UseSetting(s))
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			if e.Downloader() != DASH || e.ChunkLen() != 5 {
+				t.Fatalf("Expected a dash entry of 5 segments, but got %s of %d chunks", e.Downloader(), e.ChunkLen())
+			}
+
+			if err := New(DASH, UseSetting(s)).Download(e); err != nil {
+				t.Fatal("Error downloading:", err.Error())
+			}
+
+			result, err := os.ReadFile(e.Location())
+			if err != nil {
+				t.Fatal("Error reading downloaded file:", err.Error())
+			}
+
+			if !bytes.Equal(result, video) {
+				t.Errorf("Expected the video track at the entry location, but got %d bytes", len(result))
+			}
+
+			audioLocation := strings.TrimSuffix(e.Location(), ".mp4") + ".m4a"
+			result, err = os.ReadFile(audioLocation)
+
+			if test.muxed {
+				if !os.IsNotExist(err) {
+					t.Error("Expected the audio track to be removed once it is muxed")
+				}
+			} else if !bytes.Equal(result, audio) {
+				t.Errorf("Expected the audio track next to the video, but got %d bytes (%v)", len(result), err)
+			}
+
+			files, _ := os.ReadDir(filepath.Dir(e.Location()))
+			for _, file := range files {
+				if strings.HasPrefix(file.Name(), e.ID()) {
+					t.Errorf("Expected no leftover temp file, but got %s", file.Name())
+				}
+			}
+		})
+	}
+}
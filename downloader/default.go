@@ -1,14 +1,11 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/rapid-downloader/rapid/client"
 	"github.com/rapid-downloader/rapid/entry"
 	"github.com/rapid-downloader/rapid/log"
 	"github.com/rapid-downloader/rapid/setting"
@@ -18,7 +15,10 @@ import (
 // downloader that save the result into local file
 type localDownloader struct {
 	setting    *setting.Setting
+	open       opener // how the chunks are retrieved, which is what the providers on top of it differ in
 	onprogress OnProgress
+	digests    sync.Map
+	restarts   sync.Map // how many times a download has started over since its remote file has changed
 }
 
 var Default = "default"
@@ -32,53 +32,48 @@ func newLocalDownloader(opt *option) Downloader {
 
 	return &localDownloader{
 		setting: setting,
+		open:    (*chunk).getDownloadFile,
 	}
 }
 
 func (dl *localDownloader) Download(entry entry.Entry) error {
 	start := time.Now()
 
-	if entry.Expired() {
-		return errUrlExpired
+	if err := renew(entry); err != nil {
+		return err
 	}
 
-	w, err := worker.New(entry.Context(), dl.setting.MaxChunkCount, entry.ChunkLen())
-	if err != nil {
-		log.Println("error creating worker", err.Error())
-		return err
+	if entry.Expired() {
+		return ErrUrlExpired
 	}
 
 	var wg sync.WaitGroup
-	w.Start()
-	defer w.Stop()
 
-	progress := client.Progress{
-		ID:     entry.ID(),
-		Chunks: make([]client.ChunkProgress, entry.ChunkLen()),
+	storage, err := newStorage(dl.setting.ChunkStrategy, entry)
+	if err != nil {
+		return err
 	}
 
-	chunks := make([]*chunk, entry.ChunkLen())
-	for i := 0; i < entry.ChunkLen(); i++ {
-		chunks[i] = newChunk(entry, i, dl.setting, &progress, &wg)
-
-		if dl.onprogress != nil {
-			chunks[i].onProgress(dl.onprogress)
+	// starting from scratch, so leftover of the previous attempt, including the split chunks, must not be appended
+	if recorded, err := loadManifest(dl.setting, entry); err == nil {
+		for i := range recorded.Chunks {
+			storage.discard(newChunk(entry, i, -1, -1, dl.setting, &wg))
 		}
 	}
 
-	for _, chunk := range chunks {
-		wg.Add(1)
-		w.Add(chunk)
+	chunkSize := entry.Size() / int64(entry.ChunkLen())
+	chunks := make([]*chunk, entry.ChunkLen())
+	for i := 0; i < entry.ChunkLen(); i++ {
+		from, to := calculatePosition(entry, chunkSize, i)
+		chunks[i] = newChunk(entry, i, from, to, dl.setting, &wg)
+		storage.discard(chunks[i])
 	}
 
-	wg.Wait()
-
-	if entry.Context().Err() != nil {
-		return nil
-	}
+	if err := dl.download(entry, chunks, storage, &wg); err != nil {
+		if dl.fallback(entry, err) || dl.restart(entry, err) {
+			return dl.Download(entry)
+		}
 
-	if err := dl.createFile(entry, dl.setting); err != nil {
-		log.Println("error combining chunks:", err.Error())
 		return err
 	}
 
@@ -88,17 +83,23 @@ func (dl *localDownloader) Download(entry entry.Entry) error {
 	return nil
 }
 
-var errUrlExpired = fmt.Errorf("link is expired")
+var ErrUrlExpired = fmt.Errorf("link is expired")
 
 func (dl *localDownloader) Resume(entry entry.Entry) error {
 	start := time.Now()
 
 	if entry.Expired() {
-		return errUrlExpired
+		return ErrUrlExpired
 	}
 
 	if err := entry.Refresh(); err != nil {
-		return err
+		if !changed(err) {
+			return err
+		}
+
+		// the chunks of the old file can't be combined with the new one
+		log.Println("remote file of", entry.Name(), "has changed. Restarting...")
+		return dl.Download(entry)
 	}
 
 	log.Println("resuming download", entry.Name(), "...")
@@ -108,55 +109,147 @@ func (dl *localDownloader) Resume(entry entry.Entry) error {
 		return dl.Download(entry)
 	}
 
-	worker, err := worker.New(entry.Context(), dl.setting.MaxChunkCount, entry.ChunkLen())
+	var wg sync.WaitGroup
+
+	chunks := make([]*chunk, 0)
+
+	recorded, err := loadManifest(dl.setting, entry)
+	if err == nil && len(recorded.Chunks) > 0 {
+		storage, err := newStorage(recorded.Storage, entry)
+		if err != nil {
+			return err
+		}
+
+		// the manifest knows the ranges, including the ones which were split
+		for i, state := range recorded.Chunks {
+			chunk := newChunk(entry, i, state.Start, state.End, dl.setting, &wg)
+			written := storage.written(chunk, state.Downloaded)
+			chunk.restore(written, state.Done && written == state.Downloaded)
+
+			chunks = append(chunks, chunk)
+		}
+
+		return dl.resumed(entry, start, dl.download(entry, chunks, storage, &wg))
+	}
+
+	log.Println("manifest of", entry.Name(), "is not found. Resuming from chunk files...")
+
+	// only the temp files can tell how many bytes has been downloaded without manifest
+	storage, _ := newStorage(TempFile, entry)
+
+	chunkSize := entry.Size() / int64(entry.ChunkLen())
+	for i := 0; i < entry.ChunkLen(); i++ {
+		from, to := calculatePosition(entry, chunkSize, i)
+		chunk := newChunk(entry, i, from, to, dl.setting, &wg)
+		chunk.restore(storage.written(chunk, -1), false)
+
+		chunks = append(chunks, chunk)
+	}
+
+	return dl.resumed(entry, start, dl.download(entry, chunks, storage, &wg))
+}
+
+func (dl *localDownloader) resumed(entry entry.Entry, start time.Time, err error) error {
 	if err != nil {
-		log.Println("error creating worker", err.Error())
+		if dl.fallback(entry, err) || dl.restart(entry, err) {
+			return dl.Download(entry)
+		}
+
 		return err
 	}
 
-	var wg sync.WaitGroup
-	worker.Start()
-	defer worker.Stop()
+	elapsed := time.Since(start)
+	log.Println(entry.Name(), "resumed in", elapsed.Seconds(), "s")
+
+	return nil
+}
 
-	progress := client.Progress{
-		ID:     entry.ID(),
-		Chunks: make([]client.ChunkProgress, entry.ChunkLen()),
+// download runs the chunks in the worker pool, and combines them into the entry file once all of them are done
+func (dl *localDownloader) download(entry entry.Entry, chunks []*chunk, storage storage, wg *sync.WaitGroup) error {
+	defer storage.close()
+
+	ctx, cancel := context.WithCancel(entry.Context())
+	defer cancel()
+
+	w, err := worker.New(ctx, dl.setting.MaxChunkCount, len(chunks))
+	if err != nil {
+		log.Println("error creating worker", err.Error())
+		return err
 	}
 
-	chunks := make([]*chunk, 0)
-	for i := 0; i < entry.ChunkLen(); i++ {
-		chunk := newChunk(entry, i, dl.setting, &progress, &wg)
+	w.Start()
+	defer w.Stop()
+
+	tracker := newTracker(entry, chunks, dl.onprogress)
+	tracker.manifest.Storage = storage.name()
+	tracker.abort = cancel
 
-		downloaded := resumePosition(chunk.path)
+	for _, chunk := range chunks {
+		chunk.storage = storage
+		chunk.open = dl.open
+	}
 
-		chunk.start += downloaded
-		progress.Chunks[i].Downloaded += downloaded
+	if dl.setting.SegmentedDownload {
+		// the worker which chunk is done takes over the second half of the slowest chunk, as long as each half is at least a quarter of min chunk size
+		steal := func() {
+			if child := tracker.split(dl.setting.MinChunkSize / 4); child != nil {
+				log.Println("splitting chunk into chunk", child.index, "from", child.start, "to", child.end)
 
-		if dl.onprogress != nil {
-			chunk.onProgress(dl.onprogress)
+				wg.Add(1)
+				w.Add(child)
+			}
 		}
 
-		chunks = append(chunks, chunk)
+		for _, chunk := range chunks {
+			chunk.onfinish = steal
+		}
 	}
 
+	stopMonitor := dl.monitor(tracker)
+
 	for _, chunk := range chunks {
+		// the chunk is kept from the previous attempt
+		if chunk.finished {
+			continue
+		}
+
 		wg.Add(1)
-		worker.Add(chunk)
+		w.Add(chunk)
 	}
 
 	wg.Wait()
+	stopMonitor()
 
 	if entry.Context().Err() != nil {
 		return nil
 	}
 
-	if err := dl.createFile(entry, dl.setting); err != nil {
+	// the manifest is kept, so the chunks that are done don't have to be downloaded again on resume
+	if err := tracker.err(); err != nil {
+		return err
+	}
+
+	if err := storage.combine(entry, tracker.segments()); err != nil {
 		log.Println("error combining chunks:", err.Error())
 		return err
 	}
 
-	elapsed := time.Since(start)
-	log.Println(entry.Name(), "resumed in", elapsed.Seconds(), "s")
+	if err := removeManifest(dl.setting, entry); err != nil {
+		log.Println("error removing manifest:", err.Error())
+	}
+
+	RemoveEntryLimit(entry.ID())
+	dl.restarts.Delete(entry.ID())
+
+	digest, err := verify(entry)
+	if digest != "" {
+		dl.digests.Store(entry.ID(), digest)
+	}
+
+	if err != nil {
+		log.Println("error verifying", entry.Name(), ":", err.Error())
+		return err
+	}
 
 	return nil
 }
@@ -165,10 +258,10 @@ func (dl *localDownloader) Restart(entry entry.Entry) error {
 	log.Println("restarting download", entry.Name(), "...")
 
 	if entry.Expired() {
-		return errUrlExpired
+		return ErrUrlExpired
 	}
 
-	if err := entry.Refresh(); err != nil {
+	if err := entry.Refresh(); err != nil && !changed(err) {
 		return err
 	}
 
@@ -182,52 +275,18 @@ func (dl *localDownloader) Stop(entry entry.Entry) error {
 	return nil
 }
 
-// Watch will update the id, index, downloaded bytes, and progress in percent of chunks. Watch must be called before Download
-func (dl *localDownloader) Watch(update OnProgress) {
-	dl.onprogress = update
-}
-
-// createFile will combine chunks into single actual file
-func (dl *localDownloader) createFile(entry entry.Entry, s *setting.Setting) error {
-	// if chunk len is 1, then just rename the chunk into entry filename
-	if entry.ChunkLen() == 1 {
-		chunkname := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", entry.ID(), 0))
-		return os.Rename(chunkname, entry.Location())
+// Digest returns the checksum of the downloaded file in <algorithm>:<hex digest> format
+func (dl *localDownloader) Digest(entry entry.Entry) string {
+	if digest, ok := dl.digests.Load(entry.ID()); ok {
+		return digest.(string)
 	}
 
-	file, err := os.Create(entry.Location())
-	if err != nil {
-		log.Println("error creating downloaded file:", err.Error())
-		return err
-	}
-
-	defer file.Close()
-
-	for i := 0; i < entry.ChunkLen(); i++ {
-		tmpFilename := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", entry.ID(), i))
-		if err := dl.appendChunk(file, tmpFilename); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return ""
 }
 
-func (dl *localDownloader) appendChunk(dst io.Writer, srcName string) error {
-	tmpFile, err := os.Open(srcName)
-	if err != nil {
-		log.Println("error opening downloaded chunk file:", err.Error())
-		return err
-	}
-
-	defer tmpFile.Close()
-
-	if _, err := io.Copy(dst, tmpFile); err != nil {
-		log.Println("error copying chunk file into actual file:", err.Error())
-		return err
-	}
-
-	return os.Remove(srcName)
+// Watch will update the id, index, downloaded bytes, and progress in percent of chunks. Watch must be called before Download
+func (dl *localDownloader) Watch(update OnProgress) {
+	dl.onprogress = update
 }
 
 func init() {
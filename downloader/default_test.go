@@ -1,14 +1,24 @@
 package downloader
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/rapid-downloader/rapid/client"
 	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/fs"
 	"github.com/rapid-downloader/rapid/setting"
 )
 
@@ -190,3 +200,892 @@ func TestStopDownloadLocalResumeSuccess(t *testing.T) {
 
 	os.Remove(entry.Location())
 }
+
+func testSetting(t *testing.T) *setting.Setting {
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+	s.DataLocation = t.TempDir()
+	s.MinChunkSize = 256
+
+	// retries don't have to wait long for the test servers
+	retryBase = 10 * time.Millisecond
+
+	return s
+}
+
+func testServer(content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestDownloadLocalHttptestSuccess(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if entry.ChunkLen() < 2 {
+		t.Fatalf("Expected more than one chunk, but got %d", entry.ChunkLen())
+	}
+
+	var mutex sync.Mutex
+	var last client.Progress
+
+	dl := New(Default, UseSetting(s))
+	dl.(Watcher).Watch(func(data ...interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// the chunks publish concurrently, so the events may arrive out of order
+		if progress := data[0].(client.Progress); progress.Downloaded >= last.Downloaded {
+			last = progress
+		}
+	})
+
+	if err := dl.Download(entry); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(entry.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+
+	if last.Downloaded != entry.Size() || last.Progress != 100 || last.Status != statusDownloading {
+		t.Errorf("Expected the last progress to be complete, but got %d bytes, %f%%, status %s", last.Downloaded, last.Progress, last.Status)
+	}
+}
+
+func TestResumeFromManifest(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	fetched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// simulate a crash: the manifest recorded 10 bytes of the first chunk, but the file has some unrecorded garbage after it
+	manifest := entry.NewManifest(fetched)
+	chunkSize := fetched.Size() / int64(fetched.ChunkLen())
+	for i := 0; i < fetched.ChunkLen(); i++ {
+		start, end := calculatePosition(fetched, chunkSize, i)
+		manifest.SetChunk(i, start, end)
+	}
+
+	manifest.Downloaded(0, 10)
+	if err := manifest.Save(s.DataLocation); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	chunkfile := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", fetched.ID(), 0))
+	if err := os.WriteFile(chunkfile, append(content[:10:10], []byte("garbage")...), 0644); err != nil {
+		t.Fatal("Error writing chunk file:", err.Error())
+	}
+
+	restored, err := entry.LoadManifests(s.DataLocation)[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if err := New(Default, UseSetting(s)).Resume(restored); err != nil {
+		t.Fatal("Error resuming:", err.Error())
+	}
+
+	result, err := os.ReadFile(restored.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Resumed file is different from the served content")
+	}
+
+	if len(entry.LoadManifests(s.DataLocation)) != 0 {
+		t.Error("Expected manifest to be removed after the download completes")
+	}
+}
+
+func TestResumeFinishedChunk(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	var mutex sync.Mutex
+	ranges := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mutex.Unlock()
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testSetting(t)
+	fetched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the download is paused after the first chunk is done
+	manifest := entry.NewManifest(fetched)
+	chunkSize := fetched.Size() / int64(fetched.ChunkLen())
+	for i := 0; i < fetched.ChunkLen(); i++ {
+		start, end := calculatePosition(fetched, chunkSize, i)
+		manifest.SetChunk(i, start, end)
+	}
+
+	manifest.Downloaded(0, chunkSize)
+	manifest.Finished(0)
+	if err := manifest.Save(s.DataLocation); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	chunkfile := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", fetched.ID(), 0))
+	if err := os.WriteFile(chunkfile, content[:chunkSize], 0644); err != nil {
+		t.Fatal("Error writing chunk file:", err.Error())
+	}
+
+	restored, err := entry.LoadManifests(s.DataLocation)[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	mutex.Lock()
+	ranges = ranges[:0]
+	mutex.Unlock()
+
+	if err := New(Default, UseSetting(s)).Resume(restored); err != nil {
+		t.Fatal("Error resuming:", err.Error())
+	}
+
+	result, err := os.ReadFile(restored.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Resumed file is different from the served content")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, r := range ranges {
+		if r == fmt.Sprintf("bytes=%d-%d", chunkSize, chunkSize-1) {
+			t.Errorf("Expected the finished chunk not to be requested again, but got %v", ranges)
+		}
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	digest := sha256.Sum256(content)
+	expected := "sha256:" + hex.EncodeToString(digest[:])
+
+	matched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s), entry.UseChecksum(expected))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	dl := New(Default, UseSetting(s))
+	if err := dl.Download(matched); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	if actual := dl.(Verifier).Digest(matched); actual != expected {
+		t.Errorf("Expected digest %s, but got %s", expected, actual)
+	}
+
+	mismatched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s), entry.UseChecksum("md5:00000000000000000000000000000000"))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if err := New(Default, UseSetting(s)).Download(mismatched); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected checksum mismatch, but got %v", err)
+	}
+}
+
+func TestSegmentedDownloadSplitSlowChunk(t *testing.T) {
+	content := make([]byte, 8000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	// the first chunk is served slowly, so the other workers have to take over its range
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var from, to int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &from, &to); err != nil || from != 0 {
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+			return
+		}
+
+		if to >= len(content) {
+			to = len(content) - 1
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, to, len(content)))
+		w.Header().Set("Content-Length", fmt.Sprint(to-from+1))
+		w.WriteHeader(http.StatusPartialContent)
+
+		for i := from; i <= to; i += 10 {
+			end := i + 10
+			if end > to+1 {
+				end = to + 1
+			}
+
+			if _, err := w.Write(content[i:end]); err != nil {
+				return
+			}
+
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	s := testSetting(t)
+	s.MinChunkSize = 2000
+	s.SegmentedDownload = true
+
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	segments := 0
+	dl := New(Default, UseSetting(s))
+	dl.(Watcher).Watch(func(data ...interface{}) {
+		segments = len(data[0].(client.Progress).Chunks)
+	})
+
+	if err := dl.Download(entry); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	if segments <= entry.ChunkLen() {
+		t.Errorf("Expected the slow chunk to be split, but got %d segments from %d chunks", segments, entry.ChunkLen())
+	}
+
+	result, err := os.ReadFile(entry.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Segmented file is different from the served content")
+	}
+}
+
+func TestDownloadPreallocate(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	s.ChunkStrategy = Preallocate
+
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if err := New(Default, UseSetting(s)).Download(entry); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(entry.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+
+	files, _ := os.ReadDir(s.DownloadLocation)
+	if len(files) != 1 {
+		t.Errorf("Expected only the downloaded file without chunk files, but got %d files", len(files))
+	}
+}
+
+func TestResumePreallocate(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	fetched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// simulate a crash: only the first 10 bytes of the first chunk are recorded, the rest of the reserved file is garbage
+	manifest := entry.NewManifest(fetched)
+	manifest.Storage = Preallocate
+	chunkSize := fetched.Size() / int64(fetched.ChunkLen())
+	for i := 0; i < fetched.ChunkLen(); i++ {
+		start, end := calculatePosition(fetched, chunkSize, i)
+		manifest.SetChunk(i, start, end)
+	}
+
+	manifest.Downloaded(0, 10)
+	if err := manifest.Save(s.DataLocation); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	reserved := append(content[:10:10], bytes.Repeat([]byte("x"), len(content)-10)...)
+	if err := os.WriteFile(fetched.Location(), reserved, 0644); err != nil {
+		t.Fatal("Error writing preallocated file:", err.Error())
+	}
+
+	restored, err := entry.LoadManifests(s.DataLocation)[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if err := New(Default, UseSetting(s)).Resume(restored); err != nil {
+		t.Fatal("Error resuming:", err.Error())
+	}
+
+	result, err := os.ReadFile(restored.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Resumed file is different from the served content")
+	}
+}
+
+func TestDownloadMirrors(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	primary := testServer(content)
+	defer primary.Close()
+
+	mirror := testServer(content)
+	defer mirror.Close()
+
+	// the broken mirror passes the verification, but fails every chunk
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer broken.Close()
+
+	s := testSetting(t)
+	entry, err := entry.Fetch(primary.URL+"/file.bin", entry.UseSetting(s), entry.UseMirrors(mirror.URL+"/file.bin", broken.URL+"/file.bin"))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	var mutex sync.Mutex
+	var mirrors []client.MirrorProgress
+
+	dl := New(Default, UseSetting(s))
+	dl.(Watcher).Watch(func(data ...interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		mirrors = data[0].(client.Progress).Mirrors
+	})
+
+	if err := dl.Download(entry); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(entry.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+
+	if len(mirrors) != 3 {
+		t.Fatalf("Expected progress of 3 mirrors, but got %d", len(mirrors))
+	}
+
+	if mirrors[0].Downloaded == 0 || mirrors[1].Downloaded == 0 {
+		t.Errorf("Expected chunks to be spread across the working mirrors, but got %+v", mirrors)
+	}
+
+	if mirrors[2].Downloaded != 0 || mirrors[2].Failures == 0 {
+		t.Errorf("Expected the broken mirror to fail over, but got %+v", mirrors[2])
+	}
+}
+
+func TestDownloadStalledChunkRetried(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(s *setting.Setting)
+	}{
+		{"read timeout", func(s *setting.Setting) { s.ReadTimeout = 1 }},
+		{"watchdog", func(s *setting.Setting) { s.ReadTimeout, s.StallTimeout = 0, 1 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte("0123456789"), 100)
+			release := make(chan struct{})
+
+			var mutex sync.Mutex
+			stalled := false
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				stall := r.Header.Get("Range") != "" && !stalled
+				stalled = stalled || stall
+				mutex.Unlock()
+
+				// the first chunk request sends a few bytes, then the server stops responding
+				if stall {
+					w.Header().Set("Content-Length", "100")
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(content[:10])
+					w.(http.Flusher).Flush()
+					<-release
+					return
+				}
+
+				http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+			}))
+			defer server.Close()
+			defer close(release)
+
+			s := testSetting(t)
+			test.apply(s)
+
+			entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			dl := New(Default, UseSetting(s))
+			if err := dl.Download(entry); err != nil {
+				t.Fatal("Error downloading:", err.Error())
+			}
+
+			result, err := os.ReadFile(entry.Location())
+			if err != nil {
+				t.Fatal("Error reading downloaded file:", err.Error())
+			}
+
+			if !bytes.Equal(result, content) {
+				t.Error("Expected the stalled chunk to be retried into the same file")
+			}
+		})
+	}
+}
+
+func TestDownloadAcrossDevices(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	server := testServer(content)
+	defer server.Close()
+
+	// the chunk can't be renamed into the folder of the entry, as if it were on another disk
+	fs.Rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+	}
+
+	defer func() { fs.Rename = os.Rename }()
+
+	s := testSetting(t)
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s), entry.UseLocation(t.TempDir()))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if entry.ChunkLen() != 1 {
+		t.Fatalf("Expected a single chunk, but got %d", entry.ChunkLen())
+	}
+
+	if err := New(Default, UseSetting(s)).Download(entry); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(entry.Location())
+	if err != nil || !bytes.Equal(result, content) {
+		t.Error("Expected the chunk to be copied into the entry file")
+	}
+
+	if _, err := os.Stat(chunkPath(entry, s, 0)); !os.IsNotExist(err) {
+		t.Error("Expected the chunk file to be removed once it is copied")
+	}
+}
+
+func TestDownloadThrottledNotStalled(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 200)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	s.ReadTimeout, s.StallTimeout = 0, 1
+	s.MaxRetry = 0
+
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the chunks share the limit, so a read waits for the limiter longer than the stall timeout
+	SetEntryLimit(entry.ID(), int64(len(content))/2)
+	defer RemoveEntryLimit(entry.ID())
+
+	if err := New(Default, UseSetting(s)).Download(entry); err != nil {
+		t.Fatal("Expected the throttled download not to stall, but got:", err.Error())
+	}
+
+	result, err := os.ReadFile(entry.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+}
+
+func TestDownloadRetryAfter(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	var mutex sync.Mutex
+	limited := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		limit := r.Header.Get("Range") != "" && !limited
+		limited = limited || limit
+		mutex.Unlock()
+
+		if limit {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testSetting(t)
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	start := time.Now()
+
+	dl := New(Default, UseSetting(s))
+	if err := dl.Download(entry); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected the retry to wait for the delay asked by the server, but it took %s", elapsed)
+	}
+
+	result, err := os.ReadFile(entry.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+}
+
+func TestDownloadPermanentFailure(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	var mutex sync.Mutex
+	requests := 0
+
+	// the file passes the fetch, but every chunk is forbidden
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			mutex.Lock()
+			requests++
+			mutex.Unlock()
+
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := testSetting(t)
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	dl := New(Default, UseSetting(s))
+
+	var statusErr *statusError
+	if err := dl.Download(entry); !errors.As(err, &statusErr) || statusErr.code != http.StatusForbidden {
+		t.Fatalf("Expected the forbidden chunk to fail the download, but got %v", err)
+	}
+
+	if _, err := os.Stat(entry.Location()); err == nil {
+		t.Error("Expected no file to be combined from the failed chunks")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if requests > entry.ChunkLen() {
+		t.Errorf("Expected the forbidden chunks not to be retried, but got %d requests for %d chunks", requests, entry.ChunkLen())
+	}
+}
+
+func TestDownloadRangeIgnoredFallback(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	// the server claims to support range, but always sends the whole file
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	s := testSetting(t)
+	e, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if e.ChunkLen() < 2 {
+		t.Fatalf("Expected the entry to be split into chunks, but got %d", e.ChunkLen())
+	}
+
+	dl := New(Default, UseSetting(s))
+	if err := dl.Download(e); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(e.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Expected the file to be downloaded in a single stream without corruption")
+	}
+
+	client := e.(entry.SingleStreamClient)
+	if client.Fallback() == "" || e.ChunkLen() != 1 || e.Resumable() {
+		t.Errorf("Expected the entry to fall back to a single stream, but got fallback %q with %d chunks", client.Fallback(), e.ChunkLen())
+	}
+}
+
+// versionedServer serves the content with its etag, which can be replaced to simulate a change of the remote file
+func versionedServer(content []byte, etag string) (*httptest.Server, func(content []byte, etag string), func() []string) {
+	var mutex sync.Mutex
+	ifRanges := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		served, tag := content, etag
+		if r.Header.Get("If-Range") != "" {
+			ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		}
+		mutex.Unlock()
+
+		w.Header().Set("ETag", tag)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(served))
+	}))
+
+	replace := func(c []byte, e string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		content, etag = c, e
+	}
+
+	sent := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return append([]string{}, ifRanges...)
+	}
+
+	return server, replace, sent
+}
+
+func TestResumeChangedRemoteFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server, replace, _ := versionedServer(content, `"v1"`)
+	defer server.Close()
+
+	s := testSetting(t)
+	fetched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the first chunk was partly downloaded before the engine stopped
+	manifest := entry.NewManifest(fetched)
+	chunkSize := fetched.Size() / int64(fetched.ChunkLen())
+	for i := 0; i < fetched.ChunkLen(); i++ {
+		start, end := calculatePosition(fetched, chunkSize, i)
+		manifest.SetChunk(i, start, end)
+	}
+
+	manifest.Downloaded(0, 10)
+	if err := manifest.Save(s.DataLocation); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	chunkfile := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", fetched.ID(), 0))
+	if err := os.WriteFile(chunkfile, content[:10], 0644); err != nil {
+		t.Fatal("Error writing chunk file:", err.Error())
+	}
+
+	changed := bytes.Repeat([]byte("abcdefghij"), 100)
+	replace(changed, `"v2"`)
+
+	restored, err := entry.LoadManifests(s.DataLocation)[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if err := New(Default, UseSetting(s)).Resume(restored); err != nil {
+		t.Fatal("Error resuming:", err.Error())
+	}
+
+	result, err := os.ReadFile(restored.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, changed) {
+		t.Error("Expected the changed file to be downloaded from scratch")
+	}
+
+	if etag := restored.(entry.ConditionalClient).ETag(); etag != `"v2"` {
+		t.Errorf("Expected the entry to be refreshed to the new etag, but got %s", etag)
+	}
+}
+
+func TestDownloadRemoteFileChangedMidway(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server, replace, sent := versionedServer(content, `"v1"`)
+	defer server.Close()
+
+	s := testSetting(t)
+	fetched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the file changes after it is fetched, so the chunks are requested against the old version
+	changed := bytes.Repeat([]byte("abcdefghij"), 120)
+	replace(changed, `"v2"`)
+
+	if err := New(Default, UseSetting(s)).Download(fetched); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(fetched.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, changed) {
+		t.Error("Expected the changed file to be downloaded from scratch")
+	}
+
+	ifRanges := sent()
+	if len(ifRanges) == 0 || ifRanges[0] != `"v1"` {
+		t.Errorf("Expected the chunks to be requested with If-Range of the fetched etag, but got %v", ifRanges)
+	}
+}
+
+func TestDownloadConnectionsReleased(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	s.MaxConnsPerHost = 2
+
+	// the expired check goes through the client of the global setting, so its cap has to be exceeded as well
+	cycles := setting.Default().MaxConnsPerHost + 1
+
+	for i := 0; i < cycles; i++ {
+		done := make(chan error, 1)
+
+		go func() {
+			e, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+			if err != nil {
+				done <- err
+				return
+			}
+
+			done <- New(Default, UseSetting(s)).Download(e)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Error downloading %d:%s", i, err.Error())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Expected download %d to get a connection, but it hangs", i)
+		}
+	}
+}
+
+func TestDownloadCanceledEntry(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := testServer(content)
+	defer server.Close()
+
+	s := testSetting(t)
+	entry, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// e.g the download was stopped before it is started again
+	entry.Cancel()
+
+	if err := New(Default, UseSetting(s)).Download(entry); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	if entry.Context().Err() != nil {
+		t.Error("Expected the download to run with a new context")
+	}
+
+	if result, err := os.ReadFile(entry.Location()); err != nil || !bytes.Equal(result, content) {
+		t.Errorf("Expected the downloaded file to be the served content (%v)", err)
+	}
+}
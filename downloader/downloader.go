@@ -19,6 +19,11 @@ type (
 		Watch(update OnProgress)
 	}
 
+	Verifier interface {
+		// Digest returns the checksum of the downloaded file, which is computed once the download completes
+		Digest(entry entry.Entry) string
+	}
+
 	DownloaderFactory func(o *option) Downloader
 
 	OnProgress func(data ...interface{})
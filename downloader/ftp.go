@@ -0,0 +1,166 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+)
+
+// ftpFile is the body of a RETR, which holds a connection of its own, so the chunks are retrieved in parallel
+type ftpFile struct {
+	mutex sync.Mutex
+	ctx   context.Context
+	conn  *ftp.ServerConn
+	res   *ftp.Response
+	done  chan struct{}
+	once  sync.Once
+	eof   bool
+}
+
+var FTP = "ftp"
+
+// newFTPDownloader downloads over ftp with the same chunks, storage and retries as the default downloader
+func newFTPDownloader(opt *option) Downloader {
+	dl := newLocalDownloader(opt).(*localDownloader)
+	dl.open = (*chunk).getFTPFile
+
+	return dl
+}
+
+// ftpError tells the retry which replies are permanent. A restart that isn't understood means the server can't resume,
+// so the download falls back to a single stream
+func ftpError(err error, offset int64) error {
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return err
+	}
+
+	switch {
+	case offset > 0 && (reply.Code == ftp.StatusBadCommand || reply.Code == ftp.StatusBadArguments ||
+		reply.Code == ftp.StatusNotImplemented || reply.Code == ftp.StatusNotImplementedParameter):
+		return permanent(fmt.Errorf("%w: %s", errRangeUnsupported, err.Error()))
+	case reply.Code >= 500:
+		return permanent(err)
+	default:
+		return err
+	}
+}
+
+func (c *chunk) getFTPFile(ctx context.Context) (io.ReadCloser, error) {
+	client, ok := c.entry.(entry.FTPClient)
+	if !ok {
+		return nil, permanent(fmt.Errorf("%s is not an ftp entry", c.entry.URL()))
+	}
+
+	u := client.FTP()
+
+	options := []network.Options{network.UseSetting(c.setting)}
+	if i, ok := c.entry.(entry.InsecureClient); ok {
+		options = append(options, network.SkipVerify(i.Insecure()))
+	}
+
+	conn, err := network.DialFTP(ctx, u, options...)
+	if err != nil {
+		log.Println("error connecting for chunk", c.index, ":", err.Error())
+		return nil, ftpError(err, 0)
+	}
+
+	file := &ftpFile{
+		ctx:  ctx,
+		conn: conn,
+		done: make(chan struct{}),
+	}
+
+	go file.watch()
+
+	// a file of unknown size is always retrieved from the start
+	offset, to := c.tracker.position(c)
+	if to == -1 {
+		offset = 0
+	}
+
+	log.Println("downloading chunk", c.index, "from", offset, "over", u.Scheme)
+
+	res, err := conn.RetrFrom(u.Path, uint64(offset))
+	if err != nil {
+		file.Close()
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		log.Println("error retrieving chunk", c.index, ":", err.Error())
+		return nil, ftpError(err, offset)
+	}
+
+	file.mutex.Lock()
+	file.res = res
+	file.mutex.Unlock()
+
+	return file, nil
+}
+
+// watch interrupts the transfer once the context is done, since reads on the connections don't take the context
+func (f *ftpFile) watch() {
+	select {
+	case <-f.done:
+	case <-f.ctx.Done():
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if f.res != nil {
+			f.res.SetDeadline(time.Now())
+		}
+
+		f.conn.Quit()
+	}
+}
+
+func (f *ftpFile) Read(payload []byte) (int, error) {
+	n, err := f.res.Read(payload)
+	if err == io.EOF {
+		f.eof = true
+	}
+
+	if err != nil && f.ctx.Err() != nil {
+		return n, f.ctx.Err()
+	}
+
+	return n, err
+}
+
+// Close closes the connection. A transfer which isn't complete, e.g the chunk ends before the file does, is dropped
+// without waiting for the server to confirm it
+func (f *ftpFile) Close() error {
+	f.once.Do(func() {
+		close(f.done)
+
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if f.res != nil && f.eof {
+			f.res.Close()
+		}
+
+		f.conn.Quit()
+
+		if f.res != nil && !f.eof {
+			f.res.Close()
+		}
+	})
+
+	return nil
+}
+
+func init() {
+	registerDownloader(FTP, newFTPDownloader)
+}
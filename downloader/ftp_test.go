@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/network/ftptest"
+)
+
+func TestFTPDownloadSegmented(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	tests := []struct {
+		name    string
+		options []ftptest.Options
+	}{
+		{name: "ftp"},
+		{name: "ftps", options: []ftptest.Options{ftptest.UseTLS()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := ftptest.NewServer(map[string][]byte{"file.bin": content}, test.options...)
+			defer server.Close()
+
+			s := testSetting(t)
+			e, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s), entry.SkipVerify(true))
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			if e.Downloader() != FTP || e.ChunkLen() < 2 {
+				t.Fatalf("Expected an ftp entry split into chunks, but got %s with %d chunks", e.Downloader(), e.ChunkLen())
+			}
+
+			if err := New(FTP, UseSetting(s)).Download(e); err != nil {
+				t.Fatal("Error downloading:", err.Error())
+			}
+
+			result, err := os.ReadFile(e.Location())
+			if err != nil {
+				t.Fatal("Error reading downloaded file:", err.Error())
+			}
+
+			if !bytes.Equal(result, content) {
+				t.Error("Downloaded file is different from the served content")
+			}
+
+			if offsets := server.Offsets(); len(offsets) != e.ChunkLen() {
+				t.Errorf("Expected a transfer per chunk, but got offsets %v", offsets)
+			}
+		})
+	}
+}
+
+func TestFTPResumeFromManifest(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := ftptest.NewServer(map[string][]byte{"file.bin": content})
+	defer server.Close()
+
+	s := testSetting(t)
+	fetched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the first chunk has been downloaded up to 10 bytes before the download stopped
+	manifest := entry.NewManifest(fetched)
+	chunkSize := fetched.Size() / int64(fetched.ChunkLen())
+	for i := 0; i < fetched.ChunkLen(); i++ {
+		start, end := calculatePosition(fetched, chunkSize, i)
+		manifest.SetChunk(i, start, end)
+	}
+
+	manifest.Downloaded(0, 10)
+	if err := manifest.Save(s.DataLocation); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	chunkfile := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", fetched.ID(), 0))
+	if err := os.WriteFile(chunkfile, content[:10], 0644); err != nil {
+		t.Fatal("Error writing chunk file:", err.Error())
+	}
+
+	restored, err := entry.LoadManifests(s.DataLocation)[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if _, ok := restored.(entry.FTPClient); !ok {
+		t.Fatal("Expected the restored entry to be an ftp entry")
+	}
+
+	if err := New(FTP, UseSetting(s)).Resume(restored); err != nil {
+		t.Fatal("Error resuming:", err.Error())
+	}
+
+	result, err := os.ReadFile(restored.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Resumed file is different from the served content")
+	}
+
+	restarted := false
+	for _, offset := range server.Offsets() {
+		restarted = restarted || offset == 10
+	}
+
+	if !restarted {
+		t.Errorf("Expected the first chunk to restart at 10, but got offsets %v", server.Offsets())
+	}
+}
+
+func TestFTPSingleConnection(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := ftptest.NewServer(map[string][]byte{"file.bin": content}, ftptest.MaxConnections(1))
+	defer server.Close()
+
+	s := testSetting(t)
+	e, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if e.ChunkLen() != 1 || !e.Resumable() {
+		t.Fatalf("Expected a single resumable chunk, but got %d chunks", e.ChunkLen())
+	}
+
+	if err := New(FTP, UseSetting(s)).Download(e); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(e.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+}
+
+func TestFTPWithoutRestFallback(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := ftptest.NewServer(map[string][]byte{"file.bin": content}, ftptest.WithoutRest())
+	defer server.Close()
+
+	s := testSetting(t)
+	e, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if e.ChunkLen() < 2 {
+		t.Fatalf("Expected the entry to be split into chunks, but got %d", e.ChunkLen())
+	}
+
+	if err := New(FTP, UseSetting(s)).Download(e); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(e.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Expected the file to be downloaded in a single stream without corruption")
+	}
+
+	client := e.(entry.SingleStreamClient)
+	if client.Fallback() == "" || e.ChunkLen() != 1 {
+		t.Errorf("Expected the entry to fall back to a single stream, but got fallback %q with %d chunks", client.Fallback(), e.ChunkLen())
+	}
+}
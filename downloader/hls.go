@@ -0,0 +1,238 @@
+package downloader
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network/hls"
+)
+
+type (
+	// hlsDownloader downloads every segment of the stream as a chunk, and concatenates them in order into the entry file
+	hlsDownloader struct {
+		*streamDownloader
+		keys sync.Map // keys of the segments of every download in progress, by entry id
+	}
+
+	// keyCache keeps the keys of a download by their url
+	keyCache struct {
+		mutex sync.Mutex
+		keys  map[string][]byte
+	}
+)
+
+var HLS = "hls"
+
+func newHLSDownloader(opt *option) Downloader {
+	dl := &hlsDownloader{}
+	dl.streamDownloader = newStreamDownloader(opt, dl)
+	dl.open = dl.getSegment
+
+	return dl
+}
+
+func (dl *hlsDownloader) segments(e entry.Entry) (int, error) {
+	client, ok := e.(entry.HLSClient)
+	if !ok {
+		return 0, fmt.Errorf("%s is not an hls entry", e.URL())
+	}
+
+	segments, err := client.Segments()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(segments), nil
+}
+
+func (dl *hlsDownloader) storage(e entry.Entry) storage {
+	return &tempStorage{}
+}
+
+func (dl *hlsDownloader) release(e entry.Entry) {
+	dl.keys.Delete(e.ID())
+}
+
+// getSegment opens the segment of the chunk, and decrypts it
+func (dl *hlsDownloader) getSegment(c *chunk, ctx context.Context) (io.ReadCloser, error) {
+	client, ok := c.entry.(entry.HLSClient)
+	if !ok {
+		return nil, permanent(fmt.Errorf("%s is not an hls entry", c.entry.URL()))
+	}
+
+	segments, err := client.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.index >= len(segments) {
+		return nil, permanent(fmt.Errorf("segment %d is not in the playlist", c.index))
+	}
+
+	segment := segments[c.index]
+
+	res, err := openSegment(ctx, c, segment.URL, segment.Offset, segment.Length)
+	if err != nil {
+		return nil, err
+	}
+
+	body := res.Body
+	if segment.Key != nil {
+		key, err := dl.key(ctx, c, segment.Key)
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+
+		body, err = decrypt(body, key, segment.IV())
+		if err != nil {
+			res.Body.Close()
+			return nil, permanent(err)
+		}
+	}
+
+	return body, nil
+}
+
+// key returns the aes-128 key of the segment, which is requested once per download
+func (dl *hlsDownloader) key(ctx context.Context, c *chunk, key *hls.Key) ([]byte, error) {
+	cache, _ := dl.keys.LoadOrStore(c.entry.ID(), &keyCache{keys: make(map[string][]byte)})
+	keys := cache.(*keyCache)
+
+	// the segments that share the key wait for the one which requests it
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+
+	if value, ok := keys.keys[key.URL]; ok {
+		return value, nil
+	}
+
+	req, err := segmentRequest(ctx, c.entry, key.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := httpClient(c.entry, c.setting).Do(req)
+	if err != nil {
+		log.Println("error fetching key:", err.Error())
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, newStatusError(res)
+	}
+
+	value, err := io.ReadAll(io.LimitReader(res.Body, aes.BlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) != aes.BlockSize {
+		return nil, permanent(fmt.Errorf("key of segment %d is %d bytes instead of %d", c.index, len(value), aes.BlockSize))
+	}
+
+	keys.keys[key.URL] = value
+	return value, nil
+}
+
+// decrypter decrypts the segment with aes-128 in cbc mode while it is read. The last block is held back until the end,
+// since its padding has to be removed
+type decrypter struct {
+	body    io.ReadCloser
+	mode    cipher.BlockMode
+	buffer  []byte
+	pending []byte // ciphertext that isn't decrypted yet
+	plain   []byte // plaintext that isn't read yet
+	eof     bool
+}
+
+func decrypt(body io.ReadCloser, key, iv []byte) (io.ReadCloser, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decrypter{
+		body:   body,
+		mode:   cipher.NewCBCDecrypter(block, iv),
+		buffer: make([]byte, 32*1024),
+	}, nil
+}
+
+func (d *decrypter) Read(payload []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+
+		n, err := d.body.Read(d.buffer)
+		d.pending = append(d.pending, d.buffer[:n]...)
+
+		if err == io.EOF {
+			d.eof = true
+			if err := d.unpad(); err != nil {
+				return 0, err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		// every whole block except the last one
+		if ready := (len(d.pending)/aes.BlockSize - 1) * aes.BlockSize; ready > 0 {
+			d.plain = make([]byte, ready)
+			d.mode.CryptBlocks(d.plain, d.pending[:ready])
+			d.pending = append(d.pending[:0], d.pending[ready:]...)
+		}
+	}
+
+	n := copy(payload, d.plain)
+	d.plain = d.plain[n:]
+
+	return n, nil
+}
+
+// unpad decrypts the rest of the segment and removes its pkcs#7 padding
+func (d *decrypter) unpad() error {
+	if len(d.pending) == 0 || len(d.pending)%aes.BlockSize != 0 {
+		return permanent(fmt.Errorf("error decrypting segment:%d bytes are not whole blocks", len(d.pending)))
+	}
+
+	plain := make([]byte, len(d.pending))
+	d.mode.CryptBlocks(plain, d.pending)
+	d.pending = nil
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return permanent(fmt.Errorf("error decrypting segment:invalid padding"))
+	}
+
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return permanent(fmt.Errorf("error decrypting segment:invalid padding"))
+		}
+	}
+
+	d.plain = plain[:len(plain)-padding]
+	return nil
+}
+
+func (d *decrypter) Close() error {
+	return d.body.Close()
+}
+
+func init() {
+	registerDownloader(HLS, newHLSDownloader)
+}
@@ -0,0 +1,268 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/client"
+	"github.com/rapid-downloader/rapid/entry"
+)
+
+type hlsStream struct {
+	mutex    sync.Mutex
+	key      []byte
+	segments [][]byte // plaintext of the segments, in the order of the playlist
+	requests map[string]int
+}
+
+func encrypt(t *testing.T, key, iv, plain []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal("Error creating cipher:", err.Error())
+	}
+
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	return encrypted
+}
+
+func content(size int, seed byte) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i%251) + seed
+	}
+
+	return b
+}
+
+// hlsServer serves a media playlist of a plain segment, a segment encrypted with the iv of its sequence, a segment encrypted
+// with the iv of the playlist, and a byte range of a plain resource
+func hlsServer(t *testing.T) (*httptest.Server, *hlsStream) {
+	iv := bytes.Repeat([]byte{7}, aes.BlockSize)
+	sequenceIV := make([]byte, aes.BlockSize)
+	sequenceIV[15] = 6
+
+	stream := &hlsStream{
+		key:      []byte("0123456789abcdef"),
+		segments: [][]byte{content(1000, 0), content(70000, 1), content(333, 2), content(300, 3)},
+		requests: make(map[string]int),
+	}
+
+	resource := append(content(100, 9), stream.segments[3]...)
+	resource = append(resource, content(50, 9)...)
+
+	files := map[string][]byte{
+		"/stream/seg0.ts": stream.segments[0],
+		"/stream/seg1.ts": encrypt(t, stream.key, sequenceIV, stream.segments[1]),
+		"/stream/seg2.ts": encrypt(t, stream.key, iv, stream.segments[2]),
+		"/stream/all.ts":  resource,
+		"/stream/key.bin": stream.key,
+	}
+
+	playlist := fmt.Sprintf(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:5
+#EXTINF:4,
+seg0.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:4,
+seg1.ts
+#EXT-X-KEY:METHOD=AES-128,URI="/stream/key.bin",IV=0x%s
+#EXTINF:4,
+seg2.ts
+#EXT-X-KEY:METHOD=NONE
+#EXT-X-BYTERANGE:300@100
+#EXTINF:4,
+all.ts
+#EXT-X-ENDLIST
+`, hex.EncodeToString(iv))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream.mutex.Lock()
+		stream.requests[r.URL.Path]++
+		served := stream.key
+		stream.mutex.Unlock()
+
+		if r.URL.Path == "/stream/index.m3u8" {
+			fmt.Fprint(w, playlist)
+			return
+		}
+
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.URL.Path == "/stream/key.bin" {
+			file = served
+		}
+
+		http.ServeContent(w, r, filepath.Base(r.URL.Path), time.Time{}, bytes.NewReader(file))
+	}))
+
+	return server, stream
+}
+
+func (s *hlsStream) requested(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests[path]
+}
+
+func TestHLSDownloadSegments(t *testing.T) {
+	server, stream := hlsServer(t)
+	defer server.Close()
+
+	s := testSetting(t)
+	e, err := entry.Fetch(server.URL+"/stream/index.m3u8", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if e.Downloader() != HLS || e.ChunkLen() != len(stream.segments) {
+		t.Fatalf("Expected an hls entry of %d segments, but got %s of %d chunks", len(stream.segments), e.Downloader(), e.ChunkLen())
+	}
+
+	// the chunks publish concurrently, so the snapshot of the last chunk may not be the last one published
+	var mutex sync.Mutex
+	var progress client.Progress
+
+	dl := New(HLS, UseSetting(s))
+	dl.(Watcher).Watch(func(data ...interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if p := data[0].(client.Progress); p.Progress >= progress.Progress {
+			progress = p
+		}
+	})
+
+	if err := dl.Download(e); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(e.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, bytes.Join(stream.segments, nil)) {
+		t.Errorf("Expected the decrypted segments in order, but got %d bytes", len(result))
+	}
+
+	if !strings.HasSuffix(e.Location(), "index.ts") {
+		t.Errorf("Expected the segments to be merged into a ts file, but got %s", e.Location())
+	}
+
+	if progress.Progress != 100 || len(progress.Chunks) != len(stream.segments) {
+		t.Fatalf("Expected the progress of every segment, but got %.0f%% of %d chunks", progress.Progress, len(progress.Chunks))
+	}
+
+	for i, chunk := range progress.Chunks {
+		if !chunk.Done || chunk.Downloaded != int64(len(stream.segments[i])) {
+			t.Errorf("Expected segment %d to be done with %d bytes, but got %d bytes", i, len(stream.segments[i]), chunk.Downloaded)
+		}
+	}
+
+	if n := stream.requested("/stream/key.bin"); n != 1 {
+		t.Errorf("Expected the key to be requested once, but got %d requests", n)
+	}
+}
+
+func TestHLSResumeFromManifest(t *testing.T) {
+	server, stream := hlsServer(t)
+	defer server.Close()
+
+	s := testSetting(t)
+	fetched, err := entry.Fetch(server.URL+"/stream/index.m3u8", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the first segment is done, and the second one has been partially downloaded before the download stopped
+	manifest := entry.NewManifest(fetched)
+	for i := range manifest.Chunks {
+		manifest.SetChunk(i, -1, -1)
+	}
+
+	manifest.Downloaded(0, int64(len(stream.segments[0])))
+	manifest.Finished(0)
+	manifest.Downloaded(1, 10)
+
+	if err := manifest.Save(s.DataLocation); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	for i, written := range [][]byte{stream.segments[0], []byte("partial!!!")} {
+		chunkfile := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", fetched.ID(), i))
+		if err := os.WriteFile(chunkfile, written, 0644); err != nil {
+			t.Fatal("Error writing chunk file:", err.Error())
+		}
+	}
+
+	restored, err := entry.LoadManifests(s.DataLocation)[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if err := New(HLS, UseSetting(s)).Resume(restored); err != nil {
+		t.Fatal("Error resuming:", err.Error())
+	}
+
+	result, err := os.ReadFile(restored.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, bytes.Join(stream.segments, nil)) {
+		t.Error("Resumed file is different from the served segments")
+	}
+
+	if n := stream.requested("/stream/seg0.ts"); n != 0 {
+		t.Errorf("Expected the finished segment to be kept, but it was requested %d times", n)
+	}
+
+	if n := stream.requested("/stream/seg1.ts"); n != 1 {
+		t.Errorf("Expected the partial segment to be downloaded again, but it was requested %d times", n)
+	}
+}
+
+func TestHLSInvalidKey(t *testing.T) {
+	server, stream := hlsServer(t)
+	defer server.Close()
+
+	s := testSetting(t)
+	e, err := entry.Fetch(server.URL+"/stream/index.m3u8", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	stream.mutex.Lock()
+	stream.key = []byte("short")
+	stream.mutex.Unlock()
+
+	if err := New(HLS, UseSetting(s)).Download(e); err == nil || !strings.Contains(err.Error(), "key") {
+		t.Fatalf("Expected the download to fail on the invalid key, but got %v", err)
+	}
+
+	if n := stream.requested("/stream/key.bin"); n > 2 {
+		t.Errorf("Expected the invalid key not to be retried, but it was requested %d times", n)
+	}
+}
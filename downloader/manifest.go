@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"time"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+func loadManifest(s *setting.Setting, e entry.Entry) (*entry.Manifest, error) {
+	return entry.LoadManifest(s.DataLocation, e.ID())
+}
+
+func removeManifest(s *setting.Setting, e entry.Entry) error {
+	return entry.RemoveManifest(s.DataLocation, e.ID())
+}
+
+const persistInterval = time.Second
+
+// monitor saves the manifest and refreshes the progress periodically until the returned function is called, which saves the manifest for the last time
+func (dl *localDownloader) monitor(tracker *tracker) func() {
+	save := func() {
+		if err := tracker.manifest.Save(dl.setting.DataLocation); err != nil {
+			log.Println("error saving manifest:", err.Error())
+		}
+	}
+
+	save()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(persistInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				save()
+				tracker.tick()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		save()
+	}
+}
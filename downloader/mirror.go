@@ -0,0 +1,32 @@
+package downloader
+
+import (
+	"net/http"
+
+	"github.com/rapid-downloader/rapid/entry"
+)
+
+// source is a url which the chunks are downloaded from. An entry with mirrors has more than one
+type source struct {
+	request    *http.Request
+	downloaded int64
+	failures   int
+}
+
+func sources(e entry.Entry) []*source {
+	requests := make([]*http.Request, 0)
+	if client, ok := e.(entry.MirrorClient); ok {
+		requests = client.Mirrors()
+	} else if client, ok := e.(entry.RequestClient); ok {
+		requests = append(requests, client.Request())
+	}
+
+	sources := make([]*source, len(requests))
+	for i, req := range requests {
+		sources[i] = &source{
+			request: req,
+		}
+	}
+
+	return sources
+}
@@ -0,0 +1,23 @@
+package downloader
+
+import (
+	"net/http"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// httpClient returns the http client of the entry, which goes through its own proxy and tls options if it has them
+func httpClient(e entry.Entry, s *setting.Setting) *http.Client {
+	options := []network.Options{network.UseSetting(s)}
+	if p, ok := e.(entry.ProxyClient); ok {
+		options = append(options, network.UseProxy(p.Proxy()))
+	}
+
+	if i, ok := e.(entry.InsecureClient); ok {
+		options = append(options, network.SkipVerify(i.Insecure()))
+	}
+
+	return network.New(options...)
+}
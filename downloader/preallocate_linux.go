@@ -0,0 +1,13 @@
+package downloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves the disk space of the file with fallocate. Truncate sets the exact size, and covers the filesystem that doesn't support fallocate
+func preallocate(file *os.File, size int64) error {
+	syscall.Fallocate(int(file.Fd()), 0, 0, size)
+
+	return file.Truncate(size)
+}
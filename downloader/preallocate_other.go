@@ -0,0 +1,10 @@
+//go:build !linux
+
+package downloader
+
+import "os"
+
+// preallocate extends the file into its size. The disk space is reserved lazily by the filesystem
+func preallocate(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
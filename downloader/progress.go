@@ -0,0 +1,405 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rapid-downloader/rapid/client"
+	"github.com/rapid-downloader/rapid/entry"
+)
+
+const (
+	statusDownloading = "Downloading"
+	statusRetrying    = "Retrying"
+)
+
+// tracker keeps the progress of every chunk of a download. It also guards the range of the chunks, since it may change when a chunk is split
+type tracker struct {
+	mutex      sync.Mutex
+	chunks     []*chunk
+	sources    []*source
+	begin      time.Time
+	meter      *meter   // speed of the entry
+	meters     []*meter // speed of every chunk
+	progress   client.Progress
+	manifest   *entry.Manifest
+	onprogress OnProgress
+	abort      context.CancelFunc // stops the other chunks once a chunk fails for good
+	failure    error
+}
+
+func newTracker(e entry.Entry, chunks []*chunk, onprogress OnProgress) *tracker {
+	manifest := entry.NewManifest(e)
+	manifest.Chunks = make([]entry.ChunkState, len(chunks))
+
+	now := time.Now()
+
+	t := &tracker{
+		chunks:  chunks,
+		sources: sources(e),
+		begin:   now,
+		meter:   newMeter(now),
+		meters:  make([]*meter, len(chunks)),
+		progress: client.Progress{
+			ID:     e.ID(),
+			Status: statusDownloading,
+			Size:   e.Size(),
+			Chunks: make([]client.ChunkProgress, len(chunks)),
+		},
+		manifest:   manifest,
+		onprogress: onprogress,
+	}
+
+	for i, c := range chunks {
+		c.tracker = t
+
+		// spread the chunks across the mirrors
+		c.source = i % len(t.sources)
+
+		t.meters[i] = newMeter(now)
+		t.progress.Downloaded += c.downloaded
+
+		t.progress.Chunks[i] = client.ChunkProgress{
+			Downloaded: c.downloaded,
+			Size:       c.length(),
+			Mirror:     c.source,
+			Done:       c.finished,
+		}
+
+		if c.finished {
+			t.progress.Chunks[i].Progress = 100
+		}
+
+		manifest.Chunks[i] = entry.ChunkState{
+			Index:      i,
+			Start:      c.start,
+			End:        c.end,
+			Downloaded: c.downloaded,
+			Done:       c.finished,
+		}
+	}
+
+	return t
+}
+
+func percentage(downloaded, size int64) float64 {
+	if size <= 0 {
+		return 0
+	}
+
+	return float64(100*downloaded) / float64(size)
+}
+
+// snapshot copies the progress, so that it can be published while the chunks keep downloading. Caller must hold the lock
+func (t *tracker) snapshot() client.Progress {
+	progress := t.progress
+	progress.Chunks = append([]client.ChunkProgress{}, t.progress.Chunks...)
+	progress.Mirrors = make([]client.MirrorProgress, len(t.sources))
+
+	progress.Progress = percentage(progress.Downloaded, progress.Size)
+	if progress.Size <= 0 {
+		// the size is unknown until every chunk is done, e.g the segments of a stream, so the progress is counted in chunks
+		finished := 0
+		for _, c := range t.chunks {
+			if c.finished {
+				finished++
+			}
+		}
+
+		progress.Progress = percentage(int64(finished), int64(len(t.chunks)))
+	}
+
+	progress.Speed = t.meter.speed()
+	progress.TimeLeft = -1
+	if progress.Size > 0 {
+		progress.TimeLeft = eta(progress.Size-progress.Downloaded, progress.Speed)
+	}
+
+	for i, c := range t.chunks {
+		chunk := &progress.Chunks[i]
+		if c.finished {
+			chunk.TimeLeft = 0
+			continue
+		}
+
+		chunk.Speed = t.meters[i].speed()
+		chunk.TimeLeft = -1
+		if c.end != -1 {
+			chunk.TimeLeft = eta(c.length()-c.downloaded, chunk.Speed)
+		}
+	}
+
+	elapsed := time.Since(t.begin).Seconds()
+	for i, source := range t.sources {
+		progress.Mirrors[i] = client.MirrorProgress{
+			URL:        source.request.URL.String(),
+			Downloaded: source.downloaded,
+			Failures:   source.failures,
+		}
+
+		if elapsed > 0 {
+			progress.Mirrors[i].Speed = float64(source.downloaded) / elapsed
+		}
+	}
+
+	return progress
+}
+
+func (t *tracker) publish(progress client.Progress) {
+	if t.onprogress != nil {
+		t.onprogress(progress)
+	}
+}
+
+// request returns the request of the source of the chunk, and whether it is the url of the entry instead of a mirror
+func (t *tracker) request(c *chunk) (*http.Request, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.sources[c.source].request, c.source == 0
+}
+
+// failover records the failure of the source of the chunk, and moves the chunk to the mirror which fails the least
+func (t *tracker) failover(c *chunk) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.sources[c.source].failures++
+	t.progress.Status = statusRetrying
+
+	if len(t.sources) < 2 {
+		return
+	}
+
+	next := -1
+	for i := 1; i < len(t.sources); i++ {
+		candidate := (c.source + i) % len(t.sources)
+		if next == -1 || t.sources[candidate].failures < t.sources[next].failures {
+			next = candidate
+		}
+	}
+
+	c.source = next
+	t.progress.Chunks[c.index].Mirror = next
+}
+
+// fail records the first chunk that fails for good, and aborts the others since the file can't be completed
+func (t *tracker) fail(c *chunk, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.failure == nil {
+		t.failure = fmt.Errorf("error downloading chunk %d:%w", c.index, err)
+	}
+
+	if t.abort != nil {
+		t.abort()
+	}
+}
+
+func (t *tracker) err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.failure
+}
+
+// remaining returns how many bytes of the chunk is left, or -1 if the size is unknown
+func (t *tracker) remaining(c *chunk) int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if c.end == -1 {
+		return -1
+	}
+
+	return c.length() - c.downloaded
+}
+
+// position returns the range of the chunk that is left to download, or -1 if the size is unknown
+func (t *tracker) position(c *chunk) (int64, int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if c.end == -1 {
+		return -1, -1
+	}
+
+	return c.start + c.downloaded, c.end
+}
+
+// written returns how many bytes of the chunk has been downloaded
+func (t *tracker) written(c *chunk) int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return c.downloaded
+}
+
+// reset discards the downloaded bytes of the chunk, so it starts over
+func (t *tracker) reset(c *chunk) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.progress.Downloaded -= c.downloaded
+	c.downloaded = 0
+
+	prog := &t.progress.Chunks[c.index]
+	prog.Downloaded = 0
+	prog.Progress = 0
+	t.manifest.Downloaded(c.index, 0)
+}
+
+// advance records n bytes read by the chunk and returns how many of them belong to it. It can be less than n if the chunk was split while reading
+func (t *tracker) advance(c *chunk, n int) int {
+	t.mutex.Lock()
+
+	keep := int64(n)
+	if c.end != -1 && keep > c.length()-c.downloaded {
+		keep = c.length() - c.downloaded
+	}
+
+	c.downloaded += keep
+	t.sources[c.source].downloaded += keep
+
+	now := time.Now()
+	t.meter.add(keep, now)
+	t.meters[c.index].add(keep, now)
+
+	t.progress.Downloaded += keep
+	t.progress.Status = statusDownloading
+
+	prog := &t.progress.Chunks[c.index]
+	prog.Downloaded = c.downloaded
+	prog.Progress = percentage(prog.Downloaded, prog.Size)
+	t.manifest.Downloaded(c.index, c.downloaded)
+
+	progress := t.snapshot()
+	t.mutex.Unlock()
+
+	t.publish(progress)
+
+	return int(keep)
+}
+
+// tick samples the speed without new bytes, so it drops when the download stalls, and publishes the progress
+func (t *tracker) tick() {
+	t.mutex.Lock()
+
+	now := time.Now()
+	t.meter.add(0, now)
+
+	for i, c := range t.chunks {
+		if !c.finished {
+			t.meters[i].add(0, now)
+		}
+	}
+
+	progress := t.snapshot()
+	t.mutex.Unlock()
+
+	t.publish(progress)
+}
+
+func (t *tracker) start(c *chunk) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c.started = true
+}
+
+func (t *tracker) done(c *chunk) {
+	t.mutex.Lock()
+
+	c.finished = true
+
+	prog := &t.progress.Chunks[c.index]
+	prog.Done = true
+	prog.Progress = 100
+
+	if c.end != -1 {
+		prog.Downloaded = prog.Size
+	}
+
+	t.manifest.Finished(c.index)
+
+	progress := t.snapshot()
+	t.mutex.Unlock()
+
+	t.publish(progress)
+}
+
+// split cuts the remaining range of the chunk that has the most bytes left in half, and returns a new chunk for the second half.
+// It returns nil if some chunks haven't started yet, since an idle worker will pick them up, or if no chunk is worth to split
+func (t *tracker) split(minSize int64) *chunk {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var largest *chunk
+	var remaining int64
+
+	for _, c := range t.chunks {
+		if !c.started {
+			return nil
+		}
+
+		if c.finished || c.end == -1 {
+			continue
+		}
+
+		if left := c.length() - c.downloaded; left > remaining {
+			largest = c
+			remaining = left
+		}
+	}
+
+	if largest == nil || remaining < 2*minSize {
+		return nil
+	}
+
+	mid := largest.start + largest.downloaded + remaining/2
+	child := newChunk(largest.entry, len(t.chunks), mid, largest.end, largest.setting, largest.wg)
+	child.tracker = t
+	child.storage = largest.storage
+	child.open = largest.open
+	child.onfinish = largest.onfinish
+
+	// the split chunk may be slow because of its mirror, so the second half goes to the next one
+	child.source = (largest.source + 1) % len(t.sources)
+
+	largest.end = mid - 1
+	t.chunks = append(t.chunks, child)
+	t.meters = append(t.meters, newMeter(time.Now()))
+
+	prog := &t.progress.Chunks[largest.index]
+	prog.Size = largest.length()
+	prog.Progress = percentage(prog.Downloaded, prog.Size)
+
+	t.progress.Chunks = append(t.progress.Chunks, client.ChunkProgress{
+		Size:   child.length(),
+		Mirror: child.source,
+	})
+
+	t.manifest.SetChunk(largest.index, largest.start, largest.end)
+	t.manifest.AddChunk(child.start, child.end)
+
+	return child
+}
+
+// segments returns the chunks ordered by their position in the file
+func (t *tracker) segments() []*chunk {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// chunks of unknown position keep the order of their index
+	segments := append([]*chunk{}, t.chunks...)
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].start < segments[j].start
+	})
+
+	return segments
+}
@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+)
+
+// errRangeUnsupported means the server doesn't serve the requested range, even though it claims so. The download falls back to a single stream
+var errRangeUnsupported = fmt.Errorf("server doesn't support range")
+
+var errSizeChanged = fmt.Errorf("file size on the server has changed")
+
+// parseContentRange parses the Content-Range of a partial response, e.g bytes 0-499/1234. Total of -1 means it is unknown
+func parseContentRange(header string) (int64, int64, int64, error) {
+	unit, spec, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || unit != "bytes" {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", header)
+	}
+
+	span, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", header)
+	}
+
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", header)
+	}
+
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid start of content range %q", header)
+	}
+
+	end, err := strconv.ParseInt(to, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("invalid end of content range %q", header)
+	}
+
+	total := int64(-1)
+	if size != "*" {
+		total, err = strconv.ParseInt(size, 10, 64)
+		if err != nil || total <= end {
+			return 0, 0, 0, fmt.Errorf("invalid size of content range %q", header)
+		}
+	}
+
+	return start, end, total, nil
+}
+
+// validateRange checks that the response serves the requested range of the file. A full response is only accepted
+// when the range covers the whole file, since its body is the same. Any other mismatch would corrupt the file
+func validateRange(res *http.Response, from, to, size int64) error {
+	// the end of the last chunk may go past the file size
+	if size > 0 && to >= size {
+		to = size - 1
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		if from != 0 || size <= 0 || to != size-1 {
+			return fmt.Errorf("%w: got the full file for range %d-%d", errRangeUnsupported, from, to)
+		}
+
+		if res.ContentLength != -1 && res.ContentLength != size {
+			return fmt.Errorf("%w: got %d bytes, expected %d", errSizeChanged, res.ContentLength, size)
+		}
+
+		return nil
+	case http.StatusPartialContent:
+		start, end, total, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err != nil {
+			return fmt.Errorf("%w: %s", errRangeUnsupported, err.Error())
+		}
+
+		if total != -1 && size > 0 && total != size {
+			return fmt.Errorf("%w: got %d bytes, expected %d", errSizeChanged, total, size)
+		}
+
+		// a shorter range is fine, since the rest is requested again once the body ends
+		if start != from || end > to {
+			return fmt.Errorf("%w: got range %d-%d for range %d-%d", errRangeUnsupported, start, end, from, to)
+		}
+
+		if res.ContentLength != -1 && res.ContentLength != end-start+1 {
+			return fmt.Errorf("%w: got %d bytes for range %d-%d", errRangeUnsupported, res.ContentLength, start, end)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: unexpected status %d", errRangeUnsupported, res.StatusCode)
+	}
+}
+
+// fallback switches the entry to a single stream when the download failed because range is broken. It returns false if it
+// doesn't apply, or the entry has fallen back already
+func (dl *localDownloader) fallback(e entry.Entry, err error) bool {
+	if !errors.Is(err, errRangeUnsupported) || e.Context().Err() != nil {
+		return false
+	}
+
+	client, ok := e.(entry.SingleStreamClient)
+	if !ok || client.Fallback() != "" {
+		return false
+	}
+
+	log.Println("falling back to a single stream for", e.Name(), ":", err.Error())
+	client.SingleStream(err.Error())
+
+	return true
+}
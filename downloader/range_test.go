@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		end    int64
+		total  int64
+		valid  bool
+	}{
+		{"bytes 0-499/1234", 0, 499, 1234, true},
+		{"bytes 500-1233/1234", 500, 1233, 1234, true},
+		{"bytes 0-499/*", 0, 499, -1, true},
+		{"", 0, 0, 0, false},
+		{"items 0-499/1234", 0, 0, 0, false},
+		{"bytes */1234", 0, 0, 0, false},
+		{"bytes 500-499/1234", 0, 0, 0, false},
+		{"bytes 0-1234/1234", 0, 0, 0, false},
+		{"bytes -1-499/1234", 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		start, end, total, err := parseContentRange(test.header)
+		if (err == nil) != test.valid {
+			t.Errorf("Expected content range %q to be valid %v, but got %v", test.header, test.valid, err)
+			continue
+		}
+
+		if test.valid && (start != test.start || end != test.end || total != test.total) {
+			t.Errorf("Expected content range %q to be %d-%d/%d, but got %d-%d/%d", test.header, test.start, test.end, test.total, start, end, total)
+		}
+	}
+}
+
+func TestValidateRange(t *testing.T) {
+	response := func(status int, contentRange string, length int64) *http.Response {
+		res := &http.Response{
+			StatusCode:    status,
+			Header:        http.Header{},
+			ContentLength: length,
+		}
+
+		if contentRange != "" {
+			res.Header.Set("Content-Range", contentRange)
+		}
+
+		return res
+	}
+
+	tests := []struct {
+		name     string
+		res      *http.Response
+		from, to int64
+		expected error
+	}{
+		{"partial", response(http.StatusPartialContent, "bytes 100-199/1000", 100), 100, 199, nil},
+		{"last chunk past the size", response(http.StatusPartialContent, "bytes 900-999/1000", 100), 900, 1000, nil},
+		{"shorter range", response(http.StatusPartialContent, "bytes 100-149/1000", 50), 100, 199, nil},
+		{"unknown total", response(http.StatusPartialContent, "bytes 100-199/*", -1), 100, 199, nil},
+		{"full file for the whole range", response(http.StatusOK, "", 1000), 0, 1000, nil},
+		{"full file for a chunk", response(http.StatusOK, "", 1000), 100, 199, errRangeUnsupported},
+		{"full file for the first chunk", response(http.StatusOK, "", 1000), 0, 499, errRangeUnsupported},
+		{"other start", response(http.StatusPartialContent, "bytes 0-99/1000", 100), 100, 199, errRangeUnsupported},
+		{"longer range", response(http.StatusPartialContent, "bytes 100-299/1000", 200), 100, 199, errRangeUnsupported},
+		{"missing content range", response(http.StatusPartialContent, "", 100), 100, 199, errRangeUnsupported},
+		{"length mismatch", response(http.StatusPartialContent, "bytes 100-199/1000", 10), 100, 199, errRangeUnsupported},
+		{"other size", response(http.StatusPartialContent, "bytes 100-199/2000", 100), 100, 199, errSizeChanged},
+		{"full file of other size", response(http.StatusOK, "", 2000), 0, 1000, errSizeChanged},
+		{"no content", response(http.StatusNoContent, "", 0), 100, 199, errRangeUnsupported},
+	}
+
+	for _, test := range tests {
+		err := validateRange(test.res, test.from, test.to, 1000)
+		if test.expected == nil && err != nil {
+			t.Errorf("%s: expected the response to be valid, but got %v", test.name, err)
+		}
+
+		if test.expected != nil && !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, but got %v", test.name, test.expected, err)
+		}
+	}
+}
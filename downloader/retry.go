@@ -0,0 +1,226 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// statusError is returned when the server responds to a chunk request with an error status
+	statusError struct {
+		code       int
+		host       string
+		retryAfter time.Duration
+	}
+
+	// permanentError fails the chunk without any retry, e.g the chunk file can't be written
+	permanentError struct {
+		err error
+	}
+
+	// watchdog cancels the attempt of a chunk when no byte is received for the given duration
+	watchdog struct {
+		mutex   sync.Mutex
+		timer   *time.Timer
+		timeout time.Duration
+		stalled bool
+	}
+
+	watched struct {
+		io.ReadCloser
+		watchdog *watchdog
+	}
+)
+
+// retryBase is the delay of the first retry, which doubles on every next one up to retryMax
+var retryBase = time.Second
+
+var retryMax = 30 * time.Second
+
+// maxRetryAfter bounds the delay asked by the server, so a misbehaving server can't hold the chunk for hours
+var maxRetryAfter = 5 * time.Minute
+
+var errStalled = fmt.Errorf("chunk is stalled")
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server %s responded with status %d", e.host, e.code)
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func permanent(err error) error {
+	return &permanentError{err}
+}
+
+func newStatusError(res *http.Response) *statusError {
+	return &statusError{
+		code:       res.StatusCode,
+		host:       res.Request.URL.Host,
+		retryAfter: retryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// retryAfter parses the header, which is either the seconds to wait or the date to retry at
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil || date.Before(now) {
+		return 0
+	}
+
+	return date.Sub(now)
+}
+
+// retryable tells whether another attempt may succeed. Client errors are fatal, except for timeout and too many requests,
+// while server errors, network errors and stalls are not
+func retryable(err error) bool {
+	var permanentErr *permanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.code == http.StatusTooManyRequests, statusErr.code == http.StatusRequestTimeout:
+			return true
+		case statusErr.code >= http.StatusInternalServerError:
+			return true
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// backoff returns the delay before the given attempt, counted from 0. It grows exponentially with jitter,
+// so the chunks that failed together don't retry together. The delay asked by the server is honored instead
+func backoff(attempt int, err error) time.Duration {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+		if statusErr.retryAfter > maxRetryAfter {
+			return maxRetryAfter
+		}
+
+		return statusErr.retryAfter
+	}
+
+	delay := retryMax
+	if attempt < 30 && retryBase<<attempt < retryMax {
+		delay = retryBase << attempt
+	}
+
+	// anywhere between half and the whole delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sleep waits for the delay, and returns false if the context is done before that
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// newWatchdog calls cancel when it isn't kicked within the timeout. Timeout of 0 means no watchdog
+func newWatchdog(timeout time.Duration, cancel context.CancelFunc) *watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+
+	w := &watchdog{
+		timeout: timeout,
+	}
+
+	w.timer = time.AfterFunc(timeout, func() {
+		w.mutex.Lock()
+		w.stalled = true
+		w.mutex.Unlock()
+
+		cancel()
+	})
+
+	return w
+}
+
+func (w *watchdog) kick() {
+	if w != nil {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+// pause stops the timeout until the next kick
+func (w *watchdog) pause() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *watchdog) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+// err replaces the error caused by the cancellation with errStalled, so it is retried
+func (w *watchdog) err(err error) error {
+	if w == nil || err == nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.stalled {
+		return err
+	}
+
+	return fmt.Errorf("%w: no data received for %s", errStalled, w.timeout)
+}
+
+func (w *watchdog) watch(reader io.ReadCloser) io.ReadCloser {
+	if w == nil {
+		return reader
+	}
+
+	return &watched{reader, w}
+}
+
+func (r *watched) Read(payload []byte) (int, error) {
+	n, err := r.ReadCloser.Read(payload)
+	if n > 0 {
+		r.watchdog.kick()
+	}
+
+	return n, err
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		delay  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+
+	for _, test := range tests {
+		if delay := retryAfter(test.header, now); delay != test.delay {
+			t.Errorf("Expected Retry-After %q to be %s, but got %s", test.header, test.delay, delay)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&statusError{code: http.StatusNotFound}, false},
+		{&statusError{code: http.StatusForbidden}, false},
+		{&statusError{code: http.StatusTooManyRequests}, true},
+		{&statusError{code: http.StatusRequestTimeout}, true},
+		{&statusError{code: http.StatusBadGateway}, true},
+		{fmt.Errorf("wrapped:%w", &statusError{code: http.StatusGone}), false},
+		{io.ErrUnexpectedEOF, true},
+		{errStalled, true},
+		{permanent(fmt.Errorf("no space left on device")), false},
+	}
+
+	for _, test := range tests {
+		if retryable(test.err) != test.retryable {
+			t.Errorf("Expected %v to be retryable %v", test.err, test.retryable)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		delay := backoff(attempt, io.ErrUnexpectedEOF)
+
+		max := retryMax
+		if attempt < 30 && retryBase<<attempt < retryMax {
+			max = retryBase << attempt
+		}
+
+		if delay < max/2 || delay > max {
+			t.Errorf("Expected delay of attempt %d between %s and %s, but got %s", attempt, max/2, max, delay)
+		}
+	}
+
+	limited := &statusError{code: http.StatusTooManyRequests, retryAfter: 7 * time.Second}
+	if delay := backoff(0, limited); delay != 7*time.Second {
+		t.Errorf("Expected the delay asked by the server, but got %s", delay)
+	}
+
+	limited.retryAfter = time.Hour
+	if delay := backoff(0, limited); delay != maxRetryAfter {
+		t.Errorf("Expected the delay asked by the server to be bounded, but got %s", delay)
+	}
+}
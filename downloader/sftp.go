@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpFile reads the range of a chunk over an ssh connection of its own
+type sftpFile struct {
+	ctx    context.Context
+	conn   *ssh.Client
+	client *sftp.Client
+	file   *sftp.File
+	done   chan struct{}
+	once   sync.Once
+}
+
+var SFTP = "sftp"
+
+// newSFTPDownloader downloads over sftp with the same chunks, storage and retries as the default downloader
+func newSFTPDownloader(opt *option) Downloader {
+	dl := newLocalDownloader(opt).(*localDownloader)
+	dl.open = (*chunk).getSFTPFile
+
+	return dl
+}
+
+// sftpError tells the retry which errors are permanent, since neither the credentials nor a missing file get better with time
+func sftpError(err error) error {
+	if errors.Is(err, network.ErrSSHAuth) || errors.Is(err, network.ErrHostKey) {
+		return permanent(err)
+	}
+
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return permanent(err)
+	}
+
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.Code != uint32(sftp.ErrSSHFxFailure) {
+		return permanent(err)
+	}
+
+	return err
+}
+
+func (c *chunk) getSFTPFile(ctx context.Context) (io.ReadCloser, error) {
+	client, ok := c.entry.(entry.SFTPClient)
+	if !ok {
+		return nil, permanent(fmt.Errorf("%s is not an sftp entry", c.entry.URL()))
+	}
+
+	u := client.SFTP()
+
+	options := []network.Options{network.UseSetting(c.setting)}
+	if i, ok := c.entry.(entry.InsecureClient); ok {
+		options = append(options, network.SkipVerify(i.Insecure()))
+	}
+
+	conn, err := network.DialSSH(ctx, u, options...)
+	if err != nil {
+		log.Println("error connecting for chunk", c.index, ":", err.Error())
+		return nil, sftpError(err)
+	}
+
+	file := &sftpFile{
+		ctx:  ctx,
+		conn: conn,
+		done: make(chan struct{}),
+	}
+
+	go file.watch()
+
+	if file.client, err = sftp.NewClient(conn); err != nil {
+		file.Close()
+		return nil, ctxError(ctx, err)
+	}
+
+	if file.file, err = file.client.Open(network.SFTPPath(u)); err != nil {
+		file.Close()
+		return nil, ctxError(ctx, sftpError(err))
+	}
+
+	// a file of unknown size is always read from the start
+	offset, to := c.tracker.position(c)
+	if to == -1 {
+		offset = 0
+	}
+
+	log.Println("downloading chunk", c.index, "from", offset, "over sftp")
+
+	if _, err := file.file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, permanent(err)
+	}
+
+	return file, nil
+}
+
+// ctxError returns the error of the context if it is done, since closing the connection is what made the request fail
+func ctxError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// watch interrupts the read once the context is done, since the requests on the session don't take the context
+func (f *sftpFile) watch() {
+	select {
+	case <-f.done:
+	case <-f.ctx.Done():
+		f.conn.Close()
+	}
+}
+
+func (f *sftpFile) Read(payload []byte) (int, error) {
+	n, err := f.file.Read(payload)
+	if err != nil && err != io.EOF {
+		return n, ctxError(f.ctx, err)
+	}
+
+	return n, err
+}
+
+// Close closes the connection, which ends the session and every open file with it
+func (f *sftpFile) Close() error {
+	f.once.Do(func() {
+		close(f.done)
+		f.conn.Close()
+	})
+
+	return nil
+}
+
+func init() {
+	registerDownloader(SFTP, newSFTPDownloader)
+}
@@ -0,0 +1,173 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/network/sftptest"
+	"github.com/rapid-downloader/rapid/setting"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpSetting trusts the host key of the server, and doesn't offer the keys of the user running the tests
+func sftpSetting(t *testing.T, server *sftptest.Server) *setting.Setting {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	s := testSetting(t)
+	s.KnownHosts = filepath.Join(t.TempDir(), "known_hosts")
+
+	if err := os.WriteFile(s.KnownHosts, []byte(knownhosts.Line([]string{server.Addr()}, server.HostKey())+"\n"), 0600); err != nil {
+		t.Fatal("Error writing known hosts:", err.Error())
+	}
+
+	return s
+}
+
+func TestSFTPDownloadSegmented(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := sftptest.NewServer(map[string][]byte{"builds/app.tar.gz": content}, sftptest.WithPassword("builder", "secret"))
+	defer server.Close()
+
+	s := sftpSetting(t, server)
+	url := strings.Replace(server.URL, "://", "://builder:secret@", 1) + "/builds/app.tar.gz"
+
+	e, err := entry.Fetch(url, entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if e.Downloader() != SFTP || e.ChunkLen() < 2 {
+		t.Fatalf("Expected an sftp entry split into chunks, but got %s with %d chunks", e.Downloader(), e.ChunkLen())
+	}
+
+	if err := New(SFTP, UseSetting(s)).Download(e); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(e.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+
+	if offsets := server.Offsets(); len(offsets) != e.ChunkLen() {
+		t.Errorf("Expected a read per chunk, but got offsets %v", offsets)
+	}
+}
+
+func TestSFTPResumeFromManifest(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := sftptest.NewServer(map[string][]byte{"file.bin": content})
+	defer server.Close()
+
+	s := sftpSetting(t, server)
+	fetched, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the first chunk has been downloaded up to 10 bytes before the download stopped
+	manifest := entry.NewManifest(fetched)
+	chunkSize := fetched.Size() / int64(fetched.ChunkLen())
+	for i := 0; i < fetched.ChunkLen(); i++ {
+		start, end := calculatePosition(fetched, chunkSize, i)
+		manifest.SetChunk(i, start, end)
+	}
+
+	manifest.Downloaded(0, 10)
+	if err := manifest.Save(s.DataLocation); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	chunkfile := filepath.Join(s.DownloadLocation, fmt.Sprintf("%s-%d", fetched.ID(), 0))
+	if err := os.WriteFile(chunkfile, content[:10], 0644); err != nil {
+		t.Fatal("Error writing chunk file:", err.Error())
+	}
+
+	restored, err := entry.LoadManifests(s.DataLocation)[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if err := New(SFTP, UseSetting(s)).Resume(restored); err != nil {
+		t.Fatal("Error resuming:", err.Error())
+	}
+
+	result, err := os.ReadFile(restored.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Resumed file is different from the served content")
+	}
+
+	restarted := false
+	for _, offset := range server.Offsets() {
+		restarted = restarted || offset == 10
+	}
+
+	if !restarted {
+		t.Errorf("Expected the first chunk to continue from 10, but got offsets %v", server.Offsets())
+	}
+}
+
+func TestSFTPDownloadPartialReads(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	server := sftptest.NewServer(map[string][]byte{"file.bin": content}, sftptest.WithMaxRead(7))
+	defer server.Close()
+
+	s := sftpSetting(t, server)
+	e, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// every reply carries less than the read asked for, so a read takes several requests
+	if err := New(SFTP, UseSetting(s)).Download(e); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	result, err := os.ReadFile(e.Location())
+	if err != nil {
+		t.Fatal("Error reading downloaded file:", err.Error())
+	}
+
+	if !bytes.Equal(result, content) {
+		t.Error("Downloaded file is different from the served content")
+	}
+}
+
+func TestSFTPDownloadRemovedFile(t *testing.T) {
+	server := sftptest.NewServer(map[string][]byte{"file.bin": bytes.Repeat([]byte("0123456789"), 100)})
+	defer server.Close()
+
+	s := sftpSetting(t, server)
+	e, err := entry.Fetch(server.URL+"/file.bin", entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	server.Remove("file.bin")
+
+	// a missing file doesn't come back with retries, so the download fails right away
+	start := time.Now()
+	if err := New(SFTP, UseSetting(s)).Download(e); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected the removed file to fail the download, but got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > retryBase {
+		t.Errorf("Expected no retry of the removed file, but the download took %s", elapsed)
+	}
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import "time"
+
+// meter measures the download speed as the exponential moving average of the speed of every sample
+type meter struct {
+	rate    float64
+	bytes   int64 // bytes since the last sample
+	last    time.Time
+	sampled bool
+}
+
+const (
+	// sampleInterval is the minimum duration of a sample, so the speed doesn't jump on every read
+	sampleInterval = 500 * time.Millisecond
+	// smoothing is the weight of the latest sample
+	smoothing = 0.3
+)
+
+func newMeter(now time.Time) *meter {
+	return &meter{
+		last: now,
+	}
+}
+
+// add records n bytes, and takes a sample once the sample interval has passed. Adding 0 byte lets the speed drop when the download stalls
+func (m *meter) add(n int64, now time.Time) {
+	m.bytes += n
+
+	elapsed := now.Sub(m.last)
+	if elapsed < sampleInterval {
+		return
+	}
+
+	current := float64(m.bytes) / elapsed.Seconds()
+	if m.sampled {
+		m.rate = smoothing*current + (1-smoothing)*m.rate
+	} else {
+		m.rate = current
+		m.sampled = true
+	}
+
+	m.bytes = 0
+	m.last = now
+}
+
+// speed returns the average bytes per second
+func (m *meter) speed() float64 {
+	return m.rate
+}
+
+// eta returns the seconds left to download the remaining bytes, or -1 if it can't be estimated
+func eta(remaining int64, speed float64) float64 {
+	if remaining < 0 || speed <= 0 {
+		return -1
+	}
+
+	return float64(remaining) / speed
+}
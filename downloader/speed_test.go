@@ -0,0 +1,44 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeterMovingAverage(t *testing.T) {
+	now := time.Now()
+	m := newMeter(now)
+
+	// the speed is not sampled until the sample interval has passed
+	m.add(1000, now.Add(100*time.Millisecond))
+	if m.speed() != 0 {
+		t.Errorf("Expected no speed before the first sample, but got %f", m.speed())
+	}
+
+	now = now.Add(time.Second)
+	m.add(0, now)
+	if m.speed() != 1000 {
+		t.Errorf("Expected the first sample to be the speed, but got %f", m.speed())
+	}
+
+	// a stalled download only lowers the speed gradually
+	now = now.Add(time.Second)
+	m.add(0, now)
+	if expected := 1000 * (1 - smoothing); m.speed() != expected {
+		t.Errorf("Expected speed to drop into %f, but got %f", expected, m.speed())
+	}
+}
+
+func TestETA(t *testing.T) {
+	if left := eta(1000, 100); left != 10 {
+		t.Errorf("Expected 10 seconds left, but got %f", left)
+	}
+
+	if left := eta(1000, 0); left != -1 {
+		t.Errorf("Expected unknown time left without speed, but got %f", left)
+	}
+
+	if left := eta(-1, 100); left != -1 {
+		t.Errorf("Expected unknown time left with unknown size, but got %f", left)
+	}
+}
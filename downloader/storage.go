@@ -0,0 +1,194 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/fs"
+	"github.com/rapid-downloader/rapid/log"
+)
+
+const (
+	// TempFile writes every chunk into its own file, and combines them once all chunks are done
+	TempFile = "tempfile"
+	// Preallocate reserves the whole file up front, and every chunk writes directly into its own offset
+	Preallocate = "preallocate"
+)
+
+// storage decides where the chunks are written into
+type storage interface {
+	name() string
+	// open returns the writer of the chunk, which continues from the downloaded bytes of the chunk
+	open(c *chunk) (io.WriteCloser, error)
+	// written returns how many bytes of the chunk can be kept when resuming. Negative recorded value means it is unknown
+	written(c *chunk, recorded int64) int64
+	// discard removes what is written by the previous attempt
+	discard(c *chunk)
+	// combine turns the chunks, ordered by their position, into the entry file
+	combine(e entry.Entry, chunks []*chunk) error
+	close() error
+}
+
+func newStorage(strategy string, e entry.Entry) (storage, error) {
+	// the file can't be reserved up front if the size is unknown
+	if strategy != Preallocate || e.Size() <= 0 {
+		return &tempStorage{}, nil
+	}
+
+	return newPreallocatedStorage(e)
+}
+
+type tempStorage struct{}
+
+func (s *tempStorage) name() string {
+	return TempFile
+}
+
+func (s *tempStorage) open(c *chunk) (io.WriteCloser, error) {
+	file, err := os.OpenFile(c.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Println("error creating or appending file:", err.Error())
+		return nil, err
+	}
+
+	// the bytes after the recorded position may be left by the previous attempt, so they can't be trusted
+	if err := file.Truncate(c.tracker.written(c)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (s *tempStorage) written(c *chunk, recorded int64) int64 {
+	return resumePosition(c.path, recorded)
+}
+
+func (s *tempStorage) discard(c *chunk) {
+	os.Remove(c.path)
+}
+
+func (s *tempStorage) combine(e entry.Entry, chunks []*chunk) error {
+	// if there is only one chunk, then just rename the chunk into entry filename
+	if len(chunks) == 1 {
+		return fs.Move(chunks[0].path, e.Location())
+	}
+
+	return mergeChunks(e.Location(), chunks)
+}
+
+func (s *tempStorage) close() error {
+	return nil
+}
+
+// mergeChunks creates the file of the chunks in their order, and removes their temp files
+func mergeChunks(location string, chunks []*chunk) error {
+	file, err := os.Create(location)
+	if err != nil {
+		log.Println("error creating downloaded file:", err.Error())
+		return err
+	}
+
+	defer file.Close()
+
+	for _, chunk := range chunks {
+		if err := appendChunk(file, chunk.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendChunk(dst io.Writer, srcName string) error {
+	tmpFile, err := os.Open(srcName)
+	if err != nil {
+		log.Println("error opening downloaded chunk file:", err.Error())
+		return err
+	}
+
+	defer tmpFile.Close()
+
+	if _, err := io.Copy(dst, tmpFile); err != nil {
+		log.Println("error copying chunk file into actual file:", err.Error())
+		return err
+	}
+
+	return os.Remove(srcName)
+}
+
+type preallocatedStorage struct {
+	file *os.File
+}
+
+func newPreallocatedStorage(e entry.Entry) (*preallocatedStorage, error) {
+	file, err := os.OpenFile(e.Location(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening preallocated file: %s", err.Error())
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if stat.Size() != e.Size() {
+		if err := preallocate(file, e.Size()); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error preallocating file: %s", err.Error())
+		}
+	}
+
+	return &preallocatedStorage{file}, nil
+}
+
+func (s *preallocatedStorage) name() string {
+	return Preallocate
+}
+
+func (s *preallocatedStorage) open(c *chunk) (io.WriteCloser, error) {
+	return &offsetWriter{
+		file:   s.file,
+		offset: c.start + c.tracker.written(c),
+	}, nil
+}
+
+// written trusts the recorded value only, since the file size is already the size of the entry
+func (s *preallocatedStorage) written(c *chunk, recorded int64) int64 {
+	if recorded < 0 {
+		return 0
+	}
+
+	return recorded
+}
+
+func (s *preallocatedStorage) discard(c *chunk) {}
+
+func (s *preallocatedStorage) combine(e entry.Entry, chunks []*chunk) error {
+	return s.file.Sync()
+}
+
+func (s *preallocatedStorage) close() error {
+	return s.file.Close()
+}
+
+// offsetWriter writes into the shared file starting from the offset of a chunk
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(payload []byte) (int, error) {
+	n, err := w.file.WriteAt(payload, w.offset)
+	w.offset += int64(n)
+
+	return n, err
+}
+
+// Close does nothing, since the file is shared by every chunk and closed by the storage
+func (w *offsetWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,230 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+)
+
+type (
+	// stream is the kind of entry which is downloaded segment by segment, e.g an hls or a dash stream
+	stream interface {
+		// segments returns how many segments the entry has, which is one chunk each
+		segments(e entry.Entry) (int, error)
+		// storage returns where the segments are written into
+		storage(e entry.Entry) storage
+		// release forgets what is kept for the download of the entry
+		release(e entry.Entry)
+	}
+
+	// streamDownloader downloads every segment of the stream as a chunk of unknown size, and lets the storage of the stream
+	// merge them once all of them are done
+	streamDownloader struct {
+		*localDownloader
+		stream stream
+	}
+)
+
+func newStreamDownloader(opt *option, s stream) *streamDownloader {
+	return &streamDownloader{
+		localDownloader: newLocalDownloader(opt).(*localDownloader),
+		stream:          s,
+	}
+}
+
+// chunks returns a chunk of unknown size for every segment of the entry
+func (dl *streamDownloader) chunks(e entry.Entry, wg *sync.WaitGroup) ([]*chunk, error) {
+	count, err := dl.stream.segments(e)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("stream of %s has no segments", e.Name())
+	}
+
+	chunks := make([]*chunk, count)
+	for i := range chunks {
+		chunks[i] = newChunk(e, i, -1, -1, dl.setting, wg)
+	}
+
+	return chunks, nil
+}
+
+func (dl *streamDownloader) Download(e entry.Entry) error {
+	start := time.Now()
+
+	if err := renew(e); err != nil {
+		return err
+	}
+
+	if e.Expired() {
+		return ErrUrlExpired
+	}
+
+	var wg sync.WaitGroup
+
+	chunks, err := dl.chunks(e, &wg)
+	if err != nil {
+		return err
+	}
+
+	// the size of a stream is unknown, so its segments are always written into temp files
+	storage := dl.stream.storage(e)
+
+	if recorded, err := loadManifest(dl.setting, e); err == nil {
+		for i := range recorded.Chunks {
+			storage.discard(newChunk(e, i, -1, -1, dl.setting, &wg))
+		}
+	}
+
+	for _, chunk := range chunks {
+		storage.discard(chunk)
+	}
+
+	return dl.finish(e, start, dl.download(e, chunks, storage, &wg))
+}
+
+// Resume keeps the segments that are done, and downloads the others from their start
+func (dl *streamDownloader) Resume(e entry.Entry) error {
+	start := time.Now()
+
+	if e.Expired() {
+		return ErrUrlExpired
+	}
+
+	if err := e.Refresh(); err != nil {
+		if !changed(err) {
+			return err
+		}
+
+		log.Println("stream of", e.Name(), "has changed. Restarting...")
+		return dl.Download(e)
+	}
+
+	log.Println("resuming download", e.Name(), "...")
+
+	var wg sync.WaitGroup
+
+	chunks, err := dl.chunks(e, &wg)
+	if err != nil {
+		return err
+	}
+
+	// only the manifest can tell which segments are done, since their size is unknown
+	recorded, err := loadManifest(dl.setting, e)
+	if err != nil || len(recorded.Chunks) != len(chunks) {
+		log.Println("segments of", e.Name(), "can't be resumed without manifest. Restarting...")
+		return dl.Download(e)
+	}
+
+	storage := dl.stream.storage(e)
+
+	for i, state := range recorded.Chunks {
+		if !state.Done || storage.written(chunks[i], state.Downloaded) != state.Downloaded {
+			storage.discard(chunks[i])
+			continue
+		}
+
+		chunks[i].downloaded = state.Downloaded
+		chunks[i].started = true
+		chunks[i].finished = true
+	}
+
+	return dl.finish(e, start, dl.download(e, chunks, storage, &wg))
+}
+
+func (dl *streamDownloader) finish(e entry.Entry, start time.Time, err error) error {
+	dl.stream.release(e)
+
+	if err != nil {
+		if dl.restart(e, err) {
+			return dl.Download(e)
+		}
+
+		return err
+	}
+
+	elapsed := time.Since(start)
+	log.Println(e.Name(), "downloaded in", elapsed.Seconds(), "s")
+
+	return nil
+}
+
+func (dl *streamDownloader) Restart(e entry.Entry) error {
+	log.Println("restarting download", e.Name(), "...")
+
+	if e.Expired() {
+		return ErrUrlExpired
+	}
+
+	if err := e.Refresh(); err != nil && !changed(err) {
+		return err
+	}
+
+	return dl.Download(e)
+}
+
+// segmentRequest returns the request of the url with the headers of the entry, e.g its cookies and user agent
+func segmentRequest(ctx context.Context, e entry.Entry, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, permanent(err)
+	}
+
+	if client, ok := e.(entry.RequestClient); ok && client.Request() != nil {
+		req.Header = client.Request().Header.Clone()
+	}
+
+	return req, nil
+}
+
+// openSegment requests the segment of the chunk from its start, since the size of the segment is unknown. A negative length
+// requests the whole resource, otherwise the byte range of the resource from the offset
+func openSegment(ctx context.Context, c *chunk, url string, offset, length int64) (*http.Response, error) {
+	req, err := segmentRequest(ctx, c.entry, url)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := offset, offset+length-1
+	if length >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+	}
+
+	log.Println("downloading segment", c.index, "from", url)
+
+	res, err := httpClient(c.entry, c.setting).Do(req)
+	if err != nil {
+		log.Println("error fetching segment:", err.Error())
+		return nil, err
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		res.Body.Close()
+		return nil, newStatusError(res)
+	}
+
+	// the segment is read until its body ends, so a shorter range than the requested one can't be accepted
+	if length >= 0 {
+		if err = validateRange(res, from, to, -1); err == nil {
+			if _, end, _, _ := parseContentRange(res.Header.Get("Content-Range")); end != to {
+				err = fmt.Errorf("%w: got range ending at %d for range %d-%d", errRangeUnsupported, end, from, to)
+			}
+		}
+	} else if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	if err != nil {
+		res.Body.Close()
+		return nil, permanent(err)
+	}
+
+	return res, nil
+}
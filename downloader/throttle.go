@@ -0,0 +1,196 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+type (
+	// limiter is a token bucket which the tokens are bytes. Rate of 0 means unlimited
+	limiter struct {
+		mutex  sync.Mutex
+		rate   int64
+		tokens float64
+		last   time.Time
+	}
+
+	throttled struct {
+		ctx      context.Context
+		reader   io.ReadCloser
+		limiters []*limiter
+		guard    *watchdog // paused while waiting for the limiters, so a low limit isn't taken for a stall
+	}
+)
+
+// maxThrottledRead keeps every read small, so the limit is spread evenly across chunks
+const maxThrottledRead = 32 * 1024
+
+// maxThrottleSleep bounds each sleep, so a new limit is applied soon to the waiting reads
+const maxThrottleSleep = 100 * time.Millisecond
+
+func newLimiter(rate int64) *limiter {
+	return &limiter{
+		rate: rate,
+		last: time.Now(),
+	}
+}
+
+func (l *limiter) setRate(rate int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.refill()
+	l.rate = rate
+}
+
+func (l *limiter) Rate() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.rate
+}
+
+// refill adds the tokens earned since the last call, up to one second worth of rate. Caller must hold the lock
+func (l *limiter) refill() {
+	now := time.Now()
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+
+	if l.rate <= 0 {
+		l.tokens = 0
+		return
+	}
+
+	l.tokens += elapsed * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+}
+
+// wait takes n tokens and blocks until the bucket is no longer in debt
+func (l *limiter) wait(ctx context.Context, n int) error {
+	l.mutex.Lock()
+	l.refill()
+	l.tokens -= float64(n)
+	l.mutex.Unlock()
+
+	for {
+		l.mutex.Lock()
+		l.refill()
+
+		if l.rate <= 0 || l.tokens >= 0 {
+			l.mutex.Unlock()
+			return nil
+		}
+
+		sleep := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		l.mutex.Unlock()
+
+		if sleep > maxThrottleSleep {
+			sleep = maxThrottleSleep
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+	}
+}
+
+func (r *throttled) Read(payload []byte) (int, error) {
+	if len(payload) > maxThrottledRead {
+		payload = payload[:maxThrottledRead]
+	}
+
+	n, err := r.reader.Read(payload)
+
+	r.guard.pause()
+	defer r.guard.kick()
+
+	for _, limiter := range r.limiters {
+		if werr := limiter.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+func (r *throttled) Close() error {
+	return r.reader.Close()
+}
+
+var globalLimiter = newLimiter(0)
+
+var entryLimiters = struct {
+	sync.Mutex
+	limiters map[string]*limiter
+}{
+	limiters: make(map[string]*limiter),
+}
+
+// SetGlobalLimit sets the maximum bytes per second shared by every active download. 0 means unlimited
+func SetGlobalLimit(rate int64) {
+	globalLimiter.setRate(rate)
+}
+
+// GlobalLimit returns the maximum bytes per second shared by every active download
+func GlobalLimit() int64 {
+	return globalLimiter.Rate()
+}
+
+// entryLimiter returns the limiter of a download, and creates an unlimited one if it doesn't have any yet
+func entryLimiter(id string) *limiter {
+	entryLimiters.Lock()
+	defer entryLimiters.Unlock()
+
+	limiter, ok := entryLimiters.limiters[id]
+	if !ok {
+		limiter = newLimiter(0)
+		entryLimiters.limiters[id] = limiter
+	}
+
+	return limiter
+}
+
+// SetEntryLimit sets the maximum bytes per second of a download. 0 means unlimited
+func SetEntryLimit(id string, rate int64) {
+	entryLimiter(id).setRate(rate)
+}
+
+// EntryLimits returns the maximum bytes per second of every download that has a limit
+func EntryLimits() map[string]int64 {
+	entryLimiters.Lock()
+	defer entryLimiters.Unlock()
+
+	limits := make(map[string]int64)
+	for id, limiter := range entryLimiters.limiters {
+		if rate := limiter.Rate(); rate > 0 {
+			limits[id] = rate
+		}
+	}
+
+	return limits
+}
+
+// RemoveEntryLimit removes the limiter of a download, once it is completed or deleted
+func RemoveEntryLimit(id string) {
+	entryLimiters.Lock()
+	defer entryLimiters.Unlock()
+
+	delete(entryLimiters.limiters, id)
+}
+
+// throttle wraps the reader with the global limiter and the limiter of the entry, so changing either applies to the running chunks.
+// The watchdog of the reader, if any, doesn't run while the read waits for the limiters
+func throttle(ctx context.Context, id string, reader io.ReadCloser, guard *watchdog) io.ReadCloser {
+	return &throttled{
+		ctx:      ctx,
+		reader:   reader,
+		limiters: []*limiter{globalLimiter, entryLimiter(id)},
+		guard:    guard,
+	}
+}
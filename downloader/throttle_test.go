@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottleLimit(t *testing.T) {
+	l := newLimiter(100 * 1024)
+	reader := &throttled{
+		ctx:      context.Background(),
+		reader:   io.NopCloser(bytes.NewReader(make([]byte, 50*1024))),
+		limiters: []*limiter{l},
+	}
+
+	start := time.Now()
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatal("Error reading throttled reader:", err.Error())
+	}
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected reading 50 KB at 100 KB/s to take around 500ms, but took %s", elapsed)
+	}
+}
+
+func TestThrottleChangedAtRuntime(t *testing.T) {
+	l := newLimiter(1024)
+	reader := &throttled{
+		ctx:      context.Background(),
+		reader:   io.NopCloser(bytes.NewReader(make([]byte, 64*1024))),
+		limiters: []*limiter{l},
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		l.setRate(0)
+	}()
+
+	start := time.Now()
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatal("Error reading throttled reader:", err.Error())
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected removing the limit to apply to the running read, but took %s", elapsed)
+	}
+}
+
+func TestThrottleCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := &throttled{
+		ctx:      ctx,
+		reader:   io.NopCloser(bytes.NewReader(make([]byte, 64*1024))),
+		limiters: []*limiter{newLimiter(1)},
+	}
+
+	cancel()
+
+	if _, err := io.Copy(io.Discard, reader); err == nil {
+		t.Error("Expected error reading with canceled context")
+	}
+}
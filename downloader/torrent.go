@@ -0,0 +1,470 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network/torrent"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+type (
+	// torrentDownloader downloads the pieces of the torrent from its peers. The chunks are ranges of the pieces that the selected
+	// files take part of, laid out one after another, and the storage cuts the files out of them once all of them are done
+	torrentDownloader struct {
+		*localDownloader
+		peerID   [20]byte
+		torrents sync.Map // torrent of every download in progress, by entry id
+	}
+
+	// torrentDownload is what a download of a torrent needs from its entry
+	torrentDownload struct {
+		info     *torrent.Info
+		trackers []string
+		selected []int // files which are downloaded
+		needed   []int // pieces which the files take part of
+		swarm    *torrent.Swarm
+	}
+
+	// pieceReader reads the range of the needed pieces, a whole piece at a time
+	pieceReader struct {
+		ctx    context.Context
+		t      *torrentDownload
+		offset int64 // position in the needed pieces
+		end    int64 // position after the range
+		buf    []byte
+	}
+
+	// torrentStorage writes the chunks into temp files, and cuts the selected files out of them once all of them are done
+	torrentStorage struct {
+		tempStorage
+		t *torrentDownload
+	}
+)
+
+var Torrent = "torrent"
+
+// announceTimeout bounds the announces that are sent after the entry is stopped
+const announceTimeout = 10 * time.Second
+
+func newTorrentDownloader(opt *option) Downloader {
+	dl := &torrentDownloader{
+		localDownloader: newLocalDownloader(opt).(*localDownloader),
+		peerID:          torrent.NewPeerID(),
+	}
+
+	dl.open = dl.getPieces
+
+	return dl
+}
+
+func loadTorrent(e entry.Entry) (*torrentDownload, error) {
+	client, ok := e.(entry.TorrentClient)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a torrent entry", e.URL())
+	}
+
+	info, err := client.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := client.Selected()
+	if err != nil {
+		return nil, err
+	}
+
+	return &torrentDownload{
+		info:     info,
+		trackers: client.Trackers(),
+		selected: selected,
+		needed:   info.PiecesOf(selected),
+	}, nil
+}
+
+// chunks splits the needed pieces evenly into the chunks of the entry, so a chunk starts at the start of a piece
+func (t *torrentDownload) chunks(e entry.Entry, s *setting.Setting, wg *sync.WaitGroup) ([]*chunk, error) {
+	count := e.ChunkLen()
+	if count < 1 || count > len(t.needed) {
+		return nil, fmt.Errorf("%d chunks of %s for %d pieces", count, e.Name(), len(t.needed))
+	}
+
+	chunks := make([]*chunk, count)
+	for i := range chunks {
+		start := int64(i*len(t.needed)/count) * t.info.PieceLength
+		end := int64((i+1)*len(t.needed)/count)*t.info.PieceLength - 1
+
+		if i == count-1 {
+			end = e.Size() - 1
+		}
+
+		chunks[i] = newChunk(e, i, start, end, s, wg)
+	}
+
+	return chunks, nil
+}
+
+func (dl *torrentDownloader) Download(e entry.Entry) error {
+	start := time.Now()
+
+	if err := renew(e); err != nil {
+		return err
+	}
+
+	t, err := loadTorrent(e)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	chunks, err := t.chunks(e, dl.setting, &wg)
+	if err != nil {
+		return err
+	}
+
+	storage := &torrentStorage{t: t}
+
+	if recorded, err := loadManifest(dl.setting, e); err == nil {
+		for i := range recorded.Chunks {
+			storage.discard(newChunk(e, i, -1, -1, dl.setting, &wg))
+		}
+	}
+
+	for _, chunk := range chunks {
+		storage.discard(chunk)
+	}
+
+	return dl.finish(e, start, dl.transfer(e, t, chunks, storage, &wg))
+}
+
+// Resume keeps the pieces that are written, including the ones of the split chunks that the manifest knows of
+func (dl *torrentDownloader) Resume(e entry.Entry) error {
+	start := time.Now()
+
+	if err := e.Refresh(); err != nil {
+		return err
+	}
+
+	log.Println("resuming download", e.Name(), "...")
+
+	t, err := loadTorrent(e)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	storage := &torrentStorage{t: t}
+	chunks := make([]*chunk, 0)
+
+	recorded, err := loadManifest(dl.setting, e)
+	if err == nil && len(recorded.Chunks) > 0 {
+		for i, state := range recorded.Chunks {
+			chunk := newChunk(e, i, state.Start, state.End, dl.setting, &wg)
+			written := storage.written(chunk, state.Downloaded)
+			chunk.restore(written, state.Done && written == state.Downloaded)
+
+			chunks = append(chunks, chunk)
+		}
+	} else {
+		log.Println("manifest of", e.Name(), "is not found. Resuming from chunk files...")
+
+		if chunks, err = t.chunks(e, dl.setting, &wg); err != nil {
+			return err
+		}
+
+		for _, chunk := range chunks {
+			chunk.restore(storage.written(chunk, -1), false)
+		}
+	}
+
+	return dl.finish(e, start, dl.transfer(e, t, chunks, storage, &wg))
+}
+
+func (dl *torrentDownloader) Restart(e entry.Entry) error {
+	log.Println("restarting download", e.Name(), "...")
+
+	if err := e.Refresh(); err != nil {
+		return err
+	}
+
+	return dl.Download(e)
+}
+
+func (dl *torrentDownloader) finish(e entry.Entry, start time.Time, err error) error {
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	log.Println(e.Name(), "downloaded in", elapsed.Seconds(), "s")
+
+	return nil
+}
+
+// transfer downloads the chunks from the swarm of the torrent, and seeds the files once they are completed
+func (dl *torrentDownloader) transfer(e entry.Entry, t *torrentDownload, chunks []*chunk, storage storage, wg *sync.WaitGroup) error {
+	config := torrent.Config{
+		PeerID:   dl.peerID,
+		Port:     dl.setting.TorrentPort,
+		Trackers: t.trackers,
+		Client:   httpClient(e, dl.setting),
+	}
+
+	t.swarm = torrent.NewSwarm(t.info, config, e.Size())
+	t.swarm.Start(e.Context())
+
+	dl.torrents.Store(e.ID(), t)
+	err := dl.download(e, chunks, storage, wg)
+	dl.torrents.Delete(e.ID())
+
+	completed := err == nil && e.Context().Err() == nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), announceTimeout)
+	t.swarm.Close(ctx, completed)
+	cancel()
+
+	if completed && (dl.setting.SeedRatio > 0 || dl.setting.SeedTime > 0) {
+		if err := dl.seed(e, t); err != nil {
+			log.Println("error seeding", e.Name(), ":", err.Error())
+		}
+	}
+
+	return err
+}
+
+// getPieces reads the range of the chunk from the pieces which the swarm downloads
+func (dl *torrentDownloader) getPieces(c *chunk, ctx context.Context) (io.ReadCloser, error) {
+	value, ok := dl.torrents.Load(c.entry.ID())
+	if !ok {
+		return nil, permanent(fmt.Errorf("%s is not being downloaded", c.entry.Name()))
+	}
+
+	from, to := c.tracker.position(c)
+	log.Println("downloading pieces of chunk", c.index, "from", from, "to", to)
+
+	reader := &pieceReader{
+		ctx:    ctx,
+		t:      value.(*torrentDownload),
+		offset: from,
+		end:    to + 1,
+	}
+
+	return reader, nil
+}
+
+func (r *pieceReader) Read(p []byte) (int, error) {
+	if r.offset >= r.end {
+		return 0, io.EOF
+	}
+
+	if len(r.buf) == 0 {
+		position := r.offset / r.t.info.PieceLength
+
+		piece, err := r.t.swarm.Piece(r.ctx, r.t.needed[position])
+		if err != nil {
+			return 0, err
+		}
+
+		r.buf = piece[r.offset-position*r.t.info.PieceLength:]
+		if remaining := r.end - r.offset; int64(len(r.buf)) > remaining {
+			r.buf = r.buf[:remaining]
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *pieceReader) Close() error {
+	return nil
+}
+
+// position returns where the byte of the torrent is in the needed pieces
+func (t *torrentDownload) position(offset int64) int64 {
+	piece := int(offset / t.info.PieceLength)
+
+	for i, needed := range t.needed {
+		if needed == piece {
+			return int64(i)*t.info.PieceLength + offset%t.info.PieceLength
+		}
+	}
+
+	return -1
+}
+
+// path returns where the file of the torrent is saved, which is inside the folder of the entry unless the torrent is a single file
+func (t *torrentDownload) path(e entry.Entry, file int) string {
+	if t.info.Single {
+		return e.Location()
+	}
+
+	return filepath.Join(e.Location(), filepath.FromSlash(t.info.Files[file].Path))
+}
+
+func (s *torrentStorage) combine(e entry.Entry, chunks []*chunk) error {
+	// the needed pieces of a single file are the file itself
+	if s.t.info.Single {
+		return s.tempStorage.combine(e, chunks)
+	}
+
+	readers := make([]io.Reader, 0, len(chunks))
+	for _, chunk := range chunks {
+		file, err := os.Open(chunk.path)
+		if err != nil {
+			log.Println("error opening downloaded chunk file:", err.Error())
+			return err
+		}
+
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	pieces := io.MultiReader(readers...)
+	position := int64(0)
+
+	// the files are in the order of their offset, so the pieces are read once from the start to the end
+	for _, index := range s.t.selected {
+		file := s.t.info.Files[index]
+		location := s.t.path(e, index)
+
+		if err := os.MkdirAll(filepath.Dir(location), os.ModePerm); err != nil {
+			return err
+		}
+
+		dst, err := os.Create(location)
+		if err != nil {
+			log.Println("error creating downloaded file:", err.Error())
+			return err
+		}
+
+		if file.Length > 0 {
+			start := s.t.position(file.Offset)
+			if _, err = io.CopyN(io.Discard, pieces, start-position); err == nil {
+				_, err = io.CopyN(dst, pieces, file.Length)
+			}
+
+			position = start + file.Length
+		}
+
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			log.Println("error writing", file.Path, ":", err.Error())
+			return err
+		}
+	}
+
+	for _, chunk := range chunks {
+		os.Remove(chunk.path)
+	}
+
+	return nil
+}
+
+// seed serves the pieces of the selected files in the background, until the ratio or the time limit of the setting is
+// reached, or the entry is stopped
+func (dl *torrentDownloader) seed(e entry.Entry, t *torrentDownload) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(dl.setting.TorrentPort)))
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(t.info.Files))
+	for _, index := range t.selected {
+		paths[index] = t.path(e, index)
+	}
+
+	seeder := torrent.Seed(listener, t.info, torrent.NewFiles(t.info, paths), t.info.Within(t.selected), dl.peerID)
+	log.Println("seeding", e.Name(), "on", seeder.Addr().String())
+
+	ratio, limit := dl.setting.SeedRatio, time.Duration(dl.setting.SeedTime)*time.Minute
+	client := httpClient(e, dl.setting)
+
+	announce := func(event string) int {
+		ctx, cancel := context.WithTimeout(context.Background(), announceTimeout)
+		defer cancel()
+
+		a := torrent.Announce{
+			InfoHash:   t.info.Hash,
+			PeerID:     dl.peerID,
+			Port:       seeder.Addr().(*net.TCPAddr).Port,
+			Uploaded:   seeder.Uploaded(),
+			Downloaded: e.Size(),
+			Event:      event,
+		}
+
+		interval := 0
+		for _, tracker := range t.trackers {
+			if _, seconds, err := torrent.AnnounceTo(ctx, client, tracker, a); err != nil {
+				log.Println("error announcing to tracker:", err.Error())
+			} else if interval == 0 || seconds < interval {
+				interval = seconds
+			}
+		}
+
+		return interval
+	}
+
+	go func() {
+		defer seeder.Close()
+
+		started := time.Now()
+		next := started.Add(reannounceAfter(announce("")))
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.Context().Done():
+				log.Println("seeding", e.Name(), "is stopped")
+			case now := <-ticker.C:
+				if ratio > 0 && float64(seeder.Uploaded()) >= ratio*float64(e.Size()) {
+					log.Println("seeding", e.Name(), "has reached the ratio")
+				} else if limit > 0 && now.Sub(started) >= limit {
+					log.Println("seeding", e.Name(), "has reached the time limit")
+				} else {
+					if now.After(next) {
+						next = now.Add(reannounceAfter(announce("")))
+					}
+
+					continue
+				}
+			}
+
+			announce(torrent.EventStopped)
+			return
+		}
+	}()
+
+	return nil
+}
+
+// reannounceAfter returns when the trackers are announced to again, which is the interval they asked for or half an hour
+func reannounceAfter(interval int) time.Duration {
+	if interval <= 0 {
+		return 30 * time.Minute
+	}
+
+	return time.Duration(interval) * time.Second
+}
+
+func init() {
+	registerDownloader(Torrent, newTorrentDownloader)
+}
@@ -0,0 +1,169 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/network/torrent"
+	"github.com/rapid-downloader/rapid/network/torrenttest"
+)
+
+// torrentSwarm serves the .torrent file of the files, which are seeded by a peer that the tracker announces
+func torrentSwarm(t *testing.T, name string, files ...torrenttest.File) (string, *torrenttest.Tracker, *torrent.Seeder, *torrent.Info) {
+	tracker := torrenttest.NewTracker()
+	t.Cleanup(tracker.Close)
+
+	metainfo, info, data := torrenttest.Create(tracker.URL, name, 16*1024, files...)
+
+	seeder := torrenttest.Seed(info, data)
+	t.Cleanup(func() { seeder.Close() })
+
+	tracker.Add(seeder.Addr().String())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(metainfo)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server.URL + "/" + name + ".torrent", tracker, seeder, info
+}
+
+func TestTorrentDownload(t *testing.T) {
+	iso, sums, notes := content(70000, 1), content(300, 2), content(5000, 3)
+
+	folder := []torrenttest.File{
+		{Path: "ubuntu.iso", Content: iso},
+		{Path: "SHA256SUMS", Content: sums},
+		{Path: "extras/notes.txt", Content: notes},
+	}
+
+	tests := []struct {
+		name     string
+		files    []torrenttest.File
+		patterns []string
+		chunks   int               // a chunk per needed piece, since the pieces are larger than the min chunk size
+		expected map[string][]byte // content of the files by their path inside the entry location, empty for the entry itself
+	}{
+		{name: "single file", files: []torrenttest.File{{Content: iso}}, chunks: 5, expected: map[string][]byte{"": iso}},
+		{name: "every file", files: folder, chunks: 5, expected: map[string][]byte{"ubuntu.iso": iso, "SHA256SUMS": sums, "extras/notes.txt": notes}},
+		{name: "selected files", files: folder, patterns: []string{"SHA256SUMS", "*.txt"}, chunks: 1, expected: map[string][]byte{"SHA256SUMS": sums, "extras/notes.txt": notes}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, tracker, _, _ := torrentSwarm(t, "ubuntu", test.files...)
+			s := testSetting(t)
+
+			e, err := entry.Fetch(url, entry.UseSetting(s), entry.UseFiles(test.patterns...))
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			if e.Downloader() != Torrent || e.ChunkLen() != test.chunks {
+				t.Fatalf("Expected a torrent entry of %d chunks, but got %s of %d chunks", test.chunks, e.Downloader(), e.ChunkLen())
+			}
+
+			if err := New(Torrent, UseSetting(s)).Download(e); err != nil {
+				t.Fatal("Error downloading:", err.Error())
+			}
+
+			for path, expected := range test.expected {
+				result, err := os.ReadFile(filepath.Join(e.Location(), filepath.FromSlash(path)))
+				if err != nil || !bytes.Equal(result, expected) {
+					t.Errorf("Expected %d bytes of %s, but got %d (%v)", len(expected), path, len(result), err)
+				}
+			}
+
+			if _, err := os.Stat(filepath.Join(e.Location(), "ubuntu.iso")); len(test.patterns) > 0 && !os.IsNotExist(err) {
+				t.Error("Expected the unselected file not to be saved")
+			}
+
+			files, _ := os.ReadDir(s.DownloadLocation)
+			for _, file := range files {
+				if strings.HasPrefix(file.Name(), e.ID()) {
+					t.Errorf("Expected no leftover temp file, but got %s", file.Name())
+				}
+			}
+
+			if announces := tracker.Announces(); len(announces) < 2 || announces[0] != torrent.EventStarted || announces[len(announces)-1] != torrent.EventCompleted {
+				t.Errorf("Expected the download to be announced as started and completed, but got %v", announces)
+			}
+		})
+	}
+}
+
+func TestTorrentSeed(t *testing.T) {
+	data := content(50000, 4)
+
+	url, tracker, seeder, info := torrentSwarm(t, "ubuntu.iso", torrenttest.File{Content: data})
+	s := testSetting(t)
+	s.SeedRatio = 1
+
+	e, err := entry.Fetch(url, entry.UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	defer e.Cancel()
+
+	if err := New(Torrent, UseSetting(s)).Download(e); err != nil {
+		t.Fatal("Error downloading:", err.Error())
+	}
+
+	// only the completed download is left to serve the pieces
+	seeder.Close()
+
+	// the seeding is announced in the background, so the tracker may not know its port yet
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		announces := tracker.Announces()
+		if announces[len(announces)-1] == "" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the seeding to be announced, but got announces %v", announces)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	leecher := torrent.NewSwarm(info, torrent.Config{PeerID: torrent.NewPeerID(), Trackers: []string{tracker.URL}}, info.Length)
+	leecher.Start(ctx)
+	defer leecher.Close(ctx, true)
+
+	downloaded := make([]byte, 0, len(data))
+	for index := range info.Pieces {
+		piece, err := leecher.Piece(ctx, index)
+		if err != nil {
+			t.Fatal("Error downloading piece from the seeding download:", err.Error())
+		}
+
+		downloaded = append(downloaded, piece...)
+	}
+
+	if !bytes.Equal(downloaded, data) {
+		t.Fatalf("Expected the seeded pieces to be the file")
+	}
+
+	// the ratio of 1 is reached once the whole file is uploaded
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		announces := tracker.Announces()
+		if announces[len(announces)-1] == torrent.EventStopped {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the seeding to stop at the ratio, but got announces %v", announces)
+		}
+	}
+}
@@ -10,9 +10,11 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/rapid-downloader/rapid/api"
 	"github.com/rapid-downloader/rapid/db"
+	"github.com/rapid-downloader/rapid/downloader"
 	"github.com/rapid-downloader/rapid/entry"
 	response "github.com/rapid-downloader/rapid/helper"
 	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/queue"
 	"github.com/rapid-downloader/rapid/setting"
 	"github.com/rapid-downloader/rapid/utils"
 )
@@ -32,7 +34,7 @@ func newService(app *fiber.App) api.Service {
 	return &entryService{
 		app:     app,
 		channel: api.CreateChannel("memstore"),
-		store:   NewStore("download", db.DB()),
+		store:   NewStore("download", db.Resolve()),
 	}
 }
 
@@ -43,13 +45,27 @@ func (s *entryService) fetch(ctx *fiber.Ctx) error {
 		return response.BadRequest(ctx, err)
 	}
 
-	entry, err := entry.Fetch(req.Url, req.toOptions()...)
+	toDownload, err := s.create(req)
 	if err != nil {
 		return response.BadRequest(ctx, err)
 	}
 
+	return response.Ok(ctx, toDownload)
+}
+
+// create fetches the requested entry, hands it to the memstore, and stores it as a download record
+func (s *entryService) create(req request) (*Download, error) {
+	entry, err := entry.Fetch(req.Url, req.toOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
 	s.channel.Publish(entry)
 
+	if req.MaxSpeed > 0 {
+		downloader.SetEntryLimit(entry.ID(), req.MaxSpeed)
+	}
+
 	toDownload := Download{
 		ID:               entry.ID(),
 		Name:             entry.Name(),
@@ -65,14 +81,71 @@ func (s *entryService) fetch(ctx *fiber.Ctx) error {
 		TimeLeft:         0,
 		Speed:            0,
 		Status:           "Queued",
+		ExpectedChecksum: entry.Checksum(),
+		Mirrors:          mirrors(entry),
 		Date:             time.Now(),
 	}
 
+	toDownload.ETag, toDownload.LastModified = validators(entry)
+
 	if err := s.store.Create(entry.ID(), toDownload); err != nil {
-		return response.InternalServerError(ctx, err)
+		return nil, err
 	}
 
-	return response.Ok(ctx, toDownload)
+	return &toDownload, nil
+}
+
+// mirrors returns the verified mirrors of the entry, without the url of the entry itself
+func mirrors(e entry.Entry) []string {
+	urls := make([]string, 0)
+	if client, ok := e.(entry.MirrorClient); ok {
+		for _, req := range client.Mirrors()[1:] {
+			urls = append(urls, req.URL.String())
+		}
+	}
+
+	return urls
+}
+
+// validators returns the etag and the last modified date of the remote file, empty if the entry doesn't know them
+func validators(e entry.Entry) (string, string) {
+	if client, ok := e.(entry.ConditionalClient); ok {
+		return client.ETag(), client.LastModified()
+	}
+
+	return "", ""
+}
+
+func (s *entryService) enqueue(ctx *fiber.Ctx) error {
+	var req queueRequest
+
+	if err := ctx.BodyParser(&req); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	client := req.Client
+	if client == "" {
+		client = ClientGUI
+	}
+
+	downloads := make([]Download, 0)
+	for _, r := range req.Requests {
+		toDownload, err := s.create(r)
+		if err != nil {
+			log.Println("error fetching", r.Url, "for queue:", err.Error())
+			continue
+		}
+
+		// published through the same channel as the entry, so the entry is already in memstore once it is queued
+		s.channel.Publish(queue.Item{
+			ID:     toDownload.ID,
+			Client: client,
+		})
+
+		downloads = append(downloads, *toDownload)
+	}
+
+	return response.Ok(ctx, downloads)
 }
 
 func (s *entryService) getEntry(ctx *fiber.Ctx) error {
@@ -105,6 +178,18 @@ func (s *entryService) updateEntry(ctx *fiber.Ctx) error {
 		return response.BadRequest(ctx, err)
 	}
 
+	// status is owned by the engine, and only moves through the download lifecycle. The location and the hooks are written by the hooks,
+	// and the size, the chunks, the fallback, the expected checksum and the validators by the engine once the remote file changes or range turns out to be broken
+	payload.Status = nil
+	payload.Location = nil
+	payload.Hooks = nil
+	payload.Size = nil
+	payload.ChunkLen = nil
+	payload.Fallback = nil
+	payload.ExpectedChecksum = nil
+	payload.ETag = nil
+	payload.LastModified = nil
+
 	if err := s.store.Update(id, payload); err != nil {
 		return response.BadRequest(ctx, err)
 	}
@@ -118,6 +203,18 @@ func (s *entryService) updateAllEntry(ctx *fiber.Ctx) error {
 		return response.BadRequest(ctx, err)
 	}
 
+	for i := range payload.Payload {
+		payload.Payload[i].Status = nil
+		payload.Payload[i].Location = nil
+		payload.Payload[i].Hooks = nil
+		payload.Payload[i].Size = nil
+		payload.Payload[i].ChunkLen = nil
+		payload.Payload[i].Fallback = nil
+		payload.Payload[i].ExpectedChecksum = nil
+		payload.Payload[i].ETag = nil
+		payload.Payload[i].LastModified = nil
+	}
+
 	if err := s.store.BatchUpdate(payload.IDs, payload.Payload); err != nil {
 		return response.BadRequest(ctx, err)
 	}
@@ -125,31 +222,67 @@ func (s *entryService) updateAllEntry(ctx *fiber.Ctx) error {
 	return response.Ok(ctx)
 }
 
+// dropTimeout bounds how long a deletion waits for the downloader, e.g when the downloader service isn't running
+const dropTimeout = 5 * time.Second
+
+// drop has the downloader take the entry out of the queue and stop it
+func (s *entryService) drop(id string) {
+	deletion := Deletion{
+		ID:   id,
+		Done: make(chan struct{}),
+	}
+
+	s.channel.Publish(deletion)
+
+	select {
+	case <-deletion.Done:
+	case <-time.After(dropTimeout):
+		log.Println("error dropping entry", id, ": downloader doesn't respond")
+	}
+}
+
 func (s *entryService) deleteEntry(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
 	fromDisk := ctx.QueryBool("fromDisk", false)
 
-	entry := s.store.Get(id)
-	if entry == nil {
+	download := s.store.Get(id)
+	if download == nil {
 		return response.Success(ctx, fiber.StatusNoContent)
 	}
 
+	// a running download would keep writing the files which are removed, and hold its download slot
+	s.drop(id)
+
 	if err := s.store.Delete(id); err != nil {
 		return response.Success(ctx, fiber.StatusNoContent)
 	}
 
+	setting := setting.Get()
+
+	// chunks may have been split into more chunk files than the chunk len
+	chunklen := download.ChunkLen
+	if manifest, err := entry.LoadManifest(setting.DataLocation, id); err == nil && len(manifest.Chunks) > chunklen {
+		chunklen = len(manifest.Chunks)
+	}
+
+	if err := entry.RemoveManifest(setting.DataLocation, id); err != nil {
+		log.Println("error removing manifest:", err.Error())
+	}
+
+	downloader.RemoveEntryLimit(id)
+
 	if !fromDisk {
 		return response.Ok(ctx)
 	}
 
-	for i := 0; i < entry.ChunkLen; i++ {
-		dirpath := strings.Replace(entry.Location, filepath.Base(entry.Location), "", 1)
-		path := fmt.Sprintf("%s%s-%d", dirpath, entry.ID, i)
+	for i := 0; i < chunklen; i++ {
+		dirpath := strings.Replace(download.Location, filepath.Base(download.Location), "", 1)
+		path := fmt.Sprintf("%s%s-%d", dirpath, download.ID, i)
 
 		os.Remove(path)
 	}
 
-	if err := os.Remove(entry.Location); err != nil {
+	if err := os.Remove(download.Location); err != nil {
 		log.Println(err)
 	}
 
@@ -158,6 +291,7 @@ func (s *entryService) deleteEntry(ctx *fiber.Ctx) error {
 
 func (s *entryService) CreateRoutes() {
 	s.app.Add("POST", "/fetch", s.fetch)
+	s.app.Add("POST", "/queue", s.enqueue)
 
 	s.app.Add("GET", "/entries/:id", s.getEntry)
 	s.app.Add("GET", "/entries", s.getAllEntry)
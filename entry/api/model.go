@@ -5,10 +5,18 @@ import (
 	"time"
 
 	"github.com/rapid-downloader/rapid/entry"
+	"github.com/rapid-downloader/rapid/hook"
 	"github.com/rapid-downloader/rapid/setting"
 )
 
 type (
+	// Deletion asks the downloader to drop the entry before its download is deleted, i.e take it out of the queue and stop it.
+	// Done is closed once the entry is dropped
+	Deletion struct {
+		ID   string
+		Done chan struct{}
+	}
+
 	cookie struct {
 		Name     string    `json:"name"`
 		Value    string    `json:"value"`
@@ -26,25 +34,41 @@ type (
 		MimeType  string   `json:"mimeType"`
 		UserAgent string   `json:"userAgent"`
 		Cookies   []cookie `json:"cookies"`
+		MaxSpeed  int64    `json:"maxSpeed"` // bytes per second, 0 means unlimited
+		Checksum  string   `json:"checksum"` // <algorithm>:<hex digest>, e.g sha256:2cf24d...
+		Mirrors   []string `json:"mirrors"`  // other urls that serve the same file
+		Location  string   `json:"location"` // folder to save into, instead of the folder of the file category
+		Filename  string   `json:"filename"` // name to save as, instead of the name given by the server
+		Proxy     string   `json:"proxy"`    // proxy of the download instead of the proxy of the setting, direct to bypass it
+		Insecure  bool     `json:"insecure"` // skip the verification of the server certificate
+		Variant   string   `json:"variant"`  // variant of an hls stream or video of a dash stream: highest, lowest, 720p, 1280x720 or a bandwidth in bits per second
+		Files     []string `json:"files"`    // patterns of the files of a torrent to download, e.g *.iso. Every file if empty
 	}
 
 	Download struct {
-		ID               string    `json:"id"`
-		Name             string    `json:"name"`
-		Location         string    `json:"location"`
-		URL              string    `json:"url"`
-		Provider         string    `json:"provider"`
-		Size             int64     `json:"size"`
-		Type             string    `json:"type"`
-		ChunkLen         int       `json:"chunklen"`
-		Resumable        bool      `json:"resumable"`
-		Progress         float64   `json:"progress"`
-		Expired          bool      `json:"expired"`
-		DownloadedChunks []int64   `json:"downloadedChunks"`
-		TimeLeft         float64   `json:"timeLeft"`
-		Speed            float64   `json:"speed"`
-		Status           string    `json:"status"`
-		Date             time.Time `json:"date"`
+		ID               string        `json:"id"`
+		Name             string        `json:"name"`
+		Location         string        `json:"location"`
+		URL              string        `json:"url"`
+		Provider         string        `json:"provider"`
+		Size             int64         `json:"size"`
+		Type             string        `json:"type"`
+		ChunkLen         int           `json:"chunklen"`
+		Resumable        bool          `json:"resumable"`
+		Progress         float64       `json:"progress"`
+		Expired          bool          `json:"expired"`
+		DownloadedChunks []int64       `json:"downloadedChunks"`
+		TimeLeft         float64       `json:"timeLeft"`
+		Speed            float64       `json:"speed"`
+		Status           string        `json:"status"`
+		Checksum         string        `json:"checksum"`
+		ExpectedChecksum string        `json:"expectedChecksum"`
+		Mirrors          []string      `json:"mirrors"`
+		Fallback         string        `json:"fallback"`     // why the file is downloaded in a single stream, e.g the server ignores range
+		ETag             string        `json:"etag"`         // empty if the server doesn't send it
+		LastModified     string        `json:"lastModified"` // empty if the server doesn't send it
+		Hooks            []hook.Result `json:"hooks"`        // results of the hooks that ran once the download completed
+		Date             time.Time     `json:"date"`
 	}
 
 	BatchUpdateDownload struct {
@@ -53,18 +77,28 @@ type (
 	}
 
 	UpdateDownload struct {
-		URL              *string  `json:"url"`
-		Provider         *string  `json:"provider"`
-		Resumable        *bool    `json:"resumable"`
-		Progress         *float64 `json:"progress"`
-		Expired          *bool    `json:"expired"`
-		DownloadedChunks []int64  `json:"downloadedChunks"`
-		TimeLeft         *float64 `json:"timeLeft"`
-		Speed            *float64 `json:"speed"`
-		Status           *string  `json:"status"`
+		URL              *string       `json:"url"`
+		Provider         *string       `json:"provider"`
+		Size             *int64        `json:"size"`
+		Resumable        *bool         `json:"resumable"`
+		ChunkLen         *int          `json:"chunklen"`
+		Fallback         *string       `json:"fallback"`
+		Progress         *float64      `json:"progress"`
+		Expired          *bool         `json:"expired"`
+		DownloadedChunks []int64       `json:"downloadedChunks"`
+		TimeLeft         *float64      `json:"timeLeft"`
+		Speed            *float64      `json:"speed"`
+		Status           *string       `json:"status"`
+		Checksum         *string       `json:"checksum"`
+		ExpectedChecksum *string       `json:"expectedChecksum"`
+		ETag             *string       `json:"etag"`
+		LastModified     *string       `json:"lastModified"`
+		Location         *string       `json:"location"`
+		Hooks            []hook.Result `json:"hooks"`
 	}
 
 	queueRequest struct {
+		Client   string    `json:"client"`
 		Requests []request `json:"request"`
 	}
 )
@@ -92,6 +126,14 @@ func (r *request) toOptions() []entry.Options {
 		entry.UseSetting(setting),
 		entry.AddCookies(cookies),
 		entry.UseDownloader(r.Provider),
+		entry.UseChecksum(r.Checksum),
+		entry.UseMirrors(r.Mirrors...),
+		entry.UseLocation(r.Location),
+		entry.UseFilename(r.Filename),
+		entry.UseProxy(r.Proxy),
+		entry.SkipVerify(r.Insecure),
+		entry.UseVariant(r.Variant),
+		entry.UseFiles(r.Files...),
 		entry.AddHeaders(entry.Headers{
 			"Content-Type": r.MimeType,
 			"User-Agent":   r.UserAgent,
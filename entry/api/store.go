@@ -21,11 +21,11 @@ type Store interface {
 }
 
 type store struct {
-	db     *bbolt.DB
+	db     func() *bbolt.DB
 	bucket string
 }
 
-func NewStore(bucket string, db *bbolt.DB) Store {
+func NewStore(bucket string, db func() *bbolt.DB) Store {
 	return &store{
 		db:     db,
 		bucket: bucket,
@@ -35,7 +35,7 @@ func NewStore(bucket string, db *bbolt.DB) Store {
 func (s *store) Get(id string) *Download {
 	var out Download
 
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.db().View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte(s.bucket))
 
 		var val Download
@@ -60,7 +60,7 @@ func (s *store) Get(id string) *Download {
 func (s *store) GetAll(page, limit int) []Download {
 	var entries []Download
 
-	err := s.db.Batch(func(tx *bbolt.Tx) error {
+	err := s.db().Batch(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(s.bucket))
 		if err != nil {
 			return fmt.Errorf("error creating bucket on GetAll:%s", err.Error())
@@ -104,7 +104,7 @@ func (s *store) GetAll(page, limit int) []Download {
 }
 
 func (s *store) Create(id string, entry Download) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db().Update(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(s.bucket))
 		if err != nil {
 			return fmt.Errorf("error creating bucket on SetBatch:%s", err.Error())
@@ -120,7 +120,7 @@ func (s *store) Create(id string, entry Download) error {
 }
 
 func (s *store) CreateBatch(id []string, entries []Download) error {
-	return s.db.Batch(func(tx *bbolt.Tx) error {
+	return s.db().Batch(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(s.bucket))
 		if err != nil {
 			return fmt.Errorf("error creating bucket on SetBatch:%s", err.Error())
@@ -156,9 +156,18 @@ func (s *store) update(bucket *bbolt.Bucket, id string, toUpdate UpdateDownload)
 	if toUpdate.Provider != nil {
 		entry.Provider = *toUpdate.Provider
 	}
+	if toUpdate.Size != nil {
+		entry.Size = *toUpdate.Size
+	}
 	if toUpdate.Resumable != nil {
 		entry.Resumable = *toUpdate.Resumable
 	}
+	if toUpdate.ChunkLen != nil {
+		entry.ChunkLen = *toUpdate.ChunkLen
+	}
+	if toUpdate.Fallback != nil {
+		entry.Fallback = *toUpdate.Fallback
+	}
 	if toUpdate.Progress != nil {
 		entry.Progress = *toUpdate.Progress
 	}
@@ -177,6 +186,24 @@ func (s *store) update(bucket *bbolt.Bucket, id string, toUpdate UpdateDownload)
 	if toUpdate.Status != nil {
 		entry.Status = *toUpdate.Status
 	}
+	if toUpdate.Checksum != nil {
+		entry.Checksum = *toUpdate.Checksum
+	}
+	if toUpdate.ExpectedChecksum != nil {
+		entry.ExpectedChecksum = *toUpdate.ExpectedChecksum
+	}
+	if toUpdate.ETag != nil {
+		entry.ETag = *toUpdate.ETag
+	}
+	if toUpdate.LastModified != nil {
+		entry.LastModified = *toUpdate.LastModified
+	}
+	if toUpdate.Location != nil {
+		entry.Location = *toUpdate.Location
+	}
+	if toUpdate.Hooks != nil {
+		entry.Hooks = toUpdate.Hooks
+	}
 
 	val, err := json.Marshal(entry)
 	if err != nil {
@@ -187,7 +214,7 @@ func (s *store) update(bucket *bbolt.Bucket, id string, toUpdate UpdateDownload)
 }
 
 func (s *store) Update(id string, val UpdateDownload) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db().Update(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(s.bucket))
 		if err != nil {
 			return fmt.Errorf("error creating bucket on SetBatch:%s", err.Error())
@@ -198,7 +225,7 @@ func (s *store) Update(id string, val UpdateDownload) error {
 }
 
 func (s *store) BatchUpdate(ids []string, val []UpdateDownload) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db().Update(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(s.bucket))
 		if err != nil {
 			return fmt.Errorf("error creating bucket on SetBatch:%s", err.Error())
@@ -215,7 +242,7 @@ func (s *store) BatchUpdate(ids []string, val []UpdateDownload) error {
 }
 
 func (s *store) Delete(id string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db().Update(func(tx *bbolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(s.bucket))
 		if err != nil {
 			return fmt.Errorf("error creating bucket on Delete:%s", err.Error())
@@ -226,7 +253,7 @@ func (s *store) Delete(id string) error {
 }
 
 func (s *store) DeleteAll() error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db().Update(func(tx *bbolt.Tx) error {
 		return tx.DeleteBucket([]byte(s.bucket))
 	})
 }
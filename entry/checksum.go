@@ -0,0 +1,128 @@
+package entry
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+const (
+	MD5    = "md5"
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+var hashmap = map[string]func() hash.Hash{
+	MD5:    md5.New,
+	SHA1:   sha1.New,
+	SHA256: sha256.New,
+	SHA512: sha512.New,
+}
+
+// strength of the algorithms, used to pick the best one when the server sends more than one
+var hashrank = map[string]int{
+	MD5:    1,
+	SHA1:   2,
+	SHA256: 3,
+	SHA512: 4,
+}
+
+var errChecksumFormat = fmt.Errorf("checksum must be in <algorithm>:<hex digest> format, e.g sha256:2cf24d...")
+
+// NewHash creates the hash of the given checksum algorithm
+func NewHash(algorithm string) (hash.Hash, error) {
+	h, ok := hashmap[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("checksum algorithm %s is not supported", algorithm)
+	}
+
+	return h(), nil
+}
+
+// ParseChecksum splits checksum in <algorithm>:<hex digest> format
+func ParseChecksum(checksum string) (string, string, error) {
+	algorithm, digest, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return "", "", errChecksumFormat
+	}
+
+	algorithm = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(algorithm)), "-", "")
+	digest = strings.ToLower(strings.TrimSpace(digest))
+
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return "", "", err
+	}
+
+	if raw, err := hex.DecodeString(digest); err != nil || len(raw) != h.Size() {
+		return "", "", errChecksumFormat
+	}
+
+	return algorithm, digest, nil
+}
+
+// normalizeDigestAlgorithm maps the algorithm names used in Digest and x-goog-hash headers
+func normalizeDigestAlgorithm(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "md5":
+		return MD5
+	case "sha", "sha-1", "sha1":
+		return SHA1
+	case "sha-256", "sha256":
+		return SHA256
+	case "sha-512", "sha512":
+		return SHA512
+	}
+
+	return ""
+}
+
+// HeaderChecksumClient is implemented by the entry whose checksum may be given by the server, so it is dropped with the file it was sent for
+type HeaderChecksumClient interface {
+	HeaderChecksum() bool // true if the checksum is read from the response instead of given by the user
+}
+
+// checksumFromHeader reads the checksum of the file from Digest, Content-MD5 or x-goog-hash header, whichever is the strongest
+func checksumFromHeader(r *http.Response) string {
+	best := ""
+	rank := 0
+
+	add := func(algorithm, encoded string) {
+		algorithm = normalizeDigestAlgorithm(algorithm)
+		if algorithm == "" || hashrank[algorithm] <= rank {
+			return
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return
+		}
+
+		best = fmt.Sprintf("%s:%s", algorithm, hex.EncodeToString(raw))
+		rank = hashrank[algorithm]
+	}
+
+	for _, header := range []string{"Digest", "x-goog-hash"} {
+		for _, value := range r.Header.Values(header) {
+			for _, digest := range strings.Split(value, ",") {
+				if algorithm, encoded, ok := strings.Cut(digest, "="); ok {
+					add(algorithm, encoded)
+				}
+			}
+		}
+	}
+
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		add(MD5, value)
+	}
+
+	return best
+}
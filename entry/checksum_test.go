@@ -0,0 +1,42 @@
+package entry
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	algorithm, digest, err := ParseChecksum("SHA-256:2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824")
+	if err != nil {
+		t.Fatal("Error parsing checksum:", err.Error())
+	}
+
+	if algorithm != SHA256 || digest != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Expected normalized sha256 checksum, but got %s:%s", algorithm, digest)
+	}
+
+	for _, checksum := range []string{"2cf24d", "crc32:2cf24d", "md5:2cf24d", "sha1:not-hex"} {
+		if _, _, err := ParseChecksum(checksum); err == nil {
+			t.Errorf("Expected error parsing %s", checksum)
+		}
+	}
+}
+
+func TestChecksumFromHeader(t *testing.T) {
+	res := &http.Response{Header: http.Header{}}
+	res.Header.Add("x-goog-hash", "crc32c=n03x6A==,md5=XUFAKrxLKna5cZ2REBfFkg==")
+	res.Header.Add("Content-MD5", "XUFAKrxLKna5cZ2REBfFkg==")
+
+	if checksum := checksumFromHeader(res); checksum != "md5:5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Expected md5 checksum from header, but got %s", checksum)
+	}
+
+	res.Header.Add("Digest", "md5=XUFAKrxLKna5cZ2REBfFkg==, SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=")
+	if checksum := checksumFromHeader(res); checksum != "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Expected the strongest checksum from header, but got %s", checksum)
+	}
+
+	if checksum := checksumFromHeader(&http.Response{Header: http.Header{}}); checksum != "" {
+		t.Errorf("Expected no checksum, but got %s", checksum)
+	}
+}
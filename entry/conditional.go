@@ -0,0 +1,109 @@
+package entry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// ConditionalClient is implemented by the entry which knows the version of the remote file, so a change of the file can be detected
+type ConditionalClient interface {
+	ETag() string         // empty if the server doesn't send it
+	LastModified() string // empty if the server doesn't send it
+}
+
+// ErrChanged is returned when the remote file is not the one which was fetched, so the downloaded bytes can't be kept
+var ErrChanged = fmt.Errorf("remote file has changed")
+
+// Changed tells whether the response serves another version of the file. Validators the response doesn't send are not compared
+func Changed(client ConditionalClient, res *http.Response) bool {
+	if etag := res.Header.Get("ETag"); etag != "" && client.ETag() != "" && etag != client.ETag() {
+		return true
+	}
+
+	if modified := res.Header.Get("Last-Modified"); modified != "" && client.LastModified() != "" && modified != client.LastModified() {
+		return true
+	}
+
+	return false
+}
+
+// IfRange returns the validator for If-Range, so the server sends the whole file instead of a range of another version.
+// Weak etags can't be used for it, the last modified date is used instead
+func IfRange(client ConditionalClient) string {
+	if etag := client.ETag(); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return client.LastModified()
+}
+
+func (e *entry) ETag() string {
+	return e.ETag_
+}
+
+func (e *entry) LastModified() string {
+	return e.LastModified_
+}
+
+// Refresh prepares the entry for another download. It returns ErrChanged when the remote file has changed since it was fetched,
+// after updating the entry to the new file, so it has to be downloaded from scratch
+func (e *entry) Refresh() error {
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+
+	// TODO: do something else, such as refresh the link (future feature if browser extenstion is present)
+
+	if e.request == nil {
+		return nil
+	}
+
+	// only the headers are compared, so the body isn't requested
+	req := e.request.Clone(context.Background())
+	req.Method = http.MethodHead
+
+	res, err := network.New(network.UseProxy(e.Proxy_), network.SkipVerify(e.Insecure_)).Do(req)
+	if err != nil {
+		// the chunk requests still send If-Range, so a change is caught there
+		log.Println("error refreshing", e.Name_, ":", err.Error())
+		return nil
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil
+	}
+
+	if !Changed(e, res) && (res.ContentLength == -1 || res.ContentLength == e.Size_) {
+		return nil
+	}
+
+	log.Println("remote file of", e.Name_, "has changed")
+
+	e.Size_ = res.ContentLength
+	e.ETag_ = res.Header.Get("ETag")
+	e.LastModified_ = res.Header.Get("Last-Modified")
+	e.Resumable_ = resumable(res) && e.Fallback_ == ""
+	e.ChunkLen_ = calculatePartition(e.Size_, setting.Get())
+
+	if !e.Resumable_ {
+		e.ChunkLen_ = 1
+	}
+
+	// the checksum of the server belongs to the old file, the one given by the user is kept so the new file fails verification
+	if e.HeaderChecksum_ {
+		e.Checksum_ = checksumFromHeader(res)
+		e.HeaderChecksum_ = e.Checksum_ != ""
+	}
+
+	// mirrors were verified against the old file
+	e.Mirrors_ = make([]string, 0)
+	e.mirrors = nil
+
+	return ErrChanged
+}
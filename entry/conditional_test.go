@@ -0,0 +1,134 @@
+package entry
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+func (v validators) ETag() string {
+	return v.etag
+}
+
+func (v validators) LastModified() string {
+	return v.lastModified
+}
+
+func TestChanged(t *testing.T) {
+	date := "Wed, 21 Oct 2015 07:28:00 GMT"
+
+	tests := []struct {
+		name    string
+		client  validators
+		header  http.Header
+		changed bool
+	}{
+		{"same etag", validators{`"v1"`, date}, http.Header{"Etag": {`"v1"`}, "Last-Modified": {date}}, false},
+		{"other etag", validators{`"v1"`, ""}, http.Header{"Etag": {`"v2"`}}, true},
+		{"other date", validators{"", date}, http.Header{"Last-Modified": {"Thu, 22 Oct 2015 07:28:00 GMT"}}, true},
+		{"no validators sent", validators{`"v1"`, date}, http.Header{}, false},
+		{"no validators known", validators{}, http.Header{"Etag": {`"v2"`}}, false},
+	}
+
+	for _, test := range tests {
+		if changed := Changed(test.client, &http.Response{Header: test.header}); changed != test.changed {
+			t.Errorf("%s: expected changed %v, but got %v", test.name, test.changed, changed)
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	date := "Wed, 21 Oct 2015 07:28:00 GMT"
+
+	if validator := IfRange(validators{`"v1"`, date}); validator != `"v1"` {
+		t.Errorf("Expected the strong etag, but got %s", validator)
+	}
+
+	if validator := IfRange(validators{`W/"v1"`, date}); validator != date {
+		t.Errorf("Expected the date instead of the weak etag, but got %s", validator)
+	}
+
+	if validator := IfRange(validators{}); validator != "" {
+		t.Errorf("Expected no validator, but got %s", validator)
+	}
+}
+
+func TestRefreshChanged(t *testing.T) {
+	var mutex sync.Mutex
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	etag := `"v1"`
+	methods := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		sum := md5.Sum(content)
+		methods = append(methods, r.Method)
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(server.URL+"/file.bin", UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if e.(ConditionalClient).ETag() != etag {
+		t.Fatalf("Expected the etag to be captured on fetch, but got %s", e.(ConditionalClient).ETag())
+	}
+
+	if err := e.Refresh(); err != nil {
+		t.Fatal("Expected unchanged file to refresh, but got", err.Error())
+	}
+
+	mutex.Lock()
+	content = bytes.Repeat([]byte("abcdefghij"), 50)
+	etag = `"v2"`
+	mutex.Unlock()
+
+	if err := e.Refresh(); !errors.Is(err, ErrChanged) {
+		t.Fatalf("Expected the change to be detected, but got %v", err)
+	}
+
+	if e.Size() != 500 || e.(ConditionalClient).ETag() != `"v2"` {
+		t.Errorf("Expected the entry to be updated to the new file, but got size %d and etag %s", e.Size(), e.(ConditionalClient).ETag())
+	}
+
+	if sum := md5.Sum(content); e.Checksum() != "md5:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the checksum of the new file from the server, but got %s", e.Checksum())
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// the file is only fetched once, refreshing asks for the headers only
+	if !reflect.DeepEqual(methods, []string{"GET", "HEAD", "HEAD"}) {
+		t.Errorf("Expected the refresh to request the headers only, but got %v", methods)
+	}
+
+	if manifest := NewManifest(e); manifest.ETag != `"v2"` {
+		t.Errorf("Expected the etag to be kept in the manifest, but got %s", manifest.ETag)
+	}
+}
@@ -0,0 +1,299 @@
+package entry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/network/dash"
+)
+
+type (
+	// DASHClient is implemented by the entry of a dash stream, which tracks are downloaded segment by segment
+	DASHClient interface {
+		MPD() string // url of the media presentation description which lists the tracks
+		// Tracks returns the selected video and audio representations. The chunks of the entry are their segments in this order
+		Tracks() ([]dash.Representation, error)
+		// TrackLocation returns where the track is saved when the tracks aren't muxed into the entry file
+		TrackLocation(track dash.Representation) string
+	}
+
+	// VariantClient is implemented by the entry which is downloaded in one of the qualities of a stream
+	VariantClient interface {
+		Variant() string
+	}
+
+	// dashEntry is an entry which chunks are the segments of its video track followed by the ones of its audio track
+	dashEntry struct {
+		*entry
+		Variant_ string `json:"variant"`
+		mutex    sync.Mutex
+		tracks   []dash.Representation
+	}
+)
+
+// dashProvider is the downloader of the dash entries, unless another one is chosen
+const dashProvider = "dash"
+
+// dashManifest tells whether the url is downloaded as a dash stream. Like an hls playlist, any downloader other than the dash
+// downloader or the default one downloads the mpd itself
+func dashManifest(contentType, rawurl string, opt *option) bool {
+	switch opt.downloadProvider {
+	case dashProvider:
+		return true
+	case "", "default":
+		u, err := url.Parse(rawurl)
+		return err == nil && dash.IsManifest(contentType, u.Path)
+	default:
+		return false
+	}
+}
+
+// loadPresentation requests and parses the mpd
+func loadPresentation(client *http.Client, req *http.Request) (*dash.Presentation, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching mpd:unexpected status %d", res.StatusCode)
+	}
+
+	return dash.Parse(res.Body, res.Request.URL)
+}
+
+// selectTracks picks the video representation by the variant, and the audio representation of the highest bandwidth
+func selectTracks(p *dash.Presentation, variant string) ([]dash.Representation, error) {
+	tracks := make([]dash.Representation, 0, 2)
+
+	if videos := p.Tracks(dash.Video); len(videos) > 0 {
+		video, err := dash.Select(videos, variant)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("downloading video of", video.Bandwidth, "bps", fmt.Sprintf("(%dx%d)", video.Width, video.Height))
+		tracks = append(tracks, video)
+	}
+
+	if audios := p.Tracks(dash.Audio); len(audios) > 0 {
+		audio, err := dash.Select(audios, "highest")
+		if err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, audio)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("mpd has neither a video nor an audio track")
+	}
+
+	for _, track := range tracks {
+		if len(track.Segments) == 0 {
+			return nil, fmt.Errorf("%s track %s has no segments", track.Type, track.ID)
+		}
+	}
+
+	return tracks, nil
+}
+
+func tracksFingerprint(tracks []dash.Representation) string {
+	ranges := make([]segmentRange, 0)
+	for _, track := range tracks {
+		for _, s := range track.Segments {
+			ranges = append(ranges, segmentRange{url: s.URL, offset: s.Offset, length: s.Length})
+		}
+	}
+
+	return fingerprint(dashProvider, ranges)
+}
+
+func segmentCount(tracks []dash.Representation) int {
+	count := 0
+	for _, track := range tracks {
+		count += len(track.Segments)
+	}
+
+	return count
+}
+
+// fetchDASH loads the mpd of the stream, and picks its video and audio tracks
+func fetchDASH(rawurl string, opt *option, checksum string) (Entry, error) {
+	if len(opt.mirrors) > 0 {
+		log.Println("mirrors are ignored for dash download")
+	}
+
+	req, err := newRequest(rawurl, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	presentation, err := loadPresentation(opt.client(), req)
+	if err != nil {
+		log.Println("error fetching mpd:", err.Error())
+		return nil, err
+	}
+
+	tracks, err := selectTracks(presentation, opt.variant)
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is named after the mpd, with the extension of its first track
+	name := path.Base(req.URL.Path)
+	if u, err := url.Parse(rawurl); err == nil {
+		name = path.Base(u.Path)
+	}
+
+	if name = strings.TrimSuffix(name, path.Ext(name)); name == "" || name == "." || name == "/" {
+		name = "video"
+	}
+
+	location, err := destination(name+tracks[0].Extension(), opt)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadProvider := dashProvider
+	if opt.downloadProvider != "" && opt.downloadProvider != "default" {
+		downloadProvider = opt.downloadProvider
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	filename := filepath.Base(location)
+
+	return &dashEntry{
+		entry: &entry{
+			Id:                id(),
+			Name_:             filename,
+			Location_:         location,
+			Filetype_:         filetype(filename),
+			URL_:              rawurl,
+			Size_:             -1,
+			ChunkLen_:         segmentCount(tracks),
+			ctx:               ctx,
+			cancel:            cancel,
+			Resumable_:        true, // the segments that are done are kept
+			request:           req,
+			DownloadProvider_: downloadProvider,
+			Checksum_:         checksum,
+			Mirrors_:          make([]string, 0),
+			Proxy_:            opt.proxy,
+			Insecure_:         opt.insecure,
+			ETag_:             tracksFingerprint(tracks),
+		},
+		Variant_: opt.variant,
+		tracks:   tracks,
+	}, nil
+}
+
+func (e *dashEntry) client() *http.Client {
+	return network.New(network.UseProxy(e.Proxy_), network.SkipVerify(e.Insecure_))
+}
+
+func (e *dashEntry) MPD() string {
+	return e.request.URL.String()
+}
+
+func (e *dashEntry) Variant() string {
+	return e.Variant_
+}
+
+// Tracks returns the selected tracks. The entry restored from a manifest selects them again on the first call
+func (e *dashEntry) Tracks() ([]dash.Representation, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.tracks != nil {
+		return e.tracks, nil
+	}
+
+	presentation, err := loadPresentation(e.client(), e.request.Clone(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := selectTracks(presentation, e.Variant_)
+	if err != nil {
+		return nil, err
+	}
+
+	e.tracks = tracks
+	return e.tracks, nil
+}
+
+// TrackLocation returns the location of the entry for its first track, and the location with the extension of the track for
+// the others, e.g video.m4a next to video.mp4
+func (e *dashEntry) TrackLocation(track dash.Representation) string {
+	e.mutex.Lock()
+	first := len(e.tracks) > 0 && e.tracks[0].ID == track.ID && e.tracks[0].Type == track.Type
+	e.mutex.Unlock()
+
+	if first {
+		return e.Location_
+	}
+
+	base := strings.TrimSuffix(e.Location_, filepath.Ext(e.Location_))
+	if location := base + track.Extension(); location != e.Location_ {
+		return location
+	}
+
+	return base + "-" + track.Type + track.Extension()
+}
+
+// Expired tells whether the mpd can't be loaded anymore
+func (e *dashEntry) Expired() bool {
+	res, err := e.client().Do(e.request.Clone(context.Background()))
+	if err != nil {
+		log.Println("error fetching expired status:", err.Error())
+		return true
+	}
+
+	res.Body.Close()
+
+	return res.StatusCode >= http.StatusBadRequest
+}
+
+// Refresh prepares the entry for another download. It returns ErrChanged when the selected tracks have other segments than the
+// fetched ones, after updating the entry to the new segments
+func (e *dashEntry) Refresh() error {
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+
+	presentation, err := loadPresentation(e.client(), e.request.Clone(context.Background()))
+	if err != nil {
+		log.Println("error refreshing", e.Name_, ":", err.Error())
+		return nil
+	}
+
+	tracks, err := selectTracks(presentation, e.Variant_)
+	if err != nil {
+		log.Println("error refreshing", e.Name_, ":", err.Error())
+		return nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.tracks = tracks
+
+	etag := tracksFingerprint(tracks)
+	if etag == e.ETag_ {
+		return nil
+	}
+
+	e.ETag_ = etag
+	e.ChunkLen_ = segmentCount(tracks)
+
+	return ErrChanged
+}
@@ -0,0 +1,148 @@
+package entry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// dashServer serves an mpd of a video in two qualities and an audio track, which last for the given seconds in segments of 2s
+func dashServer(seconds *int, mutex *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/movie/manifest.mpd", "/stream":
+			mutex.Lock()
+			n := *seconds
+			mutex.Unlock()
+
+			w.Header().Set("Content-Type", "application/dash+xml")
+			fmt.Fprintf(w, `<MPD type="static" mediaPresentationDuration="PT%dS"><Period>
+<AdaptationSet mimeType="video/mp4"><SegmentTemplate duration="2" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number$.m4s"/>
+<Representation id="360" bandwidth="800000" width="640" height="360"/>
+<Representation id="720" bandwidth="2800000" width="1280" height="720"/>
+</AdaptationSet>
+<AdaptationSet mimeType="audio/mp4"><SegmentTemplate duration="2" media="$RepresentationID$/$Number$.m4s"/>
+<Representation id="64k" bandwidth="64000"/>
+<Representation id="128k" bandwidth="128000"/>
+</AdaptationSet>
+</Period></MPD>`, n)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestFetchDASH(t *testing.T) {
+	var mutex sync.Mutex
+	seconds := 6
+
+	server := dashServer(&seconds, &mutex)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		variant string
+		video   string
+	}{
+		{name: "highest", url: server.URL + "/movie/manifest.mpd", video: "720"},
+		{name: "by height", url: server.URL + "/movie/manifest.mpd", variant: "480p", video: "360"},
+		{name: "by content type", url: server.URL + "/stream", video: "720"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := setting.Default()
+			s.DownloadLocation = t.TempDir()
+
+			e, err := Fetch(test.url, UseSetting(s), UseVariant(test.variant))
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			client, ok := e.(DASHClient)
+			if !ok {
+				t.Fatal("Expected a dash entry")
+			}
+
+			tracks, err := client.Tracks()
+			if err != nil || len(tracks) != 2 {
+				t.Fatalf("Expected a video and an audio track, but got %d (%v)", len(tracks), err)
+			}
+
+			if tracks[0].ID != test.video || tracks[1].ID != "128k" {
+				t.Errorf("Expected video %s and audio 128k, but got %s and %s", test.video, tracks[0].ID, tracks[1].ID)
+			}
+
+			// the video has its initialization section on top of its 3 segments
+			if e.Downloader() != dashProvider || e.ChunkLen() != 7 || e.Size() != -1 || !e.Resumable() {
+				t.Errorf("Expected a resumable dash entry of 7 segments, but got %s of %d chunks", e.Downloader(), e.ChunkLen())
+			}
+
+			if e.Type() != "Video" || filepath.Ext(e.Name()) != ".mp4" {
+				t.Errorf("Expected an mp4 video, but got %s of type %s", e.Name(), e.Type())
+			}
+
+			if client.TrackLocation(tracks[0]) != e.Location() {
+				t.Errorf("Expected the video to be saved at the entry location, but got %s", client.TrackLocation(tracks[0]))
+			}
+
+			if audio := client.TrackLocation(tracks[1]); audio != e.Location()[:len(e.Location())-len(".mp4")]+".m4a" {
+				t.Errorf("Expected the audio to be saved next to the video, but got %s", audio)
+			}
+		})
+	}
+}
+
+func TestDASHRefreshChanged(t *testing.T) {
+	var mutex sync.Mutex
+	seconds := 6
+
+	server := dashServer(&seconds, &mutex)
+	defer server.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(server.URL+"/movie/manifest.mpd", UseSetting(s), UseVariant("360p"))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the manifest keeps the variant, so the restored entry selects the same tracks
+	restored, err := NewManifest(e).Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	client, ok := restored.(DASHClient)
+	if !ok {
+		t.Fatal("Expected the manifest to restore a dash entry")
+	}
+
+	if tracks, err := client.Tracks(); err != nil || tracks[0].ID != "360" {
+		t.Fatalf("Expected the restored entry to select the 360p video, but got %v (%v)", tracks, err)
+	}
+
+	if err := restored.Refresh(); err != nil {
+		t.Fatal("Expected unchanged mpd to refresh, but got", err.Error())
+	}
+
+	mutex.Lock()
+	seconds = 8
+	mutex.Unlock()
+
+	if err := restored.Refresh(); !errors.Is(err, ErrChanged) {
+		t.Fatalf("Expected the change to be detected, but got %v", err)
+	}
+
+	if restored.ChunkLen() != 9 {
+		t.Errorf("Expected the entry to be updated to the new segments, but got %d chunks", restored.ChunkLen())
+	}
+}
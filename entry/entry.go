@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
 	"github.com/rapid-downloader/rapid/setting"
 )
 
@@ -26,6 +28,7 @@ type (
 		Expired() bool
 		Refresh() error
 		Downloader() string
+		Checksum() string // expected checksum in <algorithm>:<hex digest> format, empty if unknown
 	}
 
 	Headers map[string]string
@@ -34,6 +37,22 @@ type (
 		Request() *http.Request
 	}
 
+	// ProxyClient is implemented by the entry which is routed through its own proxy instead of the proxy of the setting
+	ProxyClient interface {
+		Proxy() string
+	}
+
+	// InsecureClient is implemented by the entry which may skip the verification of the server certificate
+	InsecureClient interface {
+		Insecure() bool
+	}
+
+	// SingleStreamClient is implemented by the entry which can fall back to a single stream once its range support turns out to be broken
+	SingleStreamClient interface {
+		SingleStream(reason string)
+		Fallback() string // why the entry is downloaded in a single stream, empty if it isn't
+	}
+
 	entry struct {
 		ctx               context.Context    `json:"-"`
 		cancel            context.CancelFunc `json:"-"`
@@ -47,6 +66,15 @@ type (
 		Resumable_        bool               `json:"resumable"`
 		ChunkLen_         int                `json:"chunkLen"`
 		DownloadProvider_ string             `json:"downloadProvider"`
+		Checksum_         string             `json:"checksum"`
+		Mirrors_          []string           `json:"mirrors"`
+		Proxy_            string             `json:"proxy"`
+		Insecure_         bool               `json:"insecure"`
+		Fallback_         string             `json:"fallback"`
+		ETag_             string             `json:"etag"`
+		LastModified_     string             `json:"lastModified"`
+		HeaderChecksum_   bool               `json:"headerChecksum"`
+		mirrors           []*http.Request    `json:"-"`
 	}
 
 	option struct {
@@ -54,6 +82,14 @@ type (
 		cookies          []*http.Cookie
 		headers          Headers
 		downloadProvider string
+		checksum         string
+		mirrors          []string
+		location         string
+		filename         string
+		proxy            string
+		insecure         bool
+		variant          string
+		files            []string
 	}
 
 	Options func(o *option)
@@ -83,8 +119,81 @@ func UseDownloader(provider string) Options {
 	}
 }
 
+// UseChecksum sets the expected checksum of the file in <algorithm>:<hex digest> format
+func UseChecksum(checksum string) Options {
+	return func(o *option) {
+		o.checksum = checksum
+	}
+}
+
+// UseLocation saves the file into the folder instead of the folder of its category
+func UseLocation(location string) Options {
+	return func(o *option) {
+		o.location = location
+	}
+}
+
+// UseFilename saves the file with the name instead of the one given by the server
+func UseFilename(filename string) Options {
+	return func(o *option) {
+		o.filename = filename
+	}
+}
+
+// UseProxy routes the entry through the proxy instead of the proxy of the setting. network.Direct bypasses the proxy
+func UseProxy(proxy string) Options {
+	return func(o *option) {
+		o.proxy = proxy
+	}
+}
+
+// SkipVerify skips the verification of the server certificate of the entry, e.g for a server with a self-signed certificate
+func SkipVerify(insecure bool) Options {
+	return func(o *option) {
+		o.insecure = insecure
+	}
+}
+
+// UseVariant picks the variant of an hls stream or the video of a dash stream: highest, lowest, a height like 720p, a resolution
+// like 1280x720, or the highest bandwidth up to the given bits per second. The highest bandwidth is picked by default
+func UseVariant(variant string) Options {
+	return func(o *option) {
+		o.variant = variant
+	}
+}
+
+// UseFiles downloads only the files of a torrent which path or name matches any of the patterns, e.g *.iso
+func UseFiles(patterns ...string) Options {
+	return func(o *option) {
+		o.files = patterns
+	}
+}
+
+func (o *option) client() *http.Client {
+	return network.New(network.UseSetting(o.setting), network.UseProxy(o.proxy), network.SkipVerify(o.insecure))
+}
+
 func id() string {
-	return fmt.Sprint(time.Now().Unix())
+	return fmt.Sprint(time.Now().UnixNano())
+}
+
+// destination returns where the file with the name given by the server is saved, unless the options override it
+func destination(name string, opt *option) (string, error) {
+	if opt.filename != "" {
+		name = opt.filename
+	}
+
+	// the category folder is decided by the file type, so a renamed file may go into another folder
+	folder := opt.setting.Folder(filetype(name))
+	if opt.location != "" {
+		folder = opt.location
+	}
+
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating download folder:%s", err.Error())
+	}
+
+	return handleDuplicate(filepath.Join(folder, name)), nil
 }
 
 func Fetch(url string, options ...Options) (Entry, error) {
@@ -96,32 +205,91 @@ func Fetch(url string, options ...Options) (Entry, error) {
 		option(opt)
 	}
 
+	if opt.filename != "" && (filepath.Base(opt.filename) != opt.filename || opt.filename == "." || opt.filename == "..") {
+		return nil, fmt.Errorf("filename %s must not contain a path", opt.filename)
+	}
+
+	if opt.location != "" && !filepath.IsAbs(opt.location) {
+		return nil, fmt.Errorf("location %s must be an absolute path", opt.location)
+	}
+
+	if opt.proxy != "" && opt.proxy != network.Direct {
+		if _, err := network.ParseProxy(opt.proxy); err != nil {
+			return nil, err
+		}
+	}
+
 	log.Println("fetching url...")
 
-	req, err := http.NewRequest("GET", url, nil)
+	checksum := ""
+	if opt.checksum != "" {
+		algorithm, digest, err := ParseChecksum(opt.checksum)
+		if err != nil {
+			return nil, err
+		}
+
+		checksum = fmt.Sprintf("%s:%s", algorithm, digest)
+	}
+
+	if u, ok := ftpURL(url); ok {
+		return fetchFTP(u, opt, checksum)
+	}
+
+	if u, ok := sftpURL(url); ok {
+		return fetchSFTP(u, opt, checksum)
+	}
+
+	if hlsPlaylist("", url, opt) {
+		return fetchHLS(url, opt, checksum)
+	}
+
+	if dashManifest("", url, opt) {
+		return fetchDASH(url, opt, checksum)
+	}
+
+	if torrentSource("", url, opt) {
+		return fetchTorrent(url, opt, checksum)
+	}
+
+	req, err := newRequest(url, opt)
 	if err != nil {
 		log.Println("error preparing request:", err.Error())
 		return nil, err
 	}
 
-	for _, cookie := range opt.cookies {
-		req.AddCookie(cookie)
+	// retry fetch 3x if error
+	res, err := opt.client().Do(req)
+	if err != nil {
+		log.Println("error fetching url:", err.Error(), "Retrying....")
+		return nil, err
+	}
+
+	// the playlist, the mpd or the .torrent file may only be told apart by its content type
+	if hlsPlaylist(res.Header.Get("Content-Type"), url, opt) {
+		res.Body.Close()
+		return fetchHLS(res.Request.URL.String(), opt, checksum)
 	}
 
-	for key, value := range opt.headers {
-		req.Header.Add(key, value)
+	if dashManifest(res.Header.Get("Content-Type"), url, opt) {
+		res.Body.Close()
+		return fetchDASH(res.Request.URL.String(), opt, checksum)
 	}
 
-	// retry fetch 3x if error
-	res, err := http.DefaultClient.Do(req)
+	if torrentSource(res.Header.Get("Content-Type"), url, opt) {
+		res.Body.Close()
+		return fetchTorrent(res.Request.URL.String(), opt, checksum)
+	}
+
+	// only the headers are needed, the chunks request the body themselves. An open body holds a connection to the host
+	res.Body.Close()
+
+	location, err := destination(filename(res), opt)
 	if err != nil {
-		log.Println("error fetching url:", err.Error(), "Retrying....")
 		return nil, err
 	}
 
 	resumable := resumable(res)
-	filename := filepath.Base(handleDuplicate(filepath.Join(opt.setting.DownloadLocation, filename(res))))
-	location := filepath.Join(opt.setting.DownloadLocation, filename)
+	filename := filepath.Base(location)
 	filetype := filetype(filename)
 	ctx, cancel := context.WithCancel(context.Background())
 	chunklen := calculatePartition(res.ContentLength, opt.setting)
@@ -135,6 +303,14 @@ func Fetch(url string, options ...Options) (Entry, error) {
 		log.Println("downloading with unknown size...")
 	}
 
+	headerChecksum := false
+	if checksum == "" {
+		checksum = checksumFromHeader(res)
+		headerChecksum = checksum != ""
+	}
+
+	mirrors, mirrorRequests := verifyMirrors(req, opt, size, resumable)
+
 	downloadProvider := "default"
 	if opt.downloadProvider != "" {
 		downloadProvider = opt.downloadProvider
@@ -153,6 +329,14 @@ func Fetch(url string, options ...Options) (Entry, error) {
 		Resumable_:        resumable,
 		request:           req,
 		DownloadProvider_: downloadProvider,
+		Checksum_:         checksum,
+		Mirrors_:          mirrors,
+		mirrors:           mirrorRequests,
+		Proxy_:            opt.proxy,
+		Insecure_:         opt.insecure,
+		ETag_:             res.Header.Get("ETag"),
+		LastModified_:     res.Header.Get("Last-Modified"),
+		HeaderChecksum_:   headerChecksum,
 	}
 
 	return entry, nil
@@ -190,6 +374,19 @@ func (e *entry) Resumable() bool {
 	return e.Resumable_
 }
 
+// SingleStream downloads the entry in one chunk from its url from now on, since the chunks can't be requested by range
+func (e *entry) SingleStream(reason string) {
+	e.Resumable_ = false
+	e.ChunkLen_ = 1
+	e.Mirrors_ = make([]string, 0)
+	e.mirrors = nil
+	e.Fallback_ = reason
+}
+
+func (e *entry) Fallback() string {
+	return e.Fallback_
+}
+
 func (e *entry) Context() context.Context {
 	return e.ctx
 }
@@ -199,29 +396,45 @@ func (e *entry) Cancel() {
 }
 
 func (e *entry) Expired() bool {
+	// the headers tell whether the url still serves the file, without holding the connection for the whole body
 	req := e.request.Clone(context.Background())
+	req.Method = http.MethodHead
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := network.New(network.UseProxy(e.Proxy_), network.SkipVerify(e.Insecure_)).Do(req)
 	if err != nil {
 		log.Println("error fetching expired status:", err.Error())
 		return true
 	}
 
+	res.Body.Close()
+
 	return res.StatusCode != http.StatusOK && res.ContentLength <= 0
 }
 
-func (e *entry) Refresh() error {
-	e.ctx, e.cancel = context.WithCancel(context.Background())
-
-	// TODO: do something else, such as refresh the link (future feature if browser extenstion is present)
+func (e *entry) Downloader() string {
+	return e.DownloadProvider_
+}
 
-	return nil
+func (e *entry) Checksum() string {
+	return e.Checksum_
 }
 
-func (e *entry) Downloader() string {
-	return e.DownloadProvider_
+func (e *entry) HeaderChecksum() bool {
+	return e.HeaderChecksum_
 }
 
 func (e *entry) Request() *http.Request {
 	return e.request
 }
+
+func (e *entry) Proxy() string {
+	return e.Proxy_
+}
+
+func (e *entry) Insecure() bool {
+	return e.Insecure_
+}
+
+func (e *entry) Mirrors() []*http.Request {
+	return append([]*http.Request{e.request}, e.mirrors...)
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/rapid-downloader/rapid/setting"
 )
 
 func TestFilename(t *testing.T) {
@@ -144,6 +146,34 @@ func TestResumableError(t *testing.T) {
 	}
 }
 
+func TestResumableAcceptRanges(t *testing.T) {
+	tests := []struct {
+		status       int
+		acceptRanges string
+		expected     bool
+	}{
+		{http.StatusOK, "bytes", true},
+		{http.StatusOK, "Bytes", true},
+		{http.StatusOK, "none", false},
+		{http.StatusOK, "items", false},
+		{http.StatusOK, "", false},
+		{http.StatusPartialContent, "", true},
+	}
+
+	for _, test := range tests {
+		res := &http.Response{
+			StatusCode: test.status,
+			Header:     http.Header{},
+		}
+
+		res.Header.Set("Accept-Ranges", test.acceptRanges)
+
+		if isResumable := resumable(res); isResumable != test.expected {
+			t.Errorf("Resumable of status %d with Accept-Ranges %q expected to be %v, but got %v", test.status, test.acceptRanges, test.expected, isResumable)
+		}
+	}
+}
+
 func TestCalculatePartitionOneChunkLen(t *testing.T) {
 	// 10 mb file, but has no header to get the desired data
 	link := "https://cartographicperspectives.org/index.php/journal/article/view/cp13-full/pdf"
@@ -188,3 +218,40 @@ func TestCalculatePartitionMoreOneChunkLen(t *testing.T) {
 		t.Error("Chunk length expected to be more than one, but got", entry.ChunkLen())
 	}
 }
+
+func TestFetchCategoryFolder(t *testing.T) {
+	server := serve([]byte("movie"))
+	defer server.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+	s.Categories = map[string]string{"Video": "Video"}
+
+	folder := filepath.Join(s.DownloadLocation, "Video")
+	os.MkdirAll(folder, os.ModePerm)
+	os.WriteFile(filepath.Join(folder, "movie.mp4"), []byte("movie"), 0644)
+
+	e, err := Fetch(server.URL+"/movie.mp4", UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the duplicate is checked against the category folder
+	if expected := filepath.Join(folder, "movie (1).mp4"); e.Location() != expected {
+		t.Errorf("Expected location %s, got %s", expected, e.Location())
+	}
+
+	location := t.TempDir()
+	e, err = Fetch(server.URL+"/movie.mp4", UseSetting(s), UseLocation(location), UseFilename("renamed.mp4"))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if expected := filepath.Join(location, "renamed.mp4"); e.Location() != expected {
+		t.Errorf("Expected overridden location %s, got %s", expected, e.Location())
+	}
+
+	if _, err := Fetch(server.URL+"/movie.mp4", UseSetting(s), UseFilename("../movie.mp4")); err == nil {
+		t.Error("Expected error for a filename with a path")
+	}
+}
@@ -12,7 +12,7 @@ func imagetype() string {
 }
 
 func videotype() string {
-	return `^.*\.(mp4|mov|avi|mkv|wmv|flv|webm|mpeg|mpg|3gp|m4v|m4a)$`
+	return `^.*\.(mp4|mov|avi|mkv|wmv|flv|webm|mpeg|mpg|3gp|m4v|m4a|ts)$`
 }
 
 func audiotype() string {
@@ -75,6 +75,7 @@ func TestFiletypeVideo(t *testing.T) {
 		"mpeg_video.mpeg",
 		"short_clip.mp4",
 		"audio.m4a",
+		"stream.ts",
 	}
 
 	for _, filename := range testCases {
@@ -0,0 +1,218 @@
+package entry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"path/filepath"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+type (
+	// FTPClient is implemented by the entry which is downloaded over ftp, with or without tls
+	FTPClient interface {
+		FTP() *url.URL
+	}
+
+	// ftpEntry is an entry which metadata comes from the ftp server instead of an http response
+	ftpEntry struct {
+		*entry
+	}
+)
+
+// ftpProvider is the downloader of the ftp entries, unless another one is chosen
+const ftpProvider = "ftp"
+
+func ftpURL(rawurl string) (*url.URL, bool) {
+	u, err := url.Parse(rawurl)
+	if err != nil || !network.IsFTP(u) {
+		return nil, false
+	}
+
+	return u, true
+}
+
+func ftpOptions(s *setting.Setting, insecure bool) []network.Options {
+	return []network.Options{network.UseSetting(s), network.SkipVerify(insecure)}
+}
+
+// fetchFTP reads the size with SIZE and the modification time with MDTM. Servers that support SIZE support REST as well,
+// so the chunks can continue where they left off. The file is only split when the server accepts another connection
+func fetchFTP(u *url.URL, opt *option, checksum string) (Entry, error) {
+	if len(opt.mirrors) > 0 || (opt.proxy != "" && opt.proxy != network.Direct) {
+		log.Println("mirrors and proxy are ignored for", u.Scheme, "download")
+	}
+
+	conn, err := network.DialFTP(context.Background(), u, ftpOptions(opt.setting, opt.insecure)...)
+	if err != nil {
+		log.Println("error fetching url:", err.Error())
+		return nil, err
+	}
+
+	defer conn.Quit()
+
+	size, err := conn.FileSize(u.Path)
+	if err != nil {
+		log.Println("error reading file size:", err.Error(), ". Downloading with unknown size...")
+		size = -1
+	}
+
+	modified := ""
+	if conn.IsGetTimeSupported() {
+		if t, err := conn.GetTime(u.Path); err == nil {
+			modified = t.Format(http.TimeFormat)
+		}
+	}
+
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "file"
+	}
+
+	location, err := destination(name, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	resumable := size > 0
+	chunklen := calculatePartition(size, opt.setting)
+	if !resumable {
+		chunklen = 1
+	}
+
+	if chunklen > 1 && !concurrent(u, opt) {
+		log.Println(u.Host, "doesn't accept another connection. Downloading in a single stream...")
+		chunklen = 1
+	}
+
+	// the request is only kept for its url, the chunks are retrieved over ftp
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadProvider := ftpProvider
+	if opt.downloadProvider != "" && opt.downloadProvider != "default" {
+		downloadProvider = opt.downloadProvider
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	filename := filepath.Base(location)
+
+	return &ftpEntry{&entry{
+		Id:                id(),
+		Name_:             filename,
+		Location_:         location,
+		Filetype_:         filetype(filename),
+		URL_:              u.Redacted(), // the url is exposed by the api, so the password is only kept by the request
+		Size_:             size,
+		ChunkLen_:         chunklen,
+		ctx:               ctx,
+		cancel:            cancel,
+		Resumable_:        resumable,
+		request:           req,
+		DownloadProvider_: downloadProvider,
+		Checksum_:         checksum,
+		Mirrors_:          make([]string, 0),
+		Insecure_:         opt.insecure,
+		LastModified_:     modified,
+	}}, nil
+}
+
+// concurrent tells whether the server accepts another connection while one is open, since many servers limit them per client
+func concurrent(u *url.URL, opt *option) bool {
+	conn, err := network.DialFTP(context.Background(), u, ftpOptions(opt.setting, opt.insecure)...)
+	if err != nil {
+		return false
+	}
+
+	conn.Quit()
+	return true
+}
+
+func (e *ftpEntry) FTP() *url.URL {
+	u := *e.request.URL
+	return &u
+}
+
+func (e *ftpEntry) dial() (*ftp.ServerConn, error) {
+	return network.DialFTP(context.Background(), e.FTP(), ftpOptions(setting.Get(), e.Insecure_)...)
+}
+
+// Expired tells whether the file can't be retrieved anymore
+func (e *ftpEntry) Expired() bool {
+	conn, err := e.dial()
+	if err != nil {
+		log.Println("error fetching expired status:", err.Error())
+
+		// the server may still have the connection which has just been closed
+		var reply *textproto.Error
+		return !errors.As(err, &reply) || reply.Code != ftp.StatusNotAvailable
+	}
+
+	defer conn.Quit()
+
+	if e.Size_ <= 0 {
+		return false
+	}
+
+	_, err = conn.FileSize(e.FTP().Path)
+	return err != nil
+}
+
+// Refresh prepares the entry for another download. It returns ErrChanged when the size or the modification time of the file
+// has changed, after updating the entry to the new file
+func (e *ftpEntry) Refresh() error {
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+
+	conn, err := e.dial()
+	if err != nil {
+		log.Println("error refreshing", e.Name_, ":", err.Error())
+		return nil
+	}
+
+	defer conn.Quit()
+
+	size, err := conn.FileSize(e.FTP().Path)
+	if err != nil {
+		size = -1
+	}
+
+	modified := e.LastModified_
+	if conn.IsGetTimeSupported() {
+		if t, err := conn.GetTime(e.FTP().Path); err == nil {
+			modified = t.Format(http.TimeFormat)
+		}
+	}
+
+	// a single stream may be because the server doesn't accept another connection
+	return e.update(size, modified, e.ChunkLen_ == 1)
+}
+
+// update moves the entry to the remote file of the size and the modification time, and returns ErrChanged if it isn't the
+// fetched one. Files of servers without http are resumable whenever their size is known
+func (e *entry) update(size int64, modified string, single bool) error {
+	if size == e.Size_ && modified == e.LastModified_ {
+		return nil
+	}
+
+	log.Println("remote file of", e.Name_, "has changed")
+
+	e.Size_ = size
+	e.LastModified_ = modified
+	e.Resumable_ = size > 0 && e.Fallback_ == ""
+	e.ChunkLen_ = calculatePartition(size, setting.Get())
+
+	if !e.Resumable_ || single {
+		e.ChunkLen_ = 1
+	}
+
+	return ErrChanged
+}
@@ -0,0 +1,120 @@
+package entry
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/network/ftptest"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+func TestFetchFTP(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	anonymous := ftptest.NewServer(map[string][]byte{"file.bin": content})
+	defer anonymous.Close()
+
+	credentialed := ftptest.NewServer(map[string][]byte{"file.bin": content}, ftptest.WithLogin("user", "secret"))
+	defer credentialed.Close()
+
+	withLogin := func(url, login string) string {
+		return strings.Replace(url, "://", "://"+login+"@", 1)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		fail bool
+	}{
+		{"anonymous", anonymous.URL + "/file.bin", false},
+		{"credentials", withLogin(credentialed.URL, "user:secret") + "/file.bin", false},
+		{"wrong password", withLogin(credentialed.URL, "user:wrong") + "/file.bin", true},
+		{"anonymous refused", credentialed.URL + "/file.bin", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := setting.Default()
+			s.DownloadLocation = t.TempDir()
+			s.MinChunkSize = 256
+
+			e, err := Fetch(test.url, UseSetting(s))
+			if test.fail {
+				if err == nil {
+					t.Error("Expected the login to fail")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			if e.Name() != "file.bin" || e.Size() != int64(len(content)) || !e.Resumable() || e.ChunkLen() < 2 {
+				t.Errorf("Expected a resumable file.bin of %d bytes in chunks, but got %s of %d bytes in %d chunks", len(content), e.Name(), e.Size(), e.ChunkLen())
+			}
+
+			if e.Downloader() != ftpProvider || e.(ConditionalClient).LastModified() == "" {
+				t.Errorf("Expected the ftp downloader and the modification time, but got %s and %q", e.Downloader(), e.(ConditionalClient).LastModified())
+			}
+
+			if strings.Contains(e.URL(), "secret") {
+				t.Errorf("Expected the password to be left out of the url, but got %s", e.URL())
+			}
+
+			restored, err := NewManifest(e).Entry()
+			if err != nil {
+				t.Fatal("Error restoring entry:", err.Error())
+			}
+
+			// the restored entry still logs in
+			if password, _ := restored.(FTPClient).FTP().User.Password(); restored.URL() != e.URL() || (test.name == "credentials" && password != "secret") {
+				t.Errorf("Expected the restored entry to keep the credentials, but got %s", restored.URL())
+			}
+		})
+	}
+}
+
+func TestFTPRefreshChanged(t *testing.T) {
+	server := ftptest.NewServer(map[string][]byte{"file.bin": bytes.Repeat([]byte("0123456789"), 100)})
+	defer server.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(server.URL+"/file.bin", UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if err := e.Refresh(); err != nil {
+		t.Fatal("Expected unchanged file to refresh, but got", err.Error())
+	}
+
+	server.Put("file.bin", bytes.Repeat([]byte("abcdefghij"), 50))
+
+	if err := e.Refresh(); !errors.Is(err, ErrChanged) {
+		t.Fatalf("Expected the change to be detected, but got %v", err)
+	}
+
+	if e.Size() != 500 {
+		t.Errorf("Expected the entry to be updated to the new file, but got size %d", e.Size())
+	}
+
+	restored, err := NewManifest(e).Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if _, ok := restored.(FTPClient); !ok {
+		t.Error("Expected the manifest to restore an ftp entry")
+	}
+
+	if modified, err := time.Parse(time.RFC1123, e.(ConditionalClient).LastModified()); err != nil || modified.IsZero() {
+		t.Errorf("Expected the modification time in http format, but got %q", e.(ConditionalClient).LastModified())
+	}
+}
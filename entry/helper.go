@@ -51,9 +51,19 @@ func handleDuplicate(filename string) string {
 	return name
 }
 
+// resumable tells whether the server accepts byte ranges. Accept-Ranges of none, or of any other unit, means it doesn't
 func resumable(r *http.Response) bool {
-	acceptRanges := r.Header.Get("Accept-Ranges")
-	return acceptRanges != "" || acceptRanges == "bytes"
+	if r.StatusCode == http.StatusPartialContent {
+		return true
+	}
+
+	for _, unit := range strings.Split(r.Header.Get("Accept-Ranges"), ",") {
+		if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+			return true
+		}
+	}
+
+	return false
 }
 
 func filename(r *http.Response) string {
@@ -0,0 +1,266 @@
+package entry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/network/hls"
+)
+
+type (
+	// HLSClient is implemented by the entry of an hls stream, which is downloaded segment by segment
+	HLSClient interface {
+		Playlist() string // url of the media playlist which lists the segments
+		Segments() ([]hls.Segment, error)
+	}
+
+	// hlsEntry is an entry which chunks are the segments of its media playlist. Its size is unknown until all of them are downloaded
+	hlsEntry struct {
+		*entry
+		mutex    sync.Mutex
+		segments []hls.Segment
+	}
+)
+
+// hlsProvider is the downloader of the hls entries, unless another one is chosen
+const hlsProvider = "hls"
+
+// hlsPlaylist tells whether the url is downloaded as an hls stream. Only the hls downloader or the default one can download it,
+// so choosing any other downloader downloads the playlist itself
+func hlsPlaylist(contentType, rawurl string, opt *option) bool {
+	switch opt.downloadProvider {
+	case hlsProvider:
+		return true
+	case "", "default":
+		u, err := url.Parse(rawurl)
+		return err == nil && hls.IsPlaylist(contentType, u.Path)
+	default:
+		return false
+	}
+}
+
+// loadPlaylist requests and parses the playlist
+func loadPlaylist(client *http.Client, req *http.Request) (*hls.Playlist, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching playlist:unexpected status %d", res.StatusCode)
+	}
+
+	return hls.Parse(res.Body, res.Request.URL)
+}
+
+// segmentRange is where a segment of a stream is requested from
+type segmentRange struct {
+	url            string
+	offset, length int64
+}
+
+// fingerprint identifies the segments of a stream, so a changed stream can be told apart like a changed etag
+func fingerprint(kind string, segments []segmentRange) string {
+	hash := sha256.New()
+	for _, s := range segments {
+		fmt.Fprintf(hash, "%s %d %d\n", s.url, s.offset, s.length)
+	}
+
+	return kind + "-" + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func playlistFingerprint(segments []hls.Segment) string {
+	ranges := make([]segmentRange, len(segments))
+	for i, s := range segments {
+		ranges[i] = segmentRange{url: s.URL, offset: s.Offset, length: s.Length}
+	}
+
+	return fingerprint(hlsProvider, ranges)
+}
+
+// fetchHLS loads the media playlist of the stream, which is picked by the variant option if the url is a master playlist
+func fetchHLS(rawurl string, opt *option, checksum string) (Entry, error) {
+	if len(opt.mirrors) > 0 {
+		log.Println("mirrors are ignored for hls download")
+	}
+
+	req, err := newRequest(rawurl, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := loadPlaylist(opt.client(), req)
+	if err != nil {
+		log.Println("error fetching playlist:", err.Error())
+		return nil, err
+	}
+
+	if playlist.Master() {
+		variant, err := hls.Select(playlist.Variants, opt.variant)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("downloading variant of", variant.Bandwidth, "bps", fmt.Sprintf("(%dx%d)", variant.Width, variant.Height))
+
+		if req, err = newRequest(variant.URL, opt); err != nil {
+			return nil, err
+		}
+
+		if playlist, err = loadPlaylist(opt.client(), req); err != nil {
+			log.Println("error fetching media playlist:", err.Error())
+			return nil, err
+		}
+
+		if playlist.Master() {
+			return nil, fmt.Errorf("variant %s is not a media playlist", variant.URL)
+		}
+	}
+
+	if len(playlist.Segments) == 0 {
+		return nil, fmt.Errorf("playlist has no segments")
+	}
+
+	fragmented := false
+	for _, segment := range playlist.Segments {
+		if segment.Key != nil && segment.Key.Method != hls.MethodAES128 {
+			return nil, fmt.Errorf("unsupported encryption %s", segment.Key.Method)
+		}
+
+		fragmented = fragmented || segment.Init
+	}
+
+	if !playlist.Ended {
+		log.Println("playlist is live, only the segments which are listed now are downloaded")
+	}
+
+	// segments of mpeg-ts are concatenated into a ts file, while fragmented mp4 starts with its initialization section
+	name := path.Base(req.URL.Path)
+	if u, err := url.Parse(rawurl); err == nil {
+		name = path.Base(u.Path)
+	}
+
+	if name = strings.TrimSuffix(name, path.Ext(name)); name == "" || name == "." || name == "/" {
+		name = "video"
+	}
+
+	if fragmented {
+		name += ".mp4"
+	} else {
+		name += ".ts"
+	}
+
+	location, err := destination(name, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadProvider := hlsProvider
+	if opt.downloadProvider != "" && opt.downloadProvider != "default" {
+		downloadProvider = opt.downloadProvider
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	filename := filepath.Base(location)
+
+	return &hlsEntry{
+		entry: &entry{
+			Id:                id(),
+			Name_:             filename,
+			Location_:         location,
+			Filetype_:         filetype(filename),
+			URL_:              rawurl,
+			Size_:             -1,
+			ChunkLen_:         len(playlist.Segments),
+			ctx:               ctx,
+			cancel:            cancel,
+			Resumable_:        true, // the segments that are done are kept
+			request:           req,
+			DownloadProvider_: downloadProvider,
+			Checksum_:         checksum,
+			Mirrors_:          make([]string, 0),
+			Proxy_:            opt.proxy,
+			Insecure_:         opt.insecure,
+			ETag_:             playlistFingerprint(playlist.Segments),
+		},
+		segments: playlist.Segments,
+	}, nil
+}
+
+func (e *hlsEntry) client() *http.Client {
+	return network.New(network.UseProxy(e.Proxy_), network.SkipVerify(e.Insecure_))
+}
+
+func (e *hlsEntry) Playlist() string {
+	return e.request.URL.String()
+}
+
+// Segments returns the segments of the media playlist. The entry restored from a manifest loads them on the first call
+func (e *hlsEntry) Segments() ([]hls.Segment, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.segments != nil {
+		return e.segments, nil
+	}
+
+	playlist, err := loadPlaylist(e.client(), e.request.Clone(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+
+	e.segments = playlist.Segments
+	return e.segments, nil
+}
+
+// Expired tells whether the media playlist can't be loaded anymore
+func (e *hlsEntry) Expired() bool {
+	res, err := e.client().Do(e.request.Clone(context.Background()))
+	if err != nil {
+		log.Println("error fetching expired status:", err.Error())
+		return true
+	}
+
+	res.Body.Close()
+
+	return res.StatusCode >= http.StatusBadRequest
+}
+
+// Refresh prepares the entry for another download. It returns ErrChanged when the playlist lists other segments than the
+// fetched ones, after updating the entry to the new segments
+func (e *hlsEntry) Refresh() error {
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+
+	playlist, err := loadPlaylist(e.client(), e.request.Clone(context.Background()))
+	if err != nil {
+		log.Println("error refreshing", e.Name_, ":", err.Error())
+		return nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.segments = playlist.Segments
+
+	etag := playlistFingerprint(playlist.Segments)
+	if etag == e.ETag_ {
+		return nil
+	}
+
+	e.ETag_ = etag
+	e.ChunkLen_ = len(playlist.Segments)
+
+	return ErrChanged
+}
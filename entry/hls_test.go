@@ -0,0 +1,144 @@
+package entry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// hlsServer serves a master playlist of two variants, and a media playlist of the given segments for each of them
+func hlsServer(segments *int, mutex *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/video/master.m3u8", "/live":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			fmt.Fprint(w, "#EXTM3U\n")
+			fmt.Fprint(w, "#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\n360/index.m3u8\n")
+			fmt.Fprint(w, "#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720\n720/index.m3u8\n")
+		case "/video/360/index.m3u8", "/video/720/index.m3u8", "/720/index.m3u8":
+			mutex.Lock()
+			n := *segments
+			mutex.Unlock()
+
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:4\n")
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(w, "#EXTINF:4.0,\nsegment%d.ts\n", i)
+			}
+			fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestFetchHLS(t *testing.T) {
+	var mutex sync.Mutex
+	segments := 3
+
+	server := hlsServer(&segments, &mutex)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		variant  string
+		playlist string
+		provider string
+	}{
+		{name: "highest", url: server.URL + "/video/master.m3u8", playlist: "/video/720/index.m3u8"},
+		{name: "by height", url: server.URL + "/video/master.m3u8", variant: "480p", playlist: "/video/360/index.m3u8", provider: "default"},
+		{name: "media playlist", url: server.URL + "/video/360/index.m3u8", playlist: "/video/360/index.m3u8", provider: hlsProvider},
+		{name: "by content type", url: server.URL + "/live", playlist: "/720/index.m3u8"},
+		{name: "chosen downloader", url: server.URL + "/video/master.m3u8", provider: "custom"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := setting.Default()
+			s.DownloadLocation = t.TempDir()
+
+			e, err := Fetch(test.url, UseSetting(s), UseVariant(test.variant), UseDownloader(test.provider))
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			if test.playlist == "" {
+				if _, ok := e.(HLSClient); ok || e.Downloader() != test.provider {
+					t.Errorf("Expected the playlist itself to be downloaded by %s", test.provider)
+				}
+
+				return
+			}
+
+			client, ok := e.(HLSClient)
+			if !ok {
+				t.Fatal("Expected an hls entry")
+			}
+
+			if client.Playlist() != server.URL+test.playlist {
+				t.Errorf("Expected the media playlist %s, but got %s", test.playlist, client.Playlist())
+			}
+
+			if e.Downloader() != hlsProvider || e.ChunkLen() != 3 || e.Size() != -1 || !e.Resumable() {
+				t.Errorf("Expected a resumable hls entry of 3 segments, but got %s of %d chunks", e.Downloader(), e.ChunkLen())
+			}
+
+			if e.Type() != "Video" || (e.Name() != "master.ts" && e.Name() != "index.ts" && e.Name() != "live.ts") {
+				t.Errorf("Expected a ts video, but got %s of type %s", e.Name(), e.Type())
+			}
+		})
+	}
+}
+
+func TestHLSRefreshChanged(t *testing.T) {
+	var mutex sync.Mutex
+	segments := 3
+
+	server := hlsServer(&segments, &mutex)
+	defer server.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(server.URL+"/video/master.m3u8", UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	// the manifest keeps the media playlist, so the restored entry loads the same segments
+	restored, err := NewManifest(e).Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	client, ok := restored.(HLSClient)
+	if !ok {
+		t.Fatal("Expected the manifest to restore an hls entry")
+	}
+
+	if loaded, err := client.Segments(); err != nil || len(loaded) != 3 {
+		t.Fatalf("Expected the restored entry to load 3 segments, but got %d (%v)", len(loaded), err)
+	}
+
+	if err := restored.Refresh(); err != nil {
+		t.Fatal("Expected unchanged playlist to refresh, but got", err.Error())
+	}
+
+	mutex.Lock()
+	segments = 4
+	mutex.Unlock()
+
+	if err := restored.Refresh(); !errors.Is(err, ErrChanged) {
+		t.Fatalf("Expected the change to be detected, but got %v", err)
+	}
+
+	if restored.ChunkLen() != 4 {
+		t.Errorf("Expected the entry to be updated to the new segments, but got %d chunks", restored.ChunkLen())
+	}
+}
@@ -0,0 +1,50 @@
+package entry
+
+import "fmt"
+
+// statuses of a download, which only move along the transitions below
+const (
+	Queued      = "Queued"
+	Downloading = "Downloading"
+	Paused      = "Paused"
+	Failed      = "Failed"
+	Completed   = "Completed"
+	Canceled    = "Canceled"
+	Expired     = "Expired"
+	Mismatch    = "Mismatch" // downloaded, but the checksum is different from the expected one
+)
+
+var transitions = map[string][]string{
+	Queued:      {Queued, Downloading, Paused, Canceled, Expired},
+	Downloading: {Paused, Failed, Completed, Canceled, Mismatch, Expired},
+	Paused:      {Queued, Downloading, Canceled, Expired},
+	Failed:      {Queued, Downloading, Canceled, Expired},
+	Mismatch:    {Queued, Downloading, Canceled},
+	Expired:     {Queued, Downloading, Canceled},
+	Completed:   {},
+	Canceled:    {Queued, Downloading}, // restarted from scratch
+}
+
+// ErrIllegalTransition is returned when a download can't move from its current status into the requested one
+var ErrIllegalTransition = fmt.Errorf("illegal status transition")
+
+// Transition checks whether a download can move from a status into another
+func Transition(from, to string) error {
+	next, ok := transitions[from]
+	if !ok {
+		return fmt.Errorf("%w: unknown status %s", ErrIllegalTransition, from)
+	}
+
+	for _, status := range next {
+		if status == to {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, from, to)
+}
+
+// Terminal reports whether a download can no longer move into any other status
+func Terminal(status string) bool {
+	return len(transitions[status]) == 0
+}
@@ -0,0 +1,43 @@
+package entry
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	legal := [][2]string{
+		{Queued, Downloading},
+		{Downloading, Paused},
+		{Paused, Downloading},
+		{Downloading, Failed},
+		{Failed, Queued},
+		{Downloading, Completed},
+		{Queued, Canceled},
+		{Canceled, Queued},
+	}
+
+	for _, transition := range legal {
+		if err := Transition(transition[0], transition[1]); err != nil {
+			t.Errorf("Expected transition from %s to %s to be legal, but got %s", transition[0], transition[1], err.Error())
+		}
+	}
+
+	illegal := [][2]string{
+		{Completed, Downloading},
+		{Canceled, Completed},
+		{Downloading, Downloading},
+		{Queued, Completed},
+		{"Unknown", Queued},
+	}
+
+	for _, transition := range illegal {
+		if err := Transition(transition[0], transition[1]); !errors.Is(err, ErrIllegalTransition) {
+			t.Errorf("Expected transition from %s to %s to be illegal, but got %v", transition[0], transition[1], err)
+		}
+	}
+
+	if !Terminal(Completed) || Terminal(Paused) {
+		t.Error("Expected only completed to be terminal")
+	}
+}
@@ -0,0 +1,323 @@
+package entry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network/torrent"
+)
+
+type (
+	// Manifest is the persisted state of an entry and its chunks, so that the entry can be rebuilt after the engine restarts
+	Manifest struct {
+		mutex            sync.Mutex   `json:"-"`
+		ID               string       `json:"id"`
+		Name             string       `json:"name"`
+		Location         string       `json:"location"`
+		Size             int64        `json:"size"`
+		Filetype         string       `json:"filetype"`
+		URL              string       `json:"url"`
+		RequestURL       string       `json:"requestUrl"`
+		Headers          http.Header  `json:"headers"`
+		Resumable        bool         `json:"resumable"`
+		ChunkLen         int          `json:"chunkLen"`
+		DownloadProvider string       `json:"downloadProvider"`
+		Checksum         string       `json:"checksum"`
+		HeaderChecksum   bool         `json:"headerChecksum"` // the checksum is read from the response, so it's replaced when the file changes
+		Mirrors          []string     `json:"mirrors"`
+		Proxy            string       `json:"proxy"`
+		Insecure         bool         `json:"insecure"`
+		Fallback         string       `json:"fallback"` // why the entry is downloaded in a single stream, empty if it isn't
+		ETag             string       `json:"etag"`
+		LastModified     string       `json:"lastModified"`
+		Storage          string       `json:"storage"` // how the chunks are written, e.g into temp files or into preallocated file
+		Variant          string       `json:"variant"` // quality of the stream which is downloaded, e.g 720p
+		Files            []string     `json:"files"`   // patterns of the files of a torrent which are downloaded
+		Chunks           []ChunkState `json:"chunks"`
+	}
+
+	ChunkState struct {
+		Index      int   `json:"index"`
+		Start      int64 `json:"start"`
+		End        int64 `json:"end"`
+		Downloaded int64 `json:"downloaded"`
+		Done       bool  `json:"done"` // tells a finished chunk of unknown size, e.g a segment of a stream, from a partial one
+	}
+)
+
+func manifestDir(location string) string {
+	return filepath.Join(location, "manifests")
+}
+
+func manifestPath(location, id string) string {
+	return filepath.Join(manifestDir(location), id+".json")
+}
+
+// NewManifest creates manifest of an entry with empty chunk states
+func NewManifest(e Entry) *Manifest {
+	m := &Manifest{
+		ID:               e.ID(),
+		Name:             e.Name(),
+		Location:         e.Location(),
+		Size:             e.Size(),
+		Filetype:         e.Type(),
+		URL:              e.URL(),
+		RequestURL:       e.URL(),
+		Headers:          http.Header{},
+		Resumable:        e.Resumable(),
+		ChunkLen:         e.ChunkLen(),
+		DownloadProvider: e.Downloader(),
+		Checksum:         e.Checksum(),
+		Mirrors:          make([]string, 0),
+		Chunks:           make([]ChunkState, e.ChunkLen()),
+	}
+
+	if client, ok := e.(RequestClient); ok && client.Request() != nil {
+		m.RequestURL = client.Request().URL.String()
+		m.Headers = client.Request().Header.Clone()
+	}
+
+	if client, ok := e.(ProxyClient); ok {
+		m.Proxy = client.Proxy()
+	}
+
+	if client, ok := e.(InsecureClient); ok {
+		m.Insecure = client.Insecure()
+	}
+
+	if client, ok := e.(SingleStreamClient); ok {
+		m.Fallback = client.Fallback()
+	}
+
+	if client, ok := e.(ConditionalClient); ok {
+		m.ETag = client.ETag()
+		m.LastModified = client.LastModified()
+	}
+
+	if client, ok := e.(HeaderChecksumClient); ok {
+		m.HeaderChecksum = client.HeaderChecksum()
+	}
+
+	if client, ok := e.(VariantClient); ok {
+		m.Variant = client.Variant()
+	}
+
+	if client, ok := e.(TorrentClient); ok {
+		m.Files = client.Selection()
+	}
+
+	if client, ok := e.(MirrorClient); ok {
+		// the first one is the request of the entry itself
+		for _, req := range client.Mirrors()[1:] {
+			m.Mirrors = append(m.Mirrors, req.URL.String())
+		}
+	}
+
+	for i := range m.Chunks {
+		m.Chunks[i].Index = i
+	}
+
+	return m
+}
+
+// SetChunk sets the range of a chunk
+func (m *Manifest) SetChunk(index int, start, end int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Chunks[index].Start = start
+	m.Chunks[index].End = end
+}
+
+// AddChunk appends a new chunk, e.g when a chunk is split, and returns its index
+func (m *Manifest) AddChunk(start, end int64) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	index := len(m.Chunks)
+	m.Chunks = append(m.Chunks, ChunkState{
+		Index: index,
+		Start: start,
+		End:   end,
+	})
+
+	return index
+}
+
+// Downloaded sets how many bytes of a chunk has been written
+func (m *Manifest) Downloaded(index int, downloaded int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Chunks[index].Downloaded = downloaded
+}
+
+// Finished marks a chunk as done
+func (m *Manifest) Finished(index int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Chunks[index].Done = true
+}
+
+// Chunk returns the state of a chunk
+func (m *Manifest) Chunk(index int) ChunkState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.Chunks[index]
+}
+
+// Save writes the manifest atomically into the data location
+func (m *Manifest) Save(location string) error {
+	m.mutex.Lock()
+	val, err := json.Marshal(m)
+	m.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("error marshalling manifest:%s", err.Error())
+	}
+
+	if err := os.MkdirAll(manifestDir(location), os.ModePerm); err != nil {
+		return err
+	}
+
+	path := manifestPath(location, m.ID)
+	tmp := path + ".tmp"
+
+	// the manifest keeps the headers, the cookies and the credentials of the request
+	if err := os.WriteFile(tmp, val, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Entry rebuilds the entry from the manifest
+func (m *Manifest) Entry() (Entry, error) {
+	req, err := http.NewRequest("GET", m.RequestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = m.Headers.Clone()
+
+	mirrors := make([]*http.Request, 0, len(m.Mirrors))
+	for _, url := range m.Mirrors {
+		mirror, err := mirrorRequest(req, url)
+		if err != nil {
+			return nil, err
+		}
+
+		mirrors = append(mirrors, mirror)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	e := &entry{
+		Id:                m.ID,
+		Name_:             m.Name,
+		Location_:         m.Location,
+		Filetype_:         m.Filetype,
+		URL_:              m.URL,
+		Size_:             m.Size,
+		ChunkLen_:         m.ChunkLen,
+		ctx:               ctx,
+		cancel:            cancel,
+		Resumable_:        m.Resumable,
+		request:           req,
+		DownloadProvider_: m.DownloadProvider,
+		Checksum_:         m.Checksum,
+		Mirrors_:          m.Mirrors,
+		mirrors:           mirrors,
+		Proxy_:            m.Proxy,
+		Insecure_:         m.Insecure,
+		Fallback_:         m.Fallback,
+		ETag_:             m.ETag,
+		LastModified_:     m.LastModified,
+		HeaderChecksum_:   m.HeaderChecksum,
+	}
+
+	if _, ok := ftpURL(m.URL); ok {
+		return &ftpEntry{e}, nil
+	}
+
+	if _, ok := sftpURL(m.URL); ok {
+		return &sftpEntry{e}, nil
+	}
+
+	// the segments are loaded again from the media playlist, which is the request of the entry
+	if m.DownloadProvider == hlsProvider {
+		return &hlsEntry{entry: e}, nil
+	}
+
+	// the tracks are selected again from the mpd by the same variant
+	if m.DownloadProvider == dashProvider {
+		return &dashEntry{entry: e, Variant_: m.Variant}, nil
+	}
+
+	// the info is loaded again from the .torrent file or the peers of the magnet link
+	if m.DownloadProvider == torrentProvider || torrent.IsMagnet(m.URL) {
+		return &torrentEntry{entry: e, Files_: m.Files}, nil
+	}
+
+	return e, nil
+}
+
+// LoadManifest reads the manifest of an entry from the data location
+func LoadManifest(location, id string) (*Manifest, error) {
+	val, err := os.ReadFile(manifestPath(location, id))
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(val, &m); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest:%s", err.Error())
+	}
+
+	return &m, nil
+}
+
+// LoadManifests reads every manifest in the data location
+func LoadManifests(location string) []*Manifest {
+	manifests := make([]*Manifest, 0)
+
+	files, err := os.ReadDir(manifestDir(location))
+	if err != nil {
+		return manifests
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		m, err := LoadManifest(location, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			log.Println("error loading manifest", file.Name(), ":", err.Error())
+			continue
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	return manifests
+}
+
+// RemoveManifest removes the manifest of an entry from the data location
+func RemoveManifest(location, id string) error {
+	err := os.Remove(manifestPath(location, id))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+package entry
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestManifestRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	req, _ := http.NewRequest("GET", "http://localhost/file.zip", nil)
+	req.Header.Add("User-Agent", "rapid")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "secret"})
+
+	e := &entry{
+		Id:                "1",
+		Name_:             "file.zip",
+		Location_:         "/tmp/file.zip",
+		Size_:             100,
+		Filetype_:         "Compressed",
+		URL_:              "http://localhost/file.zip",
+		Resumable_:        true,
+		ChunkLen_:         2,
+		DownloadProvider_: "default",
+		request:           req,
+	}
+
+	manifest := NewManifest(e)
+	manifest.SetChunk(0, 0, 49)
+	manifest.SetChunk(1, 50, 100)
+	manifest.Downloaded(1, 20)
+
+	if err := manifest.Save(dir); err != nil {
+		t.Fatal("Error saving manifest:", err.Error())
+	}
+
+	// only the user can read the cookie
+	info, err := os.Stat(manifestPath(dir, e.ID()))
+	if err != nil {
+		t.Fatal("Error reading manifest:", err.Error())
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the manifest to be private to the user, but got %v", info.Mode())
+	}
+
+	manifests := LoadManifests(dir)
+	if len(manifests) != 1 {
+		t.Fatalf("Expected 1 manifest, but got %d", len(manifests))
+	}
+
+	if chunk := manifests[0].Chunk(1); chunk.Start != 50 || chunk.Downloaded != 20 {
+		t.Errorf("Expected chunk 1 to start at 50 with 20 bytes downloaded, but got %+v", chunk)
+	}
+
+	restored, err := manifests[0].Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if restored.ID() != e.ID() || restored.Size() != e.Size() || restored.ChunkLen() != e.ChunkLen() {
+		t.Errorf("Restored entry is different from the original: %+v", restored)
+	}
+
+	header := restored.(RequestClient).Request().Header
+	if header.Get("User-Agent") != "rapid" || header.Get("Cookie") != "session=secret" {
+		t.Errorf("Restored request lost its headers: %v", header)
+	}
+
+	if err := RemoveManifest(dir, e.ID()); err != nil {
+		t.Error("Error removing manifest:", err.Error())
+	}
+
+	if len(LoadManifests(dir)) != 0 {
+		t.Error("Expected manifest to be removed")
+	}
+}
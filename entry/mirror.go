@@ -0,0 +1,126 @@
+package entry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rapid-downloader/rapid/log"
+)
+
+// MirrorClient is implemented by the entry which can be downloaded from more than one source
+type MirrorClient interface {
+	// Mirrors returns the request of every source, the first one is the url of the entry
+	Mirrors() []*http.Request
+}
+
+// UseMirrors adds other urls that serve the same file, so the chunks can be spread across them
+func UseMirrors(urls ...string) Options {
+	return func(o *option) {
+		o.mirrors = append(o.mirrors, urls...)
+	}
+}
+
+func newRequest(url string, opt *option) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cookie := range opt.cookies {
+		req.AddCookie(cookie)
+	}
+
+	for key, value := range opt.headers {
+		req.Header.Add(key, value)
+	}
+
+	return req, nil
+}
+
+// credentials are the headers which are only sent to the host of the entry, since the mirrors are usually run by someone else
+var credentials = []string{"Authorization", "Cookie"}
+
+// mirrorRequest creates the request of the mirror with the headers of the request of the entry, except for the credentials when
+// the mirror is on another host
+func mirrorRequest(primary *http.Request, rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = primary.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+
+	if !sameHost(primary.URL, req.URL) {
+		for _, key := range credentials {
+			req.Header.Del(key)
+		}
+	}
+
+	return req, nil
+}
+
+func sameHost(a, b *url.URL) bool {
+	return a.Scheme == b.Scheme && a.Host == b.Host
+}
+
+// verifyMirror checks that the mirror serves the same file, judging by its size, and that it supports range
+func verifyMirror(primary *http.Request, url string, opt *option, size int64) (*http.Request, error) {
+	req, err := mirrorRequest(primary, url)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := opt.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("mirror responded with status %d", res.StatusCode)
+	}
+
+	if res.ContentLength != size {
+		return nil, fmt.Errorf("mirror reports size %d, expected %d", res.ContentLength, size)
+	}
+
+	if !resumable(res) {
+		return nil, fmt.Errorf("mirror doesn't support range")
+	}
+
+	return req, nil
+}
+
+// verifyMirrors returns the mirrors which serve the same file. Mirrors that don't are left out, since a chunk from them would corrupt the file
+func verifyMirrors(primary *http.Request, opt *option, size int64, resumable bool) ([]string, []*http.Request) {
+	urls := make([]string, 0)
+	requests := make([]*http.Request, 0)
+
+	if len(opt.mirrors) == 0 {
+		return urls, requests
+	}
+
+	// mirrors can only share the chunks when every one of them can serve a range
+	if size <= 0 || !resumable {
+		log.Println("mirrors are ignored, since the file size is unknown or it doesn't support range")
+		return urls, requests
+	}
+
+	for _, url := range opt.mirrors {
+		req, err := verifyMirror(primary, url, opt, size)
+		if err != nil {
+			log.Println("error verifying mirror", url, ":", err.Error())
+			continue
+		}
+
+		urls = append(urls, url)
+		requests = append(requests, req)
+	}
+
+	return urls, requests
+}
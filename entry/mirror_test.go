@@ -0,0 +1,113 @@
+package entry
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+func serve(content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestFetchMirrors(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	primary := serve(content)
+	defer primary.Close()
+
+	mirror := serve(content)
+	defer mirror.Close()
+
+	// a different file must not be used as a mirror
+	different := serve(content[:500])
+	defer different.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(primary.URL+"/file.bin", UseSetting(s), UseMirrors(mirror.URL+"/file.bin", different.URL+"/file.bin", "http://127.0.0.1:0/unreachable"))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	mirrors := e.(MirrorClient).Mirrors()
+	if len(mirrors) != 2 {
+		t.Fatalf("Expected the entry and one verified mirror, but got %d sources", len(mirrors))
+	}
+
+	if mirrors[1].URL.String() != mirror.URL+"/file.bin" {
+		t.Errorf("Expected %s to be the mirror, but got %s", mirror.URL+"/file.bin", mirrors[1].URL.String())
+	}
+
+	restored, err := NewManifest(e).Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if len(restored.(MirrorClient).Mirrors()) != 2 {
+		t.Error("Restored entry lost its mirrors")
+	}
+}
+
+func TestMirrorCredentials(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	primary := serve(content)
+	defer primary.Close()
+
+	var mutex sync.Mutex
+	received := make([]http.Header, 0)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		received = append(received, r.Header.Clone())
+		mutex.Unlock()
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer mirror.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(primary.URL+"/file.bin", UseSetting(s), UseMirrors(mirror.URL+"/file.bin"),
+		AddHeaders(Headers{"Authorization": "Bearer secret", "User-Agent": "rapid"}),
+		AddCookies([]*http.Cookie{{Name: "session", Value: "secret"}}),
+	)
+
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	restored, err := NewManifest(e).Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	sources := append(e.(MirrorClient).Mirrors(), restored.(MirrorClient).Mirrors()...)
+	if len(sources) != 4 {
+		t.Fatalf("Expected the entry and its mirror twice, but got %d sources", len(sources))
+	}
+
+	if sources[0].Header.Get("Authorization") == "" || sources[2].Header.Get("Cookie") == "" {
+		t.Error("Expected the credentials to be kept for the url of the entry")
+	}
+
+	mutex.Lock()
+	headers := append(received, sources[1].Header, sources[3].Header)
+	mutex.Unlock()
+
+	for _, header := range headers {
+		if header.Get("Authorization") != "" || header.Get("Cookie") != "" || header.Get("User-Agent") != "rapid" {
+			t.Errorf("Expected only the headers without credentials to be sent to the mirror, but got %v", header)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package entry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+type (
+	// SFTPClient is implemented by the entry which is downloaded over sftp
+	SFTPClient interface {
+		SFTP() *url.URL
+	}
+
+	// sftpEntry is an entry which metadata comes from a stat on the ssh server instead of an http response
+	sftpEntry struct {
+		*entry
+	}
+)
+
+// sftpProvider is the downloader of the sftp entries, unless another one is chosen
+const sftpProvider = "sftp"
+
+func sftpURL(rawurl string) (*url.URL, bool) {
+	u, err := url.Parse(rawurl)
+	if err != nil || !network.IsSFTP(u) {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// stat reads the size and the modification time of the file, which is formatted like Last-Modified
+func stat(u *url.URL, s *setting.Setting, insecure bool) (os.FileInfo, string, error) {
+	conn, err := network.DialSSH(context.Background(), u, network.UseSetting(s), network.SkipVerify(insecure))
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer client.Close()
+
+	info, err := client.Stat(network.SFTPPath(u))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading %s:%w", u.Path, err)
+	}
+
+	modified := ""
+	if !info.ModTime().IsZero() {
+		modified = info.ModTime().UTC().Format(http.TimeFormat)
+	}
+
+	return info, modified, nil
+}
+
+// fetchSFTP stats the file for its size and name. Every chunk reads its range over a connection of its own
+func fetchSFTP(u *url.URL, opt *option, checksum string) (Entry, error) {
+	if len(opt.mirrors) > 0 || (opt.proxy != "" && opt.proxy != network.Direct) {
+		log.Println("mirrors and proxy are ignored for", u.Scheme, "download")
+	}
+
+	info, modified, err := stat(u, opt.setting, opt.insecure)
+	if err != nil {
+		log.Println("error fetching url:", err.Error())
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a folder", u.Path)
+	}
+
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || name == "~" {
+		name = "file"
+	}
+
+	location, err := destination(name, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	resumable := info.Size() > 0
+	chunklen := calculatePartition(info.Size(), opt.setting)
+	if !resumable {
+		chunklen = 1
+	}
+
+	// the request is only kept for its url, the chunks are read over sftp
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadProvider := sftpProvider
+	if opt.downloadProvider != "" && opt.downloadProvider != "default" {
+		downloadProvider = opt.downloadProvider
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	filename := filepath.Base(location)
+
+	return &sftpEntry{&entry{
+		Id:                id(),
+		Name_:             filename,
+		Location_:         location,
+		Filetype_:         filetype(filename),
+		URL_:              u.Redacted(), // the url is exposed by the api, so the password is only kept by the request
+		Size_:             info.Size(),
+		ChunkLen_:         chunklen,
+		ctx:               ctx,
+		cancel:            cancel,
+		Resumable_:        resumable,
+		request:           req,
+		DownloadProvider_: downloadProvider,
+		Checksum_:         checksum,
+		Mirrors_:          make([]string, 0),
+		Insecure_:         opt.insecure,
+		LastModified_:     modified,
+	}}, nil
+}
+
+func (e *sftpEntry) SFTP() *url.URL {
+	u := *e.request.URL
+	return &u
+}
+
+// Expired tells whether the file can't be read anymore. The server being unreachable doesn't mean the file is gone
+func (e *sftpEntry) Expired() bool {
+	_, _, err := stat(e.SFTP(), setting.Get(), e.Insecure_)
+	if err != nil {
+		log.Println("error fetching expired status:", err.Error())
+	}
+
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission)
+}
+
+// Refresh prepares the entry for another download. It returns ErrChanged when the size or the modification time of the file
+// has changed, after updating the entry to the new file
+func (e *sftpEntry) Refresh() error {
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+
+	info, modified, err := stat(e.SFTP(), setting.Get(), e.Insecure_)
+	if err != nil {
+		log.Println("error refreshing", e.Name_, ":", err.Error())
+		return nil
+	}
+
+	return e.update(info.Size(), modified, false)
+}
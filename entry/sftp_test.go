@@ -0,0 +1,220 @@
+package entry
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/network/sftptest"
+	"github.com/rapid-downloader/rapid/setting"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshKey writes a new private key into a file, and returns its signer and the file
+func sshKey(t *testing.T) (ssh.Signer, ed25519.PrivateKey, string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Error generating key:", err.Error())
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal("Error marshaling key:", err.Error())
+	}
+
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal("Error writing key:", err.Error())
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal("Error creating signer:", err.Error())
+	}
+
+	return signer, key, path
+}
+
+func knownHostsFile(t *testing.T, addr string, key ssh.PublicKey) string {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(knownhosts.Line([]string{addr}, key)+"\n"), 0600); err != nil {
+		t.Fatal("Error writing known hosts:", err.Error())
+	}
+
+	return path
+}
+
+// sshAgent serves the key on a unix socket which SSH_AUTH_SOCK points to
+func sshAgent(t *testing.T, key ed25519.PrivateKey) {
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal("Error adding key to agent:", err.Error())
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal("Error listening agent socket:", err.Error())
+	}
+
+	t.Cleanup(func() { listener.Close() })
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+}
+
+func TestFetchSFTP(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	signer, key, keyfile := sshKey(t)
+	_, _, otherKeyfile := sshKey(t)
+
+	server := sftptest.NewServer(map[string][]byte{"builds/app.tar.gz": content}, sftptest.WithAuthorizedKey(signer.PublicKey()), sftptest.WithPassword("builder", "secret"))
+	defer server.Close()
+
+	other := sftptest.NewServer(nil)
+	defer other.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		key      string
+		agent    bool
+		known    ssh.PublicKey
+		insecure bool
+		fail     error
+	}{
+		{name: "key", url: server.URL, key: keyfile, known: server.HostKey()},
+		{name: "agent", url: server.URL, agent: true, known: server.HostKey()},
+		{name: "password", url: strings.Replace(server.URL, "://", "://builder:secret@", 1), known: server.HostKey()},
+		{name: "wrong key", url: server.URL, key: otherKeyfile, known: server.HostKey(), fail: network.ErrSSHAuth},
+		{name: "host key mismatch", url: server.URL, key: keyfile, known: other.HostKey(), fail: network.ErrHostKey},
+		{name: "host key skipped", url: server.URL, key: keyfile, known: other.HostKey(), insecure: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the keys and the agent of the user running the tests are not offered
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("SSH_AUTH_SOCK", "")
+
+			if test.agent {
+				sshAgent(t, key)
+			}
+
+			s := setting.Default()
+			s.DownloadLocation = t.TempDir()
+			s.MinChunkSize = 256
+			s.SSHKey = test.key
+			s.KnownHosts = knownHostsFile(t, server.Addr(), test.known)
+
+			e, err := Fetch(test.url+"/builds/app.tar.gz", UseSetting(s), SkipVerify(test.insecure))
+			if test.fail != nil {
+				if !errors.Is(err, test.fail) {
+					t.Errorf("Expected %v, but got %v", test.fail, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			if e.Name() != "app.tar.gz" || e.Size() != int64(len(content)) || !e.Resumable() || e.ChunkLen() < 2 {
+				t.Errorf("Expected a resumable app.tar.gz of %d bytes in chunks, but got %s of %d bytes in %d chunks", len(content), e.Name(), e.Size(), e.ChunkLen())
+			}
+
+			if e.Downloader() != sftpProvider || e.(ConditionalClient).LastModified() == "" {
+				t.Errorf("Expected the sftp downloader and the modification time, but got %s and %q", e.Downloader(), e.(ConditionalClient).LastModified())
+			}
+		})
+	}
+}
+
+func TestSFTPRefreshChanged(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := sftptest.NewServer(map[string][]byte{"file.bin": bytes.Repeat([]byte("0123456789"), 100)})
+	defer server.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(server.URL+"/file.bin", UseSetting(s), SkipVerify(true))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if err := e.Refresh(); err != nil {
+		t.Fatal("Expected unchanged file to refresh, but got", err.Error())
+	}
+
+	server.Put("file.bin", bytes.Repeat([]byte("abcdefghij"), 50))
+
+	if err := e.Refresh(); !errors.Is(err, ErrChanged) {
+		t.Fatalf("Expected the change to be detected, but got %v", err)
+	}
+
+	if e.Size() != 500 {
+		t.Errorf("Expected the entry to be updated to the new file, but got size %d", e.Size())
+	}
+
+	restored, err := NewManifest(e).Entry()
+	if err != nil {
+		t.Fatal("Error restoring entry:", err.Error())
+	}
+
+	if _, ok := restored.(SFTPClient); !ok {
+		t.Error("Expected the manifest to restore an sftp entry")
+	}
+}
+
+func TestSFTPExpired(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := sftptest.NewServer(map[string][]byte{"file.bin": bytes.Repeat([]byte("0123456789"), 100)})
+	defer server.Close()
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	e, err := Fetch(server.URL+"/file.bin", UseSetting(s), SkipVerify(true))
+	if err != nil {
+		t.Fatal("Error fetching url:", err.Error())
+	}
+
+	if e.Expired() {
+		t.Error("Expected the file on the server not to be expired")
+	}
+
+	server.Remove("file.bin")
+
+	if !e.Expired() {
+		t.Error("Expected the removed file to be expired")
+	}
+}
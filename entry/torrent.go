@@ -0,0 +1,263 @@
+package entry
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/network/torrent"
+)
+
+type (
+	// TorrentClient is implemented by the entry of a torrent, which pieces are downloaded from its peers. Its chunks are ranges of
+	// the pieces that the selected files take part of, laid out one after another, and so is its size
+	TorrentClient interface {
+		Info() (*torrent.Info, error)
+		Trackers() []string
+		Selection() []string // patterns of the files which are downloaded, empty for every file
+		// Selected returns the indexes of the files of the info which are downloaded
+		Selected() ([]int, error)
+	}
+
+	// torrentEntry is an entry which is saved as the file of a single file torrent, or as the folder of the files of the torrent
+	torrentEntry struct {
+		*entry
+		Files_   []string `json:"files"`
+		mutex    sync.Mutex
+		info     *torrent.Info
+		trackers []string
+	}
+)
+
+// torrentProvider is the downloader of the torrent entries, unless another one is chosen
+const torrentProvider = "torrent"
+
+// metadataTimeout is how long the info of a magnet link is looked for among its peers
+const metadataTimeout = 2 * time.Minute
+
+// torrentSource tells whether the url is downloaded as a torrent. A magnet link can't be downloaded otherwise, while a .torrent
+// file is downloaded itself by any downloader other than the torrent downloader or the default one
+func torrentSource(contentType, rawurl string, opt *option) bool {
+	if torrent.IsMagnet(rawurl) {
+		return true
+	}
+
+	switch opt.downloadProvider {
+	case torrentProvider:
+		return true
+	case "", "default":
+		u, err := url.Parse(rawurl)
+		return err == nil && torrent.IsMetainfo(contentType, u.Path)
+	default:
+		return false
+	}
+}
+
+// loadTorrent returns the info and the trackers of the torrent, from the .torrent file of the request or from the peers of its
+// magnet link
+func loadTorrent(client *http.Client, req *http.Request) (*torrent.Info, []string, error) {
+	rawurl := req.URL.String()
+
+	if torrent.IsMagnet(rawurl) {
+		m, err := torrent.ParseMagnet(rawurl)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+		defer cancel()
+
+		info, err := torrent.FetchMetadata(ctx, m, torrent.Config{PeerID: torrent.NewPeerID(), Client: client})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return info, m.Trackers, nil
+	}
+
+	data, err := readMetainfo(client, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metainfo, err := torrent.ParseMetainfo(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return metainfo.Info, metainfo.Trackers, nil
+}
+
+// readMetainfo reads the .torrent file from the server, or from the disk for a file url
+func readMetainfo(client *http.Client, req *http.Request) ([]byte, error) {
+	if req.URL.Scheme == "file" {
+		return os.ReadFile(req.URL.Path)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching torrent:unexpected status %d", res.StatusCode)
+	}
+
+	// a .torrent file of a few gigabytes has a few hundred kilobytes of hashes
+	return io.ReadAll(io.LimitReader(res.Body, 64*1024*1024))
+}
+
+// neededSize returns the size of the pieces that the files take part of
+func neededSize(info *torrent.Info, files []int) (int64, int) {
+	needed := info.PiecesOf(files)
+
+	size := int64(0)
+	for _, piece := range needed {
+		size += info.PieceSize(piece)
+	}
+
+	return size, len(needed)
+}
+
+// fetchTorrent loads the info of the torrent, and picks its files by the file patterns
+func fetchTorrent(rawurl string, opt *option, checksum string) (Entry, error) {
+	if len(opt.mirrors) > 0 {
+		log.Println("mirrors are ignored for torrent download")
+	}
+
+	req, err := newRequest(rawurl, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	info, trackers, err := loadTorrent(opt.client(), req)
+	if err != nil {
+		log.Println("error fetching torrent:", err.Error())
+		return nil, err
+	}
+
+	if checksum != "" && !info.Single {
+		return nil, fmt.Errorf("checksum of a torrent of many files is not supported")
+	}
+
+	selected, err := info.Select(opt.files)
+	if err != nil {
+		return nil, err
+	}
+
+	size, pieces := neededSize(info, selected)
+	if size == 0 {
+		return nil, fmt.Errorf("selected files of %s are empty", info.Name)
+	}
+
+	location, err := destination(info.Name, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	chunklen := calculatePartition(size, opt.setting)
+	if chunklen > pieces {
+		chunklen = pieces
+	}
+
+	downloadProvider := torrentProvider
+	if opt.downloadProvider != "" && opt.downloadProvider != "default" {
+		downloadProvider = opt.downloadProvider
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	filename := filepath.Base(location)
+
+	return &torrentEntry{
+		entry: &entry{
+			Id:                id(),
+			Name_:             filename,
+			Location_:         location,
+			Filetype_:         filetype(filename),
+			URL_:              rawurl,
+			Size_:             size,
+			ChunkLen_:         chunklen,
+			ctx:               ctx,
+			cancel:            cancel,
+			Resumable_:        true, // the pieces that are done are kept
+			request:           req,
+			DownloadProvider_: downloadProvider,
+			Checksum_:         checksum,
+			Mirrors_:          make([]string, 0),
+			Proxy_:            opt.proxy,
+			Insecure_:         opt.insecure,
+			ETag_:             torrentProvider + "-" + hex.EncodeToString(info.Hash[:]),
+		},
+		Files_:   opt.files,
+		info:     info,
+		trackers: trackers,
+	}, nil
+}
+
+func (e *torrentEntry) client() *http.Client {
+	return network.New(network.UseProxy(e.Proxy_), network.SkipVerify(e.Insecure_))
+}
+
+// Info returns the info of the torrent. The entry restored from a manifest loads it again on the first call
+func (e *torrentEntry) Info() (*torrent.Info, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.info != nil {
+		return e.info, nil
+	}
+
+	info, trackers, err := loadTorrent(e.client(), e.request.Clone(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+
+	if etag := torrentProvider + "-" + hex.EncodeToString(info.Hash[:]); etag != e.ETag_ {
+		return nil, fmt.Errorf("torrent of %s has changed", e.Name_)
+	}
+
+	e.info, e.trackers = info, trackers
+	return e.info, nil
+}
+
+func (e *torrentEntry) Trackers() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.trackers
+}
+
+func (e *torrentEntry) Selection() []string {
+	return e.Files_
+}
+
+func (e *torrentEntry) Selected() ([]int, error) {
+	info, err := e.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return info.Select(e.Files_)
+}
+
+// Expired tells whether the torrent can't be downloaded anymore, which is up to its peers rather than a url
+func (e *torrentEntry) Expired() bool {
+	return false
+}
+
+// Refresh prepares the entry for another download. The pieces of a torrent never change, since they are identified by its hash
+func (e *torrentEntry) Refresh() error {
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return nil
+}
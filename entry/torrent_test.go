@@ -0,0 +1,157 @@
+package entry
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/network/torrent"
+	"github.com/rapid-downloader/rapid/network/torrenttest"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// release returns a torrent of an image and its notes, in pieces of 16 KB
+func release(tracker string) ([]byte, *torrent.Info, []byte) {
+	metainfo, info, data := torrenttest.Create(tracker, "ubuntu", 16*1024,
+		torrenttest.File{Path: "ubuntu.iso", Content: bytes.Repeat([]byte("iso"), 20*1024)},
+		torrenttest.File{Path: "notes/README.txt", Content: bytes.Repeat([]byte("readme"), 1000)},
+	)
+
+	return metainfo, info, data
+}
+
+func TestFetchTorrent(t *testing.T) {
+	tracker := torrenttest.NewTracker()
+	defer tracker.Close()
+
+	metainfo, _, _ := release(tracker.URL)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ubuntu.torrent":
+		case "/download":
+			w.Header().Set("Content-Type", "application/x-bittorrent")
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(metainfo)
+	}))
+
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		files    []string
+		selected []int
+		size     int64
+	}{
+		// the image is 60 KB, and the notes start in its last piece
+		{name: "every file", url: server.URL + "/ubuntu.torrent", selected: []int{0, 1}, size: 67440},
+		{name: "by content type", url: server.URL + "/download", selected: []int{0, 1}, size: 67440},
+		{name: "notes only", url: server.URL + "/ubuntu.torrent", files: []string{"*.txt"}, selected: []int{1}, size: 67440 - 3*16*1024},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := setting.Default()
+			s.DownloadLocation = t.TempDir()
+			s.MinChunkSize = 16 * 1024
+
+			e, err := Fetch(test.url, UseSetting(s), UseFiles(test.files...))
+			if err != nil {
+				t.Fatal("Error fetching url:", err.Error())
+			}
+
+			client, ok := e.(TorrentClient)
+			if !ok {
+				t.Fatal("Expected a torrent entry")
+			}
+
+			if selected, err := client.Selected(); err != nil || !reflect.DeepEqual(selected, test.selected) {
+				t.Errorf("Expected files %v, but got %v (%v)", test.selected, selected, err)
+			}
+
+			if e.Name() != "ubuntu" || e.Downloader() != torrentProvider || e.Size() != test.size || !e.Resumable() {
+				t.Errorf("Expected resumable ubuntu of %d bytes, but got %s of %d bytes by %s", test.size, e.Name(), e.Size(), e.Downloader())
+			}
+
+			if pieces := (test.size + 16*1024 - 1) / (16 * 1024); e.ChunkLen() < 1 || int64(e.ChunkLen()) > pieces {
+				t.Errorf("Expected at most a chunk per piece, but got %d chunks", e.ChunkLen())
+			}
+
+			if trackers := client.Trackers(); !reflect.DeepEqual(trackers, []string{tracker.URL}) {
+				t.Errorf("Expected the tracker of the torrent, but got %v", trackers)
+			}
+
+			// the info is loaded again by the restored entry
+			restored, err := NewManifest(e).Entry()
+			if err != nil {
+				t.Fatal("Error restoring entry:", err.Error())
+			}
+
+			info, err := restored.(TorrentClient).Info()
+			if err != nil || restored.Size() != e.Size() || !reflect.DeepEqual(restored.(TorrentClient).Selection(), test.files) {
+				t.Fatalf("Expected the same torrent to be restored, but got %v", err)
+			}
+
+			if original, _ := client.Info(); info.Hash != original.Hash {
+				t.Errorf("Expected the same info hash")
+			}
+		})
+	}
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	if _, err := Fetch(server.URL+"/ubuntu.torrent", UseSetting(s), UseChecksum("sha256:"+hex.EncodeToString(make([]byte, 32)))); err == nil {
+		t.Error("Expected the checksum of many files to be refused")
+	}
+
+	if _, err := Fetch(server.URL+"/ubuntu.torrent", UseSetting(s), UseFiles("*.mkv")); err == nil {
+		t.Error("Expected an error when no file matches")
+	}
+}
+
+func TestFetchMagnet(t *testing.T) {
+	tracker := torrenttest.NewTracker()
+	defer tracker.Close()
+
+	metainfo, info, data := release(tracker.URL)
+
+	seeder := torrenttest.Seed(info, data)
+	defer seeder.Close()
+
+	tracker.Add(seeder.Addr().String())
+
+	s := setting.Default()
+	s.DownloadLocation = t.TempDir()
+
+	magnet := "magnet:?xt=urn:btih:" + hex.EncodeToString(info.Hash[:]) + "&dn=ubuntu&tr=" + url.QueryEscape(tracker.URL)
+
+	e, err := Fetch(magnet, UseSetting(s))
+	if err != nil {
+		t.Fatal("Error fetching magnet:", err.Error())
+	}
+
+	fetched, err := e.(TorrentClient).Info()
+	if err != nil || !bytes.Contains(metainfo, fetched.Raw) {
+		t.Fatalf("Expected the info of the torrent from its peer, but got %v", err)
+	}
+
+	if e.Name() != "ubuntu" || e.URL() != magnet || e.Downloader() != torrentProvider {
+		t.Errorf("Expected ubuntu from the magnet link, but got %s from %s", e.Name(), e.URL())
+	}
+
+	// the peers are only found through the trackers of the link
+	if _, err := Fetch("magnet:?xt=urn:btih:"+hex.EncodeToString(info.Hash[:]), UseSetting(s)); !errors.Is(err, torrent.ErrNoTracker) {
+		t.Errorf("Expected a magnet link without trackers to fail, but got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Rename is replaced by the tests, since the locations of a test are on the same device
+var Rename = os.Rename
+
+// Move renames the file, and falls back into copying it when the locations are on different devices, e.g the download location
+// and a folder on another disk. The copy keeps the permissions of the file, and the file is only removed once it is copied
+func Move(from, to string) error {
+	err := Rename(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	return os.Remove(from)
+}
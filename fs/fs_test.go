@@ -0,0 +1,88 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMove(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from.txt"), filepath.Join(dir, "to.txt")
+
+	if err := os.WriteFile(from, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Move(from, to); err != nil {
+		t.Fatal("Error moving file:", err.Error())
+	}
+
+	if content, err := os.ReadFile(to); err != nil || string(content) != "content" {
+		t.Error("Expected the file to be moved")
+	}
+
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Error("Expected the moved file to be gone")
+	}
+}
+
+func TestMoveAcrossDevices(t *testing.T) {
+	Rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+	}
+
+	defer func() { Rename = os.Rename }()
+
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from.txt"), filepath.Join(dir, "to.txt")
+
+	if err := os.WriteFile(from, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Move(from, to); err != nil {
+		t.Fatal("Error moving file:", err.Error())
+	}
+
+	content, err := os.ReadFile(to)
+	if err != nil || string(content) != "content" {
+		t.Error("Expected the file to be copied")
+	}
+
+	if info, err := os.Stat(to); err != nil || info.Mode().Perm() != 0600 {
+		t.Error("Expected the copy to keep the permissions of the file")
+	}
+
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Error("Expected the copied file to be removed")
+	}
+}
+
+func TestMoveFailed(t *testing.T) {
+	Rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EACCES}
+	}
+
+	defer func() { Rename = os.Rename }()
+
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from.txt"), filepath.Join(dir, "to.txt")
+
+	if err := os.WriteFile(from, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Move(from, to); err == nil {
+		t.Error("Expected an error other than across devices not to be copied")
+	}
+
+	if _, err := os.Stat(from); err != nil {
+		t.Error("Expected the file to be kept")
+	}
+
+	if _, err := os.Stat(to); !os.IsNotExist(err) {
+		t.Error("Expected no copy")
+	}
+}
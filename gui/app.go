@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/rapid-downloader/rapid/auth"
 	"github.com/rapid-downloader/rapid/client"
 	"github.com/rapid-downloader/rapid/client/websocket"
 	"github.com/rapid-downloader/rapid/env"
 	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/setting"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
@@ -31,7 +33,7 @@ func (a *App) startup(ctx context.Context) {
 	port := env.Get("API_PORT").String(":8888")
 	id := "gui"
 
-	wsUrl := fmt.Sprintf("ws://%s%s/ws/%s", host, port, id)
+	wsUrl := fmt.Sprintf("ws://%s%s/ws/%s?%s=%s", host, port, id, auth.Query, a.Token())
 
 	a.ws = websocket.Connect(ctx, wsUrl)
 	go a.ws.Listen(func(msg []byte) {
@@ -48,3 +50,13 @@ func (a *App) startup(ctx context.Context) {
 func (a *App) shutdown(ctx context.Context) {
 	a.ws.Close()
 }
+
+// Token returns the token of the engine, so the frontend can call the api
+func (a *App) Token() string {
+	token, err := auth.Token(setting.Get().DataLocation)
+	if err != nil {
+		log.Println("error reading token:", err.Error())
+	}
+
+	return token
+}
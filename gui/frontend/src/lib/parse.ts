@@ -50,7 +50,7 @@ export function statusColor(status: string): string {
         'Completed': 'text-success',
         'Downloading': 'text-info',
         'Failed': 'text-destructive',
-        'Stoped': 'text-warning',
+        'Canceled': 'text-warning',
         'Paused': '',
         'Queued': 'text-info'
     }
@@ -1,6 +1,6 @@
 export type Type = 'Document' | 'Audio' | 'Video' | 'Image' | 'Compressed' | 'Other'
 
-export type Status = 'Completed' | 'Stoped' | 'Downloading' | 'Queued' | 'Failed' | 'Paused'
+export type Status = 'Completed' | 'Canceled' | 'Downloading' | 'Queued' | 'Failed' | 'Paused' | 'Expired' | 'Mismatch'
 
 export type Sort = 'date' | 'name' | 'size'
 
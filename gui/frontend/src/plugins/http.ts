@@ -2,6 +2,7 @@ import { toast } from "@/components/ui/sonner"
 import axios, { AxiosInstance, isAxiosError } from "axios"
 import Refresh from "@/components/ui/icon/Refresh.vue"
 import { App } from "vue"
+import { Token } from "@/../wailsjs/go/main/App"
 
 export interface HttpOption {
     baseURL: string,
@@ -17,6 +18,14 @@ export const http = axios.create({
     baseURL: 'http://localhost:8888',
 })
 
+// every request to the engine must carry its token
+let token: Promise<string> | undefined
+http.interceptors.request.use(async config => {
+    token ??= Token()
+    config.headers['X-Rapid-Token'] = await token
+    return config
+})
+
 export default {
     install(app: App) {
         const onError = (err: any) => {
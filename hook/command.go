@@ -0,0 +1,58 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// outputLimit is how much of the command output is kept in the result
+const outputLimit = 4096
+
+// command runs the target without a shell. {file} in the arguments is replaced by the path of the file, which is appended if there is none
+func command(ctx context.Context, hook setting.Hook, file File) (string, string, error) {
+	args := strings.Fields(hook.Target)
+	if len(args) == 0 {
+		return "", "", fmt.Errorf("command is empty")
+	}
+
+	replaced := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{file}") {
+			args[i] = strings.ReplaceAll(arg, "{file}", file.Path)
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		args = append(args, file.Path)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"RAPID_ID="+file.ID,
+		"RAPID_FILE="+file.Path,
+		"RAPID_URL="+file.URL,
+	)
+
+	out, err := cmd.CombinedOutput()
+
+	output := strings.TrimSpace(string(out))
+	if len(output) > outputLimit {
+		output = output[:outputLimit]
+	}
+
+	if err != nil {
+		return output, "", fmt.Errorf("error running %s: %s", args[0], err.Error())
+	}
+
+	return output, "", nil
+}
+
+func init() {
+	registerAction(Command, command)
+}
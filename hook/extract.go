@@ -0,0 +1,234 @@
+package hook
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// compressed is the type of the entry which the archives are recognized as
+const compressed = "Compressed"
+
+// destination is the target folder of the hook, or a folder named after the archive next to it
+func destination(hook setting.Hook, file File) string {
+	if hook.Target != "" {
+		return hook.Target
+	}
+
+	name := strings.ToLower(file.Name)
+	base := file.Name
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".tar"} {
+		if strings.HasSuffix(name, ext) {
+			base = file.Name[:len(file.Name)-len(ext)]
+			break
+		}
+	}
+
+	return filepath.Join(filepath.Dir(file.Path), base)
+}
+
+// inside joins the name of an archived file into the destination, and refuses the names which escape it
+func inside(dest, name string) (string, error) {
+	path := filepath.Join(dest, name)
+	if path != dest && !strings.HasPrefix(path, dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archived file %s is outside of the destination", name)
+	}
+
+	return path, nil
+}
+
+func write(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode|0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func unzip(ctx context.Context, src, dest string) (int, error) {
+	reader, err := zip.OpenReader(src)
+	if err != nil {
+		return 0, err
+	}
+
+	defer reader.Close()
+
+	count := 0
+	for _, f := range reader.File {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		path, err := inside(dest, f.Name)
+		if err != nil {
+			return count, err
+		}
+
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(path, os.ModePerm)
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return count, err
+		}
+
+		err = write(path, r, f.Mode().Perm())
+		r.Close()
+
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func untar(ctx context.Context, src, dest string, compressed bool) (int, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	var r io.Reader = file
+	if compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := tar.NewReader(r)
+
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		header, err := reader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		path, err := inside(dest, header.Name)
+		if err != nil {
+			return count, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			os.MkdirAll(path, os.ModePerm)
+		case tar.TypeReg:
+			if err := write(path, reader, header.FileInfo().Mode().Perm()); err != nil {
+				return count, err
+			}
+
+			count++
+		}
+	}
+}
+
+const (
+	zipArchive = "zip"
+	tarArchive = "tar"
+	gzArchive  = "tar.gz"
+)
+
+// archive tells the format of the archive by its content, empty if it isn't one that can be extracted
+func archive(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	// the magic of tar is the furthest one, at offset 257
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return zipArchive, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return gzArchive, nil
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return tarArchive, nil
+	}
+
+	return "", nil
+}
+
+// extract unpacks zip, tar and tar.gz archives, which are the compressed type of the entry. The archive itself is kept
+func extract(ctx context.Context, hook setting.Hook, file File) (string, string, error) {
+	if !strings.EqualFold(file.Type, compressed) {
+		return "", "", fmt.Errorf("%s is not a compressed file", file.Name)
+	}
+
+	dest := filepath.Clean(destination(hook, file))
+
+	format, err := archive(file.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading archive %s: %s", file.Name, err.Error())
+	}
+
+	var count int
+
+	switch format {
+	case zipArchive:
+		count, err = unzip(ctx, file.Path, dest)
+	case gzArchive:
+		count, err = untar(ctx, file.Path, dest, true)
+	case tarArchive:
+		count, err = untar(ctx, file.Path, dest, false)
+	default:
+		return "", "", fmt.Errorf("archive %s is not supported, only zip, tar and tar.gz are", file.Name)
+	}
+
+	if err != nil {
+		return "", "", fmt.Errorf("error extracting %s: %s", file.Name, err.Error())
+	}
+
+	return fmt.Sprintf("extracted %d files into %s", count, dest), "", nil
+}
+
+func init() {
+	registerAction(Extract, extract)
+}
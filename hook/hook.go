@@ -0,0 +1,109 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+type (
+	// File is the completed download that the hooks run on
+	File struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Path string `json:"path"`
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+
+	// Result of a hook that ran on a completed download
+	Result struct {
+		Hook   string    `json:"hook"`
+		Action string    `json:"action"`
+		Output string    `json:"output,omitempty"`
+		Error  string    `json:"error,omitempty"`
+		Date   time.Time `json:"date"`
+	}
+
+	// Action performs the hook on the file, and returns the new path of the file when it is moved
+	Action func(ctx context.Context, hook setting.Hook, file File) (output string, path string, err error)
+)
+
+const (
+	Move    = "move"
+	Extract = "extract"
+	Command = "command"
+	Webhook = "webhook"
+)
+
+// timeout of a single hook, so a hanging command or webhook doesn't keep running forever
+const timeout = 10 * time.Minute
+
+var actions = make(map[string]Action)
+
+func registerAction(name string, action Action) {
+	actions[name] = action
+}
+
+// Match tells whether the hook applies to the file
+func Match(hook setting.Hook, file File) bool {
+	if hook.Type != "" && !strings.EqualFold(hook.Type, file.Type) {
+		return false
+	}
+
+	if hook.Glob == "" {
+		return true
+	}
+
+	match, err := filepath.Match(hook.Glob, file.Name)
+	return err == nil && match
+}
+
+// Run performs the matching hooks in order, and returns their results and the final path of the file.
+// A failing hook doesn't stop the next ones
+func Run(hooks []setting.Hook, file File) ([]Result, string) {
+	results := make([]Result, 0)
+
+	for _, hook := range hooks {
+		if !Match(hook, file) {
+			continue
+		}
+
+		result := Result{
+			Hook:   hook.Name,
+			Action: hook.Action,
+		}
+
+		action, ok := actions[hook.Action]
+		if !ok {
+			result.Error = fmt.Sprintf("action %s is not implemented", hook.Action)
+			result.Date = time.Now()
+			results = append(results, result)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		output, path, err := action(ctx, hook, file)
+		cancel()
+
+		result.Output = output
+		result.Date = time.Now()
+
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		if path != "" {
+			file.Path = path
+			file.Name = filepath.Base(path)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, file.Path
+}
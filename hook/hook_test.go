@@ -0,0 +1,213 @@
+package hook
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+func create(t *testing.T, dir, name, content string) File {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal("Error creating file:", err.Error())
+	}
+
+	return File{ID: "1", Name: name, Path: path, Type: "Other"}
+}
+
+func TestMatch(t *testing.T) {
+	file := File{Name: "movie.mkv", Type: "Video"}
+
+	tests := []struct {
+		hook  setting.Hook
+		match bool
+	}{
+		{setting.Hook{}, true},
+		{setting.Hook{Type: "video"}, true},
+		{setting.Hook{Type: "Compressed"}, false},
+		{setting.Hook{Glob: "*.mkv"}, true},
+		{setting.Hook{Type: "Video", Glob: "*.mp4"}, false},
+	}
+
+	for _, test := range tests {
+		if match := Match(test.hook, file); match != test.match {
+			t.Errorf("Expected %+v to match %v, got %v", test.hook, test.match, match)
+		}
+	}
+}
+
+func TestRunMoveThenCommand(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "videos")
+
+	file := create(t, dir, "movie.mkv", "movie")
+	file.Type = "Video"
+
+	// a file with the same name is already in the target
+	os.MkdirAll(target, os.ModePerm)
+	create(t, target, "movie.mkv", "other")
+
+	hooks := []setting.Hook{
+		{Name: "videos", Type: "Video", Action: Move, Target: target},
+		{Name: "ignored", Type: "Audio", Action: Move, Target: dir},
+		{Name: "list", Action: Command, Target: "ls {file}"},
+		{Name: "broken", Action: Command, Target: "rapid-missing-command"},
+	}
+
+	results, path := Run(hooks, file)
+
+	expected := filepath.Join(target, "movie (1).mkv")
+	if path != expected {
+		t.Fatalf("Expected the file to be moved into %s, got %s", expected, path)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+
+	if results[1].Error != "" || results[1].Output != expected {
+		t.Errorf("Expected the command to run on the moved file, got %+v", results[1])
+	}
+
+	if results[2].Error == "" {
+		t.Error("Expected the missing command to fail")
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.zip")
+
+	f, _ := os.Create(path)
+	w := zip.NewWriter(f)
+	fw, _ := w.Create("nested/hello.txt")
+	fw.Write([]byte("hello"))
+	w.Close()
+	f.Close()
+
+	results, _ := Run([]setting.Hook{{Type: "Compressed", Action: Extract}}, File{Name: "archive.zip", Path: path, Type: "Compressed"})
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("Expected extraction to succeed, got %+v", results)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "archive", "nested", "hello.txt"))
+	if err != nil || string(content) != "hello" {
+		t.Errorf("Expected the extracted file, got %q (%v)", content, err)
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.tar.gz")
+
+	write := func(names ...string) {
+		f, _ := os.Create(path)
+		gz := gzip.NewWriter(f)
+		w := tar.NewWriter(gz)
+		for _, name := range names {
+			w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 5, Typeflag: tar.TypeReg})
+			w.Write([]byte("hello"))
+		}
+		w.Close()
+		gz.Close()
+		f.Close()
+	}
+
+	write("hello.txt")
+	target := filepath.Join(dir, "out")
+
+	results, _ := Run([]setting.Hook{{Action: Extract, Target: target}}, File{Name: "archive.tar.gz", Path: path, Type: "Compressed"})
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("Expected extraction to succeed, got %+v", results)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(target, "hello.txt")); err != nil || string(content) != "hello" {
+		t.Errorf("Expected the extracted file, got %q (%v)", content, err)
+	}
+
+	// an archived file can't be written outside of the destination
+	write("../escaped.txt")
+
+	results, _ = Run([]setting.Hook{{Action: Extract, Target: target}}, File{Name: "archive.tar.gz", Path: path, Type: "Compressed"})
+	if results[0].Error == "" {
+		t.Error("Expected extraction to refuse the escaping file")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("Expected the escaping file not to be written")
+	}
+}
+
+func TestExtractByContent(t *testing.T) {
+	dir := t.TempDir()
+
+	// the zip is named like a tarball, so only its content tells how it is extracted
+	path := filepath.Join(dir, "archive.tgz")
+
+	f, _ := os.Create(path)
+	w := zip.NewWriter(f)
+	fw, _ := w.Create("hello.txt")
+	fw.Write([]byte("hello"))
+	w.Close()
+	f.Close()
+
+	results, _ := Run([]setting.Hook{{Action: Extract}}, File{Name: "archive.tgz", Path: path, Type: "Compressed"})
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("Expected extraction to succeed, got %+v", results)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(dir, "archive", "hello.txt")); err != nil || string(content) != "hello" {
+		t.Errorf("Expected the extracted file, got %q (%v)", content, err)
+	}
+
+	results, _ = Run([]setting.Hook{{Action: Extract}}, File{Name: "archive.tgz", Path: path, Type: "Other"})
+	if results[0].Error == "" {
+		t.Error("Expected extraction to refuse the file which isn't compressed")
+	}
+
+	text := create(t, dir, "notes.zip", "not an archive")
+	text.Type = "Compressed"
+
+	results, _ = Run([]setting.Hook{{Action: Extract}}, text)
+	if results[0].Error == "" {
+		t.Error("Expected extraction to refuse the file which isn't an archive")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	file := File{ID: "1", Name: "movie.mkv", Path: "/tmp/movie.mkv", Type: "Video"}
+
+	results, _ := Run([]setting.Hook{{Action: Webhook, Target: server.URL}}, file)
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("Expected the webhook to succeed, got %+v", results)
+	}
+
+	if received.Event != "completed" || received.File != file {
+		t.Errorf("Expected the completed file in the payload, got %+v", received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	results, _ = Run([]setting.Hook{{Action: Webhook, Target: failing.URL}}, file)
+	if results[0].Error == "" {
+		t.Error("Expected the failing webhook to be reported")
+	}
+}
@@ -0,0 +1,45 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rapid-downloader/rapid/fs"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// available returns a path in the folder which doesn't exist yet, by numbering the name of the file
+func available(dir, name string) string {
+	path := filepath.Join(dir, name)
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+}
+
+func move(ctx context.Context, hook setting.Hook, file File) (string, string, error) {
+	if err := os.MkdirAll(hook.Target, os.ModePerm); err != nil {
+		return "", "", fmt.Errorf("error creating folder %s: %s", hook.Target, err.Error())
+	}
+
+	path := available(hook.Target, file.Name)
+	if err := fs.Move(file.Path, path); err != nil {
+		return "", "", fmt.Errorf("error moving file into %s: %s", hook.Target, err.Error())
+	}
+
+	return "moved into " + path, path, nil
+}
+
+func init() {
+	registerAction(Move, move)
+}
@@ -0,0 +1,60 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rapid-downloader/rapid/network"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+const webhookTimeout = 30 * time.Second
+
+type payload struct {
+	Event string `json:"event"`
+	File
+}
+
+// webhook posts the completed download as json into the target url
+func webhook(ctx context.Context, hook setting.Hook, file File) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(payload{
+		Event: "completed",
+		File:  file,
+	})
+
+	if err != nil {
+		return "", "", fmt.Errorf("error marshalling webhook payload:%s", err.Error())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.Target, bytes.NewReader(body))
+	if err != nil {
+		return "", "", fmt.Errorf("error preparing webhook request:%s", err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// the webhook goes through the proxy and the certificates of the setting like the downloads do
+	res, err := network.New(network.UseSetting(setting.Get())).Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("error calling webhook:%s", err.Error())
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return "", "", fmt.Errorf("webhook responded with %s", res.Status)
+	}
+
+	return res.Status, "", nil
+}
+
+func init() {
+	registerAction(Webhook, webhook)
+}
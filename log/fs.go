@@ -20,17 +20,32 @@ const FS = "fs"
 
 // stdoutLogger will log into std out
 func FSLogger(s *setting.Setting) Logger {
+	logger := &fsLogger{
+		Mutex: &sync.Mutex{},
+		path:  logPath(s.DataLocation),
+	}
+
+	// the next logs are written into the new data location
+	setting.Subscribe(func(old, new *setting.Setting) {
+		if old.DataLocation == new.DataLocation {
+			return
+		}
+
+		logger.Lock()
+		logger.path = logPath(new.DataLocation)
+		logger.Unlock()
+	})
+
+	return logger
+}
+
+func logPath(location string) string {
 	const DDMMYYYY = "02-01-2006"
 
-	dir := fmt.Sprintf("%s/logs", s.DataLocation)
+	dir := fmt.Sprintf("%s/logs", location)
 	os.MkdirAll(dir, os.ModePerm)
 
-	filename := filepath.Join(dir, time.Now().Format(DDMMYYYY)+".txt")
-
-	return &fsLogger{
-		Mutex: &sync.Mutex{},
-		path:  filename,
-	}
+	return filepath.Join(dir, time.Now().Format(DDMMYYYY)+".txt")
 }
 
 func prefix() string {
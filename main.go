@@ -4,14 +4,17 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/joho/godotenv"
 	"github.com/rapid-downloader/rapid/api"
+	"github.com/rapid-downloader/rapid/auth"
 	"github.com/rapid-downloader/rapid/db"
 	_ "github.com/rapid-downloader/rapid/downloader/api"
 	_ "github.com/rapid-downloader/rapid/entry/api"
+	"github.com/rapid-downloader/rapid/env"
+	"github.com/rapid-downloader/rapid/log"
 	_ "github.com/rapid-downloader/rapid/log/api"
+	"github.com/rapid-downloader/rapid/setting"
 	_ "github.com/rapid-downloader/rapid/setting/api"
 )
 
@@ -28,8 +31,14 @@ func main() {
 		JSONDecoder: json.Unmarshal,
 	})
 
+	token, err := auth.Token(setting.Get().DataLocation)
+	if err != nil {
+		log.Fatal("error preparing token:", err.Error())
+	}
+
 	app.Use(logger.New())
-	app.Use(cors.New())
+	app.Use(auth.Cors(env.Get("API_ALLOWED_ORIGINS").String(auth.Origins)))
+	app.Use(auth.New(token))
 	app.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
 			c.Locals("allowed", true)
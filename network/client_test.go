@@ -0,0 +1,194 @@
+package network
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// httpProxy answers every request itself, and records the requested host and the credentials
+func httpProxy(t *testing.T) (*httptest.Server, *[]string) {
+	var mutex sync.Mutex
+	hosts := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		hosts = append(hosts, r.URL.Host+" "+r.Header.Get("Proxy-Authorization"))
+		mutex.Unlock()
+
+		w.Write([]byte("proxied"))
+	}))
+
+	t.Cleanup(server.Close)
+	return server, &hosts
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, error) {
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestHTTPProxy(t *testing.T) {
+	proxy, hosts := httpProxy(t)
+
+	s := setting.Default()
+	s.Proxy = "http://user:pass@" + proxy.Listener.Addr().String()
+	s.NoProxy = []string{".internal.test"}
+
+	body, err := get(t, New(UseSetting(s)), "http://files.example.test/file.bin")
+	if err != nil {
+		t.Fatal("Error requesting through proxy:", err.Error())
+	}
+
+	if body != "proxied" || len(*hosts) != 1 || (*hosts)[0] != "files.example.test Basic dXNlcjpwYXNz" {
+		t.Fatalf("Expected the request to go through the proxy with credentials, got %q and %v", body, *hosts)
+	}
+
+	// the host in the no proxy list is requested directly, which fails since it doesn't exist
+	if _, err := get(t, New(UseSetting(s)), "http://files.internal.test/file.bin"); err == nil {
+		t.Error("Expected the no proxy host to bypass the proxy")
+	}
+
+	if len(*hosts) != 1 {
+		t.Errorf("Expected the proxy not to be used for the no proxy host, got %v", *hosts)
+	}
+}
+
+func TestEntryProxy(t *testing.T) {
+	proxy, hosts := httpProxy(t)
+
+	s := setting.Default()
+	s.Proxy = "http://127.0.0.1:1"
+
+	if _, err := get(t, New(UseSetting(s), UseProxy("http://"+proxy.Listener.Addr().String())), "http://files.example.test/"); err != nil {
+		t.Fatal("Error requesting through the proxy of the entry:", err.Error())
+	}
+
+	if len(*hosts) != 1 {
+		t.Errorf("Expected the proxy of the entry to be used, got %v", *hosts)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct"))
+	}))
+	defer server.Close()
+
+	if body, err := get(t, New(UseSetting(s), UseProxy(Direct)), server.URL); err != nil || body != "direct" {
+		t.Errorf("Expected direct to bypass the proxy of the setting, got %q (%v)", body, err)
+	}
+}
+
+func TestBrokenProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := get(t, New(UseProxy("ftp://127.0.0.1:21")), server.URL); err == nil {
+		t.Error("Expected the request to fail rather than going around the broken proxy")
+	}
+}
+
+// socks5 serves a single connection of the username and password method, records the requested target, and relays it into the upstream
+func socks5(t *testing.T, username, password, upstream string) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err.Error())
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	targets := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		// greeting: version and the methods
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		io.ReadFull(conn, make([]byte, header[1]))
+		conn.Write([]byte{5, 2})
+
+		// username and password
+		io.ReadFull(conn, header)
+		user := make([]byte, header[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, header[:1])
+		pass := make([]byte, header[0])
+		io.ReadFull(conn, pass)
+
+		if string(user) != username || string(pass) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+
+		conn.Write([]byte{1, 0})
+
+		// connect request: version, command, reserved and the address type, which is a domain name here
+		request := make([]byte, 5)
+		io.ReadFull(conn, request)
+		host := make([]byte, request[4])
+		io.ReadFull(conn, host)
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+
+		targets <- net.JoinHostPort(string(host), strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+		up, err := net.Dial("tcp", upstream)
+		if err != nil {
+			conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+
+		defer up.Close()
+
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+		go io.Copy(up, conn)
+		io.Copy(conn, up)
+	}()
+
+	return listener.Addr().String(), targets
+}
+
+func TestSocks5Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("through socks"))
+	}))
+	defer server.Close()
+
+	addr, targets := socks5(t, "user", "pass", server.Listener.Addr().String())
+
+	s := setting.Default()
+	s.Proxy = "socks5://user:pass@" + addr
+
+	body, err := get(t, New(UseSetting(s)), "http://files.example.test:8080/file.bin")
+	if err != nil {
+		t.Fatal("Error requesting through socks5 proxy:", err.Error())
+	}
+
+	if body != "through socks" {
+		t.Errorf("Expected the response of the server, got %q", body)
+	}
+
+	if target := <-targets; target != "files.example.test:8080" {
+		t.Errorf("Expected the proxy to connect to files.example.test:8080, got %s", target)
+	}
+}
@@ -0,0 +1,573 @@
+// Package dash parses the media presentation description of mpeg-dash into the segments of its representations
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rapid-downloader/rapid/network/hls"
+)
+
+type (
+	// Presentation is the first period of a static mpd. The periods after it, e.g inserted ads, are not downloaded
+	Presentation struct {
+		Duration        time.Duration
+		Representations []Representation
+	}
+
+	// Representation is a track of the presentation in one quality
+	Representation struct {
+		ID        string
+		Type      string // video, audio or text
+		MimeType  string
+		Codecs    string
+		Bandwidth int64 // bits per second
+		Width     int
+		Height    int
+		Segments  []Segment
+	}
+
+	Segment struct {
+		URL    string
+		Offset int64
+		Length int64 // -1 if the segment is the whole resource
+		Init   bool  // the initialization section of the segments after it
+	}
+)
+
+// track types of the representations
+const (
+	Video = "video"
+	Audio = "audio"
+	Text  = "text"
+)
+
+type (
+	mpdXML struct {
+		Type     string      `xml:"type,attr"`
+		Duration string      `xml:"mediaPresentationDuration,attr"`
+		BaseURL  string      `xml:"BaseURL"`
+		Periods  []periodXML `xml:"Period"`
+	}
+
+	periodXML struct {
+		Duration       string          `xml:"duration,attr"`
+		BaseURL        string          `xml:"BaseURL"`
+		AdaptationSets []adaptationXML `xml:"AdaptationSet"`
+		segmentsXML
+	}
+
+	adaptationXML struct {
+		ContentType     string              `xml:"contentType,attr"`
+		MimeType        string              `xml:"mimeType,attr"`
+		Codecs          string              `xml:"codecs,attr"`
+		Width           int                 `xml:"width,attr"`
+		Height          int                 `xml:"height,attr"`
+		BaseURL         string              `xml:"BaseURL"`
+		Representations []representationXML `xml:"Representation"`
+		segmentsXML
+	}
+
+	representationXML struct {
+		ID        string `xml:"id,attr"`
+		MimeType  string `xml:"mimeType,attr"`
+		Codecs    string `xml:"codecs,attr"`
+		Bandwidth int64  `xml:"bandwidth,attr"`
+		Width     int    `xml:"width,attr"`
+		Height    int    `xml:"height,attr"`
+		BaseURL   string `xml:"BaseURL"`
+		segmentsXML
+	}
+
+	// segmentsXML describes the segments on any level. The lower level inherits the attributes it doesn't have
+	segmentsXML struct {
+		Template *templateXML `xml:"SegmentTemplate"`
+		List     *listXML     `xml:"SegmentList"`
+		Base     *baseXML     `xml:"SegmentBase"`
+	}
+
+	templateXML struct {
+		Media          string       `xml:"media,attr"`
+		Initialization string       `xml:"initialization,attr"`
+		StartNumber    *int64       `xml:"startNumber,attr"`
+		Timescale      *int64       `xml:"timescale,attr"`
+		Duration       *int64       `xml:"duration,attr"`
+		Timeline       *timelineXML `xml:"SegmentTimeline"`
+	}
+
+	timelineXML struct {
+		S []struct {
+			T *int64 `xml:"t,attr"`
+			D int64  `xml:"d,attr"`
+			R int64  `xml:"r,attr"`
+		} `xml:"S"`
+	}
+
+	listXML struct {
+		Initialization *urlXML `xml:"Initialization"`
+		SegmentURLs    []struct {
+			Media      string `xml:"media,attr"`
+			MediaRange string `xml:"mediaRange,attr"`
+		} `xml:"SegmentURL"`
+	}
+
+	baseXML struct {
+		Initialization *urlXML `xml:"Initialization"`
+	}
+
+	urlXML struct {
+		SourceURL string `xml:"sourceURL,attr"`
+		Range     string `xml:"range,attr"`
+	}
+)
+
+var identifier = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time)(%0?(\d+)d)?\$`)
+
+// IsManifest tells whether the resource is an mpd, judging by its content type or, if it has none, the extension of its path
+func IsManifest(contentType, p string) bool {
+	if media, _, err := mime.ParseMediaType(contentType); err == nil && strings.EqualFold(media, "application/dash+xml") {
+		return true
+	}
+
+	return strings.EqualFold(path.Ext(p), ".mpd")
+}
+
+// Parse reads the mpd. The urls in it are resolved against the base, which is the url the mpd is served from
+func Parse(r io.Reader, base *url.URL) (*Presentation, error) {
+	var mpd mpdXML
+	if err := xml.NewDecoder(r).Decode(&mpd); err != nil {
+		return nil, fmt.Errorf("error parsing mpd:%s", err.Error())
+	}
+
+	if mpd.Type == "dynamic" {
+		return nil, fmt.Errorf("live dash stream is not supported")
+	}
+
+	if len(mpd.Periods) == 0 {
+		return nil, fmt.Errorf("error parsing mpd:no period")
+	}
+
+	period := mpd.Periods[0]
+
+	duration, err := parseDuration(period.Duration)
+	if err != nil || duration == 0 {
+		if duration, err = parseDuration(mpd.Duration); err != nil {
+			return nil, err
+		}
+	}
+
+	base, err = resolve(base, mpd.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if base, err = resolve(base, period.BaseURL); err != nil {
+		return nil, err
+	}
+
+	p := &Presentation{Duration: duration}
+
+	for _, set := range period.AdaptationSets {
+		setBase, err := resolve(base, set.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rep := range set.Representations {
+			repBase, err := resolve(setBase, rep.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+
+			representation := Representation{
+				ID:        rep.ID,
+				MimeType:  first(rep.MimeType, set.MimeType),
+				Codecs:    first(rep.Codecs, set.Codecs),
+				Bandwidth: rep.Bandwidth,
+				Width:     rep.Width,
+				Height:    rep.Height,
+			}
+
+			if representation.Width == 0 && representation.Height == 0 {
+				representation.Width, representation.Height = set.Width, set.Height
+			}
+
+			representation.Type = set.ContentType
+			if representation.Type == "" {
+				representation.Type, _, _ = strings.Cut(representation.MimeType, "/")
+			}
+
+			segments := inherit(period.segmentsXML, set.segmentsXML, rep.segmentsXML)
+			if representation.Segments, err = segments.expand(repBase, representation, duration); err != nil {
+				return nil, fmt.Errorf("error parsing representation %s:%s", rep.ID, err.Error())
+			}
+
+			p.Representations = append(p.Representations, representation)
+		}
+	}
+
+	if len(p.Representations) == 0 {
+		return nil, fmt.Errorf("error parsing mpd:no representation")
+	}
+
+	return p, nil
+}
+
+// Tracks returns the representations of the type
+func (p *Presentation) Tracks(typ string) []Representation {
+	tracks := make([]Representation, 0)
+	for _, r := range p.Representations {
+		if r.Type == typ {
+			tracks = append(tracks, r)
+		}
+	}
+
+	return tracks
+}
+
+// Select picks the representation by the same preference as the variant of hls
+func Select(representations []Representation, preference string) (Representation, error) {
+	variants := make([]hls.Variant, len(representations))
+	for i, r := range representations {
+		variants[i] = hls.Variant{
+			URL:       strconv.Itoa(i),
+			Bandwidth: r.Bandwidth,
+			Width:     r.Width,
+			Height:    r.Height,
+		}
+	}
+
+	variant, err := hls.Select(variants, preference)
+	if err != nil {
+		return Representation{}, err
+	}
+
+	i, _ := strconv.Atoi(variant.URL)
+	return representations[i], nil
+}
+
+// Extension returns the file extension of the representation, e.g .mp4 for video and .m4a for audio in mp4
+func (r Representation) Extension() string {
+	_, subtype, _ := strings.Cut(r.MimeType, "/")
+
+	switch {
+	case r.Type == Audio && subtype == "mp4":
+		return ".m4a"
+	case r.Type == Audio && subtype == "webm":
+		return ".weba"
+	case subtype != "":
+		return "." + subtype
+	default:
+		return ".mp4"
+	}
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func resolve(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base, nil
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing mpd:invalid url %s", ref)
+	}
+
+	return base.ResolveReference(u), nil
+}
+
+// inherit merges the segment descriptions from the period down to the representation, where the lower level wins
+func inherit(levels ...segmentsXML) segmentsXML {
+	var merged segmentsXML
+
+	for _, level := range levels {
+		if level.Template != nil {
+			template := templateXML{}
+			if merged.Template != nil {
+				template = *merged.Template
+			}
+
+			t := level.Template
+			template.Media = first(t.Media, template.Media)
+			template.Initialization = first(t.Initialization, template.Initialization)
+
+			if t.StartNumber != nil {
+				template.StartNumber = t.StartNumber
+			}
+
+			if t.Timescale != nil {
+				template.Timescale = t.Timescale
+			}
+
+			if t.Duration != nil {
+				template.Duration = t.Duration
+			}
+
+			if t.Timeline != nil {
+				template.Timeline = t.Timeline
+			}
+
+			merged.Template = &template
+		}
+
+		if level.List != nil {
+			merged.List = level.List
+		}
+
+		if level.Base != nil {
+			merged.Base = level.Base
+		}
+	}
+
+	return merged
+}
+
+// expand lists the segments of the representation. A representation of segment base, or without any description, is a single
+// file which starts with its initialization section
+func (s segmentsXML) expand(base *url.URL, r Representation, duration time.Duration) ([]Segment, error) {
+	switch {
+	case s.Template != nil && s.Template.Media != "":
+		return s.Template.expand(base, r, duration)
+	case s.List != nil:
+		return s.List.expand(base)
+	default:
+		return []Segment{{URL: base.String(), Length: -1}}, nil
+	}
+}
+
+func (t *templateXML) expand(base *url.URL, r Representation, duration time.Duration) ([]Segment, error) {
+	segments := make([]Segment, 0)
+
+	number := int64(1)
+	if t.StartNumber != nil {
+		number = *t.StartNumber
+	}
+
+	timescale := int64(1)
+	if t.Timescale != nil && *t.Timescale > 0 {
+		timescale = *t.Timescale
+	}
+
+	add := func(template string, number, at int64, init bool) error {
+		u, err := resolve(base, substitute(template, r, number, at))
+		if err != nil {
+			return err
+		}
+
+		segments = append(segments, Segment{URL: u.String(), Length: -1, Init: init})
+		return nil
+	}
+
+	if t.Initialization != "" {
+		if err := add(t.Initialization, 0, 0, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.Timeline != nil {
+		end := int64(math.Ceil(duration.Seconds() * float64(timescale)))
+
+		var at int64
+		for i, s := range t.Timeline.S {
+			if s.T != nil {
+				at = *s.T
+			}
+
+			if s.D <= 0 {
+				return nil, fmt.Errorf("invalid segment duration %d", s.D)
+			}
+
+			repeat := s.R
+			if repeat < 0 {
+				// repeats until the next segment or the end of the period
+				until := end
+				if i+1 < len(t.Timeline.S) && t.Timeline.S[i+1].T != nil {
+					until = *t.Timeline.S[i+1].T
+				}
+
+				repeat = (until-at+s.D-1)/s.D - 1
+			}
+
+			for j := int64(0); j <= repeat; j++ {
+				if err := add(t.Media, number, at, false); err != nil {
+					return nil, err
+				}
+
+				number++
+				at += s.D
+			}
+		}
+
+		return segments, nil
+	}
+
+	if t.Duration == nil || *t.Duration <= 0 {
+		return nil, fmt.Errorf("segment template has neither a duration nor a timeline")
+	}
+
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration of the presentation is unknown")
+	}
+
+	count := int64(math.Ceil(duration.Seconds() * float64(timescale) / float64(*t.Duration)))
+	for i := int64(0); i < count; i++ {
+		if err := add(t.Media, number+i, i**t.Duration, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return segments, nil
+}
+
+func (l *listXML) expand(base *url.URL) ([]Segment, error) {
+	segments := make([]Segment, 0)
+
+	add := func(ref, byteRange string, init bool) error {
+		u, err := resolve(base, ref)
+		if err != nil {
+			return err
+		}
+
+		segment := Segment{URL: u.String(), Length: -1, Init: init}
+		if byteRange != "" {
+			if segment.Offset, segment.Length, err = parseRange(byteRange); err != nil {
+				return err
+			}
+		}
+
+		segments = append(segments, segment)
+		return nil
+	}
+
+	if l.Initialization != nil {
+		if err := add(l.Initialization.SourceURL, l.Initialization.Range, true); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range l.SegmentURLs {
+		if err := add(s.Media, s.MediaRange, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return segments, nil
+}
+
+// substitute replaces the identifiers of the template, e.g $Number%05d$, and $$ with $
+func substitute(template string, r Representation, number, at int64) string {
+	parts := strings.Split(template, "$$")
+
+	for i, part := range parts {
+		parts[i] = identifier.ReplaceAllStringFunc(part, func(match string) string {
+			groups := identifier.FindStringSubmatch(match)
+
+			var value string
+			switch groups[1] {
+			case "RepresentationID":
+				return r.ID
+			case "Number":
+				value = strconv.FormatInt(number, 10)
+			case "Bandwidth":
+				value = strconv.FormatInt(r.Bandwidth, 10)
+			case "Time":
+				value = strconv.FormatInt(at, 10)
+			}
+
+			if width, err := strconv.Atoi(groups[3]); err == nil && len(value) < width {
+				value = strings.Repeat("0", width-len(value)) + value
+			}
+
+			return value
+		})
+	}
+
+	return strings.Join(parts, "$")
+}
+
+// parseRange parses the byte range <first>-<last>
+func parseRange(value string) (int64, int64, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid byte range %s", value)
+	}
+
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid byte range %s", value)
+	}
+
+	end, err := strconv.ParseInt(to, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid byte range %s", value)
+	}
+
+	return start, end - start + 1, nil
+}
+
+// parseDuration parses the duration of iso 8601, e.g PT1H2M3.5S. Years and months are not used by mpd
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("invalid duration %s", value)
+	}
+
+	rest := value[1:]
+
+	var duration time.Duration
+	units := map[byte]time.Duration{'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+
+	inTime := false
+	number := ""
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+
+		switch {
+		case c == 'T':
+			inTime = true
+		case c >= '0' && c <= '9' || c == '.':
+			number += string(c)
+		default:
+			unit, ok := units[c]
+			if !ok || number == "" || (c == 'D') == inTime {
+				return 0, fmt.Errorf("invalid duration %s", value)
+			}
+
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %s", value)
+			}
+
+			duration += time.Duration(n * float64(unit))
+			number = ""
+		}
+	}
+
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration %s", value)
+	}
+
+	return duration, nil
+}
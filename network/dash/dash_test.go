@@ -0,0 +1,223 @@
+package dash
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, mpd string) *Presentation {
+	base, _ := url.Parse("https://example.com/movie/manifest.mpd")
+
+	p, err := Parse(strings.NewReader(mpd), base)
+	if err != nil {
+		t.Fatal("Error parsing mpd:", err.Error())
+	}
+
+	return p
+}
+
+func urls(segments []Segment) []string {
+	result := make([]string, len(segments))
+	for i, s := range segments {
+		result[i] = s.URL
+	}
+
+	return result
+}
+
+func TestParseTemplate(t *testing.T) {
+	p := parse(t, `<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT10.5S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4" width="1280" height="720">
+      <SegmentTemplate timescale="1000" duration="4000" startNumber="3" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/seg-$Number%05d$.m4s"/>
+      <Representation id="v720" bandwidth="3000000" codecs="avc1.64001f"/>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" mimeType="audio/mp4">
+      <BaseURL>audio/</BaseURL>
+      <Representation id="a128" bandwidth="128000">
+        <SegmentTemplate timescale="48000" initialization="init-$Bandwidth$.mp4" media="$Time$.m4s">
+          <SegmentTimeline>
+            <S t="0" d="192000" r="1"/>
+            <S d="120000"/>
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	if p.Duration != 10500*time.Millisecond || len(p.Representations) != 2 {
+		t.Fatalf("Expected 2 representations of 10.5s, but got %d of %s", len(p.Representations), p.Duration)
+	}
+
+	video := p.Tracks(Video)
+	if len(video) != 1 || video[0].Width != 1280 || video[0].Height != 720 || video[0].Codecs != "avc1.64001f" {
+		t.Fatalf("Expected the video to inherit the attributes of its adaptation set, but got %+v", video)
+	}
+
+	expected := []string{
+		"https://example.com/movie/v720/init.mp4",
+		"https://example.com/movie/v720/seg-00003.m4s",
+		"https://example.com/movie/v720/seg-00004.m4s",
+		"https://example.com/movie/v720/seg-00005.m4s",
+	}
+
+	if got := urls(video[0].Segments); strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected video segments %v, but got %v", expected, got)
+	}
+
+	if !video[0].Segments[0].Init || video[0].Segments[1].Init {
+		t.Error("Expected only the first segment to be the initialization section")
+	}
+
+	audio := p.Tracks(Audio)
+	expected = []string{
+		"https://example.com/movie/audio/init-128000.mp4",
+		"https://example.com/movie/audio/0.m4s",
+		"https://example.com/movie/audio/192000.m4s",
+		"https://example.com/movie/audio/384000.m4s",
+	}
+
+	if len(audio) != 1 || strings.Join(urls(audio[0].Segments), " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected audio segments %v, but got %v", expected, audio)
+	}
+}
+
+func TestParseTimelineRepeat(t *testing.T) {
+	p := parse(t, `<MPD type="static" mediaPresentationDuration="PT9S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate timescale="10" media="$Number$-$$.m4s">
+        <SegmentTimeline><S t="0" d="20" r="-1"/></SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="v" bandwidth="1"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	segments := p.Representations[0].Segments
+	if len(segments) != 5 || segments[4].URL != "https://example.com/movie/5-$.m4s" {
+		t.Errorf("Expected 5 segments until the end of the period, but got %v", urls(segments))
+	}
+}
+
+func TestParseList(t *testing.T) {
+	p := parse(t, `<MPD type="static" mediaPresentationDuration="PT8S">
+  <BaseURL>https://cdn.example.com/files/</BaseURL>
+  <Period>
+    <AdaptationSet mimeType="audio/webm">
+      <Representation id="a" bandwidth="64000">
+        <BaseURL>audio.webm</BaseURL>
+        <SegmentList>
+          <Initialization range="0-99"/>
+          <SegmentURL mediaRange="100-1099"/>
+          <SegmentURL mediaRange="1100-1599"/>
+        </SegmentList>
+      </Representation>
+      <Representation id="b" bandwidth="32000">
+        <BaseURL>low.webm</BaseURL>
+        <SegmentBase indexRange="100-200"><Initialization range="0-99"/></SegmentBase>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	list := p.Representations[0]
+	if list.Type != Audio || list.Extension() != ".weba" || len(list.Segments) != 3 {
+		t.Fatalf("Expected an audio track of 3 segments, but got %+v", list)
+	}
+
+	expected := []Segment{
+		{URL: "https://cdn.example.com/files/audio.webm", Offset: 0, Length: 100, Init: true},
+		{URL: "https://cdn.example.com/files/audio.webm", Offset: 100, Length: 1000},
+		{URL: "https://cdn.example.com/files/audio.webm", Offset: 1100, Length: 500},
+	}
+
+	for i, segment := range list.Segments {
+		if segment != expected[i] {
+			t.Errorf("Expected segment %d to be %+v, but got %+v", i, expected[i], segment)
+		}
+	}
+
+	base := p.Representations[1].Segments
+	if len(base) != 1 || base[0] != (Segment{URL: "https://cdn.example.com/files/low.webm", Length: -1}) {
+		t.Errorf("Expected the segment base to be downloaded as a whole file, but got %+v", base)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not xml":          "#EXTM3U",
+		"live":             `<MPD type="dynamic"><Period/></MPD>`,
+		"no period":        `<MPD type="static"/>`,
+		"no duration":      `<MPD><Period><AdaptationSet mimeType="video/mp4"><SegmentTemplate duration="4" media="$Number$.m4s"/><Representation id="v"/></AdaptationSet></Period></MPD>`,
+		"invalid range":    `<MPD mediaPresentationDuration="PT1S"><Period><AdaptationSet mimeType="video/mp4"><Representation id="v"><SegmentList><SegmentURL mediaRange="9-1"/></SegmentList></Representation></AdaptationSet></Period></MPD>`,
+		"invalid duration": `<MPD mediaPresentationDuration="10 seconds"><Period><AdaptationSet mimeType="video/mp4"><Representation id="v"/></AdaptationSet></Period></MPD>`,
+	}
+
+	base, _ := url.Parse("https://example.com/manifest.mpd")
+
+	for name, mpd := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(mpd), base); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT0S":        0,
+		"PT1H2M3.5S":  time.Hour + 2*time.Minute + 3500*time.Millisecond,
+		"P1DT12H":     36 * time.Hour,
+		"PT634.566S":  634566 * time.Millisecond,
+		"PT10M":       10 * time.Minute,
+		"P0Y0M0DT10S": -1,
+		"PT1D":        -1,
+		"T10S":        -1,
+	}
+
+	for value, expected := range tests {
+		duration, err := parseDuration(value)
+		if expected < 0 {
+			if err == nil {
+				t.Errorf("Expected %s to be invalid", value)
+			}
+
+			continue
+		}
+
+		if err != nil || duration != expected {
+			t.Errorf("Expected %s to be %s, but got %s (%v)", value, expected, duration, err)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	representations := []Representation{
+		{ID: "360", Bandwidth: 800000, Width: 640, Height: 360},
+		{ID: "1080", Bandwidth: 5000000, Width: 1920, Height: 1080},
+		{ID: "720", Bandwidth: 2800000, Width: 1280, Height: 720},
+	}
+
+	for preference, expected := range map[string]string{"": "1080", "lowest": "360", "720p": "720", "1000000": "360"} {
+		r, err := Select(representations, preference)
+		if err != nil || r.ID != expected {
+			t.Errorf("Expected %s to select %s, but got %s (%v)", preference, expected, r.ID, err)
+		}
+	}
+}
+
+func TestIsManifest(t *testing.T) {
+	if !IsManifest("application/dash+xml; charset=utf-8", "/live") || !IsManifest("", "/movie/Manifest.MPD") {
+		t.Error("Expected the mpd to be told apart by its content type or extension")
+	}
+
+	if IsManifest("application/xml", "/movie/manifest.xml") {
+		t.Error("Expected an xml file not to be an mpd")
+	}
+}
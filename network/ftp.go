@@ -0,0 +1,104 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+// FTP schemes. ftps is tls from the start of the connection, while ftpes upgrades a plain connection with AUTH TLS
+const (
+	FTP   = "ftp"
+	FTPS  = "ftps"
+	FTPES = "ftpes"
+)
+
+// IsFTP tells whether the url is downloaded over ftp, with or without tls
+func IsFTP(u *url.URL) bool {
+	switch u.Scheme {
+	case FTP, FTPS, FTPES:
+		return true
+	default:
+		return false
+	}
+}
+
+func ftpAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	if u.Scheme == FTPS {
+		return net.JoinHostPort(u.Hostname(), "990")
+	}
+
+	return net.JoinHostPort(u.Hostname(), "21")
+}
+
+// ftpLogin returns the credentials of the url, or the anonymous account if it has none
+func ftpLogin(u *url.URL) (string, string) {
+	if u.User == nil || u.User.Username() == "" {
+		return "anonymous", "anonymous"
+	}
+
+	password, _ := u.User.Password()
+	return u.User.Username(), password
+}
+
+// DialFTP connects and logs in to the ftp server of the url, with the timeouts and the tls options of the setting.
+// The proxy of the setting doesn't apply to ftp
+func DialFTP(ctx context.Context, u *url.URL, options ...Options) (*ftp.ServerConn, error) {
+	opt := &option{}
+	for _, option := range options {
+		option(opt)
+	}
+
+	if opt.setting == nil {
+		opt.setting = setting.Get()
+	}
+
+	dialOptions := []ftp.DialOption{
+		ftp.DialWithContext(ctx),
+		ftp.DialWithTimeout(seconds(opt.setting.ConnectTimeout)),
+	}
+
+	if u.Scheme == FTPS || u.Scheme == FTPES {
+		tlsConfig, err := newTLSConfig(config{
+			ca:       opt.setting.CACertificates,
+			insecure: opt.insecure,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.ServerName = u.Hostname()
+
+		dialOptions = append(dialOptions, ftpTLS(u.Scheme, tlsConfig))
+	}
+
+	conn, err := ftp.Dial(ftpAddress(u), dialOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s:%w", u.Host, err)
+	}
+
+	if err := conn.Login(ftpLogin(u)); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("error logging in to %s:%w", u.Host, err)
+	}
+
+	return conn, nil
+}
+
+func ftpTLS(scheme string, tlsConfig *tls.Config) ftp.DialOption {
+	if scheme == FTPES {
+		return ftp.DialWithExplicitTLS(tlsConfig)
+	}
+
+	return ftp.DialWithTLS(tlsConfig)
+}
@@ -0,0 +1,393 @@
+// Package ftptest provides an in-process ftp server for the tests of the ftp downloads, like httptest does for http
+package ftptest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	file struct {
+		content  []byte
+		modified time.Time
+	}
+
+	// Server serves the files in passive mode to the anonymous account, or to a single account if it has a login
+	Server struct {
+		URL      string
+		listener net.Listener
+		tls      *tls.Config
+		user     string
+		password string
+		maxConns int
+		noRest   bool
+		mutex    sync.Mutex
+		files    map[string]file
+		conns    map[net.Conn]struct{}
+		offsets  []int64
+		wg       sync.WaitGroup
+	}
+
+	Options func(s *Server)
+
+	session struct {
+		server *Server
+		conn   net.Conn
+		reader *bufio.Reader
+		user   string
+		authed bool
+		offset int64
+		data   net.Listener
+	}
+)
+
+// WithLogin only accepts the user with the password instead of the anonymous account
+func WithLogin(user, password string) Options {
+	return func(s *Server) {
+		s.user = user
+		s.password = password
+	}
+}
+
+// MaxConnections refuses the connections above n at a time, as many servers do per client
+func MaxConnections(n int) Options {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// WithoutRest refuses REST, so a transfer can only start from the beginning of the file
+func WithoutRest() Options {
+	return func(s *Server) {
+		s.noRest = true
+	}
+}
+
+// UseTLS serves implicit tls with a self-signed certificate, on both the control and the data connections
+func UseTLS() Options {
+	return func(s *Server) {
+		s.tls = &tls.Config{Certificates: []tls.Certificate{certificate()}}
+	}
+}
+
+// NewServer starts serving the files, keyed by their path, on a local port
+func NewServer(files map[string][]byte, options ...Options) *Server {
+	s := &Server{
+		files: make(map[string]file),
+		conns: make(map[net.Conn]struct{}),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	for name, content := range files {
+		s.Put(name, content)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: error listening:%s", err.Error()))
+	}
+
+	scheme := "ftp"
+	if s.tls != nil {
+		listener = tls.NewListener(listener, s.tls)
+		scheme = "ftps"
+	}
+
+	s.listener = listener
+	s.URL = fmt.Sprintf("%s://%s", scheme, listener.Addr().String())
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// Put replaces the file, which changes its modification time
+func (s *Server) Put(name string, content []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	modified := time.Now().UTC().Truncate(time.Second)
+	if old, ok := s.files[clean(name)]; ok && !modified.After(old.modified) {
+		modified = old.modified.Add(time.Second)
+	}
+
+	s.files[clean(name)] = file{content: content, modified: modified}
+}
+
+// Offsets returns the offsets of the files retrieved so far, in order
+func (s *Server) Offsets() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]int64(nil), s.offsets...)
+}
+
+// Close stops the server and drops the open connections
+func (s *Server) Close() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	s.mutex.Lock()
+	if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+		s.mutex.Unlock()
+		fmt.Fprintf(conn, "421 Too many connections\r\n")
+		return
+	}
+
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	ss := &session{server: s, conn: conn, reader: bufio.NewReader(conn)}
+	defer ss.closeData()
+
+	ss.reply(220, "ftptest ready")
+
+	for {
+		line, err := ss.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		if !ss.exec(strings.ToUpper(command), arg) {
+			return
+		}
+	}
+}
+
+func (ss *session) reply(code int, message string) {
+	fmt.Fprintf(ss.conn, "%d %s\r\n", code, message)
+}
+
+// exec runs the command, and returns false once the session is over
+func (ss *session) exec(command, arg string) bool {
+	s := ss.server
+
+	switch command {
+	case "USER":
+		ss.user = arg
+		ss.reply(331, "password required")
+	case "PASS":
+		anonymous := s.user == "" && (ss.user == "anonymous" || ss.user == "ftp")
+		if !anonymous && (ss.user != s.user || arg != s.password) {
+			ss.reply(530, "login incorrect")
+			break
+		}
+
+		ss.authed = true
+		ss.reply(230, "logged in")
+	case "FEAT":
+		features := "211-Features:\r\n SIZE\r\n MDTM\r\n"
+		if !s.noRest {
+			features += " REST STREAM\r\n"
+		}
+
+		fmt.Fprintf(ss.conn, "%s211 End\r\n", features)
+	case "QUIT":
+		ss.reply(221, "bye")
+		return false
+	case "NOOP", "PBSZ", "PROT", "TYPE", "OPTS":
+		ss.reply(200, "ok")
+	case "PWD":
+		ss.reply(257, `"/"`)
+	default:
+		if !ss.authed {
+			ss.reply(530, "not logged in")
+			break
+		}
+
+		ss.transfer(command, arg)
+	}
+
+	return true
+}
+
+func (ss *session) transfer(command, arg string) {
+	s := ss.server
+
+	switch command {
+	case "SIZE", "MDTM":
+		s.mutex.Lock()
+		f, ok := s.files[clean(arg)]
+		s.mutex.Unlock()
+
+		if !ok {
+			ss.reply(550, "file not found")
+		} else if command == "SIZE" {
+			ss.reply(213, strconv.Itoa(len(f.content)))
+		} else {
+			ss.reply(213, f.modified.Format("20060102150405"))
+		}
+	case "EPSV", "PASV":
+		ss.closeData()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			ss.reply(425, "can't open data connection")
+			break
+		}
+
+		if s.tls != nil {
+			listener = tls.NewListener(listener, s.tls)
+		}
+
+		ss.data = listener
+		port := listener.Addr().(*net.TCPAddr).Port
+
+		if command == "EPSV" {
+			ss.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		} else {
+			ss.reply(227, fmt.Sprintf("Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256))
+		}
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if s.noRest {
+			ss.reply(502, "REST not implemented")
+		} else if err != nil || offset < 0 {
+			ss.reply(501, "invalid offset")
+		} else {
+			ss.offset = offset
+			ss.reply(350, "restarting")
+		}
+	case "RETR":
+		ss.retrieve(arg)
+	default:
+		ss.reply(502, "command not implemented")
+	}
+}
+
+func (ss *session) retrieve(name string) {
+	s := ss.server
+
+	offset := ss.offset
+	ss.offset = 0
+
+	s.mutex.Lock()
+	f, ok := s.files[clean(name)]
+	s.mutex.Unlock()
+
+	if !ok {
+		ss.reply(550, "file not found")
+		return
+	}
+
+	if ss.data == nil {
+		ss.reply(425, "use EPSV or PASV first")
+		return
+	}
+
+	if offset > int64(len(f.content)) {
+		ss.reply(554, "invalid offset")
+		return
+	}
+
+	s.mutex.Lock()
+	s.offsets = append(s.offsets, offset)
+	s.mutex.Unlock()
+
+	ss.reply(150, "opening data connection")
+
+	data, err := ss.data.Accept()
+	ss.closeData()
+
+	if err != nil {
+		ss.reply(425, "can't open data connection")
+		return
+	}
+
+	_, err = io.Copy(data, strings.NewReader(string(f.content[offset:])))
+	data.Close()
+
+	if err != nil {
+		ss.reply(426, "transfer aborted")
+		return
+	}
+
+	ss.reply(226, "transfer complete")
+}
+
+func (ss *session) closeData() {
+	if ss.data != nil {
+		ss.data.Close()
+		ss.data = nil
+	}
+}
+
+func clean(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
+
+func certificate() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: error generating key:%s", err.Error()))
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"ftptest"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: error creating certificate:%s", err.Error()))
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,390 @@
+// Package hls parses the master and media playlists of http live streaming, as described by rfc 8216
+package hls
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Playlist is either a master playlist, which lists the variants of the stream, or a media playlist, which lists its segments
+	Playlist struct {
+		Variants []Variant
+		Segments []Segment
+		Ended    bool // a playlist without an end is live, so more segments may be listed later
+	}
+
+	// Variant is a media playlist of the stream in one quality
+	Variant struct {
+		URL       string
+		Bandwidth int64 // bits per second
+		Width     int
+		Height    int
+	}
+
+	Segment struct {
+		URL      string
+		Duration float64 // seconds
+		Sequence int64
+		Key      *Key  // nil if the segment isn't encrypted
+		Offset   int64 // where the segment starts in the resource of its url
+		Length   int64 // -1 if the segment is the whole resource
+		Init     bool  // the initialization section of the segments after it, e.g the header of fragmented mp4
+	}
+
+	Key struct {
+		Method string
+		URL    string
+		IV     []byte // nil if the iv is derived from the sequence of the segment
+	}
+)
+
+// encryption methods of the segments
+const (
+	MethodNone      = "NONE"
+	MethodAES128    = "AES-128"
+	MethodSampleAES = "SAMPLE-AES"
+)
+
+var mimeTypes = map[string]bool{
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"audio/mpegurl":                 true,
+	"audio/x-mpegurl":               true,
+}
+
+// IsPlaylist tells whether the resource is a playlist, judging by its content type or, if it has none, the extension of its path
+func IsPlaylist(contentType, p string) bool {
+	if media, _, err := mime.ParseMediaType(contentType); err == nil && mimeTypes[strings.ToLower(media)] {
+		return true
+	}
+
+	return strings.EqualFold(path.Ext(p), ".m3u8")
+}
+
+// Master tells whether the playlist lists variants instead of segments
+func (p *Playlist) Master() bool {
+	return len(p.Variants) > 0
+}
+
+// IV returns the initialization vector of the segment, which is its sequence number unless the key has one
+func (s Segment) IV() []byte {
+	if s.Key != nil && s.Key.IV != nil {
+		return s.Key.IV
+	}
+
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], uint64(s.Sequence))
+
+	return iv
+}
+
+// Parse reads the playlist. The urls in it are resolved against the base, which is the url the playlist is served from
+func Parse(r io.Reader, base *url.URL) (*Playlist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() || strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff")) != "#EXTM3U" {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading playlist:%w", err)
+		}
+
+		return nil, fmt.Errorf("error parsing playlist:missing #EXTM3U header")
+	}
+
+	p := &Playlist{
+		Variants: make([]Variant, 0),
+		Segments: make([]Segment, 0),
+	}
+
+	var (
+		variant  *Variant
+		key      *Key
+		section  *Segment
+		sequence int64
+		duration float64
+		length   int64 = -1
+		offset   int64
+		next     = map[string]int64{} // where the next byte range without offset starts, by url
+		last     string
+	)
+
+	resolve := func(ref string) (string, error) {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("error parsing playlist:invalid url %s", ref)
+		}
+
+		return base.ResolveReference(u).String(), nil
+	}
+
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		tag, value, _ := strings.Cut(text, ":")
+
+		switch {
+		case tag == "#EXT-X-STREAM-INF":
+			attrs := attributes(value)
+			variant = &Variant{}
+			variant.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+
+			if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+				variant.Width, _ = strconv.Atoi(w)
+				variant.Height, _ = strconv.Atoi(h)
+			}
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing playlist:invalid media sequence %s", value)
+			}
+
+			sequence = n
+		case tag == "#EXTINF":
+			d, _, _ := strings.Cut(value, ",")
+			n, err := strconv.ParseFloat(d, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing playlist:invalid duration %s", d)
+			}
+
+			duration = n
+		case tag == "#EXT-X-BYTERANGE":
+			n, o, err := byteRange(value)
+			if err != nil {
+				return nil, err
+			}
+
+			length, offset = n, o
+		case tag == "#EXT-X-KEY":
+			attrs := attributes(value)
+			if attrs["METHOD"] == MethodNone {
+				key = nil
+				continue
+			}
+
+			uri, err := resolve(attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+
+			key = &Key{Method: attrs["METHOD"], URL: uri}
+			if iv := attrs["IV"]; iv != "" {
+				decoded, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+				if err != nil || len(decoded) != 16 {
+					return nil, fmt.Errorf("error parsing playlist:invalid iv %s", iv)
+				}
+
+				key.IV = decoded
+			}
+		case tag == "#EXT-X-MAP":
+			attrs := attributes(value)
+			uri, err := resolve(attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+
+			section = &Segment{URL: uri, Length: -1, Key: key, Init: true}
+			if r := attrs["BYTERANGE"]; r != "" {
+				n, o, err := byteRange(r)
+				if err != nil {
+					return nil, err
+				}
+
+				section.Length, section.Offset = n, o
+				if section.Offset < 0 {
+					section.Offset = 0
+				}
+			}
+		case tag == "#EXT-X-ENDLIST":
+			p.Ended = true
+		case strings.HasPrefix(text, "#"):
+			// comments and the tags which don't change what is downloaded
+		default:
+			uri, err := resolve(text)
+			if err != nil {
+				return nil, err
+			}
+
+			if variant != nil {
+				variant.URL = uri
+				p.Variants = append(p.Variants, *variant)
+				variant = nil
+				continue
+			}
+
+			// the initialization section is only downloaded again once it changes
+			if section != nil {
+				if last != section.URL+strconv.FormatInt(section.Offset, 10) {
+					last = section.URL + strconv.FormatInt(section.Offset, 10)
+					p.Segments = append(p.Segments, *section)
+				}
+
+				section = nil
+			}
+
+			segment := Segment{URL: uri, Duration: duration, Sequence: sequence, Key: key, Length: length}
+			if length >= 0 {
+				segment.Offset = offset
+				if offset < 0 {
+					segment.Offset = next[uri]
+				}
+
+				next[uri] = segment.Offset + length
+			}
+
+			p.Segments = append(p.Segments, segment)
+
+			sequence++
+			duration = 0
+			length = -1
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading playlist:%w", err)
+	}
+
+	return p, nil
+}
+
+// byteRange parses <length>[@<offset>]. The offset is -1 if the range continues from the previous one
+func byteRange(value string) (int64, int64, error) {
+	l, o, hasOffset := strings.Cut(value, "@")
+
+	length, err := strconv.ParseInt(l, 10, 64)
+	if err != nil || length < 0 {
+		return 0, 0, fmt.Errorf("error parsing playlist:invalid byte range %s", value)
+	}
+
+	if !hasOffset {
+		return length, -1, nil
+	}
+
+	offset, err := strconv.ParseInt(o, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("error parsing playlist:invalid byte range %s", value)
+	}
+
+	return length, offset, nil
+}
+
+// attributes parses the attribute list of a tag. Quoted values may contain commas
+func attributes(value string) map[string]string {
+	attrs := make(map[string]string)
+
+	for value != "" {
+		name, rest, ok := strings.Cut(value, "=")
+		if !ok {
+			break
+		}
+
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				end = len(rest) - 1
+			}
+
+			val = rest[1 : end+1]
+			rest = rest[end+1:]
+			if rest != "" {
+				rest = rest[1:]
+			}
+		} else {
+			val, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+
+		attrs[strings.ToUpper(strings.TrimSpace(name))] = strings.TrimSpace(val)
+		value = strings.TrimPrefix(strings.TrimLeft(rest, " "), ",")
+	}
+
+	return attrs
+}
+
+// Select picks the variant by the preference, which is one of:
+//   - highest or empty, for the highest bandwidth
+//   - lowest, for the lowest bandwidth
+//   - a height like 720p, or a resolution like 1280x720, for the largest variant which fits into it
+//   - a bandwidth in bits per second, for the highest bandwidth that doesn't exceed it
+//
+// The lowest variant is picked when none fits into the preference
+func Select(variants []Variant, preference string) (Variant, error) {
+	if len(variants) == 0 {
+		return Variant{}, fmt.Errorf("playlist has no variant")
+	}
+
+	sorted := append([]Variant{}, variants...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Height != sorted[j].Height {
+			return sorted[i].Height < sorted[j].Height
+		}
+
+		return sorted[i].Bandwidth < sorted[j].Bandwidth
+	})
+
+	byBandwidth := append([]Variant{}, variants...)
+	sort.SliceStable(byBandwidth, func(i, j int) bool {
+		return byBandwidth[i].Bandwidth < byBandwidth[j].Bandwidth
+	})
+
+	fits := func(candidates []Variant, fit func(v Variant) bool) Variant {
+		best := candidates[0]
+		for _, v := range candidates {
+			if fit(v) {
+				best = v
+			}
+		}
+
+		return best
+	}
+
+	preference = strings.ToLower(strings.TrimSpace(preference))
+
+	switch {
+	case preference == "" || preference == "highest":
+		return byBandwidth[len(byBandwidth)-1], nil
+	case preference == "lowest":
+		return byBandwidth[0], nil
+	case strings.HasSuffix(preference, "p"):
+		height, err := strconv.Atoi(strings.TrimSuffix(preference, "p"))
+		if err != nil {
+			return Variant{}, fmt.Errorf("invalid variant %s", preference)
+		}
+
+		return fits(sorted, func(v Variant) bool { return v.Height <= height }), nil
+	case strings.Contains(preference, "x"):
+		w, h, _ := strings.Cut(preference, "x")
+
+		width, err := strconv.Atoi(w)
+		if err != nil {
+			return Variant{}, fmt.Errorf("invalid variant %s", preference)
+		}
+
+		height, err := strconv.Atoi(h)
+		if err != nil {
+			return Variant{}, fmt.Errorf("invalid variant %s", preference)
+		}
+
+		return fits(sorted, func(v Variant) bool { return v.Width <= width && v.Height <= height }), nil
+	default:
+		bandwidth, err := strconv.ParseInt(preference, 10, 64)
+		if err != nil {
+			return Variant{}, fmt.Errorf("invalid variant %s", preference)
+		}
+
+		return fits(byBandwidth, func(v Variant) bool { return v.Bandwidth <= bandwidth }), nil
+	}
+}
@@ -0,0 +1,194 @@
+package hls
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseMaster(t *testing.T) {
+	master := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2"
+/streams/mid/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080
+https://cdn.example.com/high/index.m3u8
+`
+
+	base, _ := url.Parse("https://example.com/video/master.m3u8?token=abc")
+
+	p, err := Parse(strings.NewReader(master), base)
+	if err != nil {
+		t.Fatal("Error parsing playlist:", err.Error())
+	}
+
+	if !p.Master() || len(p.Variants) != 3 {
+		t.Fatalf("Expected a master playlist of 3 variants, but got %d", len(p.Variants))
+	}
+
+	expected := []Variant{
+		{URL: "https://example.com/video/low/index.m3u8", Bandwidth: 800000, Width: 640, Height: 360},
+		{URL: "https://example.com/streams/mid/index.m3u8", Bandwidth: 2800000, Width: 1280, Height: 720},
+		{URL: "https://cdn.example.com/high/index.m3u8", Bandwidth: 5000000, Width: 1920, Height: 1080},
+	}
+
+	for i, variant := range p.Variants {
+		if variant != expected[i] {
+			t.Errorf("Expected variant %d to be %+v, but got %+v", i, expected[i], variant)
+		}
+	}
+}
+
+func TestParseMedia(t *testing.T) {
+	media := "\ufeff" + `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.0,
+seg7.m4s
+#EXT-X-KEY:METHOD=AES-128,URI="keys/1.key",IV=0x000102030405060708090a0b0c0d0e0f
+#EXTINF:4.0,
+seg8.m4s
+#EXT-X-KEY:METHOD=NONE
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-BYTERANGE:1000@0
+#EXTINF:3.5,title
+all.m4s
+#EXT-X-BYTERANGE:500
+#EXTINF:2.5,
+all.m4s
+#EXT-X-ENDLIST
+`
+
+	base, _ := url.Parse("https://example.com/video/720/index.m3u8")
+
+	p, err := Parse(strings.NewReader(media), base)
+	if err != nil {
+		t.Fatal("Error parsing playlist:", err.Error())
+	}
+
+	if p.Master() || !p.Ended || len(p.Segments) != 5 {
+		t.Fatalf("Expected an ended media playlist of 5 segments, but got %d", len(p.Segments))
+	}
+
+	init := p.Segments[0]
+	if !init.Init || init.URL != "https://example.com/video/720/init.mp4" || init.Length != -1 {
+		t.Errorf("Expected the initialization section first, but got %+v", init)
+	}
+
+	if s := p.Segments[1]; s.Sequence != 7 || s.Key != nil || s.Duration != 4 {
+		t.Errorf("Expected the unencrypted segment 7, but got %+v", s)
+	}
+
+	key := p.Segments[2].Key
+	if key == nil || key.Method != MethodAES128 || key.URL != "https://example.com/video/720/keys/1.key" {
+		t.Fatalf("Expected segment 8 to be encrypted, but got %+v", key)
+	}
+
+	if iv := p.Segments[2].IV(); iv[0] != 0 || iv[15] != 0x0f {
+		t.Errorf("Expected the iv of the key, but got %x", iv)
+	}
+
+	if s := p.Segments[3]; s.Key != nil || s.Offset != 0 || s.Length != 1000 || s.Sequence != 9 {
+		t.Errorf("Expected the first byte range of all.m4s, but got %+v", s)
+	}
+
+	if s := p.Segments[4]; s.Offset != 1000 || s.Length != 500 {
+		t.Errorf("Expected the second byte range to continue from the first one, but got %+v", s)
+	}
+}
+
+func TestSegmentIV(t *testing.T) {
+	s := Segment{Sequence: 258, Key: &Key{Method: MethodAES128}}
+
+	expected := make([]byte, 16)
+	expected[14], expected[15] = 1, 2
+
+	if !bytes.Equal(s.IV(), expected) {
+		t.Errorf("Expected the iv to be the sequence number, but got %x", s.IV())
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing header":   "#EXTINF:4,\nseg.ts\n",
+		"invalid duration": "#EXTM3U\n#EXTINF:four,\nseg.ts\n",
+		"invalid range":    "#EXTM3U\n#EXT-X-BYTERANGE:abc\n#EXTINF:4,\nseg.ts\n",
+		"invalid iv":       "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0x0102\n#EXTINF:4,\nseg.ts\n",
+	}
+
+	base, _ := url.Parse("https://example.com/index.m3u8")
+
+	for name, playlist := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(playlist), base); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	variants := []Variant{
+		{URL: "720", Bandwidth: 2800000, Width: 1280, Height: 720},
+		{URL: "360", Bandwidth: 800000, Width: 640, Height: 360},
+		{URL: "1080", Bandwidth: 5000000, Width: 1920, Height: 1080},
+		{URL: "720-high", Bandwidth: 3500000, Width: 1280, Height: 720},
+	}
+
+	tests := []struct {
+		preference string
+		expected   string
+	}{
+		{preference: "", expected: "1080"},
+		{preference: "highest", expected: "1080"},
+		{preference: "lowest", expected: "360"},
+		{preference: "720p", expected: "720-high"},
+		{preference: "900p", expected: "720-high"},
+		{preference: "240p", expected: "360"},
+		{preference: "1280x720", expected: "720-high"},
+		{preference: "1000x1080", expected: "360"},
+		{preference: "3000000", expected: "720"},
+		{preference: "100", expected: "360"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.preference, func(t *testing.T) {
+			variant, err := Select(variants, test.preference)
+			if err != nil {
+				t.Fatal("Error selecting variant:", err.Error())
+			}
+
+			if variant.URL != test.expected {
+				t.Errorf("Expected variant %s, but got %s", test.expected, variant.URL)
+			}
+		})
+	}
+
+	if _, err := Select(variants, "best"); err == nil {
+		t.Error("Expected an invalid preference to fail")
+	}
+}
+
+func TestIsPlaylist(t *testing.T) {
+	tests := []struct {
+		contentType string
+		path        string
+		expected    bool
+	}{
+		{contentType: "application/vnd.apple.mpegurl", path: "/live", expected: true},
+		{contentType: "audio/x-mpegURL; charset=utf-8", path: "/live", expected: true},
+		{path: "/video/Index.M3U8", expected: true},
+		{contentType: "video/mp2t", path: "/video/seg.ts"},
+		{contentType: "application/octet-stream", path: "/playlist.m3u"},
+	}
+
+	for _, test := range tests {
+		if result := IsPlaylist(test.contentType, test.path); result != test.expected {
+			t.Errorf("Expected IsPlaylist(%q, %q) to be %v, but got %v", test.contentType, test.path, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// Package sftptest provides an in-process ssh server with the sftp subsystem for the tests of the sftp downloads, like httptest does for http
+package sftptest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+type (
+	file struct {
+		content  []byte
+		modified time.Time
+	}
+
+	// Server serves the files read-only. It accepts anyone unless it has an authorized key or a password
+	Server struct {
+		URL      string
+		listener net.Listener
+		config   *ssh.ServerConfig
+		hostKey  ssh.Signer
+		keys     []ssh.PublicKey
+		user     string
+		password string
+		mutex    sync.Mutex
+		files    map[string]file
+		conns    map[net.Conn]struct{}
+		offsets  []int64
+		maxRead  int
+		wg       sync.WaitGroup
+	}
+
+	Options func(s *Server)
+
+	// handlers serve the files read-only over the sftp request server
+	handlers struct {
+		server *Server
+	}
+
+	// reader reads an opened file. It records the offset of its first read, and returns at most maxRead bytes at once
+	reader struct {
+		server  *Server
+		content []byte
+		once    sync.Once
+	}
+
+	fileInfo struct {
+		name string
+		file file
+	}
+
+	lister []os.FileInfo
+)
+
+// WithAuthorizedKey accepts the key. The server only accepts its authorized keys and its password once it has any
+func WithAuthorizedKey(key ssh.PublicKey) Options {
+	return func(s *Server) {
+		s.keys = append(s.keys, key)
+	}
+}
+
+// WithPassword accepts the user with the password
+func WithPassword(user, password string) Options {
+	return func(s *Server) {
+		s.user = user
+		s.password = password
+	}
+}
+
+// WithMaxRead replies to every read with at most n bytes, like a server that sends the file in smaller packets than asked for
+func WithMaxRead(n int) Options {
+	return func(s *Server) {
+		s.maxRead = n
+	}
+}
+
+// NewServer starts serving the files, keyed by their path, on a local port with a new host key
+func NewServer(files map[string][]byte, options ...Options) *Server {
+	s := &Server{
+		files: make(map[string]file),
+		conns: make(map[net.Conn]struct{}),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	for name, content := range files {
+		s.Put(name, content)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("sftptest: error generating host key:%s", err.Error()))
+	}
+
+	if s.hostKey, err = ssh.NewSignerFromKey(key); err != nil {
+		panic(fmt.Sprintf("sftptest: error creating host key:%s", err.Error()))
+	}
+
+	s.config = &ssh.ServerConfig{
+		NoClientAuth:      len(s.keys) == 0 && s.user == "",
+		PublicKeyCallback: s.publicKey,
+		PasswordCallback:  s.passwordCallback,
+	}
+
+	s.config.AddHostKey(s.hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("sftptest: error listening:%s", err.Error()))
+	}
+
+	s.listener = listener
+	s.URL = "sftp://" + listener.Addr().String()
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// HostKey returns the public key the server identifies with, e.g for a known_hosts file
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// Addr returns the host and the port of the server
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Put replaces the file, which changes its modification time
+func (s *Server) Put(name string, content []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	modified := time.Now().UTC().Truncate(time.Second)
+	if old, ok := s.files[clean(name)]; ok && !modified.After(old.modified) {
+		modified = old.modified.Add(time.Second)
+	}
+
+	s.files[clean(name)] = file{content: content, modified: modified}
+}
+
+// Remove deletes the file, as if it were taken down from the server
+func (s *Server) Remove(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.files, clean(name))
+}
+
+// Offsets returns the offsets of the first read of every opened file so far, in order
+func (s *Server) Offsets() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]int64(nil), s.offsets...)
+}
+
+// Close stops the server and drops the open connections
+func (s *Server) Close() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) publicKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	for _, authorized := range s.keys {
+		if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+			return nil, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key for %s", meta.User())
+}
+
+func (s *Server) passwordCallback(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if s.user != "" && meta.User() == s.user && string(password) == s.password {
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("wrong password for %s", meta.User())
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer channel.Close()
+
+			for request := range requests {
+				subsystem := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(subsystem, nil)
+
+				if subsystem {
+					go ssh.DiscardRequests(requests)
+
+					server := sftp.NewRequestServer(channel, sftp.Handlers{
+						FileGet:  handlers{s},
+						FilePut:  handlers{s},
+						FileCmd:  handlers{s},
+						FileList: handlers{s},
+					})
+
+					server.Serve()
+					server.Close()
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (s *Server) lookup(name string) (file, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, ok := s.files[clean(name)]
+	return f, ok
+}
+
+func (h handlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, ok := h.server.lookup(r.Filepath)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return &reader{server: h.server, content: f.content}, nil
+}
+
+func (h handlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return nil, os.ErrPermission
+}
+
+func (h handlers) Filecmd(r *sftp.Request) error {
+	return os.ErrPermission
+}
+
+func (h handlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	if r.Method != "Stat" {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+
+	f, ok := h.server.lookup(r.Filepath)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return lister{&fileInfo{name: r.Filepath, file: f}}, nil
+}
+
+func (r *reader) ReadAt(payload []byte, offset int64) (int, error) {
+	r.once.Do(func() {
+		r.server.mutex.Lock()
+		r.server.offsets = append(r.server.offsets, offset)
+		r.server.mutex.Unlock()
+	})
+
+	if offset >= int64(len(r.content)) {
+		return 0, io.EOF
+	}
+
+	limit := payload
+	if r.server.maxRead > 0 && len(limit) > r.server.maxRead {
+		limit = limit[:r.server.maxRead]
+	}
+
+	// a short read is sent as it is, the request server only replies with the end of the file when nothing is read
+	n := copy(limit, r.content[offset:])
+	if n < len(payload) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (l lister) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *fileInfo) Name() string       { return f.name[strings.LastIndex(f.name, "/")+1:] }
+func (f *fileInfo) Size() int64        { return int64(len(f.file.content)) }
+func (f *fileInfo) Mode() os.FileMode  { return 0644 }
+func (f *fileInfo) ModTime() time.Time { return f.file.modified }
+func (f *fileInfo) IsDir() bool        { return false }
+func (f *fileInfo) Sys() interface{}   { return nil }
+
+func clean(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
@@ -0,0 +1,242 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rapid-downloader/rapid/setting"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP schemes. scp urls are downloaded over sftp as well, since scp can't start from an offset
+const (
+	SFTP = "sftp"
+	SCP  = "scp"
+)
+
+var (
+	// ErrSSHAuth is returned when none of the keys, the agent or the password of the url is accepted
+	ErrSSHAuth = errors.New("ssh authentication failed")
+	// ErrHostKey is returned when the host key is unknown or doesn't match the known hosts
+	ErrHostKey = errors.New("ssh host key verification failed")
+)
+
+// IsSFTP tells whether the url is downloaded over sftp
+func IsSFTP(u *url.URL) bool {
+	return u.Scheme == SFTP || u.Scheme == SCP
+}
+
+// SFTPPath returns the path of the file on the server. A path starting with /~/ is relative to the home folder
+func SFTPPath(u *url.URL) string {
+	if strings.HasPrefix(u.Path, "/~/") {
+		return u.Path[len("/~/"):]
+	}
+
+	return u.Path
+}
+
+func sshAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return net.JoinHostPort(u.Hostname(), "22")
+}
+
+func sshUser(u *url.URL) string {
+	if u.User != nil && u.User.Username() != "" {
+		return u.User.Username()
+	}
+
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// sshSigners returns the key of the setting, or the default keys of the user if there is none. Keys with a passphrase are skipped
+func sshSigners(s *setting.Setting) ([]ssh.Signer, error) {
+	paths := []string{s.SSHKey}
+	if s.SSHKey == "" {
+		home, _ := os.UserHomeDir()
+		paths = []string{
+			filepath.Join(home, ".ssh", "id_ed25519"),
+			filepath.Join(home, ".ssh", "id_ecdsa"),
+			filepath.Join(home, ".ssh", "id_rsa"),
+		}
+	}
+
+	signers := make([]ssh.Signer, 0)
+	for _, path := range paths {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			if s.SSHKey != "" {
+				return nil, fmt.Errorf("error reading ssh key:%s", err.Error())
+			}
+
+			continue
+		}
+
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			if s.SSHKey != "" {
+				return nil, fmt.Errorf("error parsing ssh key %s:%s", path, err.Error())
+			}
+
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// sshAuth offers the keys and the keys of the agent, then the password of the url. The returned function closes the agent
+func sshAuth(u *url.URL, s *setting.Setting) ([]ssh.AuthMethod, func(), error) {
+	signers, err := sshSigners(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeAgent := func() {}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+
+			closeAgent = func() { conn.Close() }
+		}
+	}
+
+	methods := make([]ssh.AuthMethod, 0)
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			methods = append(methods, ssh.Password(password))
+		}
+	}
+
+	return methods, closeAgent, nil
+}
+
+func knownHosts(s *setting.Setting) (ssh.HostKeyCallback, error) {
+	path := s.KnownHosts
+	if path == "" {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known hosts:%s", err.Error())
+	}
+
+	return callback, nil
+}
+
+// DialSSH connects and authenticates to the ssh server of the url with the keys of the setting or of the agent, or the
+// password of the url. The host key is checked against the known hosts, unless the verification is skipped
+func DialSSH(ctx context.Context, u *url.URL, options ...Options) (*ssh.Client, error) {
+	opt := &option{}
+	for _, option := range options {
+		option(opt)
+	}
+
+	if opt.setting == nil {
+		opt.setting = setting.Get()
+	}
+
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if !opt.insecure {
+		callback, err := knownHosts(opt.setting)
+		if err != nil {
+			return nil, err
+		}
+
+		hostKey = callback
+	}
+
+	methods, closeAgent, err := sshAuth(u, opt.setting)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeAgent()
+
+	timeout := seconds(opt.setting.ConnectTimeout)
+	dialer := &net.Dialer{Timeout: timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", sshAddress(u))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s:%w", u.Host, err)
+	}
+
+	// the handshake doesn't take the context, so the connection is closed once the context is done
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	config := &ssh.ClientConfig{
+		User:            sshUser(u),
+		Auth:            methods,
+		HostKeyCallback: hostKey,
+		Timeout:         timeout,
+	}
+
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, sshAddress(u), config)
+	if err != nil {
+		conn.Close()
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, sshError(u, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+func sshError(u *url.URL, err error) error {
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+
+	switch {
+	case errors.As(err, &keyErr) || errors.As(err, &revokedErr):
+		return fmt.Errorf("%w for %s:%s", ErrHostKey, u.Host, err.Error())
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		return fmt.Errorf("%w for %s:%s", ErrSSHAuth, u.Host, err.Error())
+	default:
+		return fmt.Errorf("error connecting to %s:%w", u.Host, err)
+	}
+}
@@ -0,0 +1,37 @@
+package network
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSFTPAddressAndPath(t *testing.T) {
+	tests := []struct {
+		url     string
+		address string
+		path    string
+	}{
+		{"sftp://example.com/builds/app.tar.gz", "example.com:22", "/builds/app.tar.gz"},
+		{"sftp://builder@example.com:2222/~/app.tar.gz", "example.com:2222", "app.tar.gz"},
+		{"scp://example.com/~/builds/app.tar.gz", "example.com:22", "builds/app.tar.gz"},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal("Error parsing url:", err.Error())
+		}
+
+		if !IsSFTP(u) {
+			t.Errorf("Expected %s to be an sftp url", test.url)
+		}
+
+		if address := sshAddress(u); address != test.address {
+			t.Errorf("Expected address %s for %s, but got %s", test.address, test.url, address)
+		}
+
+		if path := SFTPPath(u); path != test.path {
+			t.Errorf("Expected path %s for %s, but got %s", test.path, test.url, path)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// maxDepth limits the nesting of lists and dictionaries, so a malicious value can't exhaust the stack
+const maxDepth = 64
+
+// Encode encodes the value in bencode. It accepts integers, strings, byte slices, lists and dictionaries of string keys,
+// which are written in the order of their keys
+func Encode(v interface{}) []byte {
+	var buf bytes.Buffer
+	encode(&buf, v)
+
+	return buf.Bytes()
+}
+
+func encode(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case int:
+		fmt.Fprintf(buf, "i%de", value)
+	case int64:
+		fmt.Fprintf(buf, "i%de", value)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(value), value)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(value))
+		buf.Write(value)
+	case []string:
+		buf.WriteByte('l')
+		for _, item := range value {
+			encode(buf, item)
+		}
+		buf.WriteByte('e')
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range value {
+			encode(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, key := range keys {
+			encode(buf, key)
+			encode(buf, value[key])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode can't encode %T", v))
+	}
+}
+
+// Decode decodes the bencoded value. Integers are decoded as int64, strings as string, lists as []interface{}, and
+// dictionaries as map[string]interface{}
+func Decode(data []byte) (interface{}, error) {
+	v, n, err := decodePrefix(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if n != len(data) {
+		return nil, fmt.Errorf("error decoding bencode:%d bytes after the value", len(data)-n)
+	}
+
+	return v, nil
+}
+
+// decodePrefix decodes the value at the start of the data, and returns how many bytes it takes
+func decodePrefix(data []byte) (interface{}, int, error) {
+	d := &decoder{data: data}
+
+	v, err := d.value()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error decoding bencode:%s", err.Error())
+	}
+
+	return v, d.pos, nil
+}
+
+// rawField returns the bencoded value of the key of the dictionary as it is in the data, e.g the info of a metainfo,
+// which is hashed as it is
+func rawField(data []byte, key string) ([]byte, error) {
+	d := &decoder{data: data}
+
+	if d.peek() != 'd' {
+		return nil, fmt.Errorf("error decoding bencode:not a dictionary")
+	}
+
+	d.pos++
+
+	for d.peek() != 'e' {
+		k, err := d.str()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding bencode:%s", err.Error())
+		}
+
+		start := d.pos
+		if _, err := d.value(); err != nil {
+			return nil, fmt.Errorf("error decoding bencode:%s", err.Error())
+		}
+
+		if k == key {
+			return data[start:d.pos], nil
+		}
+	}
+
+	return nil, fmt.Errorf("error decoding bencode:%s is not found", key)
+}
+
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// peek returns the next byte, or zero at the end of the data
+func (d *decoder) peek() byte {
+	if d.pos >= len(d.data) {
+		return 0
+	}
+
+	return d.data[d.pos]
+}
+
+func (d *decoder) value() (interface{}, error) {
+	switch c := d.peek(); {
+	case c == 'i':
+		return d.integer()
+	case c >= '0' && c <= '9':
+		return d.str()
+	case c == 'l':
+		return d.list()
+	case c == 'd':
+		return d.dict()
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of data")
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", c, d.pos)
+	}
+}
+
+func (d *decoder) integer() (int64, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 'e')
+	if end < 0 {
+		return 0, fmt.Errorf("unterminated integer at %d", d.pos)
+	}
+
+	digits := string(d.data[d.pos+1 : d.pos+end])
+	if digits == "-0" || (len(digits) > 1 && (digits[0] == '0' || digits[:2] == "-0")) {
+		return 0, fmt.Errorf("invalid integer %s", digits)
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %s", digits)
+	}
+
+	d.pos += end + 1
+	return n, nil
+}
+
+func (d *decoder) str() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", fmt.Errorf("unterminated string length at %d", d.pos)
+	}
+
+	length, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid string length at %d", d.pos)
+	}
+
+	start := d.pos + colon + 1
+	if length > len(d.data)-start {
+		return "", fmt.Errorf("string at %d is longer than the data", d.pos)
+	}
+
+	d.pos = start + length
+	return string(d.data[start:d.pos]), nil
+}
+
+func (d *decoder) list() ([]interface{}, error) {
+	if d.depth++; d.depth > maxDepth {
+		return nil, fmt.Errorf("value is nested too deep")
+	}
+
+	defer func() { d.depth-- }()
+
+	d.pos++
+
+	list := make([]interface{}, 0)
+	for d.peek() != 'e' {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, v)
+	}
+
+	d.pos++
+	return list, nil
+}
+
+func (d *decoder) dict() (map[string]interface{}, error) {
+	if d.depth++; d.depth > maxDepth {
+		return nil, fmt.Errorf("value is nested too deep")
+	}
+
+	defer func() { d.depth-- }()
+
+	d.pos++
+
+	dict := make(map[string]interface{})
+	for d.peek() != 'e' {
+		if c := d.peek(); c < '0' || c > '9' {
+			return nil, fmt.Errorf("dictionary key at %d is not a string", d.pos)
+		}
+
+		key, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+
+		dict[key] = v
+	}
+
+	d.pos++
+	return dict, nil
+}
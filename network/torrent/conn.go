@@ -0,0 +1,227 @@
+package torrent
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// messages of the peer wire protocol
+const (
+	msgChoke         = 0
+	msgUnchoke       = 1
+	msgInterested    = 2
+	msgNotInterested = 3
+	msgHave          = 4
+	msgBitfield      = 5
+	msgRequest       = 6
+	msgPiece         = 7
+	msgCancel        = 8
+	msgExtended      = 20
+)
+
+const (
+	protocol = "BitTorrent protocol"
+	// blockSize is how much of a piece is requested at once, which is what every client serves
+	blockSize = 16 * 1024
+	// maxMessage limits the size of a message, which is a block and its header, or the bitfield of a huge torrent
+	maxMessage = 1024 * 1024
+	// utMetadata is the id of the metadata extension in the messages that are sent to this client
+	utMetadata = 1
+)
+
+type (
+	// conn is a connection to a peer, after both sides have sent their handshake
+	conn struct {
+		net.Conn
+		r          *bufio.Reader
+		peerID     [20]byte
+		extensions bool // the peer supports the extension protocol
+	}
+
+	message struct {
+		id      byte
+		payload []byte
+	}
+)
+
+func handshake(infoHash, peerID [20]byte) []byte {
+	buf := make([]byte, 0, 68)
+	buf = append(buf, byte(len(protocol)))
+	buf = append(buf, protocol...)
+
+	// the extension protocol is the 20th bit from the right of the reserved bytes
+	reserved := make([]byte, 8)
+	reserved[5] |= 0x10
+
+	buf = append(buf, reserved...)
+	buf = append(buf, infoHash[:]...)
+	buf = append(buf, peerID[:]...)
+
+	return buf
+}
+
+// readHandshake reads the handshake of the peer, and returns its info hash
+func (c *conn) readHandshake() ([20]byte, error) {
+	var infoHash [20]byte
+
+	buf := make([]byte, 68)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return infoHash, fmt.Errorf("error reading handshake:%s", err.Error())
+	}
+
+	if int(buf[0]) != len(protocol) || string(buf[1:20]) != protocol {
+		return infoHash, fmt.Errorf("error reading handshake:peer doesn't speak bittorrent")
+	}
+
+	c.extensions = buf[25]&0x10 != 0
+	copy(infoHash[:], buf[28:48])
+	copy(c.peerID[:], buf[48:68])
+
+	return infoHash, nil
+}
+
+// dial connects to the peer and exchanges the handshakes of the torrent
+func dial(ctx context.Context, addr string, infoHash, peerID [20]byte) (*conn, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	c.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if _, err := c.Write(handshake(infoHash, peerID)); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	hash, err := c.readHandshake()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if hash != infoHash {
+		c.Close()
+		return nil, fmt.Errorf("peer %s serves another torrent", addr)
+	}
+
+	if c.peerID == peerID {
+		c.Close()
+		return nil, fmt.Errorf("peer %s is this client", addr)
+	}
+
+	return c, nil
+}
+
+// watch interrupts the reads and writes of the connection once the context is done. The returned function stops watching
+func (c *conn) watch(ctx context.Context) func() {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// read reads the next message, skipping the keep alives
+func (c *conn) read() (message, error) {
+	for {
+		var length uint32
+		if err := binary.Read(c.r, binary.BigEndian, &length); err != nil {
+			return message{}, err
+		}
+
+		if length == 0 {
+			continue
+		}
+
+		if length > maxMessage {
+			return message{}, fmt.Errorf("message of %d bytes is too long", length)
+		}
+
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return message{}, err
+		}
+
+		return message{id: buf[0], payload: buf[1:]}, nil
+	}
+}
+
+func (c *conn) send(id byte, payload []byte) error {
+	buf := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = id
+
+	_, err := c.Write(append(buf, payload...))
+	return err
+}
+
+// request asks for the block of the piece, and is answered by a piece message of the same index and begin
+func (c *conn) request(id byte, index int, begin, length int64) error {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	binary.BigEndian.PutUint32(payload[4:], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:], uint32(length))
+
+	return c.send(id, payload)
+}
+
+// extended sends the message of the extension, which is 0 for the handshake of the extension protocol
+func (c *conn) extended(id byte, dict map[string]interface{}, data []byte) error {
+	payload := append([]byte{id}, Encode(dict)...)
+	return c.send(msgExtended, append(payload, data...))
+}
+
+// parseExtended splits the message of an extension into its id, its dictionary and the data after it
+func parseExtended(payload []byte) (byte, map[string]interface{}, []byte, error) {
+	if len(payload) < 2 {
+		return 0, nil, nil, fmt.Errorf("extended message is empty")
+	}
+
+	v, n, err := decodePrefix(payload[1:])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("extended message is not a dictionary")
+	}
+
+	return payload[0], dict, payload[1+n:], nil
+}
+
+// parseBlock splits the piece message into its index, its begin and the block
+func parseBlock(payload []byte) (int, int64, []byte, error) {
+	if len(payload) < 8 {
+		return 0, 0, nil, fmt.Errorf("piece message is too short")
+	}
+
+	return int(binary.BigEndian.Uint32(payload)), int64(binary.BigEndian.Uint32(payload[4:])), payload[8:], nil
+}
+
+// extensionID returns the id which the peer expects for the extension, zero if it doesn't support it
+func extensionID(dict map[string]interface{}, name string) byte {
+	m, _ := dict["m"].(map[string]interface{})
+	id, _ := m[name].(int64)
+
+	if id <= 0 || id > 255 {
+		return 0
+	}
+
+	return byte(id)
+}
@@ -0,0 +1,72 @@
+package torrent
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Magnet is the magnet link of a torrent, which info is fetched from the peers
+type Magnet struct {
+	InfoHash [20]byte
+	Name     string // display name, empty if the link has none
+	Trackers []string
+}
+
+// IsMagnet tells whether the url is a magnet link
+func IsMagnet(rawurl string) bool {
+	return len(rawurl) >= 7 && strings.EqualFold(rawurl[:7], "magnet:")
+}
+
+// ParseMagnet parses the magnet link of a bittorrent v1 info hash, in hex or base32
+func ParseMagnet(rawurl string) (*Magnet, error) {
+	if !IsMagnet(rawurl) {
+		return nil, fmt.Errorf("%s is not a magnet link", rawurl)
+	}
+
+	_, query, _ := strings.Cut(rawurl, "?")
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing magnet link:%s", err.Error())
+	}
+
+	m := &Magnet{
+		Name:     values.Get("dn"),
+		Trackers: values["tr"],
+	}
+
+	found := false
+	for _, xt := range values["xt"] {
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+
+		hash := strings.TrimPrefix(xt, "urn:btih:")
+
+		var decoded []byte
+		switch len(hash) {
+		case 40:
+			decoded, err = hex.DecodeString(hash)
+		case 32:
+			decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("invalid length")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error parsing magnet link:invalid info hash %s", hash)
+		}
+
+		copy(m.InfoHash[:], decoded)
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("error parsing magnet link:no bittorrent info hash")
+	}
+
+	return m, nil
+}
@@ -0,0 +1,191 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+)
+
+// messages of the metadata extension
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+	// metadataPiece is the size of the pieces that the info is sent in
+	metadataPiece = 16 * 1024
+	// maxMetadata limits the size of the info that a peer may announce
+	maxMetadata = 16 * 1024 * 1024
+)
+
+// ErrNoTracker means the magnet link has no tracker. The peers are only found through the trackers, since there is no dht or
+// peer exchange
+var ErrNoTracker = errors.New("magnet link has no tracker (tr=)")
+
+// FetchMetadata fetches the info of the magnet link from the peers which its trackers announce, and verifies it against the info hash
+func FetchMetadata(ctx context.Context, m *Magnet, config Config) (*Info, error) {
+	// the trackers are called through the proxy and the timeouts of the setting like the downloads are
+	if config.Client == nil {
+		config.Client = network.New()
+	}
+
+	if len(m.Trackers) > 0 {
+		config.Trackers = m.Trackers
+	}
+
+	if len(config.Trackers) == 0 {
+		return nil, fmt.Errorf("error fetching metadata:%w", ErrNoTracker)
+	}
+
+	// the size is unknown until the info is fetched, so the trackers are told that something is left
+	a := Announce{InfoHash: m.InfoHash, PeerID: config.PeerID, Port: config.Port, Left: 1}
+
+	seen := make(map[string]bool)
+	for _, tracker := range config.Trackers {
+		peers, _, err := AnnounceTo(ctx, config.Client, tracker, a)
+		if err != nil {
+			log.Println("error announcing to tracker:", err.Error())
+			continue
+		}
+
+		for _, addr := range peers {
+			if seen[addr] {
+				continue
+			}
+
+			seen[addr] = true
+
+			info, err := fetchFrom(ctx, addr, m.InfoHash, config.PeerID)
+			if err == nil {
+				return info, nil
+			}
+
+			log.Println("error fetching metadata from", addr, ":", err.Error())
+
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("error fetching metadata:%s", ErrNoPeers.Error())
+}
+
+// fetchFrom fetches the info from the peer, piece by piece
+func fetchFrom(ctx context.Context, addr string, infoHash, peerID [20]byte) (*Info, error) {
+	c, err := dial(ctx, addr, infoHash, peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.Close()
+
+	stop := c.watch(ctx)
+	defer stop()
+
+	if !c.extensions {
+		return nil, errors.New("peer doesn't support extensions")
+	}
+
+	if err := c.extended(0, map[string]interface{}{"m": map[string]interface{}{"ut_metadata": utMetadata}}, nil); err != nil {
+		return nil, err
+	}
+
+	var (
+		id   byte
+		size int64
+	)
+
+	for id == 0 {
+		payload, err := c.extension(0)
+		if err != nil {
+			return nil, err
+		}
+
+		_, dict, _, err := parseExtended(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if id = extensionID(dict, "ut_metadata"); id == 0 {
+			return nil, errors.New("peer doesn't serve metadata")
+		}
+
+		size, _ = dict["metadata_size"].(int64)
+	}
+
+	if size <= 0 || size > maxMetadata {
+		return nil, fmt.Errorf("invalid metadata size %d", size)
+	}
+
+	raw := make([]byte, size)
+	pieces := int((size + metadataPiece - 1) / metadataPiece)
+
+	for piece := 0; piece < pieces; piece++ {
+		if err := c.extended(id, map[string]interface{}{"msg_type": metadataRequest, "piece": piece}, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for received := 0; received < pieces; {
+		payload, err := c.extension(utMetadata)
+		if err != nil {
+			return nil, err
+		}
+
+		_, dict, data, err := parseExtended(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		kind, _ := dict["msg_type"].(int64)
+		piece, _ := dict["piece"].(int64)
+
+		switch kind {
+		case metadataReject:
+			return nil, fmt.Errorf("peer rejected metadata piece %d", piece)
+		case metadataData:
+			start := piece * metadataPiece
+			if piece < 0 || piece >= int64(pieces) || int64(len(data)) != minInt64(metadataPiece, size-start) {
+				return nil, fmt.Errorf("invalid metadata piece %d", piece)
+			}
+
+			copy(raw[start:], data)
+			received++
+		}
+	}
+
+	if sha1.Sum(raw) != infoHash {
+		return nil, errors.New("metadata doesn't match the info hash")
+	}
+
+	return ParseInfo(raw)
+}
+
+// extension reads the messages until the peer sends one of the extension, and returns its payload
+func (c *conn) extension(id byte) ([]byte, error) {
+	for {
+		c.SetDeadline(time.Now().Add(peerTimeout))
+
+		msg, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.id == msgExtended && len(msg.payload) > 0 && msg.payload[0] == id {
+			return msg.payload, nil
+		}
+	}
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,325 @@
+// Package torrent implements the part of bittorrent that a download needs: metainfo and magnet links, http trackers,
+// the peer wire protocol with the metadata extension, and seeding of the downloaded pieces
+package torrent
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// Metainfo is the content of a .torrent file
+	Metainfo struct {
+		Trackers []string // announce urls, the announce list in its order followed by the announce url if it isn't listed
+		Info     *Info
+	}
+
+	// Info describes the files of the torrent and the hashes of its pieces. The files are laid out one after another,
+	// and the pieces are cut from them regardless of where a file ends
+	Info struct {
+		Hash        [20]byte // sha-1 of the bencoded info, which identifies the torrent
+		Raw         []byte   // the bencoded info, which is sent to the peers that fetch it from a magnet link
+		Name        string   // name of the file, or of the folder of the files
+		PieceLength int64
+		Pieces      [][20]byte
+		Files       []File
+		Length      int64 // size of every file together
+		Single      bool  // the torrent is a single file, rather than a folder
+	}
+
+	File struct {
+		Path   string // slash separated path inside the folder of the torrent, or its name if it is a single file
+		Length int64
+		Offset int64 // where the file starts in the data of the torrent
+	}
+
+	// Bitfield tells which pieces are there, one bit per piece from the highest bit of the first byte
+	Bitfield []byte
+)
+
+// IsMetainfo tells whether the resource is a .torrent file, judging by its content type or, if it has none, the extension of its path
+func IsMetainfo(contentType, p string) bool {
+	if media, _, err := mime.ParseMediaType(contentType); err == nil && strings.EqualFold(media, "application/x-bittorrent") {
+		return true
+	}
+
+	return strings.EqualFold(path.Ext(p), ".torrent")
+}
+
+// NewPeerID returns a random peer id, which identifies this client to the trackers and the peers
+func NewPeerID() [20]byte {
+	var id [20]byte
+	copy(id[:], "-RD0001-")
+
+	rand.Read(id[8:])
+
+	return id
+}
+
+// ParseMetainfo parses the .torrent file
+func ParseMetainfo(data []byte) (*Metainfo, error) {
+	v, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error parsing metainfo:not a dictionary")
+	}
+
+	raw, err := rawField(data, "info")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metainfo:%s", err.Error())
+	}
+
+	info, err := ParseInfo(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metainfo{Trackers: make([]string, 0), Info: info}
+
+	seen := make(map[string]bool)
+	add := func(tracker interface{}) {
+		if url, ok := tracker.(string); ok && url != "" && !seen[url] {
+			seen[url] = true
+			m.Trackers = append(m.Trackers, url)
+		}
+	}
+
+	if tiers, ok := dict["announce-list"].([]interface{}); ok {
+		for _, tier := range tiers {
+			if trackers, ok := tier.([]interface{}); ok {
+				for _, tracker := range trackers {
+					add(tracker)
+				}
+			}
+		}
+	}
+
+	add(dict["announce"])
+
+	return m, nil
+}
+
+// ParseInfo parses the bencoded info dictionary
+func ParseInfo(raw []byte) (*Info, error) {
+	v, err := Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error parsing info:not a dictionary")
+	}
+
+	info := &Info{
+		Hash: sha1.Sum(raw),
+		Raw:  raw,
+	}
+
+	info.Name, _ = dict["name"].(string)
+	if !validName(info.Name) {
+		return nil, fmt.Errorf("error parsing info:invalid name %q", info.Name)
+	}
+
+	info.PieceLength, _ = dict["piece length"].(int64)
+	if info.PieceLength <= 0 {
+		return nil, fmt.Errorf("error parsing info:invalid piece length")
+	}
+
+	pieces, _ := dict["pieces"].(string)
+	if len(pieces) == 0 || len(pieces)%20 != 0 {
+		return nil, fmt.Errorf("error parsing info:pieces are not sha-1 hashes")
+	}
+
+	info.Pieces = make([][20]byte, len(pieces)/20)
+	for i := range info.Pieces {
+		copy(info.Pieces[i][:], pieces[i*20:])
+	}
+
+	if length, ok := dict["length"].(int64); ok {
+		info.Single = true
+		info.Files = []File{{Path: info.Name, Length: length}}
+	} else if files, ok := dict["files"].([]interface{}); ok && len(files) > 0 {
+		for i, f := range files {
+			file, err := parseFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing info:file %d %s", i, err.Error())
+			}
+
+			info.Files = append(info.Files, file)
+		}
+	} else {
+		return nil, fmt.Errorf("error parsing info:neither a length nor files")
+	}
+
+	for i := range info.Files {
+		if info.Files[i].Length < 0 {
+			return nil, fmt.Errorf("error parsing info:negative length of %s", info.Files[i].Path)
+		}
+
+		info.Files[i].Offset = info.Length
+		info.Length += info.Files[i].Length
+	}
+
+	if count := (info.Length + info.PieceLength - 1) / info.PieceLength; count != int64(len(info.Pieces)) {
+		return nil, fmt.Errorf("error parsing info:%d pieces for %d bytes of %d bytes pieces", len(info.Pieces), info.Length, info.PieceLength)
+	}
+
+	return info, nil
+}
+
+func parseFile(v interface{}) (File, error) {
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return File{}, fmt.Errorf("is not a dictionary")
+	}
+
+	length, ok := dict["length"].(int64)
+	if !ok {
+		return File{}, fmt.Errorf("has no length")
+	}
+
+	elements, _ := dict["path"].([]interface{})
+	if len(elements) == 0 {
+		return File{}, fmt.Errorf("has no path")
+	}
+
+	parts := make([]string, len(elements))
+	for i, element := range elements {
+		part, _ := element.(string)
+		if !validName(part) {
+			return File{}, fmt.Errorf("has invalid path element %q", part)
+		}
+
+		parts[i] = part
+	}
+
+	return File{Path: strings.Join(parts, "/"), Length: length}, nil
+}
+
+// validName tells whether the name can be a file name, so a path of the torrent can't escape its folder
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// PieceSize returns the size of the piece, which is the piece length except for the last one
+func (i *Info) PieceSize(index int) int64 {
+	if index == len(i.Pieces)-1 {
+		return i.Length - int64(index)*i.PieceLength
+	}
+
+	return i.PieceLength
+}
+
+// Select returns the indexes of the files which path or name matches any of the patterns, e.g *.iso. Every file is
+// selected if there is no pattern
+func (i *Info) Select(patterns []string) ([]int, error) {
+	selected := make([]int, 0)
+
+	for index, file := range i.Files {
+		match := len(patterns) == 0
+
+		for _, pattern := range patterns {
+			byPath, err := path.Match(pattern, file.Path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file pattern %s", pattern)
+			}
+
+			byName, _ := path.Match(pattern, path.Base(file.Path))
+			match = match || byPath || byName
+		}
+
+		if match {
+			selected = append(selected, index)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no file of %s matches %s", i.Name, strings.Join(patterns, ", "))
+	}
+
+	return selected, nil
+}
+
+// PiecesOf returns the pieces that the files take part of, in order
+func (i *Info) PiecesOf(files []int) []int {
+	needed := make(map[int]bool)
+
+	for _, index := range files {
+		file := i.Files[index]
+		if file.Length == 0 {
+			continue
+		}
+
+		first := int(file.Offset / i.PieceLength)
+		last := int((file.Offset + file.Length - 1) / i.PieceLength)
+
+		for piece := first; piece <= last; piece++ {
+			needed[piece] = true
+		}
+	}
+
+	pieces := make([]int, 0, len(needed))
+	for piece := range needed {
+		pieces = append(pieces, piece)
+	}
+
+	sort.Ints(pieces)
+	return pieces
+}
+
+// Within returns which pieces are entirely inside the files, so they can be served once the files are downloaded
+func (i *Info) Within(files []int) Bitfield {
+	have := NewBitfield(len(i.Pieces))
+	covered := make([]int64, len(i.Pieces))
+
+	for _, index := range files {
+		file := i.Files[index]
+
+		for offset := file.Offset; offset < file.Offset+file.Length; {
+			piece := int(offset / i.PieceLength)
+			end := int64(piece+1) * i.PieceLength
+			if fileEnd := file.Offset + file.Length; end > fileEnd {
+				end = fileEnd
+			}
+
+			covered[piece] += end - offset
+			offset = end
+		}
+	}
+
+	for piece, n := range covered {
+		if n == i.PieceSize(piece) {
+			have.Set(piece)
+		}
+	}
+
+	return have
+}
+
+func NewBitfield(pieces int) Bitfield {
+	return make(Bitfield, (pieces+7)/8)
+}
+
+func (b Bitfield) Has(index int) bool {
+	if index < 0 || index/8 >= len(b) {
+		return false
+	}
+
+	return b[index/8]&(0x80>>(index%8)) != 0
+}
+
+func (b Bitfield) Set(index int) {
+	if index >= 0 && index/8 < len(b) {
+		b[index/8] |= 0x80 >> (index % 8)
+	}
+}
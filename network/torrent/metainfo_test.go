@@ -0,0 +1,154 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// info returns the bencoded info of the files, which are cut into pieces of the length
+func info(name string, pieceLength int, files map[string]int) map[string]interface{} {
+	total := 0
+	list := make([]interface{}, 0)
+
+	for _, path := range []string{"a/first.bin", "a/second.iso", "third.txt"} {
+		if length, ok := files[path]; ok {
+			list = append(list, map[string]interface{}{"length": length, "path": strings.Split(path, "/")})
+			total += length
+		}
+	}
+
+	pieces := make([]byte, 20*((total+pieceLength-1)/pieceLength))
+
+	return map[string]interface{}{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+		"files":        list,
+	}
+}
+
+func TestBencode(t *testing.T) {
+	value := map[string]interface{}{
+		"b": []interface{}{int64(-3), "x"},
+		"a": map[string]interface{}{"n": int64(0)},
+	}
+
+	data := Encode(value)
+	if string(data) != "d1:ad1:ni0ee1:bli-3e1:xee" {
+		t.Fatalf("Expected the keys to be sorted, but got %s", data)
+	}
+
+	decoded, err := Decode(data)
+	if err != nil || !reflect.DeepEqual(decoded, value) {
+		t.Fatalf("Expected %v, but got %v (%v)", value, decoded, err)
+	}
+
+	for _, invalid := range []string{"", "i01e", "i-0e", "5:abc", "l1:a", "d1:ai1ee1:x", "ie", strings.Repeat("l", 100) + strings.Repeat("e", 100)} {
+		if _, err := Decode([]byte(invalid)); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestParseMetainfo(t *testing.T) {
+	data := Encode(map[string]interface{}{
+		"announce":      "http://tracker.example.com/announce",
+		"announce-list": []interface{}{[]string{"http://backup.example.com/announce"}, []string{"http://tracker.example.com/announce"}},
+		"info":          info("folder", 16, map[string]int{"a/first.bin": 20, "a/second.iso": 30, "third.txt": 5}),
+	})
+
+	m, err := ParseMetainfo(data)
+	if err != nil {
+		t.Fatal("Error parsing metainfo:", err.Error())
+	}
+
+	if !reflect.DeepEqual(m.Trackers, []string{"http://backup.example.com/announce", "http://tracker.example.com/announce"}) {
+		t.Fatalf("Expected the announce list without duplicates, but got %v", m.Trackers)
+	}
+
+	raw := Encode(info("folder", 16, map[string]int{"a/first.bin": 20, "a/second.iso": 30, "third.txt": 5}))
+	if !bytes.Equal(m.Info.Raw, raw) || m.Info.Hash != sha1.Sum(raw) {
+		t.Fatalf("Expected the hash of the raw info")
+	}
+
+	if m.Info.Single || m.Info.Length != 55 || len(m.Info.Pieces) != 4 || m.Info.PieceSize(3) != 7 {
+		t.Fatalf("Expected 55 bytes in 4 pieces, but got %+v", m.Info)
+	}
+
+	if f := m.Info.Files[1]; f.Path != "a/second.iso" || f.Offset != 20 || f.Length != 30 {
+		t.Fatalf("Expected second file at 20, but got %+v", f)
+	}
+}
+
+func TestParseInfoInvalid(t *testing.T) {
+	escape := info("folder", 16, map[string]int{"third.txt": 5})
+	escape["files"] = []interface{}{map[string]interface{}{"length": 5, "path": []string{"..", "passwd"}}}
+
+	pieces := info("folder", 16, map[string]int{"third.txt": 5})
+	pieces["pieces"] = make([]byte, 40)
+
+	name := info("../folder", 16, map[string]int{"third.txt": 5})
+
+	for i, invalid := range []map[string]interface{}{escape, pieces, name} {
+		if _, err := ParseInfo(Encode(invalid)); err == nil {
+			t.Errorf("Expected info %d to be invalid", i)
+		}
+	}
+}
+
+func TestParseMagnet(t *testing.T) {
+	hash := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+
+	m, err := ParseMagnet("magnet:?xt=urn:btih:" + hash + "&dn=ubuntu.iso&tr=http%3A%2F%2Ftracker.example.com%2Fannounce")
+	if err != nil {
+		t.Fatal("Error parsing magnet:", err.Error())
+	}
+
+	if hex.EncodeToString(m.InfoHash[:]) != hash || m.Name != "ubuntu.iso" || !reflect.DeepEqual(m.Trackers, []string{"http://tracker.example.com/announce"}) {
+		t.Fatalf("Unexpected magnet %+v", m)
+	}
+
+	base32, err := ParseMagnet("magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK")
+	if err != nil || base32.InfoHash != m.InfoHash {
+		t.Fatalf("Expected the base32 hash to be the same, but got %v", err)
+	}
+
+	for _, invalid := range []string{"http://example.com/a.torrent", "magnet:?dn=name", "magnet:?xt=urn:btih:1234"} {
+		if _, err := ParseMagnet(invalid); err == nil {
+			t.Errorf("Expected %s to be invalid", invalid)
+		}
+	}
+}
+
+func TestSelectFiles(t *testing.T) {
+	i, err := ParseInfo(Encode(info("folder", 16, map[string]int{"a/first.bin": 20, "a/second.iso": 30, "third.txt": 5})))
+	if err != nil {
+		t.Fatal("Error parsing info:", err.Error())
+	}
+
+	selected, err := i.Select([]string{"*.iso"})
+	if err != nil || !reflect.DeepEqual(selected, []int{1}) {
+		t.Fatalf("Expected the iso to be selected, but got %v (%v)", selected, err)
+	}
+
+	// the iso is from 20 to 50, which is in pieces 1 to 3, but only piece 2 is entirely in it
+	if pieces := i.PiecesOf(selected); !reflect.DeepEqual(pieces, []int{1, 2, 3}) {
+		t.Fatalf("Expected pieces 1 to 3, but got %v", pieces)
+	}
+
+	if have := i.Within(selected); have.Has(1) || !have.Has(2) || have.Has(3) {
+		t.Fatalf("Expected only piece 2 to be within the iso, but got %08b", have)
+	}
+
+	if all, _ := i.Select(nil); len(all) != 3 {
+		t.Fatalf("Expected every file without a pattern, but got %v", all)
+	}
+
+	if _, err := i.Select([]string{"*.mkv"}); err == nil {
+		t.Fatalf("Expected an error when nothing matches")
+	}
+}
@@ -0,0 +1,287 @@
+package torrent
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rapid-downloader/rapid/log"
+)
+
+type (
+	// Seeder serves the pieces it has, and the info of the torrent, to the peers that connect to it. Every peer is unchoked
+	Seeder struct {
+		info     *Info
+		data     io.ReaderAt
+		have     Bitfield
+		peerID   [20]byte
+		listener net.Listener
+		mutex    sync.Mutex
+		conns    map[net.Conn]struct{}
+		wg       sync.WaitGroup
+		uploaded int64 // atomic
+	}
+
+	// Files reads the data of the torrent from the files it is saved into
+	Files struct {
+		info  *Info
+		paths []string
+	}
+)
+
+const (
+	// maxRequest is the largest block which is served, since the other clients don't request more than it
+	maxRequest = 128 * 1024
+	// idleTimeout is how long a peer may be silent before it is disconnected
+	idleTimeout = 2 * time.Minute
+)
+
+// NewFiles returns the data of the torrent from the paths of its files. A file without a path isn't saved, so it can't be read
+func NewFiles(info *Info, paths []string) *Files {
+	return &Files{info: info, paths: paths}
+}
+
+func (f *Files) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+
+	for i, file := range f.info.Files {
+		if n == len(p) {
+			break
+		}
+
+		position := off + int64(n)
+		if position < file.Offset || position >= file.Offset+file.Length {
+			continue
+		}
+
+		if f.paths[i] == "" {
+			return n, errors.New("file of the piece is not downloaded")
+		}
+
+		size := file.Offset + file.Length - position
+		if size > int64(len(p)-n) {
+			size = int64(len(p) - n)
+		}
+
+		read, err := readFile(f.paths[i], p[n:n+int(size)], position-file.Offset)
+		n += read
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func readFile(path string, p []byte, off int64) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	return file.ReadAt(p, off)
+}
+
+// Seed serves the pieces of the data that it has to the peers which connect to the listener, until it is closed
+func Seed(listener net.Listener, info *Info, data io.ReaderAt, have Bitfield, peerID [20]byte) *Seeder {
+	s := &Seeder{
+		info:     info,
+		data:     data,
+		have:     have,
+		peerID:   peerID,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s
+}
+
+func (s *Seeder) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Uploaded returns how many bytes of the pieces have been served
+func (s *Seeder) Uploaded() int64 {
+	return atomic.LoadInt64(&s.uploaded)
+}
+
+// Close stops accepting the peers, and disconnects the connected ones
+func (s *Seeder) Close() error {
+	err := s.listener.Close()
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Seeder) accept() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.conns[nc] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			if err := s.serve(&conn{Conn: nc, r: bufio.NewReader(nc)}); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Println("error serving peer", nc.RemoteAddr(), ":", err.Error())
+			}
+
+			s.mutex.Lock()
+			delete(s.conns, nc)
+			s.mutex.Unlock()
+
+			nc.Close()
+		}()
+	}
+}
+
+func (s *Seeder) serve(c *conn) error {
+	c.SetDeadline(time.Now().Add(idleTimeout))
+
+	hash, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+
+	if hash != s.info.Hash {
+		return errors.New("peer asks for another torrent")
+	}
+
+	if _, err := c.Write(handshake(s.info.Hash, s.peerID)); err != nil {
+		return err
+	}
+
+	if c.extensions {
+		dict := map[string]interface{}{
+			"m":             map[string]interface{}{"ut_metadata": utMetadata},
+			"metadata_size": len(s.info.Raw),
+		}
+
+		if err := c.extended(0, dict, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := c.send(msgBitfield, s.have); err != nil {
+		return err
+	}
+
+	if err := c.send(msgUnchoke, nil); err != nil {
+		return err
+	}
+
+	var metadataID byte // id of the metadata extension of the peer
+
+	for {
+		c.SetDeadline(time.Now().Add(idleTimeout))
+
+		msg, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		switch msg.id {
+		case msgRequest:
+			if err := s.block(c, msg.payload); err != nil {
+				return err
+			}
+		case msgExtended:
+			id, dict, _, err := parseExtended(msg.payload)
+			if err != nil {
+				return err
+			}
+
+			switch id {
+			case 0:
+				metadataID = extensionID(dict, "ut_metadata")
+			case utMetadata:
+				if metadataID != 0 {
+					if err := s.metadata(c, metadataID, dict); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+// block sends the requested block of a piece that the seeder has
+func (s *Seeder) block(c *conn, payload []byte) error {
+	if len(payload) != 12 {
+		return errors.New("invalid request")
+	}
+
+	index := int(binary.BigEndian.Uint32(payload))
+	begin := int64(binary.BigEndian.Uint32(payload[4:]))
+	length := int64(binary.BigEndian.Uint32(payload[8:]))
+
+	if !s.have.Has(index) || length <= 0 || length > maxRequest || begin+length > s.info.PieceSize(index) {
+		return errors.New("invalid request")
+	}
+
+	block := make([]byte, 8+length)
+	copy(block, payload[:8])
+
+	if _, err := s.data.ReadAt(block[8:], int64(index)*s.info.PieceLength+begin); err != nil {
+		return err
+	}
+
+	if err := c.send(msgPiece, block); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&s.uploaded, length)
+	return nil
+}
+
+// metadata answers the request of a piece of the info
+func (s *Seeder) metadata(c *conn, id byte, dict map[string]interface{}) error {
+	if kind, _ := dict["msg_type"].(int64); kind != metadataRequest {
+		return nil
+	}
+
+	piece, _ := dict["piece"].(int64)
+	start := piece * metadataPiece
+
+	if piece < 0 || start >= int64(len(s.info.Raw)) {
+		return c.extended(id, map[string]interface{}{"msg_type": metadataReject, "piece": piece}, nil)
+	}
+
+	end := start + metadataPiece
+	if end > int64(len(s.info.Raw)) {
+		end = int64(len(s.info.Raw))
+	}
+
+	answer := map[string]interface{}{"msg_type": metadataData, "piece": piece, "total_size": len(s.info.Raw)}
+	return c.extended(id, answer, s.info.Raw[start:end])
+}
@@ -0,0 +1,426 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/network"
+)
+
+type (
+	// Config is how the swarm reaches the peers
+	Config struct {
+		PeerID   [20]byte
+		Port     int          // port which is announced to the trackers
+		Trackers []string     // announce urls, which are asked for the peers
+		Client   *http.Client // client of the trackers, the client of the setting if nil
+		MaxPeers int          // how many peers are connected at once, 0 means 20
+	}
+
+	// Swarm downloads the pieces of a torrent from its peers. Every connected peer serves one piece at a time, so the
+	// pieces are downloaded in parallel from as many peers as they are requested
+	Swarm struct {
+		info       *Info
+		config     Config
+		mutex      sync.Mutex
+		peers      []*peer
+		addrs      []string        // peers which aren't connected yet
+		known      map[string]bool // every address which has been announced, so a peer isn't connected twice
+		connecting int
+		wake       chan struct{} // closed when a peer is released or connected
+		announced  time.Time
+		left       int64
+		downloaded int64 // atomic
+	}
+
+	peer struct {
+		conn   *conn
+		addr   string
+		have   Bitfield
+		choked bool
+		busy   bool
+	}
+)
+
+// ErrNoPeers means no peer has the piece at the moment. The trackers are asked again for the peers on the next attempt
+var ErrNoPeers = errors.New("no peer has the piece")
+
+const (
+	// pipeline is how many blocks are requested from a peer before their pieces arrive
+	pipeline = 8
+	// reannounce is how long the swarm waits before asking the trackers again once it has run out of peers
+	reannounce = 10 * time.Second
+	// peerTimeout is how long a peer may be silent while a piece is downloaded from it
+	peerTimeout = 30 * time.Second
+)
+
+// NewSwarm returns the swarm of the torrent, which needs left bytes of it
+func NewSwarm(info *Info, config Config, left int64) *Swarm {
+	if config.MaxPeers <= 0 {
+		config.MaxPeers = 20
+	}
+
+	// the trackers are called through the proxy and the timeouts of the setting like the downloads are
+	if config.Client == nil {
+		config.Client = network.New()
+	}
+
+	return &Swarm{
+		info:   info,
+		config: config,
+		known:  make(map[string]bool),
+		wake:   make(chan struct{}),
+		left:   left,
+	}
+}
+
+// AddPeers adds the addresses to the peers which the pieces are downloaded from
+func (s *Swarm) AddPeers(addrs ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, addr := range addrs {
+		if !s.known[addr] {
+			s.known[addr] = true
+			s.addrs = append(s.addrs, addr)
+		}
+	}
+
+	s.signal()
+}
+
+// signal wakes the pieces that wait for a peer. Caller must hold the lock
+func (s *Swarm) signal() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// Downloaded returns how many bytes of verified pieces have been downloaded
+func (s *Swarm) Downloaded() int64 {
+	return atomic.LoadInt64(&s.downloaded)
+}
+
+// Piece downloads the piece from one of the peers, and verifies it against its hash
+func (s *Swarm) Piece(ctx context.Context, index int) ([]byte, error) {
+	if index < 0 || index >= len(s.info.Pieces) {
+		return nil, fmt.Errorf("piece %d is not in the torrent", index)
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var p *peer
+		if p, err = s.acquire(ctx, index); err != nil {
+			return nil, err
+		}
+
+		var data []byte
+		if data, err = s.download(ctx, p, index); err == nil {
+			s.release(p)
+			atomic.AddInt64(&s.downloaded, int64(len(data)))
+
+			return data, nil
+		}
+
+		log.Println("error downloading piece", index, "from", p.addr, ":", err.Error())
+		s.drop(p)
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, err
+}
+
+// acquire returns an idle peer which has the piece, and connects to the other peers while there isn't any
+func (s *Swarm) acquire(ctx context.Context, index int) (*peer, error) {
+	for {
+		s.mutex.Lock()
+
+		found := false
+		for _, p := range s.peers {
+			if !p.have.Has(index) {
+				continue
+			}
+
+			found = true
+			if !p.busy {
+				p.busy = true
+				s.mutex.Unlock()
+
+				return p, nil
+			}
+		}
+
+		if len(s.addrs) > 0 && len(s.peers)+s.connecting < s.config.MaxPeers {
+			addr := s.addrs[0]
+			s.addrs = s.addrs[1:]
+			s.connecting++
+			s.mutex.Unlock()
+
+			p, err := s.connect(ctx, addr)
+
+			s.mutex.Lock()
+			s.connecting--
+			if err == nil {
+				s.peers = append(s.peers, p)
+			} else {
+				log.Println("error connecting to peer", addr, ":", err.Error())
+				delete(s.known, addr)
+			}
+
+			s.signal()
+			s.mutex.Unlock()
+
+			continue
+		}
+
+		// the peers that are connecting may have the piece
+		waiting := found || s.connecting > 0
+		wake := s.wake
+		s.mutex.Unlock()
+
+		if !waiting {
+			if err := s.announce(ctx, ""); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Swarm) release(p *peer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p.busy = false
+	s.signal()
+}
+
+// drop disconnects the peer, which is connected again only if a tracker announces it again
+func (s *Swarm) drop(p *peer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p.conn.Close()
+
+	for i, connected := range s.peers {
+		if connected == p {
+			s.peers = append(s.peers[:i], s.peers[i+1:]...)
+			break
+		}
+	}
+
+	delete(s.known, p.addr)
+	s.signal()
+}
+
+// announce asks the trackers for more peers, unless they have been asked recently
+func (s *Swarm) announce(ctx context.Context, event string) error {
+	s.mutex.Lock()
+	if event == "" && time.Since(s.announced) < reannounce {
+		s.mutex.Unlock()
+		return ErrNoPeers
+	}
+
+	s.announced = time.Now()
+	s.mutex.Unlock()
+
+	a := Announce{
+		InfoHash:   s.info.Hash,
+		PeerID:     s.config.PeerID,
+		Port:       s.config.Port,
+		Downloaded: s.Downloaded(),
+		Left:       s.left - s.Downloaded(),
+		Event:      event,
+	}
+
+	found := 0
+	for _, tracker := range s.config.Trackers {
+		peers, _, err := AnnounceTo(ctx, s.config.Client, tracker, a)
+		if err != nil {
+			log.Println("error announcing to tracker:", err.Error())
+			continue
+		}
+
+		found += len(peers)
+		s.AddPeers(peers...)
+	}
+
+	if found == 0 && event == "" {
+		return ErrNoPeers
+	}
+
+	return nil
+}
+
+// Start announces the download to the trackers, which answer with the first peers
+func (s *Swarm) Start(ctx context.Context) {
+	s.announce(ctx, EventStarted)
+}
+
+// Close disconnects every peer, and tells the trackers whether the download is completed or stopped
+func (s *Swarm) Close(ctx context.Context, completed bool) {
+	s.mutex.Lock()
+	for _, p := range s.peers {
+		p.conn.Close()
+	}
+
+	s.peers = nil
+	s.mutex.Unlock()
+
+	event := EventStopped
+	if completed {
+		event = EventCompleted
+	}
+
+	s.announce(ctx, event)
+}
+
+// connect connects to the peer, and waits until the peer unchokes this client
+func (s *Swarm) connect(ctx context.Context, addr string) (*peer, error) {
+	c, err := dial(ctx, addr, s.info.Hash, s.config.PeerID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peer{conn: c, addr: addr, have: NewBitfield(len(s.info.Pieces)), choked: true}
+
+	stop := c.watch(ctx)
+	defer stop()
+
+	if err := c.send(msgInterested, nil); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	for p.choked {
+		if err := s.handle(p, nil); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// handle reads the next message of the peer. The block of a piece message is passed to onblock
+func (s *Swarm) handle(p *peer, onblock func(index int, begin int64, block []byte) error) error {
+	p.conn.SetDeadline(time.Now().Add(peerTimeout))
+
+	msg, err := p.conn.read()
+	if err != nil {
+		return err
+	}
+
+	switch msg.id {
+	case msgChoke:
+		p.choked = true
+	case msgUnchoke:
+		p.choked = false
+	case msgHave:
+		if len(msg.payload) == 4 {
+			p.have.Set(int(binary.BigEndian.Uint32(msg.payload)))
+		}
+	case msgBitfield:
+		if len(msg.payload) != len(p.have) {
+			return fmt.Errorf("bitfield of %d bytes for %d pieces", len(msg.payload), len(s.info.Pieces))
+		}
+
+		copy(p.have, msg.payload)
+	case msgPiece:
+		index, begin, block, err := parseBlock(msg.payload)
+		if err != nil {
+			return err
+		}
+
+		if onblock != nil {
+			return onblock(index, begin, block)
+		}
+	}
+
+	return nil
+}
+
+// download downloads the piece from the peer block by block, keeping a few blocks requested at any time
+func (s *Swarm) download(ctx context.Context, p *peer, index int) ([]byte, error) {
+	stop := p.conn.watch(ctx)
+	defer stop()
+
+	for p.choked {
+		if err := s.handle(p, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	size := s.info.PieceSize(index)
+	data := make([]byte, size)
+
+	blocks := int((size + blockSize - 1) / blockSize)
+	received := make([]bool, blocks)
+	requested, done := 0, 0
+
+	onblock := func(i int, begin int64, block []byte) error {
+		if i != index || begin%blockSize != 0 || begin >= size {
+			return nil
+		}
+
+		b := int(begin / blockSize)
+		if received[b] || int64(len(block)) != blockLength(size, b) {
+			return nil
+		}
+
+		copy(data[begin:], block)
+		received[b] = true
+		done++
+
+		return nil
+	}
+
+	for done < blocks {
+		for ; requested < blocks && requested-done < pipeline; requested++ {
+			if err := p.conn.request(msgRequest, index, int64(requested)*blockSize, blockLength(size, requested)); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := s.handle(p, onblock); err != nil {
+			return nil, err
+		}
+
+		// the requests are dropped by a peer which chokes this client
+		if p.choked {
+			return nil, fmt.Errorf("peer choked while piece %d is downloaded", index)
+		}
+	}
+
+	if hash := sha1.Sum(data); !bytes.Equal(hash[:], s.info.Pieces[index][:]) {
+		return nil, fmt.Errorf("piece %d doesn't match its hash", index)
+	}
+
+	return data, nil
+}
+
+func blockLength(size int64, block int) int64 {
+	if rest := size - int64(block)*blockSize; rest < blockSize {
+		return rest
+	}
+
+	return blockSize
+}
@@ -0,0 +1,298 @@
+package torrent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// single returns the info of a single file torrent of the data
+func single(t *testing.T, data []byte, pieceLength int) *Info {
+	pieces := make([]byte, 0)
+	for start := 0; start < len(data); start += pieceLength {
+		end := start + pieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+
+		hash := sha1.Sum(data[start:end])
+		pieces = append(pieces, hash[:]...)
+	}
+
+	i, err := ParseInfo(Encode(map[string]interface{}{"name": "file.bin", "length": len(data), "piece length": pieceLength, "pieces": pieces}))
+	if err != nil {
+		t.Fatal("Error parsing info:", err.Error())
+	}
+
+	return i
+}
+
+// serve seeds every piece of the data, and returns a tracker which announces the seeder
+func serve(t *testing.T, i *Info, data []byte) (*Seeder, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err.Error())
+	}
+
+	have := NewBitfield(len(i.Pieces))
+	for index := range i.Pieces {
+		have.Set(index)
+	}
+
+	seeder := Seed(listener, i, bytes.NewReader(data), have, NewPeerID())
+	t.Cleanup(func() { seeder.Close() })
+
+	port := seeder.Addr().(*net.TCPAddr).Port
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := []byte{127, 0, 0, 1, byte(port >> 8), byte(port)}
+		w.Write(Encode(map[string]interface{}{"interval": 60, "peers": peer}))
+	}))
+
+	t.Cleanup(tracker.Close)
+
+	return seeder, tracker.URL + "/announce"
+}
+
+// announce returns a tracker which announces the peers in their order
+func announce(t *testing.T, addrs ...net.Addr) string {
+	var peers []byte
+	for _, addr := range addrs {
+		port := addr.(*net.TCPAddr).Port
+		peers = append(peers, 127, 0, 0, 1, byte(port>>8), byte(port))
+	}
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(Encode(map[string]interface{}{"interval": 60, "peers": peers}))
+	}))
+
+	t.Cleanup(tracker.Close)
+
+	return tracker.URL + "/announce"
+}
+
+// seed seeds every piece of the data, without a tracker
+func seed(t *testing.T, i *Info, data []byte) *Seeder {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err.Error())
+	}
+
+	have := NewBitfield(len(i.Pieces))
+	for index := range i.Pieces {
+		have.Set(index)
+	}
+
+	seeder := Seed(listener, i, bytes.NewReader(data), have, NewPeerID())
+	t.Cleanup(func() { seeder.Close() })
+
+	return seeder
+}
+
+// disconnecting is a peer which has every piece, but disconnects once it has sent the first block that is requested
+func disconnecting(t *testing.T, i *Info, data []byte) net.Addr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err.Error())
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	have := NewBitfield(len(i.Pieces))
+	for index := range i.Pieces {
+		have.Set(index)
+	}
+
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+			if _, err := c.readHandshake(); err != nil {
+				c.Close()
+				continue
+			}
+
+			c.Write(handshake(i.Hash, NewPeerID()))
+			c.send(msgBitfield, have)
+			c.send(msgUnchoke, nil)
+
+			for {
+				msg, err := c.read()
+				if err != nil {
+					break
+				}
+
+				if msg.id != msgRequest {
+					continue
+				}
+
+				index, begin, length := binary.BigEndian.Uint32(msg.payload), binary.BigEndian.Uint32(msg.payload[4:]), binary.BigEndian.Uint32(msg.payload[8:])
+				start := int64(index)*i.PieceLength + int64(begin)
+
+				c.send(msgPiece, append(append([]byte(nil), msg.payload[:8]...), data[start:start+int64(length)]...))
+				break
+			}
+
+			c.Close()
+		}
+	}()
+
+	return listener.Addr()
+}
+
+func random(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal("Error generating data:", err.Error())
+	}
+
+	return data
+}
+
+func TestSwarmDownload(t *testing.T) {
+	data := random(t, 100*1024+123)
+	i := single(t, data, 32*1024)
+	seeder, tracker := serve(t, i, data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	swarm := NewSwarm(i, Config{PeerID: NewPeerID(), Trackers: []string{tracker}}, i.Length)
+	swarm.Start(ctx)
+	defer swarm.Close(ctx, true)
+
+	downloaded := make([]byte, 0, len(data))
+	for index := len(i.Pieces) - 1; index >= 0; index-- {
+		piece, err := swarm.Piece(ctx, index)
+		if err != nil {
+			t.Fatal("Error downloading piece:", err.Error())
+		}
+
+		downloaded = append(piece, downloaded...)
+	}
+
+	if !bytes.Equal(downloaded, data) {
+		t.Fatalf("Expected the pieces to be the data")
+	}
+
+	if swarm.Downloaded() != i.Length || seeder.Uploaded() != i.Length {
+		t.Fatalf("Expected %d bytes both ways, but downloaded %d and uploaded %d", i.Length, swarm.Downloaded(), seeder.Uploaded())
+	}
+}
+
+func TestSwarmCorruptPiece(t *testing.T) {
+	data := random(t, 64*1024)
+	i := single(t, data, 32*1024)
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[40*1024] ^= 0xff
+
+	_, tracker := serve(t, i, corrupt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	swarm := NewSwarm(i, Config{PeerID: NewPeerID(), Trackers: []string{tracker}}, i.Length)
+	swarm.Start(ctx)
+	defer swarm.Close(ctx, false)
+
+	if piece, err := swarm.Piece(ctx, 0); err != nil || !bytes.Equal(piece, data[:32*1024]) {
+		t.Fatalf("Expected the intact piece, but got %v", err)
+	}
+
+	if _, err := swarm.Piece(ctx, 1); err == nil {
+		t.Fatalf("Expected the corrupt piece to fail")
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	// many small pieces make the info larger than a piece of the metadata
+	data := random(t, 16*1024)
+	i := single(t, data, 16)
+
+	_, tracker := serve(t, i, data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	fetched, err := FetchMetadata(ctx, &Magnet{InfoHash: i.Hash, Trackers: []string{tracker}}, Config{PeerID: NewPeerID()})
+	if err != nil {
+		t.Fatal("Error fetching metadata:", err.Error())
+	}
+
+	if len(i.Raw) <= metadataPiece || !bytes.Equal(fetched.Raw, i.Raw) || fetched.Length != i.Length {
+		t.Fatalf("Expected the info of %d bytes to be fetched in pieces", len(i.Raw))
+	}
+
+	if _, err := FetchMetadata(ctx, &Magnet{InfoHash: sha1.Sum([]byte("other")), Trackers: []string{tracker}}, Config{PeerID: NewPeerID()}); err == nil {
+		t.Fatalf("Expected no peer to have another torrent")
+	}
+}
+
+func TestSwarmCorruptPeer(t *testing.T) {
+	data := random(t, 64*1024)
+	i := single(t, data, 32*1024)
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[40*1024] ^= 0xff
+
+	// the corrupt peer is connected first, and dropped once its piece fails the hash
+	bad, good := seed(t, i, corrupt), seed(t, i, data)
+	tracker := announce(t, bad.Addr(), good.Addr())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	swarm := NewSwarm(i, Config{PeerID: NewPeerID(), Trackers: []string{tracker}}, i.Length)
+	swarm.Start(ctx)
+	defer swarm.Close(ctx, true)
+
+	piece, err := swarm.Piece(ctx, 1)
+	if err != nil || !bytes.Equal(piece, data[32*1024:]) {
+		t.Fatalf("Expected the piece from the intact peer, but got %v", err)
+	}
+
+	if bad.Uploaded() == 0 {
+		t.Error("Expected the piece to be downloaded from the corrupt peer first")
+	}
+
+	if swarm.Downloaded() != int64(len(piece)) {
+		t.Errorf("Expected only the verified piece to be counted, but got %d bytes", swarm.Downloaded())
+	}
+}
+
+func TestSwarmPeerDisconnect(t *testing.T) {
+	data := random(t, 64*1024)
+	i := single(t, data, 32*1024)
+
+	// the first peer disconnects after the first of the two blocks of the piece
+	tracker := announce(t, disconnecting(t, i, data), seed(t, i, data).Addr())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	swarm := NewSwarm(i, Config{PeerID: NewPeerID(), Trackers: []string{tracker}}, i.Length)
+	swarm.Start(ctx)
+	defer swarm.Close(ctx, true)
+
+	piece, err := swarm.Piece(ctx, 0)
+	if err != nil || !bytes.Equal(piece, data[:32*1024]) {
+		t.Fatalf("Expected the piece from the other peer, but got %v", err)
+	}
+
+	if swarm.Downloaded() != int64(len(piece)) {
+		t.Errorf("Expected only the verified piece to be counted, but got %d bytes", swarm.Downloaded())
+	}
+}
@@ -0,0 +1,125 @@
+package torrent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Announce tells the tracker how the download is going, and asks it for the peers of the torrent
+type Announce struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      string // started, completed or stopped, empty for a regular announce
+}
+
+// events of the announce
+const (
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventStopped   = "stopped"
+)
+
+// AnnounceTo announces to the http tracker, and returns the addresses of the peers it knows, and how many seconds to
+// wait before the next announce. Udp trackers are not supported
+func AnnounceTo(ctx context.Context, client *http.Client, tracker string, a Announce) ([]string, int, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error parsing tracker %s:%s", tracker, err.Error())
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, 0, fmt.Errorf("%s tracker is not supported", u.Scheme)
+	}
+
+	// the query of the tracker url, e.g a passkey, is kept in front of the announce
+	query := fmt.Sprintf("info_hash=%s&peer_id=%s&port=%d&uploaded=%d&downloaded=%d&left=%d&compact=1",
+		url.QueryEscape(string(a.InfoHash[:])), url.QueryEscape(string(a.PeerID[:])), a.Port, a.Uploaded, a.Downloaded, a.Left)
+
+	if a.Event != "" {
+		query += "&event=" + a.Event
+	}
+
+	if u.RawQuery != "" {
+		query = u.RawQuery + "&" + query
+	}
+
+	u.RawQuery = query
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("error announcing to %s:unexpected status %d", u.Host, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1024*1024))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	v, err := Decode(body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("error announcing to %s:response is not a dictionary", u.Host)
+	}
+
+	if reason, ok := dict["failure reason"].(string); ok {
+		return nil, 0, fmt.Errorf("error announcing to %s:%s", u.Host, reason)
+	}
+
+	interval, _ := dict["interval"].(int64)
+
+	peers := make([]string, 0)
+	switch list := dict["peers"].(type) {
+	case string:
+		// compact peers are 4 bytes of ipv4 address and 2 bytes of port each
+		for i := 0; i+6 <= len(list); i += 6 {
+			peers = append(peers, compactPeer([]byte(list[i:i+4]), []byte(list[i+4:i+6])))
+		}
+	case []interface{}:
+		for _, p := range list {
+			peer, _ := p.(map[string]interface{})
+			ip, _ := peer["ip"].(string)
+			port, _ := peer["port"].(int64)
+
+			if ip != "" && port > 0 {
+				peers = append(peers, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+			}
+		}
+	}
+
+	if list, ok := dict["peers6"].(string); ok {
+		for i := 0; i+18 <= len(list); i += 18 {
+			peers = append(peers, compactPeer([]byte(list[i:i+16]), []byte(list[i+16:i+18])))
+		}
+	}
+
+	return peers, int(interval), nil
+}
+
+func compactPeer(ip, port []byte) string {
+	return net.JoinHostPort(net.IP(ip).String(), strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+}
@@ -0,0 +1,171 @@
+// Package torrenttest provides an in-process tracker and seeder for the tests of the torrent downloads, like httptest does for http
+package torrenttest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rapid-downloader/rapid/network/torrent"
+)
+
+type (
+	// Tracker answers every announce with the peers that are added to it, regardless of the torrent. A peer which announces
+	// its port is added until it announces that it has stopped
+	Tracker struct {
+		URL       string // announce url
+		server    *httptest.Server
+		mutex     sync.Mutex
+		peers     []string
+		announces []string
+	}
+
+	// File is a file of a torrent which is created for a test
+	File struct {
+		Path    string // slash separated path inside the folder of the torrent, empty for a single file torrent
+		Content []byte
+	}
+)
+
+func NewTracker() *Tracker {
+	t := &Tracker{}
+	t.server = httptest.NewServer(http.HandlerFunc(t.announce))
+	t.URL = t.server.URL + "/announce"
+
+	return t
+}
+
+// Add adds the address of a peer, which is announced from then on
+func (t *Tracker) Add(addr string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.peers = append(t.peers, addr)
+}
+
+// Announces returns the events of the announces which the tracker has received, "" for a regular announce
+func (t *Tracker) Announces() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return append([]string(nil), t.announces...)
+}
+
+func (t *Tracker) Close() {
+	t.server.Close()
+}
+
+func (t *Tracker) announce(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if len(query.Get("info_hash")) != 20 || len(query.Get("peer_id")) != 20 {
+		w.Write(torrent.Encode(map[string]interface{}{"failure reason": "invalid announce"}))
+		return
+	}
+
+	t.mutex.Lock()
+	t.announces = append(t.announces, query.Get("event"))
+
+	if port := query.Get("port"); port != "" && port != "0" {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		t.announced(net.JoinHostPort(host, port), query.Get("event") != torrent.EventStopped)
+	}
+
+	var peers []byte
+	for _, addr := range t.peers {
+		host, port, _ := net.SplitHostPort(addr)
+		n, _ := strconv.Atoi(port)
+
+		peers = append(peers, net.ParseIP(host).To4()...)
+		peers = append(peers, byte(n>>8), byte(n))
+	}
+	t.mutex.Unlock()
+
+	w.Write(torrent.Encode(map[string]interface{}{"interval": 60, "peers": peers}))
+}
+
+// announced adds or removes the peer. Caller must hold the lock
+func (t *Tracker) announced(addr string, active bool) {
+	for i, peer := range t.peers {
+		if peer == addr {
+			t.peers = append(t.peers[:i], t.peers[i+1:]...)
+			break
+		}
+	}
+
+	if active {
+		t.peers = append(t.peers, addr)
+	}
+}
+
+// Create returns the .torrent file of the files, its info and its data. A single file without a path is a single file
+// torrent of the name, otherwise the files are in the folder of the name
+func Create(announce, name string, pieceLength int, files ...File) ([]byte, *torrent.Info, []byte) {
+	var data []byte
+	for _, f := range files {
+		data = append(data, f.Content...)
+	}
+
+	pieces := make([]byte, 0)
+	for start := 0; start < len(data) || start == 0; start += pieceLength {
+		end := start + pieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+
+		hash := sha1.Sum(data[start:end])
+		pieces = append(pieces, hash[:]...)
+	}
+
+	info := map[string]interface{}{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+
+	if len(files) == 1 && files[0].Path == "" {
+		info["length"] = len(files[0].Content)
+	} else {
+		list := make([]interface{}, len(files))
+		for i, f := range files {
+			list[i] = map[string]interface{}{
+				"length": len(f.Content),
+				"path":   strings.Split(f.Path, "/"),
+			}
+		}
+
+		info["files"] = list
+	}
+
+	raw := torrent.Encode(info)
+
+	parsed, err := torrent.ParseInfo(raw)
+	if err != nil {
+		panic("torrenttest: " + err.Error())
+	}
+
+	// the encoding is canonical, so the info in the .torrent file is the same as the raw one
+	metainfo := torrent.Encode(map[string]interface{}{"announce": announce, "info": info})
+
+	return metainfo, parsed, data
+}
+
+// Seed seeds every piece of the data on a local port
+func Seed(info *torrent.Info, data []byte) *torrent.Seeder {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("torrenttest: " + err.Error())
+	}
+
+	have := torrent.NewBitfield(len(info.Pieces))
+	for i := range info.Pieces {
+		have.Set(i)
+	}
+
+	return torrent.Seed(listener, info, bytes.NewReader(data), have, torrent.NewPeerID())
+}
@@ -0,0 +1,174 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// idleConnsPerHost is the idle connections kept when the connections per host are unlimited, enough for the chunks of a few downloads
+const idleConnsPerHost = 32
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// newTransport builds the transport of the config. A transport that can't be built fails the requests, rather than ignoring the config
+func newTransport(cfg config) http.RoundTripper {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return failing{err}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   seconds(cfg.connect),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxyFunc(cfg.proxy, cfg.noProxy),
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   seconds(cfg.handshake),
+		ResponseHeaderTimeout: seconds(cfg.read),
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		MaxConnsPerHost:       cfg.maxConns,
+		MaxIdleConnsPerHost:   cfg.maxConns,
+		ForceAttemptHTTP2:     cfg.http2,
+	}
+
+	if cfg.maxConns == 0 {
+		transport.MaxIdleConnsPerHost = idleConnsPerHost
+	}
+
+	if !cfg.http2 {
+		// a non-nil empty map disables http2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if cfg.read == 0 {
+		return transport
+	}
+
+	return &idleTimeout{
+		transport: transport,
+		timeout:   seconds(cfg.read),
+	}
+}
+
+func newTLSConfig(cfg config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.insecure,
+	}
+
+	if cfg.ca == "" {
+		return tlsConfig, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	pem, err := os.ReadFile(cfg.ca)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ca certificates:%s", err.Error())
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ca certificates %s has no pem certificate", cfg.ca)
+	}
+
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
+
+type failing struct {
+	err error
+}
+
+func (f failing) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	return nil, f.err
+}
+
+// idleTimeout cancels the request once a read of the body doesn't receive any data for the timeout, so a stalled server returns an error instead of hanging.
+// Only the time spent waiting for the server counts, so a slow reader, e.g a throttled one, is not affected
+type idleTimeout struct {
+	transport http.RoundTripper
+	timeout   time.Duration
+}
+
+func (t *idleTimeout) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+
+	res, err := t.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	body := &idleBody{
+		body:    res.Body,
+		cancel:  cancel,
+		timeout: t.timeout,
+	}
+
+	body.timer = time.AfterFunc(t.timeout, body.expire)
+	body.timer.Stop()
+	res.Body = body
+
+	return res, nil
+}
+
+type idleBody struct {
+	mutex   sync.Mutex
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	timeout time.Duration
+	expired bool
+}
+
+func (b *idleBody) expire() {
+	b.mutex.Lock()
+	b.expired = true
+	b.mutex.Unlock()
+
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+
+	b.mutex.Lock()
+	expired := b.expired
+	b.mutex.Unlock()
+
+	if expired {
+		return n, fmt.Errorf("no data received for %s", b.timeout)
+	}
+
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+
+	return b.body.Close()
+}
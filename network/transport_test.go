@@ -0,0 +1,123 @@
+package network
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+func TestReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+
+		// the server stalls in the middle of the body
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := setting.Default()
+	s.ReadTimeout = 1
+
+	res, err := New(UseSetting(s)).Get(server.URL)
+	if err != nil {
+		t.Fatal("Error requesting:", err.Error())
+	}
+
+	defer res.Body.Close()
+
+	start := time.Now()
+	_, err = io.ReadAll(res.Body)
+
+	if err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Fatalf("Expected the stalled read to time out, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the read to time out after about a second, took %s", elapsed)
+	}
+}
+
+func TestSlowReaderNotTimedOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 64)))
+	}))
+	defer server.Close()
+
+	s := setting.Default()
+	s.ReadTimeout = 1
+
+	res, err := New(UseSetting(s)).Get(server.URL)
+	if err != nil {
+		t.Fatal("Error requesting:", err.Error())
+	}
+
+	defer res.Body.Close()
+
+	// the reader waits longer than the timeout between the reads, which isn't the fault of the server
+	buffer := make([]byte, 32)
+	for {
+		time.Sleep(700 * time.Millisecond)
+
+		if _, err := res.Body.Read(buffer); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("Expected a slow reader not to time out, got", err.Error())
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	s := setting.Default()
+
+	if _, err := get(t, New(UseSetting(s)), server.URL); err == nil {
+		t.Fatal("Expected the self-signed certificate to be refused")
+	}
+
+	if _, err := get(t, New(UseSetting(s), SkipVerify(true)), server.URL); err != nil {
+		t.Fatal("Expected the entry that skips verification to be accepted, got", err.Error())
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	s.CACertificates = bundle
+
+	proto, err := get(t, New(UseSetting(s)), server.URL)
+	if err != nil {
+		t.Fatal("Expected the certificate of the bundle to be trusted, got", err.Error())
+	}
+
+	if proto != "HTTP/2.0" {
+		t.Errorf("Expected http2, got %s", proto)
+	}
+
+	s.HTTP2 = false
+
+	if proto, _ := get(t, New(UseSetting(s)), server.URL); proto != "HTTP/1.1" {
+		t.Errorf("Expected http2 to be disabled, got %s", proto)
+	}
+
+	s.CACertificates = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := get(t, New(UseSetting(s), SkipVerify(true)), server.URL); err == nil {
+		t.Error("Expected a missing bundle to fail the requests")
+	}
+}
@@ -0,0 +1,272 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/rapid-downloader/rapid/log"
+	"go.etcd.io/bbolt"
+)
+
+type (
+	Item struct {
+		ID      string `json:"id"`
+		Client  string `json:"client"`
+		Resume  bool   `json:"resume,omitempty"`  // continues where the download left off
+		Restart bool   `json:"restart,omitempty"` // starts the download over
+	}
+
+	// Runner performs the download of a promoted item. The slot is released when it returns
+	Runner func(item Item)
+
+	Manager interface {
+		Push(item Item) error
+		Remove(id string) error
+		Move(id string, position int) error
+		Queued() []Item
+		Active() []Item
+		SetLimit(limit int)
+		Start()
+		Stop()
+	}
+
+	manager struct {
+		mutex   sync.Mutex
+		db      func() *bbolt.DB
+		bucket  string
+		limit   int
+		run     Runner
+		pending []Item
+		active  []Item
+		started bool
+	}
+
+	persisted struct {
+		Active  []Item `json:"active"`
+		Pending []Item `json:"pending"`
+	}
+)
+
+const key = "items"
+
+var errNotQueued = fmt.Errorf("entry is not in the queue")
+
+// ErrDuplicate is returned when the pushed entry is already queued or running
+var ErrDuplicate = fmt.Errorf("entry is already in the queue")
+
+// New creates a queue manager that runs at most limit items at once and keeps its order in the given bucket of the resolved db
+func New(db func() *bbolt.DB, bucket string, limit int, run Runner) (Manager, error) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	m := &manager{
+		db:      db,
+		bucket:  bucket,
+		limit:   limit,
+		run:     run,
+		pending: make([]Item, 0),
+		active:  make([]Item, 0),
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *manager) load() error {
+	return m.db().Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(m.bucket))
+		if err != nil {
+			return fmt.Errorf("error creating bucket on queue load:%s", err.Error())
+		}
+
+		val := bucket.Get([]byte(key))
+		if val == nil {
+			return nil
+		}
+
+		var items persisted
+		if err := json.Unmarshal(val, &items); err != nil {
+			return fmt.Errorf("error unmarshalling queue:%s", err.Error())
+		}
+
+		// items that were running when the engine stopped go back to the front of the queue
+		m.pending = append(items.Active, items.Pending...)
+
+		return nil
+	})
+}
+
+func (m *manager) persist() error {
+	return m.db().Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(m.bucket))
+		if err != nil {
+			return fmt.Errorf("error creating bucket on queue persist:%s", err.Error())
+		}
+
+		val, err := json.Marshal(persisted{
+			Active:  m.active,
+			Pending: m.pending,
+		})
+
+		if err != nil {
+			return fmt.Errorf("error marshalling queue:%s", err.Error())
+		}
+
+		return bucket.Put([]byte(key), val)
+	})
+}
+
+func indexOf(items []Item, id string) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (m *manager) Push(item Item) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if indexOf(m.pending, item.ID) != -1 || indexOf(m.active, item.ID) != -1 {
+		return ErrDuplicate
+	}
+
+	m.pending = append(m.pending, item)
+	if err := m.persist(); err != nil {
+		return err
+	}
+
+	m.promote()
+	return nil
+}
+
+func (m *manager) Remove(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := indexOf(m.pending, id)
+	if i == -1 {
+		return errNotQueued
+	}
+
+	m.pending = append(m.pending[:i], m.pending[i+1:]...)
+	return m.persist()
+}
+
+// Move puts a queued item at the given position, counted from the front of the queue
+func (m *manager) Move(id string, position int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := indexOf(m.pending, id)
+	if i == -1 {
+		return errNotQueued
+	}
+
+	item := m.pending[i]
+	m.pending = append(m.pending[:i], m.pending[i+1:]...)
+
+	if position < 0 {
+		position = 0
+	}
+
+	if position > len(m.pending) {
+		position = len(m.pending)
+	}
+
+	m.pending = append(m.pending[:position], append([]Item{item}, m.pending[position:]...)...)
+
+	return m.persist()
+}
+
+func (m *manager) Queued() []Item {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Item{}, m.pending...)
+}
+
+func (m *manager) Active() []Item {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Item{}, m.active...)
+}
+
+func (m *manager) SetLimit(limit int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	m.limit = limit
+	m.promote()
+}
+
+func (m *manager) Start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.started = true
+	m.promote()
+}
+
+// Stop prevents new items to be promoted. Running items are not affected
+func (m *manager) Stop() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.started = false
+}
+
+// promote moves items from the front of the queue into the free slots. Caller must hold the lock
+func (m *manager) promote() {
+	if !m.started {
+		return
+	}
+
+	promoted := false
+	for len(m.active) < m.limit && len(m.pending) > 0 {
+		item := m.pending[0]
+		m.pending = m.pending[1:]
+		m.active = append(m.active, item)
+		promoted = true
+
+		go m.execute(item)
+	}
+
+	if !promoted {
+		return
+	}
+
+	if err := m.persist(); err != nil {
+		log.Println("error persisting queue:", err.Error())
+	}
+}
+
+func (m *manager) execute(item Item) {
+	m.run(item)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if i := indexOf(m.active, item.ID); i != -1 {
+		m.active = append(m.active[:i], m.active[i+1:]...)
+	}
+
+	if err := m.persist(); err != nil {
+		log.Println("error persisting queue:", err.Error())
+	}
+
+	m.promote()
+}
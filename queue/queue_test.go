@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func openDB(t *testing.T) func() *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "queue.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Error opening db:", err.Error())
+	}
+
+	t.Cleanup(func() { db.Close() })
+	return func() *bbolt.DB { return db }
+}
+
+func TestQueueConcurrencyLimit(t *testing.T) {
+	db := openDB(t)
+
+	var mutex sync.Mutex
+	running, max := 0, 0
+
+	var wg sync.WaitGroup
+	wg.Add(5)
+
+	m, err := New(db, "queue", 2, func(item Item) {
+		defer wg.Done()
+
+		mutex.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	})
+
+	if err != nil {
+		t.Fatal("Error creating queue:", err.Error())
+	}
+
+	m.Start()
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if err := m.Push(Item{ID: id}); err != nil {
+			t.Error("Error pushing item:", err.Error())
+		}
+	}
+
+	wg.Wait()
+
+	if max != 2 {
+		t.Errorf("Expected at most 2 running downloads, but got %d", max)
+	}
+}
+
+func TestQueueMoveAndRemove(t *testing.T) {
+	db := openDB(t)
+
+	m, err := New(db, "queue", 1, func(item Item) {})
+	if err != nil {
+		t.Fatal("Error creating queue:", err.Error())
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		m.Push(Item{ID: id})
+	}
+
+	if err := m.Push(Item{ID: "1"}); err == nil {
+		t.Error("Expected error when pushing duplicate item")
+	}
+
+	if err := m.Move("3", 0); err != nil {
+		t.Error("Error moving item:", err.Error())
+	}
+
+	if err := m.Remove("1"); err != nil {
+		t.Error("Error removing item:", err.Error())
+	}
+
+	queued := m.Queued()
+	if len(queued) != 2 || queued[0].ID != "3" || queued[1].ID != "2" {
+		t.Errorf("Expected queue to be [3 2], but got %v", queued)
+	}
+}
+
+func TestQueuePersisted(t *testing.T) {
+	db := openDB(t)
+
+	m, err := New(db, "queue", 1, func(item Item) {})
+	if err != nil {
+		t.Fatal("Error creating queue:", err.Error())
+	}
+
+	m.Push(Item{ID: "1", Client: "cli"})
+	m.Push(Item{ID: "2", Client: "gui"})
+
+	restored, err := New(db, "queue", 1, func(item Item) {})
+	if err != nil {
+		t.Fatal("Error restoring queue:", err.Error())
+	}
+
+	queued := restored.Queued()
+	if len(queued) != 2 || queued[0].ID != "1" || queued[1].Client != "gui" {
+		t.Errorf("Expected restored queue to keep its order, but got %v", queued)
+	}
+}
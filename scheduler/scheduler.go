@@ -0,0 +1,344 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rapid-downloader/rapid/log"
+	"github.com/rapid-downloader/rapid/setting"
+	"go.etcd.io/bbolt"
+)
+
+type (
+	// Schedule tells when a download is allowed to run. A download with a start time runs once the time comes,
+	// and a download with a window only runs while the window is open
+	Schedule struct {
+		ID     string     `json:"id"`
+		Client string     `json:"client"`
+		Start  *time.Time `json:"start,omitempty"`
+		Window *Window    `json:"window,omitempty"`
+	}
+
+	// Controller starts and pauses the downloads as their schedule opens and closes
+	Controller interface {
+		Start(s Schedule) error
+		Pause(id string) error
+
+		// Hold prevents new downloads from starting during the quiet hours, and returns the running ones so they continue afterwards
+		Hold() []Schedule
+		Release()
+	}
+
+	Manager interface {
+		Set(s Schedule) error
+		Get(id string) *Schedule
+		All() []Schedule
+		Remove(id string) error
+		Allowed(id string) bool
+		Start()
+		Stop()
+	}
+
+	manager struct {
+		mutex      sync.Mutex
+		db         func() *bbolt.DB
+		bucket     string
+		controller Controller
+		schedules  map[string]Schedule
+		open       map[string]bool // last evaluation of each schedule, so the controller is only called when it changes
+		quiet      bool
+		evaluated  bool
+		stop       chan struct{}
+	}
+)
+
+// interval of the evaluation. Windows have minute precision, so it doesn't need to be more often
+const interval = 15 * time.Second
+
+// ErrGone is returned by the controller when the scheduled download no longer exists or is already finished, which removes the schedule
+var ErrGone = fmt.Errorf("scheduled download is gone")
+
+func (s Schedule) Validate() error {
+	if s.ID == "" {
+		return fmt.Errorf("schedule has no download id")
+	}
+
+	if s.Window != nil {
+		return s.Window.Validate()
+	}
+
+	return nil
+}
+
+// Allowed tells whether the download may run at the given time
+func (s Schedule) Allowed(now time.Time) bool {
+	if s.Start != nil && now.Before(*s.Start) {
+		return false
+	}
+
+	return s.Window == nil || s.Window.Open(now)
+}
+
+// recurring schedules are kept after the download starts, since it needs to be paused once the window closes
+func (s Schedule) recurring() bool {
+	return s.Window != nil
+}
+
+// New creates a scheduler which keeps the schedules in the given bucket of the resolved db
+func New(db func() *bbolt.DB, bucket string, controller Controller) (Manager, error) {
+	m := &manager{
+		db:         db,
+		bucket:     bucket,
+		controller: controller,
+		schedules:  make(map[string]Schedule),
+		open:       make(map[string]bool),
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *manager) load() error {
+	return m.db().Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(m.bucket))
+		if err != nil {
+			return fmt.Errorf("error creating bucket on schedule load:%s", err.Error())
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var s Schedule
+			if err := json.Unmarshal(v, &s); err != nil {
+				return fmt.Errorf("error unmarshalling schedule:%s", err.Error())
+			}
+
+			m.schedules[string(k)] = s
+			return nil
+		})
+	})
+}
+
+func (m *manager) put(s Schedule) error {
+	return m.db().Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(m.bucket))
+		if err != nil {
+			return fmt.Errorf("error creating bucket on schedule put:%s", err.Error())
+		}
+
+		val, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("error marshalling schedule:%s", err.Error())
+		}
+
+		return bucket.Put([]byte(s.ID), val)
+	})
+}
+
+func (m *manager) delete(id string) error {
+	delete(m.schedules, id)
+	delete(m.open, id)
+
+	return m.db().Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(m.bucket))
+		if err != nil {
+			return fmt.Errorf("error creating bucket on schedule delete:%s", err.Error())
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// Set creates or replaces the schedule of a download, which is evaluated right away
+func (m *manager) Set(s Schedule) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+
+	if err := m.put(s); err != nil {
+		m.mutex.Unlock()
+		return err
+	}
+
+	m.schedules[s.ID] = s
+	delete(m.open, s.ID)
+	started := m.stop != nil
+
+	m.mutex.Unlock()
+
+	if started {
+		go m.tick()
+	}
+
+	return nil
+}
+
+func (m *manager) Get(id string) *Schedule {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, ok := m.schedules[id]
+	if !ok {
+		return nil
+	}
+
+	return &s
+}
+
+func (m *manager) All() []Schedule {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	schedules := make([]Schedule, 0, len(m.schedules))
+	for _, s := range m.schedules {
+		schedules = append(schedules, s)
+	}
+
+	return schedules
+}
+
+func (m *manager) Remove(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.schedules[id]; !ok {
+		return fmt.Errorf("download %s has no schedule", id)
+	}
+
+	return m.delete(id)
+}
+
+// Allowed tells whether the scheduled download may run now. A download without schedule is always allowed,
+// since the controller already holds them during the quiet hours
+func (m *manager) Allowed(id string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, ok := m.schedules[id]
+	if !ok {
+		return true
+	}
+
+	return !m.quiet && s.Allowed(time.Now())
+}
+
+// Start evaluates the schedules right away, then periodically until Stop is called
+func (m *manager) Start() {
+	m.mutex.Lock()
+	if m.stop != nil {
+		m.mutex.Unlock()
+		return
+	}
+
+	stop := make(chan struct{})
+	m.stop = stop
+	m.mutex.Unlock()
+
+	m.tick()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.tick()
+			}
+		}
+	}()
+}
+
+func (m *manager) Stop() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+func (m *manager) tick() {
+	var quiet *Window
+	if hours := setting.Get().QuietHours; hours != "" {
+		window, err := ParseWindow(hours)
+		if err != nil {
+			log.Println("error parsing quiet hours:", err.Error())
+		}
+
+		quiet = window
+	}
+
+	m.evaluate(time.Now(), quiet)
+}
+
+// evaluate calls the controller for every schedule whose state changed since the last evaluation
+func (m *manager) evaluate(now time.Time, quiet *Window) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	isQuiet := quiet != nil && quiet.Open(now)
+	if isQuiet != m.quiet || !m.evaluated {
+		switch {
+		case isQuiet:
+			// the running downloads get a schedule without constraint, so they continue once the quiet hours end
+			for _, s := range m.controller.Hold() {
+				if _, ok := m.schedules[s.ID]; ok {
+					continue
+				}
+
+				if err := m.put(s); err != nil {
+					log.Println("error scheduling held download", s.ID, ":", err.Error())
+					continue
+				}
+
+				m.schedules[s.ID] = s
+			}
+		case m.evaluated:
+			m.controller.Release()
+		}
+
+		m.quiet = isQuiet
+	}
+
+	m.evaluated = true
+
+	for id, s := range m.schedules {
+		allowed := !isQuiet && s.Allowed(now)
+		if open, ok := m.open[id]; ok && open == allowed {
+			continue
+		}
+
+		var err error
+		if allowed {
+			err = m.controller.Start(s)
+		} else {
+			err = m.controller.Pause(id)
+		}
+
+		switch {
+		case errors.Is(err, ErrGone):
+			if err := m.delete(id); err != nil {
+				log.Println("error removing schedule", id, ":", err.Error())
+			}
+		case err != nil:
+			// evaluated again on the next tick
+			log.Println("error applying schedule", id, ":", err.Error())
+		case allowed && !s.recurring():
+			if err := m.delete(id); err != nil {
+				log.Println("error removing schedule", id, ":", err.Error())
+			}
+		default:
+			m.open[id] = allowed
+		}
+	}
+}
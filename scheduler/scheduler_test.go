@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+type fakeController struct {
+	mutex   sync.Mutex
+	started []string
+	paused  []string
+	running []Schedule
+	held    bool
+	gone    map[string]bool
+}
+
+func (c *fakeController) Start(s Schedule) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.gone[s.ID] {
+		return ErrGone
+	}
+
+	c.started = append(c.started, s.ID)
+	return nil
+}
+
+func (c *fakeController) Pause(id string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.paused = append(c.paused, id)
+	return nil
+}
+
+func (c *fakeController) Hold() []Schedule {
+	c.held = true
+	return c.running
+}
+
+func (c *fakeController) Release() {
+	c.held = false
+}
+
+func openDB(t *testing.T) func() *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "schedule.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Error opening db:", err.Error())
+	}
+
+	t.Cleanup(func() { db.Close() })
+	return func() *bbolt.DB { return db }
+}
+
+// at returns the time of 2024-01-01 (monday) plus the given days, at the given clock
+func at(days int, hhmm string) time.Time {
+	t, _ := time.Parse(clock, hhmm)
+	return time.Date(2024, 1, 1+days, t.Hour(), t.Minute(), 0, 0, time.Local)
+}
+
+func TestWindowOpen(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+	tests := []struct {
+		name   string
+		window Window
+		now    time.Time
+		open   bool
+	}{
+		{"inside daytime", Window{From: "09:00", To: "17:00"}, at(0, "12:00"), true},
+		{"end is excluded", Window{From: "09:00", To: "17:00"}, at(0, "17:00"), false},
+		{"before overnight", Window{From: "22:00", To: "07:00"}, at(0, "21:59"), false},
+		{"overnight evening", Window{From: "22:00", To: "07:00"}, at(0, "23:00"), true},
+		{"overnight morning", Window{From: "22:00", To: "07:00"}, at(1, "06:30"), true},
+		{"weekday evening", Window{From: "22:00", To: "07:00", Days: weekdays}, at(4, "23:00"), true},
+		{"saturday evening", Window{From: "22:00", To: "07:00", Days: weekdays}, at(5, "23:00"), false},
+		{"saturday morning after friday", Window{From: "22:00", To: "07:00", Days: weekdays}, at(5, "03:00"), true},
+		{"monday morning after sunday", Window{From: "22:00", To: "07:00", Days: weekdays}, at(0, "03:00"), false},
+		{"whole day", Window{From: "00:00", To: "00:00"}, at(2, "15:00"), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if open := test.window.Open(test.now); open != test.open {
+				t.Errorf("Expected open to be %v, got %v", test.open, open)
+			}
+		})
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow("22:00 - 07:00")
+	if err != nil {
+		t.Fatal("Error parsing window:", err.Error())
+	}
+
+	if w.From != "22:00" || w.To != "07:00" {
+		t.Errorf("Expected 22:00-07:00, got %s-%s", w.From, w.To)
+	}
+
+	for _, str := range []string{"22:00", "25:00-07:00", "22-7"} {
+		if _, err := ParseWindow(str); err == nil {
+			t.Errorf("Expected error parsing %s", str)
+		}
+	}
+}
+
+func TestScheduleStartTime(t *testing.T) {
+	c := &fakeController{}
+	m, err := New(openDB(t), "schedule", c)
+	if err != nil {
+		t.Fatal("Error creating scheduler:", err.Error())
+	}
+
+	start := at(0, "02:00")
+	if err := m.Set(Schedule{ID: "a", Start: &start}); err != nil {
+		t.Fatal("Error setting schedule:", err.Error())
+	}
+
+	manager := m.(*manager)
+	manager.evaluate(at(0, "01:00"), nil)
+	manager.evaluate(at(0, "01:30"), nil)
+
+	if len(c.started) != 0 || len(c.paused) != 1 {
+		t.Fatalf("Expected to be paused once before the start time, got started %v and paused %v", c.started, c.paused)
+	}
+
+	manager.evaluate(at(0, "02:00"), nil)
+
+	if len(c.started) != 1 || c.started[0] != "a" {
+		t.Fatalf("Expected to be started at the start time, got %v", c.started)
+	}
+
+	if m.Get("a") != nil {
+		t.Error("Expected the one-off schedule to be removed once started")
+	}
+}
+
+func TestScheduleWindow(t *testing.T) {
+	c := &fakeController{}
+	db := openDB(t)
+
+	m, _ := New(db, "schedule", c)
+	m.Set(Schedule{ID: "a", Window: &Window{From: "22:00", To: "07:00"}})
+
+	manager := m.(*manager)
+	manager.evaluate(at(0, "21:00"), nil)
+	manager.evaluate(at(0, "22:00"), nil)
+	manager.evaluate(at(1, "06:00"), nil)
+	manager.evaluate(at(1, "07:00"), nil)
+
+	if len(c.started) != 1 || len(c.paused) != 2 {
+		t.Errorf("Expected started once and paused twice, got started %v and paused %v", c.started, c.paused)
+	}
+
+	// the schedule survives a restart
+	restored, _ := New(db, "schedule", c)
+	if s := restored.Get("a"); s == nil || s.Window == nil || s.Window.From != "22:00" {
+		t.Errorf("Expected the window to be restored, got %+v", s)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	c := &fakeController{running: []Schedule{{ID: "running", Client: "gui"}}}
+	m, _ := New(openDB(t), "schedule", c)
+	m.Set(Schedule{ID: "a", Window: &Window{From: "00:00", To: "00:00"}})
+
+	quiet, _ := ParseWindow("09:00-17:00")
+
+	manager := m.(*manager)
+	manager.evaluate(at(0, "08:00"), quiet)
+	manager.evaluate(at(0, "09:00"), quiet)
+
+	if !c.held {
+		t.Fatal("Expected the downloads to be held during the quiet hours")
+	}
+
+	if m.Allowed("a") {
+		t.Error("Expected the scheduled download not to be allowed during the quiet hours")
+	}
+
+	if len(c.paused) != 2 {
+		t.Fatalf("Expected the running and the scheduled download to be paused, got %v", c.paused)
+	}
+
+	manager.evaluate(at(0, "17:00"), quiet)
+
+	if c.held {
+		t.Error("Expected the downloads to be released after the quiet hours")
+	}
+
+	if len(c.started) != 3 {
+		t.Errorf("Expected both downloads to continue after the quiet hours, got %v", c.started)
+	}
+
+	if m.Get("running") != nil {
+		t.Error("Expected the schedule of the held download to be removed once continued")
+	}
+}
+
+func TestScheduleGone(t *testing.T) {
+	c := &fakeController{gone: map[string]bool{"a": true}}
+	m, _ := New(openDB(t), "schedule", c)
+	m.Set(Schedule{ID: "a", Window: &Window{From: "00:00", To: "00:00"}})
+
+	m.(*manager).evaluate(at(0, "12:00"), nil)
+
+	if m.Get("a") != nil {
+		t.Error("Expected the schedule of a finished download to be removed")
+	}
+}
@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a recurring time range, e.g from 22:00 to 07:00 on weekdays. A window which ends before it starts goes past midnight
+type Window struct {
+	From string         `json:"from"`           // HH:MM
+	To   string         `json:"to"`             // HH:MM
+	Days []time.Weekday `json:"days,omitempty"` // days on which the window opens, every day if empty
+}
+
+const clock = "15:04"
+
+// ParseWindow parses a daily window, e.g 22:00-07:00
+func ParseWindow(str string) (*Window, error) {
+	from, to, ok := strings.Cut(str, "-")
+	if !ok {
+		return nil, fmt.Errorf("window %s must be formatted as HH:MM-HH:MM", str)
+	}
+
+	w := &Window{
+		From: strings.TrimSpace(from),
+		To:   strings.TrimSpace(to),
+	}
+
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func minutes(str string) (int, error) {
+	t, err := time.Parse(clock, str)
+	if err != nil {
+		return 0, fmt.Errorf("time %s must be formatted as HH:MM", str)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *Window) Validate() error {
+	if _, err := minutes(w.From); err != nil {
+		return err
+	}
+
+	if _, err := minutes(w.To); err != nil {
+		return err
+	}
+
+	for _, day := range w.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("day %d must be between 0 (sunday) and 6 (saturday)", day)
+		}
+	}
+
+	return nil
+}
+
+func (w *Window) on(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Open tells whether the time is inside the window. A window that starts and ends at the same time lasts the whole day
+func (w *Window) Open(now time.Time) bool {
+	from, err := minutes(w.From)
+	if err != nil {
+		return false
+	}
+
+	to, err := minutes(w.To)
+	if err != nil {
+		return false
+	}
+
+	current := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+
+	switch {
+	case from == to:
+		return w.on(day)
+	case from < to:
+		return current >= from && current < to && w.on(day)
+	case current >= from:
+		return w.on(day)
+	case current < to:
+		// the window is opened on the previous day
+		return w.on((day + 6) % 7)
+	}
+
+	return false
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"encoding/json"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/rapid-downloader/rapid/api"
 	response "github.com/rapid-downloader/rapid/helper"
@@ -23,19 +26,44 @@ func (s *settingService) getSetting(ctx *fiber.Ctx) error {
 }
 
 func (s *settingService) updateSetting(ctx *fiber.Ctx) error {
-	var stg setting.Setting
-	if err := ctx.BodyParser(&stg); err != nil {
-		return response.BadRequest(ctx)
+	// the body is decoded on top of the current setting, so a partial update keeps the other fields
+	stg := setting.Get()
+
+	// a map would be merged into the current one, so the categories of the body replace them instead, which is how one is removed
+	if replaces(ctx.Body(), "Categories") {
+		stg.Categories = nil
+	}
+
+	if err := ctx.BodyParser(stg); err != nil {
+		return response.BadRequest(ctx, err)
+	}
+
+	if err := setting.Update(stg); err != nil {
+		return response.BadRequest(ctx, err)
 	}
 
-	// TODO: validation
+	return response.Ok(ctx, setting.Get())
+}
+
+// replaces tells whether the json body has the field
+func replaces(body []byte, field string) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+
+	for key := range fields {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
 
-	return response.Ok(ctx, stg)
+	return false
 }
 
 func (s *settingService) CreateRoutes() {
 	s.app.Add("GET", "/settings", s.getSetting)
-	s.app.Add("GET", "/settings", s.updateSetting)
+	s.app.Add("PUT", "/settings", s.updateSetting)
 }
 
 func init() {
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rapid-downloader/rapid/setting"
+)
+
+func TestUpdateCategories(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	app := fiber.New()
+	newService(app).CreateRoutes()
+
+	update := func(body string) {
+		req := httptest.NewRequest("PUT", "/settings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := app.Test(req)
+		if err != nil || res.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected the setting to be updated, but got %v (%v)", res, err)
+		}
+	}
+
+	update(`{"categories": {"Video": "Video", "Audio": "Audio"}}`)
+
+	// the categories are replaced, so Audio is removed
+	update(`{"categories": {"Video": "Movies"}}`)
+
+	if categories := setting.Get().Categories; len(categories) != 1 || categories["Video"] != "Movies" {
+		t.Errorf("Expected only the video category, but got %v", categories)
+	}
+
+	// a partial update without categories keeps them
+	update(`{"maxRetry": 5}`)
+
+	if s := setting.Get(); s.MaxRetry != 5 || len(s.Categories) != 1 {
+		t.Errorf("Expected the categories to be kept, but got %v", s.Categories)
+	}
+}
@@ -0,0 +1,143 @@
+package setting
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func prepare(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	cache.Lock()
+	cache.setting = nil
+	cache.subscribers = nil
+	cache.Unlock()
+}
+
+func TestUpdate(t *testing.T) {
+	prepare(t)
+
+	var notified *Setting
+	Subscribe(func(old, new *Setting) {
+		if old.MaxChunkCount != 8 {
+			t.Errorf("Expected previous max chunk count 8, got %d", old.MaxChunkCount)
+		}
+
+		notified = new
+	})
+
+	s := Get()
+	s.MaxChunkCount = 4
+	s.DownloadLocation = filepath.Join(t.TempDir(), "downloads")
+
+	if err := Update(s); err != nil {
+		t.Fatal("Error updating setting:", err.Error())
+	}
+
+	if notified == nil || notified.MaxChunkCount != 4 {
+		t.Fatal("Expected the subscriber to be notified with the updated setting")
+	}
+
+	if Get().MaxChunkCount != 4 {
+		t.Errorf("Expected cached max chunk count 4, got %d", Get().MaxChunkCount)
+	}
+
+	// the setting is read back from the file
+	cache.Lock()
+	cache.setting = nil
+	cache.Unlock()
+
+	if got := Get(); got.MaxChunkCount != 4 || got.DownloadLocation != s.DownloadLocation {
+		t.Errorf("Expected the update to be saved, got %+v", got)
+	}
+
+	if _, err := os.Stat(location() + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected no leftover temporary setting file")
+	}
+}
+
+func TestUpdateInvalid(t *testing.T) {
+	prepare(t)
+
+	tests := map[string]func(s *Setting){
+		"no chunk":              func(s *Setting) { s.MaxChunkCount = 0 },
+		"tiny chunk":            func(s *Setting) { s.MinChunkSize = 1024 },
+		"negative retry":        func(s *Setting) { s.MaxRetry = -1 },
+		"no concurrency":        func(s *Setting) { s.MaxConcurrentDownload = 0 },
+		"unknown strategy":      func(s *Setting) { s.ChunkStrategy = "memory" },
+		"malformed quiet hours": func(s *Setting) { s.QuietHours = "22-7" },
+		"unknown hook action":   func(s *Setting) { s.Hooks = []Hook{{Action: "upload"}} },
+		"hook without target":   func(s *Setting) { s.Hooks = []Hook{{Action: "move"}} },
+		"unsupported proxy":     func(s *Setting) { s.Proxy = "ftp://127.0.0.1:21" },
+		"unwritable category":   func(s *Setting) { s.Categories = map[string]string{"Video": "/dev/null/Video"} },
+		"malformed hook glob":   func(s *Setting) { s.Hooks = []Hook{{Action: "extract", Glob: "[a"}} },
+		"relative location":     func(s *Setting) { s.DownloadLocation = "downloads" },
+		"unwritable location":   func(s *Setting) { s.DownloadLocation = "/dev/null/downloads" },
+		"missing ssh key":       func(s *Setting) { s.SSHKey = "/dev/null/id_ed25519" },
+		"muxer without output":  func(s *Setting) { s.Muxer = "ffmpeg -i {video} -i {audio}" },
+		"negative seed ratio":   func(s *Setting) { s.SeedRatio = -1 },
+		"invalid torrent port":  func(s *Setting) { s.TorrentPort = 70000 },
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := Get()
+			modify(s)
+
+			if err := Update(s); err == nil {
+				t.Error("Expected validation error")
+			}
+
+			if !reflect.DeepEqual(Get(), Default()) {
+				t.Error("Expected the setting to stay unchanged")
+			}
+		})
+	}
+}
+
+func TestValidateCreatesNothing(t *testing.T) {
+	prepare(t)
+
+	s := Get()
+	s.DownloadLocation = filepath.Join(t.TempDir(), "downloads", "rapid")
+	s.Categories = map[string]string{"Video": "Video"}
+
+	if err := Validate(s); err != nil {
+		t.Fatal("Expected a location inside a writable folder to be valid, but got", err.Error())
+	}
+
+	s.MaxChunkCount = 0
+	if err := Update(s); err == nil {
+		t.Fatal("Expected validation error")
+	}
+
+	if _, err := os.Stat(filepath.Dir(s.DownloadLocation)); !os.IsNotExist(err) {
+		t.Error("Expected the validation not to create the location")
+	}
+}
+
+func TestFolder(t *testing.T) {
+	s := &Setting{
+		DownloadLocation: "/downloads",
+		Categories: map[string]string{
+			"Video":    "Video",
+			"Document": "/documents",
+			"Audio":    "",
+		},
+	}
+
+	tests := map[string]string{
+		"Video":    "/downloads/Video",
+		"Document": "/documents",
+		"Audio":    "/downloads",
+		"Other":    "/downloads",
+	}
+
+	for filetype, expected := range tests {
+		if folder := s.Folder(filetype); folder != expected {
+			t.Errorf("Expected folder of %s to be %s, got %s", filetype, expected, folder)
+		}
+	}
+}